@@ -0,0 +1,111 @@
+package main
+
+import "sync"
+
+// pagesPerSlab is the number of pages carved out of each arena slab.
+const pagesPerSlab = 16
+
+// slabPool recycles arena slabs between pagers so short-lived sessions (such as
+// the insert benchmarks, which open a fresh table per iteration) reuse memory
+// instead of allocating new slabs. Array pointers are pooled so Put doesn't allocate.
+var slabPool = sync.Pool{
+	New: func() any { return new([pagesPerSlab * pageSize]byte) },
+}
+
+// pageBufferPool recycles the page-sized scratch buffers used while a page is
+// rebuilt or written out (the cells of a leaf being split or merged, a page
+// being encrypted or compared with the file), so bulk inserts and commits
+// don't allocate a fresh 4 KB slice for each of them. Borrow buffers with
+// getPageBuffer and give them back with putPageBuffer once nothing refers to
+// them any more.
+var pageBufferPool = sync.Pool{
+	New: func() any { return new([pageSize]byte) },
+}
+
+func getPageBuffer() *[pageSize]byte {
+	return pageBufferPool.Get().(*[pageSize]byte)
+}
+
+func putPageBuffer(buf *[pageSize]byte) {
+	pageBufferPool.Put(buf)
+}
+
+// rowBufferPool recycles the buffers a row is serialized into outside a page:
+// the new cell of a leaf split, the old value an update may have to put back,
+// the rows a key lookup hands over. Each holds a leaf cell of the largest row.
+var rowBufferPool = sync.Pool{
+	New: func() any { return new([LeafNodeValueOffset + rowSize]byte) },
+}
+
+func getRowBuffer() *[LeafNodeValueOffset + rowSize]byte {
+	return rowBufferPool.Get().(*[LeafNodeValueOffset + rowSize]byte)
+}
+
+func putRowBuffer(buf *[LeafNodeValueOffset + rowSize]byte) {
+	rowBufferPool.Put(buf)
+}
+
+// pageArena backs the page cache with a few large slabs sliced into page-sized
+// chunks, instead of one small allocation per page. Chunks are only returned
+// to the system when the whole arena is released.
+type pageArena struct {
+	slabs [][]byte
+	// next is the not yet handed out remainder of the newest slab
+	next []byte
+	// freed holds chunks given back with free, reused before carving new ones
+	freed [][]byte
+}
+
+// alloc returns a zeroed page-sized chunk.
+func (a *pageArena) alloc() []byte {
+	var page []byte
+	if n := len(a.freed); n > 0 {
+		page = a.freed[n-1]
+		a.freed = a.freed[:n-1]
+	} else {
+		if len(a.next) == 0 {
+			slab := slabPool.Get().(*[pagesPerSlab * pageSize]byte)
+			a.slabs = append(a.slabs, slab[:])
+			a.next = slab[:]
+		}
+		// Cap the chunk so appends can never spill into the neighbouring page
+		page = a.next[:pageSize:pageSize]
+		a.next = a.next[pageSize:]
+	}
+	clear(page)
+	return page
+}
+
+// allocRun returns n adjacent page-sized chunks as one buffer, so a single read
+// can fill several pages without a staging copy. n must not exceed pagesPerSlab.
+// Unlike alloc, the chunks are not zeroed; the caller is expected to overwrite them.
+func (a *pageArena) allocRun(n int) []byte {
+	size := n * pageSize
+	if len(a.next) < size {
+		// Keep the tail of the current slab around for single-page allocations
+		for len(a.next) > 0 {
+			a.freed = append(a.freed, a.next[:pageSize:pageSize])
+			a.next = a.next[pageSize:]
+		}
+		slab := slabPool.Get().(*[pagesPerSlab * pageSize]byte)
+		a.slabs = append(a.slabs, slab[:])
+		a.next = slab[:]
+	}
+	run := a.next[:size:size]
+	a.next = a.next[size:]
+	return run
+}
+
+// free gives a chunk obtained from alloc back to the arena for reuse.
+func (a *pageArena) free(page []byte) {
+	a.freed = append(a.freed, page)
+}
+
+// release returns every slab to the pool. No chunk handed out by the arena may
+// be used afterwards.
+func (a *pageArena) release() {
+	for _, slab := range a.slabs {
+		slabPool.Put((*[pagesPerSlab * pageSize]byte)(slab))
+	}
+	a.slabs, a.next, a.freed = nil, nil, nil
+}
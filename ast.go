@@ -0,0 +1,118 @@
+package main
+
+// Statement is the syntax tree of one parsed statement. The parser only
+// records what was written; plan_statement decides how to carry it out.
+type Statement interface {
+	statementNode()
+}
+
+// InsertStatement inserts one or more rows. AutoID is set for the single-row
+// form written without an id or with a null one, where the table assigns it.
+// IgnoreDuplicates is set by "insert or ignore": rows whose id is taken are
+// skipped instead of failing the statement.
+type InsertStatement struct {
+	Rows             []Row
+	AutoID           bool
+	IgnoreDuplicates bool
+}
+
+// Projection is what a select returns for the rows it matches.
+type Projection int
+
+const (
+	PROJECT_ROWS           Projection = iota // the rows themselves ("select" or "select *")
+	PROJECT_COUNT                            // the number of rows ("select count(*)")
+	PROJECT_LAST_INSERT_ID                   // the id of the last insert ("select last_insert_id()")
+	PROJECT_COLUMN                           // one column of the rows ("select [distinct] <column>")
+)
+
+// SelectStatement is "select [<projection>] [where <condition>] [order by id [asc|desc]]".
+type SelectStatement struct {
+	Projection Projection
+	Column     string    // "id", "username" or "email" for PROJECT_COLUMN
+	Distinct   bool      // PROJECT_COLUMN returns each value once
+	Where      Condition // nil matches every row
+	OrderBy    *OrderBy  // nil keeps ascending key order
+}
+
+// Condition is the predicate of a where clause.
+type Condition interface {
+	conditionNode()
+}
+
+// IDEquals matches the row whose id is ID.
+type IDEquals struct {
+	ID int64
+}
+
+// IDIn matches the rows whose id is one of IDs.
+type IDIn struct {
+	IDs []int64
+}
+
+// IDBetween matches the rows whose id is from Low to High, both inclusive.
+// It matches nothing when Low is greater than High.
+type IDBetween struct {
+	Low, High int64
+}
+
+// IsNull matches the rows whose Column ("username" or "email") is NULL, or
+// is not NULL when Not is set.
+type IsNull struct {
+	Column string
+	Not    bool
+}
+
+// OrderBy sorts the result by id, the only column with an order.
+type OrderBy struct {
+	Descending bool
+}
+
+// UpdateStatement replaces the row with Row.ID by Row.
+type UpdateStatement struct {
+	Row Row
+}
+
+// DeleteStatement removes the row with the given id.
+type DeleteStatement struct {
+	ID int64
+}
+
+// PragmaStatement reads the named header field, or sets it to Value when HasValue is set.
+type PragmaStatement struct {
+	Name     string
+	Value    int32
+	HasValue bool
+}
+
+// VacuumStatement rebuilds the database file with its trees packed.
+type VacuumStatement struct{}
+
+// BeginStatement opens a transaction, CommitStatement writes its changes to
+// the file and RollbackStatement discards them.
+type BeginStatement struct{}
+type CommitStatement struct{}
+type RollbackStatement struct{}
+
+// ExplainStatement is "explain [analyze] <statement>": it describes how
+// Statement would be run, or with Analyze runs it and reports what each step did.
+type ExplainStatement struct {
+	Statement Statement
+	Analyze   bool
+}
+
+func (*InsertStatement) statementNode()   {}
+func (*SelectStatement) statementNode()   {}
+func (*UpdateStatement) statementNode()   {}
+func (*DeleteStatement) statementNode()   {}
+func (*PragmaStatement) statementNode()   {}
+func (*VacuumStatement) statementNode()   {}
+func (*BeginStatement) statementNode()    {}
+func (*CommitStatement) statementNode()   {}
+func (*RollbackStatement) statementNode() {}
+func (*ExplainStatement) statementNode()  {}
+
+func (*IDEquals) conditionNode()  {}
+func (*IDIn) conditionNode()      {}
+func (*IDBetween) conditionNode() {}
+func (*IsNull) conditionNode()    {}
@@ -0,0 +1,146 @@
+package main
+
+import "slices"
+
+// backupStepPages is how many pages Database.Backup copies per step.
+const backupStepPages = 16
+
+// Backup copies a live database into another file page by page, from the
+// page cache, so changes not yet committed are included. Between steps the
+// database can go on changing: pages changed after they were copied are
+// copied again, and the backup only finishes after a step leaves no page
+// behind, at which point the file is an image of the database as of that
+// step. Steps hold the database's latch for writing, so a step never sees a
+// write half done.
+type Backup struct {
+	db  *Database
+	dst Storage
+	// next is the first page the first pass has not copied yet
+	next uint32
+	// stale marks pages changed after they were copied
+	stale [tableMaxPages]bool
+}
+
+// StartBackup creates (or empties) the file at path and returns a Backup
+// into it. Nothing is copied until Step is called.
+func (db *Database) StartBackup(path string) (*Backup, error) {
+	db.latch.Lock()
+	defer db.latch.Unlock()
+	dst, err := db.cfg.storage(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := dst.Truncate(0); err != nil {
+		dst.Close()
+		return nil, err
+	}
+	b := &Backup{db: db, dst: dst}
+	db.pager.backups = append(db.pager.backups, b)
+	return b, nil
+}
+
+// Backup copies the database into the file at path in one go.
+func (db *Database) Backup(path string) error {
+	b, err := db.StartBackup(path)
+	if err != nil {
+		return err
+	}
+	for {
+		done, err := b.Step(backupStepPages)
+		if err != nil {
+			b.Close()
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// Step copies up to n pages: first the pages the first pass has not reached,
+// then the ones changed since they were copied. done is true once the copy
+// is complete; the file is then synced and closed and the Backup must not be
+// used again.
+func (b *Backup) Step(n int) (done bool, err error) {
+	b.db.latch.Lock()
+	defer b.db.latch.Unlock()
+
+	p := b.db.pager
+	scratch := getPageBuffer()
+	defer putPageBuffer(scratch)
+	for ; n > 0; n-- {
+		pageNum, ok := b.nextPage(p.numPages)
+		if !ok {
+			break
+		}
+		page, err := p.getPage(pageNum)
+		if err != nil {
+			return false, err
+		}
+		if page, err = p.filePage(pageNum, page, scratch); err != nil {
+			return false, err
+		}
+		if _, err := b.dst.WriteAt(page, int64(pageNum)*pageSize); err != nil {
+			return false, err
+		}
+	}
+	if b.pending(p.numPages) {
+		return false, nil
+	}
+
+	if err := b.dst.Truncate(int64(p.numPages) * pageSize); err != nil {
+		return false, err
+	}
+	if p.syncMode != SyncOff {
+		if err := b.dst.Sync(); err != nil {
+			return false, err
+		}
+	}
+	return true, b.close()
+}
+
+// nextPage picks the page to copy next and marks it copied.
+func (b *Backup) nextPage(numPages uint32) (uint32, bool) {
+	if b.next < numPages {
+		b.next++
+		return b.next - 1, true
+	}
+	for pageNum := range numPages {
+		if b.stale[pageNum] {
+			b.stale[pageNum] = false
+			return pageNum, true
+		}
+	}
+	return 0, false
+}
+
+// pending reports whether pages are left to copy.
+func (b *Backup) pending(numPages uint32) bool {
+	return b.next < numPages || slices.Contains(b.stale[:numPages], true)
+}
+
+// restart makes the backup copy every page again, for when the whole file
+// was replaced.
+func (b *Backup) restart() {
+	b.next = 0
+	b.stale = [tableMaxPages]bool{}
+}
+
+// Close abandons the backup, leaving a partial copy behind, and stops
+// tracking changes for it.
+func (b *Backup) Close() error {
+	b.db.latch.Lock()
+	defer b.db.latch.Unlock()
+	return b.close()
+}
+
+func (b *Backup) close() error {
+	p := b.db.pager
+	for i, other := range p.backups {
+		if other == b {
+			p.backups = append(p.backups[:i], p.backups[i+1:]...)
+			break
+		}
+	}
+	return b.dst.Close()
+}
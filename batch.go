@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"math"
+)
+
+// rowBatchSize is the number of rows a scan hands over per batch.
+const rowBatchSize = 128
+
+// RowBatch holds up to rowBatchSize rows stored column by column, so operators
+// that only look at one column walk a dense slice instead of striding across
+// 291-byte rows. Username and email values alias page memory with the NUL
+// padding trimmed; they are only valid until the callback returns.
+type RowBatch struct {
+	IDs       []int64
+	Usernames [][]byte
+	Emails    [][]byte
+	Nulls     []uint8 // null bitmap of each row, see Row.Nulls
+}
+
+func newRowBatch() *RowBatch {
+	return &RowBatch{
+		IDs:       make([]int64, 0, rowBatchSize),
+		Usernames: make([][]byte, 0, rowBatchSize),
+		Emails:    make([][]byte, 0, rowBatchSize),
+		Nulls:     make([]uint8, 0, rowBatchSize),
+	}
+}
+
+// Len returns the number of rows in the batch.
+func (b *RowBatch) Len() int {
+	return len(b.IDs)
+}
+
+func (b *RowBatch) reset() {
+	b.IDs = b.IDs[:0]
+	b.Usernames = b.Usernames[:0]
+	b.Emails = b.Emails[:0]
+	b.Nulls = b.Nulls[:0]
+}
+
+// appendRow decodes a serialized row into the batch columns.
+func (b *RowBatch) appendRow(src []byte) {
+	view := RowView{data: src}
+	b.IDs = append(b.IDs, view.ID())
+	username, emailOffset := rowText(src, usernameOffset)
+	email, _ := rowText(src, emailOffset)
+	b.Usernames = append(b.Usernames, username)
+	b.Emails = append(b.Emails, email)
+	b.Nulls = append(b.Nulls, src[nullsOffset])
+}
+
+// appendFrom copies row i of src into the batch. The column values keep
+// aliasing the memory src aliases.
+func (b *RowBatch) appendFrom(src *RowBatch, i int) {
+	b.IDs = append(b.IDs, src.IDs[i])
+	b.Usernames = append(b.Usernames, src.Usernames[i])
+	b.Emails = append(b.Emails, src.Emails[i])
+	b.Nulls = append(b.Nulls, src.Nulls[i])
+}
+
+// ScanBatches walks the leaves from left to right and calls fn with batches of
+// up to rowBatchSize rows in key order. The batch is reused between calls, so
+// fn must not keep references to it. Scanning stops at the first error fn returns.
+func (t *Table) ScanBatches(fn func(*RowBatch) error) error {
+	return t.ScanRange(0, math.MaxUint64, fn)
+}
+
+// ScanRange is ScanBatches restricted to the keys from low to high, both
+// inclusive. It descends to the leaf holding low and walks the leaves from
+// there, stopping at the first key past high.
+func (t *Table) ScanRange(low, high uint64, fn func(*RowBatch) error) error {
+	return t.ScanRangeContext(context.Background(), low, high, fn)
+}
+
+// ScanRangeContext is ScanRange that stops with ctx's error once ctx is done.
+// It checks ctx before each leaf it reads.
+func (t *Table) ScanRangeContext(ctx context.Context, low, high uint64, fn func(*RowBatch) error) error {
+	t.rlock()
+	defer t.runlock()
+	return t.scanRange(ctx, low, high, fn)
+}
+
+func (t *Table) scanRange(ctx context.Context, low, high uint64, fn func(*RowBatch) error) error {
+	if low > high {
+		return nil
+	}
+	start, err := t.findKey(low)
+	if err != nil {
+		return err
+	}
+
+	batch := newRowBatch()
+	pageNum, cellNum := start.pageNum, start.cellNum
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		page, err := t.pager.getPage(pageNum)
+		if err != nil {
+			return err
+		}
+
+		numCells := leafNodeNumCells(page).get()
+		for i := cellNum; i < numCells; i++ {
+			if leafNodeKey(page, i) > high {
+				return flushBatch(batch, fn)
+			}
+			batch.appendRow(leafNodeValue(page, i))
+			if batch.Len() == rowBatchSize {
+				if err := fn(batch); err != nil {
+					return err
+				}
+				batch.reset()
+			}
+		}
+
+		nextLeaf := leafNodeNextLeaf(page).get()
+		if nextLeaf == 0 {
+			break
+		}
+		if nextLeaf > pageNum && nextLeaf-pageNum <= readAheadPages {
+			_ = t.pager.prefetch(nextLeaf, readAheadPages)
+		}
+		pageNum, cellNum = nextLeaf, 0
+	}
+
+	return flushBatch(batch, fn)
+}
+
+// flushBatch hands the rows left over at the end of a scan to fn.
+func flushBatch(batch *RowBatch, fn func(*RowBatch) error) error {
+	if batch.Len() > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// Count returns the number of rows in the table. It walks the leaves like
+// ScanBatches but only reads the cell count in each leaf header, without
+// decoding any rows.
+func (t *Table) Count() (int, error) {
+	t.rlock()
+	defer t.runlock()
+	return t.count()
+}
+
+func (t *Table) count() (int, error) {
+	start, err := t.findKey(0)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for pageNum := start.pageNum; pageNum != 0; {
+		page, err := t.pager.getPage(pageNum)
+		if err != nil {
+			return 0, err
+		}
+		count += int(leafNodeNumCells(page).get())
+		pageNum = leafNodeNextLeaf(page).get()
+	}
+	return count, nil
+}
+
+// ScanBatchesReverse is ScanBatches in descending key order: it starts at the
+// rightmost leaf and walks the leaves from right to left.
+func (t *Table) ScanBatchesReverse(fn func(*RowBatch) error) error {
+	return t.ScanRangeReverse(0, math.MaxUint64, fn)
+}
+
+// ScanRangeReverse is ScanRange in descending key order: it starts at the leaf
+// holding high and walks the leaves from right to left, stopping at the first
+// key below low.
+func (t *Table) ScanRangeReverse(low, high uint64, fn func(*RowBatch) error) error {
+	return t.ScanRangeReverseContext(context.Background(), low, high, fn)
+}
+
+// ScanRangeReverseContext is ScanRangeReverse that stops with ctx's error once
+// ctx is done, checked before each leaf like in ScanRangeContext.
+func (t *Table) ScanRangeReverseContext(ctx context.Context, low, high uint64, fn func(*RowBatch) error) error {
+	t.rlock()
+	defer t.runlock()
+
+	if low > high {
+		return nil
+	}
+	start, err := t.findKey(high)
+	if err != nil {
+		return err
+	}
+
+	batch := newRowBatch()
+	pageNum := start.pageNum
+	// Cells before the cursor hold keys below high, the cell under it may be high itself
+	end := start.cellNum
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		page, err := t.pager.getPage(pageNum)
+		if err != nil {
+			return err
+		}
+
+		if end < leafNodeNumCells(page).get() && leafNodeKey(page, end) == high {
+			end++
+		}
+		for i := end; i > 0; i-- {
+			if leafNodeKey(page, i-1) < low {
+				return flushBatch(batch, fn)
+			}
+			batch.appendRow(leafNodeValue(page, i-1))
+			if batch.Len() == rowBatchSize {
+				if err := fn(batch); err != nil {
+					return err
+				}
+				batch.reset()
+			}
+		}
+
+		if pageNum, err = t.previousLeaf(pageNum); err != nil {
+			return err
+		}
+		if pageNum == 0 {
+			break
+		}
+		page, err = t.pager.getPage(pageNum)
+		if err != nil {
+			return err
+		}
+		end = leafNodeNumCells(page).get()
+	}
+
+	return flushBatch(batch, fn)
+}
@@ -7,8 +7,9 @@ import (
 	"testing"
 )
 
-// setupBenchmarkTable creates a temporary database for benchmarking
-func setupBenchmarkTable(b *testing.B) (*Table, func()) {
+// setupBenchmarkDatabase creates a temporary database for benchmarking and
+// returns it with its default table
+func setupBenchmarkDatabase(b *testing.B, opts ...Option) (*Database, *Table, func()) {
 	b.Helper()
 	tmpFile, err := os.CreateTemp("", "benchmark_*.db")
 	if err != nil {
@@ -16,22 +17,35 @@ func setupBenchmarkTable(b *testing.B) (*Table, func()) {
 	}
 	tmpFile.Close()
 
-	table, err := OpenDatabase(tmpFile.Name())
+	db, err := OpenDatabase(tmpFile.Name(), opts...)
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		b.Fatal(err)
+	}
+	table, err := db.Table(defaultTableName)
 	if err != nil {
+		db.Close()
 		os.Remove(tmpFile.Name())
 		b.Fatal(err)
 	}
 
 	cleanup := func() {
-		table.Close()
+		db.Close()
 		os.Remove(tmpFile.Name())
 	}
 
+	return db, table, cleanup
+}
+
+// setupBenchmarkTable creates a temporary database for benchmarking
+func setupBenchmarkTable(b *testing.B, opts ...Option) (*Table, func()) {
+	b.Helper()
+	_, table, cleanup := setupBenchmarkDatabase(b, opts...)
 	return table, cleanup
 }
 
 // createRow creates a test row with the given ID
-func createRow(id int32) *Row {
+func createRow(id int64) *Row {
 	row := &Row{ID: id}
 	copy(row.Username[:], fmt.Sprintf("user%d", id))
 	copy(row.Email[:], fmt.Sprintf("user%d@example.com", id))
@@ -42,18 +56,37 @@ func createRow(id int32) *Row {
 func populateTable(b *testing.B, table *Table, n int) {
 	b.Helper()
 	for i := range n {
-		if err := table.Insert(createRow(int32(i))); err != nil {
+		if err := table.Insert(createRow(int64(i))); err != nil {
 			b.Fatal(err)
 		}
 	}
 }
 
+// minLeafCells is the number of cells every leaf can hold, even with rows at
+// their largest size.
+const minLeafCells = LeafNodeSpaceForCells / (LeafNodeCellPointerSize + LeafNodeMaxCellSize)
+
 // maxSafeRows returns the maximum number of rows we can safely insert
 // given tableMaxPages and the B-tree structure.
-// With LeafNodeMaxCells ≈ 13 and some pages used for internal nodes,
-// we conservatively estimate ~80% of pages can be leaves.
+// With at least minLeafCells ≈ 13 rows per leaf and some pages used for
+// internal nodes, we conservatively estimate ~80% of pages can be leaves.
 func maxSafeRows() int {
-	return int(float64(tableMaxPages) * 0.8 * float64(LeafNodeMaxCells))
+	return int(float64(tableMaxPages) * 0.8 * float64(minLeafCells))
+}
+
+// fillLeafNode initializes node as a leaf and fills it with rows of createRow
+// under the keys 0, 10, 20, ... until it has no room left. It returns the
+// number of cells.
+func fillLeafNode(node []byte) uint32 {
+	initializeLeafNode(node)
+	for i := uint32(0); ; i++ {
+		row := createRow(int64(i))
+		value := leafNodeInsertCell(node, i, uint64(i)*10, serializedRowSize(row))
+		if value == nil {
+			return i
+		}
+		serializeRow(row, value)
+	}
 }
 
 func BenchmarkInsert(b *testing.B) {
@@ -67,7 +100,7 @@ func BenchmarkInsert(b *testing.B) {
 			// Insert a batch of rows (enough to trigger multiple splits)
 			batchSize := 100
 			for j := range batchSize {
-				if err := table.Insert(createRow(int32(j))); err != nil {
+				if err := table.Insert(createRow(int64(j))); err != nil {
 					cleanup()
 					b.Fatal(err)
 				}
@@ -88,11 +121,11 @@ func BenchmarkInsert(b *testing.B) {
 
 			// Pre-generate random IDs
 			batchSize := 100
-			ids := make([]int32, batchSize)
-			used := make(map[int32]bool)
+			ids := make([]int64, batchSize)
+			used := make(map[int64]bool)
 			for j := range batchSize {
 				for {
-					id := rng.Int31()
+					id := rng.Int63()
 					if !used[id] {
 						used[id] = true
 						ids[j] = id
@@ -115,6 +148,128 @@ func BenchmarkInsert(b *testing.B) {
 	})
 }
 
+func BenchmarkInsertMany(b *testing.B) {
+	// Same random IDs as BenchmarkInsert/Random, inserted as one batch
+	rng := rand.New(rand.NewSource(42))
+
+	for range b.N {
+		b.StopTimer()
+		table, cleanup := setupBenchmarkTable(b)
+
+		batchSize := 100
+		rows := make([]Row, batchSize)
+		used := make(map[int64]bool)
+		for j := range batchSize {
+			for {
+				id := rng.Int63()
+				if !used[id] {
+					used[id] = true
+					rows[j] = *createRow(id)
+					break
+				}
+			}
+		}
+
+		b.StartTimer()
+		if err := table.InsertMany(rows); err != nil {
+			cleanup()
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		cleanup()
+	}
+}
+
+func BenchmarkBulkLoad(b *testing.B) {
+	// As many sequential rows as a table safely holds, built bottom-up
+	rows := make([]Row, maxSafeRows())
+	for i := range rows {
+		rows[i] = *createRow(int64(i))
+	}
+	n := len(rows)
+
+	for range b.N {
+		b.StopTimer()
+		table, cleanup := setupBenchmarkTable(b)
+		b.StartTimer()
+
+		err := table.BulkLoad(func(yield func(*Row) bool) {
+			for i := range rows {
+				if !yield(&rows[i]) {
+					return
+				}
+			}
+		})
+		if err != nil {
+			cleanup()
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		count, err := table.Count()
+		if err != nil || count != n {
+			cleanup()
+			b.Fatalf("count = %d, %v; want %d", count, err, n)
+		}
+		cleanup()
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	// Email-like keys, each shared by a few rows
+	const numKeys, rowsPerKey = 500, 4
+	keys := make([][]byte, numKeys)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("user%d@example.com", i))
+	}
+	populate := func(b *testing.B, index *Index) {
+		b.Helper()
+		rng := rand.New(rand.NewSource(42))
+		for _, i := range rng.Perm(numKeys * rowsPerKey) {
+			if err := index.Insert(keys[i%numKeys], uint64(i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("Insert", func(b *testing.B) {
+		for range b.N {
+			b.StopTimer()
+			db, _, cleanup := setupBenchmarkDatabase(b)
+			index, err := db.CreateIndex("by_email", false)
+			if err != nil {
+				cleanup()
+				b.Fatal(err)
+			}
+			b.StartTimer()
+
+			populate(b, index)
+
+			b.StopTimer()
+			cleanup()
+		}
+	})
+
+	b.Run("Lookup", func(b *testing.B) {
+		db, _, cleanup := setupBenchmarkDatabase(b)
+		defer cleanup()
+		index, err := db.CreateIndex("by_email", false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		populate(b, index)
+
+		b.ResetTimer()
+		for i := range b.N {
+			pks, err := index.Lookup(keys[i%numKeys])
+			if err != nil || len(pks) != rowsPerKey {
+				b.Fatalf("lookup = %v, %v; want %d primary keys", pks, err, rowsPerKey)
+			}
+		}
+	})
+}
+
 func BenchmarkFindKey(b *testing.B) {
 	b.Run("Shallow_50rows", func(b *testing.B) {
 		table, cleanup := setupBenchmarkTable(b)
@@ -124,7 +279,7 @@ func BenchmarkFindKey(b *testing.B) {
 
 		b.ResetTimer()
 		for i := range b.N {
-			key := uint32(i % 50)
+			key := uint64(i % 50)
 			if _, err := table.findKey(key); err != nil {
 				b.Fatal(err)
 			}
@@ -139,7 +294,7 @@ func BenchmarkFindKey(b *testing.B) {
 
 		b.ResetTimer()
 		for i := range b.N {
-			key := uint32(i % 200)
+			key := uint64(i % 200)
 			if _, err := table.findKey(key); err != nil {
 				b.Fatal(err)
 			}
@@ -154,7 +309,7 @@ func BenchmarkFindKey(b *testing.B) {
 
 		b.ResetTimer()
 		for i := range b.N {
-			key := uint32(i % 300)
+			key := uint64(i % 300)
 			if _, err := table.findKey(key); err != nil {
 				b.Fatal(err)
 			}
@@ -162,6 +317,71 @@ func BenchmarkFindKey(b *testing.B) {
 	})
 }
 
+// BenchmarkGet measures point lookups of keys that are not in the table
+func BenchmarkGet(b *testing.B) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"NoBloom", nil},
+		{"Bloom", []Option{WithBloomFilter()}},
+	} {
+		b.Run(tc.name+"/Absent_300rows", func(b *testing.B) {
+			table, cleanup := setupBenchmarkTable(b, tc.opts...)
+			defer cleanup()
+
+			populateTable(b, table, 300)
+
+			b.ResetTimer()
+			for i := range b.N {
+				// Keys past the populated range are never present
+				key := uint64(300 + i%300)
+				if _, found, err := table.Get(key); err != nil || found {
+					b.Fatalf("Get(%d) = found %v, err %v", key, found, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetMany(b *testing.B) {
+	rng := rand.New(rand.NewSource(42))
+	keys := make([]uint64, 50)
+	for i := range keys {
+		keys[i] = uint64(rng.Int31n(300))
+	}
+
+	b.Run("Batched_300rows", func(b *testing.B) {
+		table, cleanup := setupBenchmarkTable(b)
+		defer cleanup()
+
+		populateTable(b, table, 300)
+
+		b.ResetTimer()
+		for range b.N {
+			if _, err := table.GetMany(keys); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Individual_300rows", func(b *testing.B) {
+		table, cleanup := setupBenchmarkTable(b)
+		defer cleanup()
+
+		populateTable(b, table, 300)
+
+		b.ResetTimer()
+		for range b.N {
+			for _, key := range keys {
+				if _, err := table.findKey(key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
 func BenchmarkSelectAll(b *testing.B) {
 	for _, rowCount := range []int{50, 100, 200} {
 		b.Run(fmt.Sprintf("Rows_%d", rowCount), func(b *testing.B) {
@@ -181,6 +401,159 @@ func BenchmarkSelectAll(b *testing.B) {
 	}
 }
 
+func BenchmarkSelectAllColdCache(b *testing.B) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"Read", nil},
+		{"Mmap", []Option{WithMmap()}},
+	} {
+		for _, rowCount := range []int{100, 300} {
+			b.Run(fmt.Sprintf("%s/Rows_%d", tc.name, rowCount), func(b *testing.B) {
+				benchmarkSelectAllColdCache(b, rowCount, tc.opts)
+			})
+		}
+	}
+}
+
+func benchmarkSelectAllColdCache(b *testing.B, rowCount int, opts []Option) {
+	db, table, cleanup := setupBenchmarkDatabase(b)
+	defer cleanup()
+	populateTable(b, table, rowCount)
+	path := table.pager.file.(fileStorage).Name()
+	if err := db.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for range b.N {
+		// Reopen so every page has to come from disk again
+		cold, err := OpenDatabase(path, opts...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		coldTable, err := cold.Table(defaultTableName)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if rows := coldTable.SelectAll(); len(rows) != rowCount {
+			b.Fatalf("expected %d rows, got %d", rowCount, len(rows))
+		}
+		b.StopTimer()
+		cold.Close()
+		b.StartTimer()
+	}
+}
+
+func BenchmarkClose(b *testing.B) {
+	syncModes := []struct {
+		name string
+		mode SyncMode
+	}{
+		{"SyncFull", SyncFull},
+		{"SyncNormal", SyncNormal},
+		{"SyncOff", SyncOff},
+	}
+	for _, sm := range syncModes {
+		for _, rowCount := range []int{100, 300} {
+			b.Run(fmt.Sprintf("%s/Rows_%d", sm.name, rowCount), func(b *testing.B) {
+				for range b.N {
+					b.StopTimer()
+					db, table, cleanup := setupBenchmarkDatabase(b, WithSyncMode(sm.mode))
+					populateTable(b, table, rowCount)
+					b.StartTimer()
+
+					// Close journals, flushes and syncs every cached page
+					if err := db.Close(); err != nil {
+						cleanup()
+						b.Fatal(err)
+					}
+
+					b.StopTimer()
+					cleanup()
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkScanBatches(b *testing.B) {
+	for _, rowCount := range []int{50, 100, 200} {
+		b.Run(fmt.Sprintf("Rows_%d", rowCount), func(b *testing.B) {
+			table, cleanup := setupBenchmarkTable(b)
+			defer cleanup()
+
+			populateTable(b, table, rowCount)
+
+			b.ResetTimer()
+			for range b.N {
+				seen := 0
+				err := table.ScanBatches(func(batch *RowBatch) error {
+					seen += batch.Len()
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if seen != rowCount {
+					b.Fatalf("expected %d rows, got %d", rowCount, seen)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCount(b *testing.B) {
+	for _, rowCount := range []int{50, 100, 200} {
+		b.Run(fmt.Sprintf("Rows_%d", rowCount), func(b *testing.B) {
+			table, cleanup := setupBenchmarkTable(b)
+			defer cleanup()
+
+			populateTable(b, table, rowCount)
+
+			b.ResetTimer()
+			for range b.N {
+				count, err := table.Count()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if count != rowCount {
+					b.Fatalf("expected %d rows, got %d", rowCount, count)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDistinctSet(b *testing.B) {
+	const values, distinct = 2000, 250
+	run := func(b *testing.B, limit int) {
+		defer func(old int) { distinctMemoryLimit = old }(distinctMemoryLimit)
+		distinctMemoryLimit = limit
+
+		for range b.N {
+			set := newDistinctSet()
+			for i := range values {
+				if err := set.add([]byte(fmt.Sprintf("user%d@example.com", i%distinct)), false); err != nil {
+					b.Fatal(err)
+				}
+			}
+			seen := 0
+			if err := set.each(func(string, bool) { seen++ }); err != nil {
+				b.Fatal(err)
+			}
+			set.close()
+			if seen != distinct {
+				b.Fatalf("expected %d distinct values, got %d", distinct, seen)
+			}
+		}
+	}
+
+	b.Run("InMemory", func(b *testing.B) { run(b, distinctMemoryLimit) })
+	b.Run("Spilled", func(b *testing.B) { run(b, 1<<10) })
+}
+
 func BenchmarkCursor(b *testing.B) {
 	b.Run("Advance_50rows", func(b *testing.B) {
 		table, cleanup := setupBenchmarkTable(b)
@@ -215,6 +588,31 @@ func BenchmarkCursor(b *testing.B) {
 	})
 }
 
+func BenchmarkCursorSeek(b *testing.B) {
+	table, cleanup := setupBenchmarkTable(b)
+	defer cleanup()
+
+	populateTable(b, table, 200)
+
+	b.ResetTimer()
+	for range b.N {
+		cursor := TableStart(table)
+		if found, err := cursor.Seek(50); err != nil {
+			b.Fatal(err)
+		} else if !found {
+			b.Fatal("expected key 50 to be found")
+		}
+		rows := 0
+		for cursor.NextUntil(149) {
+			_ = cursor.Value()
+			rows++
+		}
+		if rows != 100 {
+			b.Fatalf("expected 100 rows, got %d", rows)
+		}
+	}
+}
+
 func BenchmarkSerializeRow(b *testing.B) {
 	row := createRow(42)
 	dest := make([]byte, rowSize)
@@ -238,42 +636,52 @@ func BenchmarkDeserializeRow(b *testing.B) {
 	}
 }
 
+func BenchmarkRowView(b *testing.B) {
+	src := make([]byte, rowSize)
+	serializeRow(createRow(42), src)
+	view := RowView{data: src}
+
+	b.Run("ID", func(b *testing.B) {
+		for range b.N {
+			_ = view.ID()
+		}
+	})
+
+	b.Run("AllColumns", func(b *testing.B) {
+		for range b.N {
+			_, _, _ = view.ID(), view.Username(), view.Email()
+		}
+	})
+}
+
 func BenchmarkBTreeLeafNode(b *testing.B) {
 	b.Run("KeyAccess", func(b *testing.B) {
 		node := make([]byte, pageSize)
-		initializeLeafNode(node)
-		*leafNodeNumCells(node) = uint32(LeafNodeMaxCells)
-
-		// Initialize keys
-		for i := range LeafNodeMaxCells {
-			*leafNodeKey(node, uint32(i)) = uint32(i) * 10
-		}
+		numCells := fillLeafNode(node)
 
 		b.ResetTimer()
 		for i := range b.N {
-			_ = *leafNodeKey(node, uint32(i%LeafNodeMaxCells))
+			_ = leafNodeKey(node, uint32(i)%numCells)
 		}
 	})
 
 	b.Run("ValueAccess", func(b *testing.B) {
 		node := make([]byte, pageSize)
-		initializeLeafNode(node)
-		*leafNodeNumCells(node) = uint32(LeafNodeMaxCells)
+		numCells := fillLeafNode(node)
 
 		b.ResetTimer()
 		for i := range b.N {
-			_ = leafNodeValue(node, uint32(i%LeafNodeMaxCells))
+			_ = leafNodeValue(node, uint32(i)%numCells)
 		}
 	})
 
 	b.Run("CellAccess", func(b *testing.B) {
 		node := make([]byte, pageSize)
-		initializeLeafNode(node)
-		*leafNodeNumCells(node) = uint32(LeafNodeMaxCells)
+		numCells := fillLeafNode(node)
 
 		b.ResetTimer()
 		for i := range b.N {
-			_ = leafNodeCell(node, uint32(i%LeafNodeMaxCells))
+			_ = leafNodeCell(node, uint32(i)%numCells)
 		}
 	})
 }
@@ -282,46 +690,46 @@ func BenchmarkBTreeInternalNode(b *testing.B) {
 	b.Run("FindChild", func(b *testing.B) {
 		node := make([]byte, pageSize)
 		initializeInternalNode(node)
-		*internalNodeNumKeys(node) = InternalNodeMaxKeys
+		internalNodeNumKeys(node).set(uint32(InternalNodeMaxKeys))
 
-		// Set up keys: 100, 200, 300
+		// Set up keys: 100, 200, 300, ...
 		for i := range InternalNodeMaxKeys {
-			*internalNodeKey(node, uint32(i)) = uint32(i+1) * 100
+			internalNodeKey(node, uint32(i)).set(uint64(i+1) * 100)
 		}
 
 		b.ResetTimer()
 		for i := range b.N {
-			_ = internalNodeFindChild(node, uint32(i%400))
+			_ = internalNodeFindChild(node, uint64(i%((InternalNodeMaxKeys+1)*100)))
 		}
 	})
 
 	b.Run("KeyAccess", func(b *testing.B) {
 		node := make([]byte, pageSize)
 		initializeInternalNode(node)
-		*internalNodeNumKeys(node) = InternalNodeMaxKeys
+		internalNodeNumKeys(node).set(uint32(InternalNodeMaxKeys))
 
 		for i := range InternalNodeMaxKeys {
-			*internalNodeKey(node, uint32(i)) = uint32(i+1) * 100
+			internalNodeKey(node, uint32(i)).set(uint64(i+1) * 100)
 		}
 
 		b.ResetTimer()
 		for i := range b.N {
-			_ = *internalNodeKey(node, uint32(i%InternalNodeMaxKeys))
+			_ = internalNodeKey(node, uint32(i%InternalNodeMaxKeys)).get()
 		}
 	})
 
 	b.Run("ChildAccess", func(b *testing.B) {
 		node := make([]byte, pageSize)
 		initializeInternalNode(node)
-		*internalNodeNumKeys(node) = InternalNodeMaxKeys
+		internalNodeNumKeys(node).set(uint32(InternalNodeMaxKeys))
 
 		for i := range InternalNodeMaxKeys {
-			_ = *internalNodeChild(node, uint32(i))
+			_ = internalNodeChild(node, uint32(i)).get()
 		}
 
 		b.ResetTimer()
 		for i := range b.N {
-			_ = *internalNodeChild(node, uint32(i%InternalNodeMaxKeys))
+			_ = internalNodeChild(node, uint32(i%InternalNodeMaxKeys)).get()
 		}
 	})
 }
@@ -333,9 +741,18 @@ func BenchmarkLeafNodeSplit(b *testing.B) {
 			b.StopTimer()
 			table, cleanup := setupBenchmarkTable(b)
 
-			// Fill leaf node to capacity (LeafNodeMaxCells)
-			for j := range LeafNodeMaxCells {
-				if err := table.Insert(createRow(int32(j * 2))); err != nil {
+			// Fill leaf node until the next row no longer fits
+			root, err := table.pager.getPage(table.rootPageNum)
+			if err != nil {
+				cleanup()
+				b.Fatal(err)
+			}
+			// Key 1 shares the prefix of the keys already in the leaf
+			need := func() int {
+				return LeafNodeCellPointerSize + LeafNodeKeySize - leafNodePrefixLen(root) + serializedRowSize(createRow(1))
+			}
+			for j := 1; leafNodeUsedSpace(root)+need() <= LeafNodeSpaceForCells; j++ {
+				if err := table.Insert(createRow(int64(j * 2))); err != nil {
 					cleanup()
 					b.Fatal(err)
 				}
@@ -343,7 +760,7 @@ func BenchmarkLeafNodeSplit(b *testing.B) {
 
 			b.StartTimer()
 			// This insert triggers a leaf split
-			if err := table.Insert(createRow(int32(1))); err != nil {
+			if err := table.Insert(createRow(int64(1))); err != nil {
 				cleanup()
 				b.Fatal(err)
 			}
@@ -355,29 +772,54 @@ func BenchmarkLeafNodeSplit(b *testing.B) {
 }
 
 func BenchmarkInternalNodeSplit(b *testing.B) {
-	// This benchmark measures the time to split an internal node
-	// We need to fill the tree enough to have a full internal node, then trigger a split
+	// This benchmark measures the time to split an internal node. A full
+	// internal node has more children than tableMaxPages allows, so the root
+	// is filled by hand with children that share a few leaf pages: the split
+	// only reads the max key of the rightmost child and the new one, and
+	// rewrites the parent pointers of all of them.
 	b.Run("SingleSplit", func(b *testing.B) {
 		for range b.N {
 			b.StopTimer()
 			table, cleanup := setupBenchmarkTable(b)
+			pager := table.pager
 
-			// Calculate how many rows we need to fill the internal node
-			// InternalNodeMaxKeys = 3, so we need 4 leaf nodes to have a full internal node
-			// Each leaf holds LeafNodeMaxCells rows
-			// After 4 leaves, the next split will cause an internal node split
-			rowsNeeded := LeafNodeMaxCells * (InternalNodeMaxKeys + 1)
-
-			for j := range rowsNeeded {
-				if err := table.Insert(createRow(int32(j))); err != nil {
+			// One leaf per key: its page number and the one key in it
+			newLeaf := func(key uint64) uint32 {
+				pageNum, err := pager.getUnusedPageNum()
+				if err != nil {
+					cleanup()
+					b.Fatal(err)
+				}
+				page, err := pager.getPageForWrite(pageNum)
+				if err != nil {
 					cleanup()
 					b.Fatal(err)
 				}
+				initializeLeafNode(page)
+				row := createRow(int64(key))
+				serializeRow(row, leafNodeInsertCell(page, 0, key, serializedRowSize(row)))
+				return pageNum
+			}
+			shared := []uint32{newLeaf(0), newLeaf(1), newLeaf(2)}
+			rightChild := newLeaf(uint64(InternalNodeMaxKeys))
+			child := newLeaf(uint64(InternalNodeMaxKeys + 1))
+
+			root, err := pager.getPageForWrite(table.rootPageNum)
+			if err != nil {
+				cleanup()
+				b.Fatal(err)
+			}
+			initializeInternalNode(root)
+			setNodeRoot(root, true)
+			internalNodeNumKeys(root).set(uint32(InternalNodeMaxKeys))
+			for i := range uint32(InternalNodeMaxKeys) {
+				internalNodeChildPtr(root, i).set(shared[int(i)%len(shared)])
+				internalNodeKey(root, i).set(uint64(i))
 			}
+			internalNodeRightChild(root).set(rightChild)
 
 			b.StartTimer()
-			// This insert triggers an internal node split
-			if err := table.Insert(createRow(int32(rowsNeeded))); err != nil {
+			if err := table.internalNodeSplitAndInsert(table.rootPageNum, child); err != nil {
 				cleanup()
 				b.Fatal(err)
 			}
@@ -399,7 +841,7 @@ func BenchmarkMixedWorkload(b *testing.B) {
 			populateTable(b, table, 100)
 
 			rng := rand.New(rand.NewSource(42))
-			nextID := int32(100)
+			nextID := int64(100)
 
 			b.StartTimer()
 			// Run a batch of mixed operations
@@ -413,7 +855,7 @@ func BenchmarkMixedWorkload(b *testing.B) {
 					nextID++
 				} else {
 					// Search
-					key := uint32(rng.Int31n(int32(nextID)))
+					key := uint64(rng.Int63n(nextID))
 					if _, err := table.findKey(key); err != nil {
 						cleanup()
 						b.Fatal(err)
@@ -428,31 +870,41 @@ func BenchmarkMixedWorkload(b *testing.B) {
 }
 
 func BenchmarkGetNodeMaxKey(b *testing.B) {
+	table, cleanup := setupBenchmarkTable(b)
+	defer cleanup()
+	populateTable(b, table, 200)
+
 	b.Run("LeafNode", func(b *testing.B) {
-		node := make([]byte, pageSize)
-		initializeLeafNode(node)
-		*leafNodeNumCells(node) = uint32(LeafNodeMaxCells)
-		for i := range LeafNodeMaxCells {
-			*leafNodeKey(node, uint32(i)) = uint32(i) * 10
+		pageNum, err := table.rightmostLeafPage(table.rootPageNum)
+		if err != nil {
+			b.Fatal(err)
+		}
+		node, err := table.pager.getPage(pageNum)
+		if err != nil {
+			b.Fatal(err)
 		}
 
 		b.ResetTimer()
 		for range b.N {
-			_ = getNodeMaxKey(node)
+			if _, err := table.getNodeMaxKey(node); err != nil {
+				b.Fatal(err)
+			}
 		}
 	})
 
+	// The root is internal after 200 rows, so this includes the descent to
+	// the last leaf
 	b.Run("InternalNode", func(b *testing.B) {
-		node := make([]byte, pageSize)
-		initializeInternalNode(node)
-		*internalNodeNumKeys(node) = InternalNodeMaxKeys
-		for i := range InternalNodeMaxKeys {
-			*internalNodeKey(node, uint32(i)) = uint32(i+1) * 100
+		node, err := table.pager.getPage(table.rootPageNum)
+		if err != nil {
+			b.Fatal(err)
 		}
 
 		b.ResetTimer()
 		for range b.N {
-			_ = getNodeMaxKey(node)
+			if _, err := table.getNodeMaxKey(node); err != nil {
+				b.Fatal(err)
+			}
 		}
 	})
 }
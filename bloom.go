@@ -0,0 +1,71 @@
+package main
+
+import "math"
+
+// bloomFilter is a fixed-size bloom filter over B-tree keys. It answers "maybe
+// present" or "definitely absent", letting point lookups for keys that were
+// never inserted skip the tree descent entirely.
+type bloomFilter struct {
+	bits     []uint64
+	numHash  uint32
+	capacity int // number of keys the filter was sized for
+	count    int // number of keys added so far
+}
+
+// bloomFalsePositiveRate is the target false positive rate at full capacity.
+const bloomFalsePositiveRate = 0.01
+
+// bloomMinCapacity keeps tiny tables from building degenerate filters.
+const bloomMinCapacity = 1024
+
+// newBloomFilter sizes a filter for capacity keys at bloomFalsePositiveRate.
+func newBloomFilter(capacity int) *bloomFilter {
+	capacity = max(capacity, bloomMinCapacity)
+	// m = -n*ln(p) / ln(2)^2, k = m/n * ln(2)
+	numBits := math.Ceil(-float64(capacity) * math.Log(bloomFalsePositiveRate) / (math.Ln2 * math.Ln2))
+	numHash := uint32(math.Round(numBits / float64(capacity) * math.Ln2))
+	return &bloomFilter{
+		bits:     make([]uint64, (int(numBits)+63)/64),
+		numHash:  max(numHash, 1),
+		capacity: capacity,
+	}
+}
+
+// hashes derives the two base hashes used for double hashing (Kirsch-Mitzenmacher).
+func (f *bloomFilter) hashes(key uint64) (uint64, uint64) {
+	// splitmix64 finalizer spreads sequential keys across the whole bit array
+	h := key + 0x9e3779b97f4a7c15
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	h ^= h >> 31
+	return h, h>>32 | 1
+}
+
+func (f *bloomFilter) add(key uint64) {
+	h1, h2 := f.hashes(key)
+	numBits := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.numHash); i++ {
+		bit := (h1 + i*h2) % numBits
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+// mayContain reports false only if key was definitely never added.
+func (f *bloomFilter) mayContain(key uint64) bool {
+	h1, h2 := f.hashes(key)
+	numBits := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.numHash); i++ {
+		bit := (h1 + i*h2) % numBits
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// full reports whether the filter holds more keys than it was sized for, at
+// which point its false positive rate starts to degrade.
+func (f *bloomFilter) full() bool {
+	return f.count > f.capacity
+}
@@ -1,11 +1,36 @@
 package main
 
 import (
+	"encoding/binary"
 	"fmt"
+	"math"
+	"math/bits"
 	"unsafe"
 )
 
-// TODO: Use proper go structs with serialization instead of byte arrays and unsafe
+// Nodes are read and written in place in their page. Multi-byte fields are
+// stored little endian whatever the byte order of the machine; the accessors
+// below return them as a field16, field32 or field64 over the bytes of the
+// field, whose get and set methods do the conversion.
+type (
+	field16 []byte
+	field32 []byte
+	field64 []byte
+)
+
+// field16At, field32At and field64At return the field at offset in b. The
+// fields have their exact size, which lets the compiler drop the bounds
+// checks of their get and set methods.
+func field16At(b []byte, offset int) field16 { return field16(b[offset : offset+2]) }
+func field32At(b []byte, offset int) field32 { return field32(b[offset : offset+4]) }
+func field64At(b []byte, offset int) field64 { return field64(b[offset : offset+8]) }
+
+func (f field16) get() uint16  { return binary.LittleEndian.Uint16(f) }
+func (f field16) set(v uint16) { binary.LittleEndian.PutUint16(f, v) }
+func (f field32) get() uint32  { return binary.LittleEndian.Uint32(f) }
+func (f field32) set(v uint32) { binary.LittleEndian.PutUint32(f, v) }
+func (f field64) get() uint64  { return binary.LittleEndian.Uint64(f) }
+func (f field64) set(v uint64) { binary.LittleEndian.PutUint64(f, v) }
 
 // NodeType represents the type of a B-tree node.
 // It can be either an internal node or a leaf node.
@@ -14,6 +39,9 @@ type NodeType uint8
 const (
 	NodeTypeInternal NodeType = iota
 	NodeTypeLeaf
+	// Nodes of index trees, see index.go
+	NodeTypeIndexInternal
+	NodeTypeIndexLeaf
 )
 
 // Common node header Layout used by both internal and leaf nodes.
@@ -29,31 +57,50 @@ const (
 
 // Leaf node header Layout.
 const (
-	LeafNodeNumCellsSize   = int(unsafe.Sizeof(uint32(0)))
-	LeafNodeNumCellsOffset = CommonHeaderSize
-	LeafNodeNextLeafSize   = int(unsafe.Sizeof(uint32(0)))
-	LeafNodeNextLeafOffset = LeafNodeNumCellsOffset + LeafNodeNumCellsSize
-	LeafNodeHeaderSize     = CommonHeaderSize + LeafNodeNumCellsSize + LeafNodeNextLeafSize
+	LeafNodeNumCellsSize     = int(unsafe.Sizeof(uint32(0)))
+	LeafNodeNumCellsOffset   = CommonHeaderSize
+	LeafNodeNextLeafSize     = int(unsafe.Sizeof(uint32(0)))
+	LeafNodeNextLeafOffset   = LeafNodeNumCellsOffset + LeafNodeNumCellsSize
+	LeafNodeContentStartSize = int(unsafe.Sizeof(uint16(0)))
+	LeafNodeContentOffset    = LeafNodeNextLeafOffset + LeafNodeNextLeafSize
+	LeafNodePrefixLenSize    = int(unsafe.Sizeof(uint8(0)))
+	LeafNodePrefixLenOffset  = LeafNodeContentOffset + LeafNodeContentStartSize
+	LeafNodePrefixSize       = int(unsafe.Sizeof(uint64(0)))
+	LeafNodePrefixOffset     = LeafNodePrefixLenOffset + LeafNodePrefixLenSize
+	LeafNodeHeaderSize       = CommonHeaderSize + LeafNodeNumCellsSize + LeafNodeNextLeafSize + LeafNodeContentStartSize +
+		LeafNodePrefixLenSize + LeafNodePrefixSize
 )
 
-// Leaf node body Layout.
+// Leaf node body Layout. LeafNodeKeyOffset and LeafNodeValueOffset describe
+// cells outside a page (see leafNodeCellsIn), which hold their key in full.
 const (
-	LeafNodeKeySize       = int(unsafe.Sizeof(uint32(0)))
-	LeafNodeKeyOffset     = 0
-	LeafNodeValueSize     = rowSize
-	LeafNodeValueOffset   = LeafNodeKeyOffset + LeafNodeKeySize
-	LeafNodeCellSize      = LeafNodeKeySize + LeafNodeValueSize
-	LeafNodeSpaceForCells = pageSize - LeafNodeHeaderSize
-	LeafNodeMaxCells      = LeafNodeSpaceForCells / LeafNodeCellSize
-)
-
-const (
-	LeafNodeRightSplitCount = (LeafNodeMaxCells + 1) / 2 // +1 is for the new cell we are adding
-	LeafNodeLeftSplitCount  = (LeafNodeMaxCells + 1) - LeafNodeRightSplitCount
+	LeafNodeCellPointerSize = int(unsafe.Sizeof(uint16(0)))
+	LeafNodeKeySize         = int(unsafe.Sizeof(uint64(0)))
+	LeafNodeKeyOffset       = 0
+	LeafNodeValueOffset     = LeafNodeKeyOffset + LeafNodeKeySize
+	LeafNodeMaxCellSize     = LeafNodeKeySize + rowSize
+	LeafNodeSpaceForCells   = pageUsableSize - LeafNodeHeaderSize
+	// A non-root leaf using fewer bytes than this for its cells and cell
+	// pointers borrows from or merges with a sibling on delete
+	LeafNodeMinUsedSpace = LeafNodeSpaceForCells / 3
 )
 
 // Leaf Node Page Layout
 //
+// Leaves are slotted pages. Cells hold a key and a serialized row, whose size
+// depends on its values. They are stored in a heap growing down from the end
+// of the page, in no particular order, and an array of cell pointers growing
+// up after the header lists their offsets in key order. The heap is kept
+// packed: removing a cell moves the cells below it up, so the free space is
+// always the gap between the pointer array and the start of the heap.
+//
+// The high-order bytes all keys of a leaf share are stored once, in the
+// header: PrefixLen says how many there are and Prefix holds them (its other
+// bytes are zero). Cells only store the remaining 8-PrefixLen low-order bytes
+// of their key, little endian, so leaves of nearby keys fit more cells. A key
+// that does not share the prefix shortens it when it is inserted, which
+// rewrites the cells already there with longer keys.
+//
 //   0                   1                   2                   3
 //   0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
@@ -63,36 +110,39 @@ const (
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 //  |                    LeafNodeNextLeaf (uint32)                  |  bytes 10..13
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-//  |                        Cell[0]                                |  bytes 14..(14+CellSize-1)
-//  |  Key (u32)  |                Value (rowSize bytes)            |
+//  |  ContentStart (uint16)        |  PrefixLen    |               |  bytes 14..16
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-//  |                        Cell[1]                                |  bytes (14+1*CellSize)..(14+2*CellSize-1)
-//  |  Key (u32)  |                Value (rowSize bytes)            |
+//  |                 Prefix (u64), from byte 17 ...                |  bytes 17..24
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-//  |                              ...                              |
+//  |  CellPointer[0]  ...          |  CellPointer[NumCells-1]      |  bytes Hdr..(Hdr+2*NumCells-1)
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-//  |                        Cell[i]                                |  i in [0..NumCells-1]
-//  |  Key @ (Hdr+i*CellSize) .. (Hdr+i*CellSize+3)                 |
-//  |  Val @ (Hdr+i*CellSize+4) .. (Hdr+(i+1)*CellSize-1)           |
+//  |                          Free Space                           |  bytes (Hdr+2*NumCells)..(ContentStart-1)
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-//  |                          Free Space                           |  bytes (Hdr+NumCells*CellSize)..(pageSize-1)
+//  |                         Cell Heap                             |  bytes ContentStart..(pageUsableSize-1)
+//  |  Key suffix  |  Value (serialized row, variable size)  | ...  |
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 
 // Internal node header layout
 const (
-	InternalNodeNumKeysSize      = int(unsafe.Sizeof(uint32(0)))
-	InternalNodeNumKeysOffset    = CommonHeaderSize
-	InternalNodeRightChildSize   = int(unsafe.Sizeof(uint32(0)))
-	InternalNodeRightChildOffset = InternalNodeNumKeysOffset + InternalNodeNumKeysSize
-	InternalNodeHeaderSize       = CommonHeaderSize + InternalNodeNumKeysSize + InternalNodeRightChildSize
+	InternalNodeNumKeysSize       = int(unsafe.Sizeof(uint32(0)))
+	InternalNodeNumKeysOffset     = CommonHeaderSize
+	InternalNodeRightChildSize    = int(unsafe.Sizeof(uint32(0)))
+	InternalNodeRightChildOffset  = InternalNodeNumKeysOffset + InternalNodeNumKeysSize
+	InternalNodeNextSiblingSize   = int(unsafe.Sizeof(uint32(0)))
+	InternalNodeNextSiblingOffset = InternalNodeRightChildOffset + InternalNodeRightChildSize
+	InternalNodePrevSiblingSize   = int(unsafe.Sizeof(uint32(0)))
+	InternalNodePrevSiblingOffset = InternalNodeNextSiblingOffset + InternalNodeNextSiblingSize
+	InternalNodeHeaderSize        = CommonHeaderSize + InternalNodeNumKeysSize + InternalNodeRightChildSize +
+		InternalNodeNextSiblingSize + InternalNodePrevSiblingSize
 )
 
 // Internal node body layout
 const (
-	InternalNodeKeySize   = int(unsafe.Sizeof(uint32(0)))
+	InternalNodeKeySize   = int(unsafe.Sizeof(uint64(0)))
 	InternalNodeChildSize = int(unsafe.Sizeof(uint32(0)))
 	InternalNodeCellSize  = InternalNodeKeySize + InternalNodeChildSize
-	InternalNodeMaxKeys   = 3
+	// As many cells as fit in the usable part of the page after the header
+	InternalNodeMaxKeys = (pageUsableSize - InternalNodeHeaderSize) / InternalNodeCellSize
 )
 
 // Internal node split constants
@@ -102,6 +152,8 @@ const (
 const (
 	InternalNodeRightSplitCount = (InternalNodeMaxKeys + 1) / 2                               // Keys that go to right node
 	InternalNodeLeftSplitCount  = (InternalNodeMaxKeys + 1) - InternalNodeRightSplitCount - 1 // Keys that stay in left node (-1 for key promoted to parent)
+	// A non-root internal node with fewer keys than this borrows from or merges with a sibling on delete
+	InternalNodeMinKeys = InternalNodeMaxKeys / 2
 )
 
 // Internal Node Layout
@@ -110,6 +162,9 @@ const (
 // Each cell in the body consists of a (child_pointer, key) pair.
 // The header also contains an additional "right child" pointer,
 // making a total of (num_keys + 1) child pointers per internal node.
+// Internal nodes on the same level are linked through next/prev sibling
+// pointers (0 means no sibling, page 0 is the file header), so a level can be
+// walked left to right without going back through the parents.
 //
 // Internal Node Page Layout:
 //
@@ -122,11 +177,15 @@ const (
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 //  |                    InternalNodeRightChild (uint32)            |  bytes 10..13
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//  |                    InternalNodeNextSibling (uint32)           |  bytes 14..17
+//  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//  |                    InternalNodePrevSibling (uint32)           |  bytes 18..21
+//  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 //  |                           Cell[0]                             |  bytes Hdr..(Hdr+CellSize-1)
-//  |  Child (u32) |                    Key (u32)                   |
+//  |  Child (u32) |                    Key (u64)                   |
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 //  |                           Cell[1]                             |  bytes (Hdr+1*CellSize)..(Hdr+2*CellSize-1)
-//  |  Child (u32) |                    Key (u32)                   |
+//  |  Child (u32) |                    Key (u64)                   |
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 //  |                              ...                              |
 //  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
@@ -139,31 +198,295 @@ const (
 
 // Leaf node helper functions
 
-func leafNodeNumCells(node []byte) *uint32 {
-	return (*uint32)(unsafe.Pointer(&node[LeafNodeNumCellsOffset]))
+func leafNodeNumCells(node []byte) field32 {
+	return field32At(node, LeafNodeNumCellsOffset)
 }
+
+// leafNodeContentStart returns the offset of the first byte of the cell heap.
+func leafNodeContentStart(node []byte) field16 {
+	return field16At(node, LeafNodeContentOffset)
+}
+
+func leafNodeCellPointer(node []byte, cellNum uint32) field16 {
+	return field16At(node, LeafNodeHeaderSize+int(cellNum)*LeafNodeCellPointerSize)
+}
+
+// leafNodePrefixLen returns how many high-order bytes every key of the leaf
+// shares with its prefix.
+func leafNodePrefixLen(node []byte) int {
+	return int(node[LeafNodePrefixLenOffset])
+}
+
+func leafNodePrefix(node []byte) field64 {
+	return field64At(node, LeafNodePrefixOffset)
+}
+
+// setLeafNodePrefix makes the first prefixLen bytes of key the prefix of the
+// leaf, without touching its cells.
+func setLeafNodePrefix(node []byte, prefixLen int, key uint64) {
+	node[LeafNodePrefixLenOffset] = uint8(prefixLen)
+	leafNodePrefix(node).set(key &^ keySuffixMask(prefixLen))
+}
+
+// keySuffixMask returns the mask of the low-order bytes of a key left after
+// a prefix of prefixLen bytes.
+func keySuffixMask(prefixLen int) uint64 {
+	if prefixLen == 0 {
+		return math.MaxUint64
+	}
+	return 1<<(8*(LeafNodeKeySize-prefixLen)) - 1
+}
+
+// sharedKeyBytes returns how many high-order bytes a and b have in common.
+func sharedKeyBytes(a, b uint64) int {
+	return bits.LeadingZeros64(a^b) / 8
+}
+
+// leafNodeCell returns the bytes of cell cellNum as the page stores them,
+// with the key suffix.
 func leafNodeCell(node []byte, cellNum uint32) []byte {
-	start := LeafNodeHeaderSize + int(cellNum)*LeafNodeCellSize
-	end := start + LeafNodeCellSize
-	return node[start:end]
+	start := int(leafNodeCellPointer(node, cellNum).get())
+	valueStart := start + LeafNodeKeySize - leafNodePrefixLen(node)
+	return node[start : valueStart+serializedRowLen(node[valueStart:])]
 }
-func leafNodeKey(node []byte, cellNum uint32) *uint32 {
-	cell := leafNodeCell(node, cellNum)
-	return (*uint32)(unsafe.Pointer(&cell[LeafNodeKeyOffset]))
+
+func leafNodeKey(node []byte, cellNum uint32) uint64 {
+	start := int(leafNodeCellPointer(node, cellNum).get())
+	var suffix [8]byte
+	copy(suffix[:], node[start:start+LeafNodeKeySize-leafNodePrefixLen(node)])
+	return leafNodePrefix(node).get() | binary.LittleEndian.Uint64(suffix[:])
 }
+
 func leafNodeValue(node []byte, cellNum uint32) []byte {
 	cell := leafNodeCell(node, cellNum)
-	return cell[LeafNodeValueOffset : LeafNodeValueOffset+LeafNodeValueSize]
+	return cell[LeafNodeKeySize-leafNodePrefixLen(node):]
+}
+
+// leafCellKey returns the key of a cell outside a page.
+func leafCellKey(cell []byte) uint64 {
+	return binary.LittleEndian.Uint64(cell[LeafNodeKeyOffset:])
+}
+
+// setLeafCellKey sets the key of a cell outside a page.
+func setLeafCellKey(cell []byte, key uint64) {
+	binary.LittleEndian.PutUint64(cell[LeafNodeKeyOffset:], key)
+}
+
+// leafNodeUsedSpace returns the number of bytes taken by the cells of the
+// leaf and their pointers.
+func leafNodeUsedSpace(node []byte) int {
+	return pageUsableSize - int(leafNodeContentStart(node).get()) + int(leafNodeNumCells(node).get())*LeafNodeCellPointerSize
+}
+
+// leafCellsSpace returns the number of bytes cells outside a page, in key
+// order, would take in one leaf with the key prefix they share.
+func leafCellsSpace(cells [][]byte) int {
+	if len(cells) == 0 {
+		return 0
+	}
+	prefixLen := sharedKeyBytes(leafCellKey(cells[0]), leafCellKey(cells[len(cells)-1]))
+	space := 0
+	for _, cell := range cells {
+		space += len(cell) - prefixLen + LeafNodeCellPointerSize
+	}
+	return space
+}
+
+// leafNodeMergedSpace returns the number of bytes the cells of left and
+// right, whose keys all come after the ones of left, would take in one leaf.
+func leafNodeMergedSpace(left, right []byte) int {
+	numLeft, numRight := int(leafNodeNumCells(left).get()), int(leafNodeNumCells(right).get())
+	if numLeft == 0 || numRight == 0 {
+		return leafNodeUsedSpace(left) + leafNodeUsedSpace(right)
+	}
+	prefixLen := min(leafNodePrefixLen(left), leafNodePrefixLen(right),
+		sharedKeyBytes(leafNodeKey(left, 0), leafNodeKey(right, uint32(numRight-1))))
+	return leafNodeUsedSpace(left) + numLeft*(leafNodePrefixLen(left)-prefixLen) +
+		leafNodeUsedSpace(right) + numRight*(leafNodePrefixLen(right)-prefixLen)
+}
+
+// leafNodeInsertCell makes room for a cell holding key and a value of size
+// bytes at index cellNum, shifting the pointers of the cells after it right,
+// and returns the value's bytes for the caller to fill. A key that does not
+// share the leaf's prefix shortens it first. It returns nil if the leaf has
+// no room left.
+func leafNodeInsertCell(node []byte, cellNum uint32, key uint64, size int) []byte {
+	numCells := leafNodeNumCells(node).get()
+	if numCells == 0 {
+		setLeafNodePrefix(node, LeafNodeKeySize, key)
+	}
+	prefixLen := min(leafNodePrefixLen(node), sharedKeyBytes(key, leafNodePrefix(node).get()))
+	grow := int(numCells) * (leafNodePrefixLen(node) - prefixLen)
+	if leafNodeUsedSpace(node)+grow+LeafNodeCellPointerSize+LeafNodeKeySize-prefixLen+size > LeafNodeSpaceForCells {
+		return nil
+	}
+	if prefixLen < leafNodePrefixLen(node) {
+		shortenLeafNodePrefix(node, prefixLen)
+	}
+	return leafNodeAddCell(node, cellNum, key, size)
+}
+
+// leafNodeAddCell is leafNodeInsertCell for a key that shares the leaf's
+// prefix, in a leaf known to have room for the cell.
+func leafNodeAddCell(node []byte, cellNum uint32, key uint64, size int) []byte {
+	numCells := leafNodeNumCells(node).get()
+	pointers := node[LeafNodeHeaderSize : LeafNodeHeaderSize+int(numCells+1)*LeafNodeCellPointerSize]
+	copy(pointers[int(cellNum+1)*LeafNodeCellPointerSize:], pointers[int(cellNum)*LeafNodeCellPointerSize:])
+
+	suffixLen := LeafNodeKeySize - leafNodePrefixLen(node)
+	start := int(leafNodeContentStart(node).get()) - suffixLen - size
+	leafNodeContentStart(node).set(uint16(start))
+	leafNodeNumCells(node).set(numCells + 1)
+	leafNodeCellPointer(node, cellNum).set(uint16(start))
+	var suffix [8]byte
+	binary.LittleEndian.PutUint64(suffix[:], key)
+	copy(node[start:start+suffixLen], suffix[:])
+	return node[start+suffixLen : start+suffixLen+size]
+}
+
+// shortenLeafNodePrefix rewrites the cells of the leaf for a prefix of
+// prefixLen bytes, shorter than the current one. The caller makes sure the
+// longer cells fit.
+func shortenLeafNodePrefix(node []byte, prefixLen int) {
+	buf := getPageBuffer()
+	defer putPageBuffer(buf)
+	cells := leafNodeCellsIn(node, buf[:])
+	leafNodeClearCells(node)
+	setLeafNodePrefix(node, prefixLen, leafNodePrefix(node).get())
+	for i, cell := range cells {
+		copy(leafNodeAddCell(node, uint32(i), leafCellKey(cell), len(cell)-LeafNodeValueOffset), cell[LeafNodeValueOffset:])
+	}
+}
+
+// leafNodeAppendCells appends copies of cells outside a page, which must not
+// alias node, in order after the last cell of the leaf. The caller makes
+// sure they fit, see leafCellsSpace and leafNodeMergedSpace.
+func leafNodeAppendCells(node []byte, cells [][]byte) {
+	if len(cells) == 0 {
+		return
+	}
+	last := leafCellKey(cells[len(cells)-1])
+	if leafNodeNumCells(node).get() == 0 {
+		setLeafNodePrefix(node, sharedKeyBytes(leafCellKey(cells[0]), last), last)
+	} else if prefixLen := sharedKeyBytes(last, leafNodePrefix(node).get()); prefixLen < leafNodePrefixLen(node) {
+		// The keys in between share at least as much
+		shortenLeafNodePrefix(node, prefixLen)
+	}
+	for _, cell := range cells {
+		value := leafNodeAddCell(node, leafNodeNumCells(node).get(), leafCellKey(cell), len(cell)-LeafNodeValueOffset)
+		copy(value, cell[LeafNodeValueOffset:])
+	}
+}
+
+// leafNodeCellsIn returns the cells of the leaf in key order, copied out of
+// the page into buf with their keys in full. buf is replaced by a larger one
+// if it is too small for them.
+func leafNodeCellsIn(node []byte, buf []byte) [][]byte {
+	numCells := leafNodeNumCells(node).get()
+	size := leafNodeUsedSpace(node) + int(numCells)*(leafNodePrefixLen(node)-LeafNodeCellPointerSize)
+	if len(buf) < size {
+		buf = make([]byte, size)
+	}
+	cells := make([][]byte, numCells)
+	for i := range numCells {
+		value := leafNodeValue(node, i)
+		cell := buf[:LeafNodeValueOffset+len(value)]
+		buf = buf[len(cell):]
+		setLeafCellKey(cell, leafNodeKey(node, i))
+		copy(cell[LeafNodeValueOffset:], value)
+		cells[i] = cell
+	}
+	return cells
 }
+
+// leafNodeClearCells removes every cell of the leaf, keeping its header.
+func leafNodeClearCells(node []byte) {
+	leafNodeNumCells(node).set(0)
+	leafNodeContentStart(node).set(pageUsableSize)
+}
+
+// leafSplitPoint returns the number of cells, in key order, that go to the
+// left node when cells are split into two leaves: the left node gets the
+// first cells up to leftPercent of their total size, and each side gets at
+// least one. The point then moves towards the side with room left if the
+// cells of the other one do not fit a leaf with the key prefix they share.
+func leafSplitPoint(cells [][]byte, leftPercent int) int {
+	total := 0
+	for _, cell := range cells {
+		total += len(cell) + LeafNodeCellPointerSize
+	}
+	split := len(cells) - 1
+	left := 0
+	for i, cell := range cells {
+		left += len(cell) + LeafNodeCellPointerSize
+		if 100*left >= leftPercent*total {
+			split = min(i+1, len(cells)-1)
+			break
+		}
+	}
+	for split > 1 && leafCellsSpace(cells[:split]) > LeafNodeSpaceForCells {
+		split--
+	}
+	for split < len(cells)-1 && leafCellsSpace(cells[split:]) > LeafNodeSpaceForCells {
+		split++
+	}
+	return split
+}
+
+// leafNodeFindKey returns the cell index holding key, or the index where key
+// would be inserted if the leaf does not contain it.
+func leafNodeFindKey(node []byte, key uint64) uint32 {
+	// Binary search
+	i, j := uint32(0), leafNodeNumCells(node).get()
+	for i != j {
+		mid := (i + j) / 2
+		midKey := leafNodeKey(node, mid)
+		if key == midKey {
+			return mid
+		}
+		if key < midKey {
+			j = mid
+		} else {
+			i = mid + 1
+		}
+	}
+	return i
+}
+
+// leafNodeRemoveCell deletes cell cellNum, shifting the pointers after it
+// left and the cells below it in the heap up to keep the heap packed.
+func leafNodeRemoveCell(node []byte, cellNum uint32) {
+	numCells := leafNodeNumCells(node).get()
+	contentStart := int(leafNodeContentStart(node).get())
+	offset := int(leafNodeCellPointer(node, cellNum).get())
+	size := len(leafNodeCell(node, cellNum))
+
+	copy(node[contentStart+size:offset+size], node[contentStart:offset])
+	pointers := node[LeafNodeHeaderSize : LeafNodeHeaderSize+int(numCells)*LeafNodeCellPointerSize]
+	copy(pointers[int(cellNum)*LeafNodeCellPointerSize:], pointers[int(cellNum+1)*LeafNodeCellPointerSize:])
+	leafNodeNumCells(node).set(numCells - 1)
+	leafNodeContentStart(node).set(uint16(contentStart + size))
+	for i := range numCells - 1 {
+		if p := leafNodeCellPointer(node, i); int(p.get()) < offset {
+			p.set(p.get() + uint16(size))
+		}
+	}
+}
+
 func initializeLeafNode(node []byte) {
-	*nodeType(node) = NodeTypeLeaf
+	setNodeType(node, NodeTypeLeaf)
 	setNodeRoot(node, false)
-	*leafNodeNumCells(node) = 0
-	*leafNodeNextLeaf(node) = 0 // 0 means no sibling
+	leafNodeClearCells(node)
+	setLeafNodePrefix(node, LeafNodeKeySize, 0)
+	leafNodeNextLeaf(node).set(0) // 0 means no sibling
 }
 
-func nodeType(node []byte) *NodeType {
-	return (*NodeType)(unsafe.Pointer(&node[NodeTypeOffset]))
+func nodeType(node []byte) NodeType {
+	return NodeType(node[NodeTypeOffset])
+}
+
+func setNodeType(node []byte, t NodeType) {
+	node[NodeTypeOffset] = uint8(t)
 }
 
 func isNodeRoot(node []byte) bool {
@@ -178,18 +501,26 @@ func setNodeRoot(node []byte, isRoot bool) {
 	}
 }
 
-func leafNodeNextLeaf(node []byte) *uint32 {
-	return (*uint32)(unsafe.Pointer(&node[LeafNodeNextLeafOffset]))
+func leafNodeNextLeaf(node []byte) field32 {
+	return field32At(node, LeafNodeNextLeafOffset)
 }
 
 // Internal node helper functions
 
-func internalNodeNumKeys(node []byte) *uint32 {
-	return (*uint32)(unsafe.Pointer(&node[InternalNodeNumKeysOffset]))
+func internalNodeNumKeys(node []byte) field32 {
+	return field32At(node, InternalNodeNumKeysOffset)
+}
+
+func internalNodeRightChild(node []byte) field32 {
+	return field32At(node, InternalNodeRightChildOffset)
+}
+
+func internalNodeNextSibling(node []byte) field32 {
+	return field32At(node, InternalNodeNextSiblingOffset)
 }
 
-func internalNodeRightChild(node []byte) *uint32 {
-	return (*uint32)(unsafe.Pointer(&node[InternalNodeRightChildOffset]))
+func internalNodePrevSibling(node []byte) field32 {
+	return field32At(node, InternalNodePrevSiblingOffset)
 }
 
 func internalNodeCell(node []byte, cellNum uint32) []byte {
@@ -198,32 +529,38 @@ func internalNodeCell(node []byte, cellNum uint32) []byte {
 	return node[start:end]
 }
 
-func internalNodeKey(node []byte, cellNum uint32) *uint32 {
+func internalNodeKey(node []byte, cellNum uint32) field64 {
 	offset := InternalNodeHeaderSize + int(cellNum)*InternalNodeCellSize + InternalNodeChildSize
-	return (*uint32)(unsafe.Pointer(&node[offset]))
+	return field64At(node, offset)
 }
 
-func internalNodeChild(node []byte, cellNum uint32) *uint32 {
-	numKeys := *internalNodeNumKeys(node)
+func internalNodeChild(node []byte, cellNum uint32) field32 {
+	numKeys := internalNodeNumKeys(node).get()
 	if cellNum > numKeys {
 		panic(fmt.Sprintf("Tried to access child_num %d > num_keys %d", cellNum, numKeys))
 	} else if cellNum == numKeys {
 		return internalNodeRightChild(node)
 	} else {
-		cell := internalNodeCell(node, cellNum)
-		return (*uint32)(unsafe.Pointer(&cell[0]))
+		return field32At(internalNodeCell(node, cellNum), 0)
 	}
 }
 
+// routesLeftOf reports whether key belongs to the child on the left of the
+// separator key sep in an internal node. Separators are the max key of their
+// left child, so a key equal to the separator lives on the left.
+func routesLeftOf(key uint64, sep uint64) bool {
+	return key <= sep
+}
+
 // internalNodeFindChild returns the index of the child pointer which should contain the given key
-func internalNodeFindChild(node []byte, key uint32) uint32 {
+func internalNodeFindChild(node []byte, key uint64) uint32 {
 	// Binary search
-	numKeys := *internalNodeNumKeys(node)
+	numKeys := internalNodeNumKeys(node).get()
 	i, j := uint32(0), numKeys
 	for i != j {
 		mid := (i + j) / 2
-		midKey := *internalNodeKey(node, mid)
-		if key < midKey {
+		midKey := internalNodeKey(node, mid).get()
+		if routesLeftOf(key, midKey) {
 			j = mid
 		} else {
 			i = mid + 1
@@ -232,54 +569,52 @@ func internalNodeFindChild(node []byte, key uint32) uint32 {
 	return i
 }
 
-func getNodeMaxKey(node []byte) uint32 {
-	nType := *nodeType(node)
-	if nType == NodeTypeLeaf {
-		numCells := *leafNodeNumCells(node)
-		return *leafNodeKey(node, numCells-1)
-	} else if nType == NodeTypeInternal {
-		numKeys := *internalNodeNumKeys(node)
-		return *internalNodeKey(node, numKeys-1)
-	} else {
-		panic("Unknown node type")
-	}
-}
-
 func initializeInternalNode(node []byte) {
-	*nodeType(node) = NodeTypeInternal
+	setNodeType(node, NodeTypeInternal)
 	setNodeRoot(node, false)
-	*internalNodeNumKeys(node) = 0
+	internalNodeNumKeys(node).set(0)
+	internalNodeNextSibling(node).set(0) // 0 means no sibling
+	internalNodePrevSibling(node).set(0)
 }
 
-func nodeParent(node []byte) *uint32 {
-	return (*uint32)(unsafe.Pointer(&node[ParentPointerOffset]))
+func nodeParent(node []byte) field32 {
+	return field32At(node, ParentPointerOffset)
 }
 
-func updateInternalNodeKey(node []byte, oldKey uint32, newKey uint32) {
+func updateInternalNodeKey(node []byte, oldKey uint64, newKey uint64) {
 	oldChildIndex := internalNodeFindChild(node, oldKey)
-	*internalNodeKey(node, oldChildIndex) = newKey
+	internalNodeKey(node, oldChildIndex).set(newKey)
 }
 
 // internalNodeFindChildByPage returns the index of the child with the given page number.
 // Returns numKeys if the child is the right child.
 // Panics if the child is not found.
 func internalNodeFindChildByPage(node []byte, childPageNum uint32) uint32 {
-	numKeys := *internalNodeNumKeys(node)
+	numKeys := internalNodeNumKeys(node).get()
 	for i := uint32(0); i < numKeys; i++ {
-		if *internalNodeChild(node, i) == childPageNum {
+		if internalNodeChild(node, i).get() == childPageNum {
 			return i
 		}
 	}
-	if *internalNodeRightChild(node) == childPageNum {
+	if internalNodeRightChild(node).get() == childPageNum {
 		return numKeys
 	}
 	panic("child not found in parent")
 }
 
-// internalNodeChildPtr returns a pointer to the child page number at the given cell index.
+// internalNodeRemoveCell deletes cell cellNum (a child pointer and its key),
+// shifting the cells after it left. The right child is left alone.
+func internalNodeRemoveCell(node []byte, cellNum uint32) {
+	numKeys := internalNodeNumKeys(node).get()
+	for i := cellNum; i+1 < numKeys; i++ {
+		copy(internalNodeCell(node, i), internalNodeCell(node, i+1))
+	}
+	internalNodeNumKeys(node).set(numKeys - 1)
+}
+
+// internalNodeChildPtr returns the child page number field at the given cell index.
 // Unlike internalNodeChild which handles the right child case specially,
-// this returns the raw pointer to the child field in the cell.
-func internalNodeChildPtr(node []byte, cellNum uint32) *uint32 {
-	cell := internalNodeCell(node, cellNum)
-	return (*uint32)(unsafe.Pointer(&cell[0]))
+// this returns the child field in the cell even for cellNum == numKeys.
+func internalNodeChildPtr(node []byte, cellNum uint32) field32 {
+	return field32At(internalNodeCell(node, cellNum), 0)
 }
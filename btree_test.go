@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestTable opens a new database in a temporary directory with opts and
+// returns its default table.
+func openTestTable(t *testing.T, opts ...Option) *Table {
+	t.Helper()
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "test.db"), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	table, err := db.Table(defaultTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+// newTestLeaf puts a leaf holding a row for each of keys on a new page and
+// returns the page number.
+func newTestLeaf(t *testing.T, table *Table, keys ...uint64) uint32 {
+	t.Helper()
+	pageNum, err := table.pager.getUnusedPageNum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := table.pager.getPageForWrite(pageNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initializeLeafNode(page)
+	for i, key := range keys {
+		row := createRow(int64(key))
+		serializeRow(row, leafNodeInsertCell(page, uint32(i), key, serializedRowSize(row)))
+	}
+	return pageNum
+}
+
+func TestRootSplitSeparatesAtLeftMaxKey(t *testing.T) {
+	table := openTestTable(t)
+	var root []byte
+	var key int64
+	for ; root == nil || nodeType(root) == NodeTypeLeaf; key++ {
+		if err := table.Insert(createRow(key)); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		if root, err = table.pager.getPage(table.rootPageNum); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := internalNodeNumKeys(root).get(); n != 1 {
+		t.Fatalf("new root has %d keys, want 1", n)
+	}
+	left, err := table.pager.getPage(internalNodeChild(root, 0).get())
+	if err != nil {
+		t.Fatal(err)
+	}
+	leftMaxKey, err := table.getNodeMaxKey(left)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sep := internalNodeKey(root, 0).get(); sep != leftMaxKey {
+		t.Fatalf("root separator is %d, want the left child's max key %d", sep, leftMaxKey)
+	}
+	rootMaxKey, err := table.getNodeMaxKey(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rootMaxKey != uint64(key-1) {
+		t.Fatalf("root max key is %d, want %d", rootMaxKey, key-1)
+	}
+	problems, err := table.db.IntegrityCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 {
+		t.Fatalf("integrity check after root split: %v", problems)
+	}
+}
+
+// The separators a split sets in the parent must be the max keys of the whole
+// subtrees, not the last separators of the internal nodes.
+func TestInternalSplitSeparatesAtSubtreeMaxKeys(t *testing.T) {
+	table := openTestTable(t)
+	maxKeys := uint64(InternalNodeMaxKeys)
+
+	// Node is a full internal node under the root. Its children share a
+	// few leaves, as there are not enough pages for one leaf per key: the
+	// split only reads the max key of the rightmost child and the new one.
+	shared := []uint32{newTestLeaf(t, table, 0), newTestLeaf(t, table, 1), newTestLeaf(t, table, 2)}
+	rightChild := newTestLeaf(t, table, maxKeys)
+	child := newTestLeaf(t, table, maxKeys+1)
+	last := newTestLeaf(t, table, 1000)
+
+	nodePageNum, err := table.pager.getUnusedPageNum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := table.pager.getPageForWrite(nodePageNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initializeInternalNode(node)
+	nodeParent(node).set(table.rootPageNum)
+	internalNodeNumKeys(node).set(uint32(maxKeys))
+	for i := range uint32(maxKeys) {
+		internalNodeChildPtr(node, i).set(shared[int(i)%len(shared)])
+		internalNodeKey(node, i).set(uint64(i))
+	}
+	internalNodeRightChild(node).set(rightChild)
+
+	root, err := table.pager.getPageForWrite(table.rootPageNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initializeInternalNode(root)
+	setNodeRoot(root, true)
+	internalNodeNumKeys(root).set(1)
+	internalNodeChildPtr(root, 0).set(nodePageNum)
+	internalNodeKey(root, 0).set(maxKeys)
+	internalNodeRightChild(root).set(last)
+	table.invalidateLeafHint()
+
+	if err := table.internalNodeSplitAndInsert(nodePageNum, child); err != nil {
+		t.Fatal(err)
+	}
+
+	// The root now holds the old node, its new sibling and the last leaf
+	if n := internalNodeNumKeys(root).get(); n != 2 {
+		t.Fatalf("root has %d keys, want 2", n)
+	}
+	if got := internalNodeChild(root, 0).get(); got != nodePageNum {
+		t.Fatalf("root child 0 is page %d, want %d", got, nodePageNum)
+	}
+	if got, want := internalNodeKey(root, 0).get(), uint64(InternalNodeLeftSplitCount); got != want {
+		t.Fatalf("separator of the old node is %d, want %d", got, want)
+	}
+	if got := internalNodeKey(root, 1).get(); got != maxKeys+1 {
+		t.Fatalf("separator of the new sibling is %d, want its max key %d", got, maxKeys+1)
+	}
+	if got := internalNodeRightChild(root).get(); got != last {
+		t.Fatalf("root right child is page %d, want %d", got, last)
+	}
+
+	if _, found, err := table.Get(maxKeys + 1); err != nil {
+		t.Fatal(err)
+	} else if !found {
+		t.Fatalf("key %d is not found after the split", maxKeys+1)
+	}
+}
+
+func TestGrowingUpdateOnFullTableKeepsRow(t *testing.T) {
+	table := openTestTable(t)
+	// Fill the table until a split finds no page left
+	for id := 0; table.Insert(createRow(int64(id))) == nil; id++ {
+	}
+	n, err := table.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id := range 50 {
+		row := createRow(int64(id))
+		copy(row.Email[:], strings.Repeat("e", ColumnEmailSize))
+		found, err := table.Update(row)
+		if !found || (err != nil && !errors.Is(err, ErrTableFull)) {
+			t.Fatalf("Update(%d) = %v, %v, want found and nil or ErrTableFull", id, found, err)
+		}
+	}
+
+	if count, err := table.Count(); err != nil || count != n {
+		t.Fatalf("Count() = %d, %v after growing updates, want %d", count, err, n)
+	}
+	for id := range n {
+		if _, found, err := table.Get(uint64(id)); !found || err != nil {
+			t.Fatalf("Get(%d) = %v, %v after growing updates, want the row", id, found, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// bulkLoadFillPercent is how full BulkLoad packs leaves, in percent of their
+// space for cells. The room left over lets the first inserts into the loaded
+// range go in without splitting every leaf they touch.
+const bulkLoadFillPercent = 90
+
+var ErrTableNotEmpty = errors.New("table is not empty")
+var ErrUnsortedRows = errors.New("rows are not in increasing ID order")
+
+// BulkLoad fills the empty table with the rows rows yields, which must come
+// in increasing ID order. Instead of inserting them one by one, splitting
+// leaves as it goes, it serializes them all and builds the tree bottom-up:
+// leaves packed to bulkLoadFillPercent of their space, then full internal
+// nodes above them, like Vacuum does. Nothing is written unless every row
+// is in order and the tree fits in the pages left; the table's root page
+// stays where it is and is written last.
+func (t *Table) BulkLoad(rows func(yield func(*Row) bool)) error {
+	return t.BulkLoadContext(context.Background(), rows)
+}
+
+// BulkLoadContext is BulkLoad that stops with ctx's error once ctx is done.
+// It checks ctx every rowBatchSize rows while taking them in, before anything
+// is written, so a cancelled load leaves the table empty.
+func (t *Table) BulkLoadContext(ctx context.Context, rows func(yield func(*Row) bool)) error {
+	t.lock()
+	defer t.unlock()
+
+	root, err := t.pager.getPage(t.rootPageNum)
+	if err != nil {
+		return err
+	}
+	if nodeType(root) != NodeTypeLeaf || leafNodeNumCells(root).get() > 0 {
+		return ErrTableNotEmpty
+	}
+
+	var cells [][]byte
+	var lastID int64
+	rows(func(row *Row) bool {
+		if len(cells)%rowBatchSize == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		if len(cells) > 0 && row.ID <= lastID {
+			err = ErrUnsortedRows
+			return false
+		}
+		cell := make([]byte, LeafNodeValueOffset+serializedRowSize(row))
+		setLeafCellKey(cell, uint64(row.ID))
+		serializeRow(row, cell[LeafNodeValueOffset:])
+		cells = append(cells, cell)
+		lastID = row.ID
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if len(cells) == 0 {
+		return nil
+	}
+
+	leafCapacity := LeafNodeSpaceForCells * bulkLoadFillPercent / 100
+	free, err := t.pager.freePageCount()
+	if err != nil {
+		return err
+	}
+	// The root page is already the table's
+	if packedPages(cells, leafCapacity)-1 > free {
+		return ErrTableFull
+	}
+
+	t.invalidateLeafHint()
+	if err := buildPackedTree(t.pager, t.rootPageNum, cells, leafCapacity); err != nil {
+		return err
+	}
+	t.rightmostLeaf = t.rootPageNum
+	if t.bloom != nil {
+		if err := t.rebuildBloomFilter(0); err != nil {
+			return err
+		}
+	}
+	return t.recordInsert(lastID)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Catalog Layout
+//
+// The catalog maps the names of tables and indexes to the root pages of
+// their B-trees. It is stored in the header page right after the header
+// fields, as an array of fixed-size entries in creation order; the header's
+// table count says how many are in use, indexes included. Tables and indexes
+// share one namespace. Every table has the same row layout, so the catalog
+// does not record columns. Root pages never move (splits and collapses keep
+// the root on its page), so only the sequence changes after the table is
+// created.
+//
+//	offset  size  field
+//	     0    32  table or index name, NUL padded
+//	    32     4  root page number (uint32)
+//	    36     8  sequence (uint64), the largest id ever stored in the table
+//	    44     1  kind: 0 table, 1 index, 2 unique index
+//	    45     3  reserved, zero
+const (
+	catalogOffset = headerSize
+
+	catalogNameSize       = 32
+	catalogNameOffset     = 0
+	catalogRootPageOffset = catalogNameOffset + catalogNameSize
+	catalogSequenceOffset = catalogRootPageOffset + 4
+	catalogKindOffset     = catalogSequenceOffset + 8
+	catalogEntrySize      = 48
+	catalogMaxTables      = (pageUsableSize - catalogOffset) / catalogEntrySize
+	defaultTableName      = "main"
+)
+
+// Kinds of catalog entries. Files of older format versions only have tables,
+// their reserved byte is zero.
+const (
+	catalogKindTable uint8 = iota
+	catalogKindIndex
+	catalogKindUniqueIndex
+)
+
+var ErrNoSuchTable = errors.New("no such table")
+var ErrTableExists = errors.New("table already exists")
+var ErrTooManyTables = errors.New("too many tables")
+var errInvalidTableName = errors.New("table names are 1 to 32 letters, digits and underscores, not starting with a digit")
+
+func headerTableCount(page []byte) uint32 {
+	return binary.LittleEndian.Uint32(page[headerTableCountOffset:])
+}
+
+func catalogEntry(page []byte, i uint32) []byte {
+	return catalogEntryAt(page, catalogOffset, i)
+}
+
+// catalogEntryAt returns entry i of a catalog starting at offset, which is
+// only not catalogOffset in files of an older format version.
+func catalogEntryAt(page []byte, offset int, i uint32) []byte {
+	start := offset + int(i)*catalogEntrySize
+	return page[start : start+catalogEntrySize]
+}
+
+func catalogEntryName(entry []byte) string {
+	return trimNul(entry[catalogNameOffset : catalogNameOffset+catalogNameSize])
+}
+
+func catalogEntryRootPage(entry []byte) uint32 {
+	return binary.LittleEndian.Uint32(entry[catalogRootPageOffset:])
+}
+
+func catalogEntrySequence(entry []byte) uint64 {
+	return binary.LittleEndian.Uint64(entry[catalogSequenceOffset:])
+}
+
+func setCatalogEntrySequence(entry []byte, seq uint64) {
+	binary.LittleEndian.PutUint64(entry[catalogSequenceOffset:], seq)
+}
+
+func catalogEntryKind(entry []byte) uint8 {
+	return entry[catalogKindOffset]
+}
+
+func setCatalogEntryKind(entry []byte, kind uint8) {
+	entry[catalogKindOffset] = kind
+}
+
+// catalogFind returns the index of the entry of the table called name. ok is
+// false if there is no such table.
+func catalogFind(page []byte, name string) (index uint32, ok bool) {
+	for i := range headerTableCount(page) {
+		if catalogEntryName(catalogEntry(page, i)) == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// catalogAppend adds an entry for the table called name rooted at rootPageNum
+// and returns its index. Indexes set their kind afterwards.
+func catalogAppend(page []byte, name string, rootPageNum uint32) (index uint32, err error) {
+	n := headerTableCount(page)
+	if n >= catalogMaxTables {
+		return 0, ErrTooManyTables
+	}
+	entry := catalogEntry(page, n)
+	clear(entry)
+	copy(entry[catalogNameOffset:], name)
+	binary.LittleEndian.PutUint32(entry[catalogRootPageOffset:], rootPageNum)
+	binary.LittleEndian.PutUint32(page[headerTableCountOffset:], n+1)
+	return n, nil
+}
+
+// validateTableName checks that name can be stored in a catalog entry and
+// typed in a statement.
+func validateTableName(name string) error {
+	if len(name) == 0 || len(name) > catalogNameSize {
+		return errInvalidTableName
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return errInvalidTableName
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// checkpointer commits the database's dirty pages every interval from a
+// background goroutine, so a long session does not keep all its changes in
+// memory until Close. It shares Database.mu with the code running
+// statements and skips a tick rather than wait while the database is busy.
+type checkpointer struct {
+	stop chan struct{}
+	done sync.WaitGroup
+	// err is the first failed checkpoint, reported by Close.
+	err error
+}
+
+func (db *Database) startCheckpointer(interval time.Duration) {
+	c := &checkpointer{stop: make(chan struct{})}
+	db.checkpointer = c
+	c.done.Add(1)
+	go func() {
+		defer c.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+			if !db.mu.TryLock() {
+				continue
+			}
+			if !db.latch.TryLock() {
+				db.mu.Unlock()
+				continue
+			}
+			// An open transaction is only written by its Commit
+			if db.pager.txn == nil {
+				if err := db.pager.commit(); err != nil && c.err == nil {
+					c.err = err
+				}
+			}
+			db.latch.Unlock()
+			db.mu.Unlock()
+		}
+	}()
+}
+
+// stopCheckpointer ends the background goroutine, if any, and returns the
+// error of a failed checkpoint.
+func (db *Database) stopCheckpointer() error {
+	c := db.checkpointer
+	if c == nil {
+		return nil
+	}
+	close(c.stop)
+	c.done.Wait()
+	db.checkpointer = nil
+	return c.err
+}
+
+// Checkpoint writes every dirty page to the file now, through the rollback
+// journal like Close does, and keeps the database open. It fails with
+// ErrTransactionOpen inside a transaction.
+func (db *Database) Checkpoint() error {
+	db.latch.Lock()
+	defer db.latch.Unlock()
+	if db.pager.txn != nil {
+		return ErrTransactionOpen
+	}
+	return db.pager.commit()
+}
+
+// Lock and Unlock guard the database against the background checkpoints of
+// WithCheckpointInterval. Hold the lock while running statements or calling
+// Database methods, including Close, when that option is used.
+func (db *Database) Lock()   { db.mu.Lock() }
+func (db *Database) Unlock() { db.mu.Unlock() }
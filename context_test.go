@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestScanRangeContextStopsWhenCancelled(t *testing.T) {
+	table := openTestTable(t)
+	rows := make([]Row, 3*rowBatchSize)
+	for i := range rows {
+		rows[i] = *createRow(int64(i))
+	}
+	if err := table.InsertMany(rows); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batches := 0
+	err := table.ScanRangeContext(ctx, 0, math.MaxUint64, func(*RowBatch) error {
+		batches++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || batches != 1 {
+		t.Fatalf("ScanRangeContext returned %v after %d batches, want context.Canceled after 1", err, batches)
+	}
+	err = table.ScanRangeReverseContext(ctx, 0, math.MaxUint64, func(*RowBatch) error {
+		t.Fatal("fn called with a cancelled context")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ScanRangeReverseContext returned %v, want context.Canceled", err)
+	}
+}
+
+func TestCancelledLoadsWriteNothing(t *testing.T) {
+	table := openTestTable(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := table.BulkLoadContext(ctx, func(yield func(*Row) bool) {
+		for i := range 10 {
+			if !yield(createRow(int64(i))) {
+				return
+			}
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("BulkLoadContext returned %v, want context.Canceled", err)
+	}
+	err = table.InsertManyContext(ctx, []Row{*createRow(1), *createRow(2)})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("InsertManyContext returned %v, want context.Canceled", err)
+	}
+	if n, err := table.Count(); err != nil || n != 0 {
+		t.Fatalf("Count() = %d, %v after cancelled loads, want 0", n, err)
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
+import (
+	"slices"
+)
+
 // Cursor represents a cursor for iterating over rows in the table.
 type Cursor struct {
 	pageNum    uint32
 	cellNum    uint32
 	table      *Table
 	endOfTable bool
+	// sought is set by Seek until NextUntil returns the row it found.
+	sought bool
 }
 
 // Advance moves the cursor to the next row in the table.
@@ -17,13 +23,19 @@ func (c *Cursor) Advance() {
 	}
 
 	c.cellNum++
-	numCells := *leafNodeNumCells(page)
+	numCells := leafNodeNumCells(page).get()
 	if c.cellNum >= numCells {
 		// Check if there is a next leaf node
-		nextLeaf := *leafNodeNextLeaf(page)
+		nextLeaf := leafNodeNextLeaf(page).get()
 		if nextLeaf == 0 {
 			c.endOfTable = true
 		} else {
+			// Leaves laid out close together on disk are read in one batch
+			// when the scan reaches them on a cold cache. A failed prefetch is
+			// not fatal, the page is simply read on its own.
+			if nextLeaf > pageNum && nextLeaf-pageNum <= readAheadPages {
+				_ = c.table.pager.prefetch(nextLeaf, readAheadPages)
+			}
 			c.pageNum = nextLeaf
 			c.cellNum = 0
 		}
@@ -41,107 +53,155 @@ func (c *Cursor) Value() []byte {
 	return leafNodeValue(page, c.cellNum)
 }
 
+// Row returns a lazily decoded view of the row under the cursor.
+func (c *Cursor) Row() RowView {
+	return RowView{data: c.Value()}
+}
+
 func (c *Cursor) IsEndOfTable() bool {
 	return c.endOfTable
 }
 
-func (c *Cursor) InsertLeafNode(key uint32, value *Row) error {
+// Key returns the key of the row under the cursor.
+func (c *Cursor) Key() uint64 {
 	page, err := c.table.pager.getPage(c.pageNum)
 	if err != nil {
-		return err
+		panic(err) // TODO: In production code, handle this error properly
 	}
+	return leafNodeKey(page, c.cellNum)
+}
 
-	numCells := *leafNodeNumCells(page)
-	if numCells >= uint32(LeafNodeMaxCells) {
-		// TODO: add log to file
-		return c.SplitAndInsert(key, value)
+// Seek moves the cursor to the first row with a key of at least key, or to
+// the end of the table if there is none, and reports whether that row's key
+// is key. The next call to NextUntil stays on that row, so a range is read
+// with:
+//
+//	if _, err := c.Seek(low); err != nil { ... }
+//	for c.NextUntil(high) {
+//		row := c.Row()
+//	}
+func (c *Cursor) Seek(key uint64) (found bool, err error) {
+	at, found, err := c.table.seek(key)
+	if err != nil {
+		return false, err
 	}
+	c.pageNum, c.cellNum = at.pageNum, at.cellNum
+	c.endOfTable = false
+	c.sought = true
 
-	if c.cellNum < numCells {
-		// Make room for new cell by shifting cells to the right
-		for i := numCells; i > c.cellNum; i-- {
-			dest := leafNodeCell(page, i)
-			src := leafNodeCell(page, i-1)
-			copy(dest, src)
+	// The key can sort after every cell of the leaf it belongs to
+	for {
+		page, err := c.table.pager.getPage(c.pageNum)
+		if err != nil {
+			return false, err
 		}
+		if c.cellNum < leafNodeNumCells(page).get() {
+			return found, nil
+		}
+		nextLeaf := leafNodeNextLeaf(page).get()
+		if nextLeaf == 0 {
+			c.endOfTable = true
+			return false, nil
+		}
+		c.pageNum, c.cellNum = nextLeaf, 0
 	}
+}
 
-	*leafNodeNumCells(page) = numCells + 1
-	*leafNodeKey(page, c.cellNum) = key
-	serializeRow(value, leafNodeValue(page, c.cellNum))
+// NextUntil moves the cursor to the next row, or leaves it on the row Seek
+// found, and reports whether that row's key is at most maxKey. Once it
+// returns false the cursor is at the end of the table.
+func (c *Cursor) NextUntil(maxKey uint64) bool {
+	if c.endOfTable {
+		return false
+	}
+	if c.sought {
+		c.sought = false
+	} else if c.Advance(); c.endOfTable {
+		return false
+	}
+	if c.Key() > maxKey {
+		c.endOfTable = true
+		return false
+	}
+	return true
+}
+
+func (c *Cursor) InsertLeafNode(key uint64, value *Row) error {
+	page, err := c.table.pager.getPageForWrite(c.pageNum)
+	if err != nil {
+		return err
+	}
+
+	cell := leafNodeInsertCell(page, c.cellNum, key, serializedRowSize(value))
+	if cell == nil {
+		// TODO: add log to file
+		return c.SplitAndInsert(key, value)
+	}
+	serializeRow(value, cell)
 
 	return nil
 }
 
-func (c *Cursor) SplitAndInsert(key uint32, value *Row) error {
-	oldPage, err := c.table.pager.getPage(c.pageNum)
+func (c *Cursor) SplitAndInsert(key uint64, value *Row) error {
+	oldPage, err := c.table.pager.getPageForWrite(c.pageNum)
 	if err != nil {
 		return err
 	}
-	newPageNum := c.table.pager.getUnusedPageNum()
-	newPage, err := c.table.pager.getPage(newPageNum)
+	newPageNum, err := c.table.pager.getUnusedPageNumNear(c.pageNum)
 	if err != nil {
 		return err
 	}
-	initializeLeafNode(newPage)
-	*nodeParent(newPage) = *nodeParent(oldPage)
-	*leafNodeNextLeaf(newPage) = *leafNodeNextLeaf(oldPage)
-	*leafNodeNextLeaf(oldPage) = newPageNum
-
-	// Move half the cells to the new page
-	// We need to distribute (LeafNodeMaxCells + 1) cells into:
-	// - oldPage: LeafNodeLeftSplitCount cells (indices 0 to LeafNodeLeftSplitCount-1)
-	// - newPage: LeafNodeRightSplitCount cells (indices 0 to LeafNodeRightSplitCount-1)
-	for i := LeafNodeMaxCells; i >= 0; i-- {
-		var destPage []byte
-		var indexWithinPage int
-
-		if i >= LeafNodeLeftSplitCount {
-			destPage = newPage
-			indexWithinPage = i - LeafNodeLeftSplitCount
-		} else {
-			destPage = oldPage
-			indexWithinPage = i
-		}
-		dest := leafNodeCell(destPage, uint32(indexWithinPage))
-
-		// Case 1: At the new row's insertion position - write the new row
-		// Destination: Either oldPage or newPage (determined by split logic above)
-		// Source: The new value parameter being inserted
-		if uint32(i) == c.cellNum {
-			serializeRow(value,
-				leafNodeValue(destPage, uint32(indexWithinPage)))
-			*leafNodeKey(destPage, uint32(indexWithinPage)) = key
-			// Case 2: After the insertion position - shift existing cells right by 1
-			// Destination: Position i in either oldPage or newPage
-			// Source: Position (i-1) from oldPage (skipping over where new row will go)
-		} else if uint32(i) > c.cellNum {
-			copy(dest, leafNodeCell(oldPage, uint32((i-1))))
-			// Case 3: Before the insertion position - copy existing cells as-is
-			// Destination: Position i in either oldPage or newPage
-			// Source: Position i from oldPage (no shift needed for cells before insertion)
-		} else {
-			copy(dest, leafNodeCell(oldPage, uint32(i)))
-		}
+	newPage, err := c.table.pager.getPageForWrite(newPageNum)
+	if err != nil {
+		return err
+	}
+	// An insert past the last key of the table is most likely one of many
+	// in increasing order, which never come back to the left leaf
+	leftPercent := 50
+	if split := c.table.db.cfg.appendSplitPercent; split > 0 &&
+		leafNodeNextLeaf(oldPage).get() == 0 && c.cellNum == leafNodeNumCells(oldPage).get() {
+		leftPercent = split
 	}
 
-	*leafNodeNumCells(oldPage) = uint32(LeafNodeLeftSplitCount)
-	*leafNodeNumCells(newPage) = uint32(LeafNodeRightSplitCount)
+	initializeLeafNode(newPage)
+	c.table.invalidateLeafHint()
+	nodeParent(newPage).set(nodeParent(oldPage).get())
+	leafNodeNextLeaf(newPage).set(leafNodeNextLeaf(oldPage).get())
+	leafNodeNextLeaf(oldPage).set(newPageNum)
+
+	// Lay out the existing cells and the new one in key order, then move
+	// the ones past the middle byte to the new page
+	cellBuf := getRowBuffer()
+	defer putRowBuffer(cellBuf)
+	newCell := cellBuf[:LeafNodeValueOffset+serializedRowSize(value)]
+	setLeafCellKey(newCell, key)
+	serializeRow(value, newCell[LeafNodeValueOffset:])
+	buf := getPageBuffer()
+	defer putPageBuffer(buf)
+	cells := slices.Insert(leafNodeCellsIn(oldPage, buf[:]), int(c.cellNum), newCell)
+	split := leafSplitPoint(cells, leftPercent)
+
+	leafNodeClearCells(oldPage)
+	leafNodeAppendCells(oldPage, cells[:split])
+	leafNodeAppendCells(newPage, cells[split:])
 
 	if isNodeRoot(oldPage) {
 		return c.table.createNewRoot(newPageNum)
 	} else {
-		parentPageNum := *nodeParent(oldPage)
-		parentPage, err := c.table.pager.getPage(parentPageNum)
+		parentPageNum := nodeParent(oldPage).get()
+		parentPage, err := c.table.pager.getPageForWrite(parentPageNum)
 		if err != nil {
 			return err
 		}
 		// Find the child index by page number (more reliable than by key)
 		oldChildIndex := internalNodeFindChildByPage(parentPage, c.pageNum)
 		// Update the key for this child (only if it's not the rightmost child)
-		if oldChildIndex < *internalNodeNumKeys(parentPage) {
-			newMaxKey := getNodeMaxKey(oldPage)
-			*internalNodeKey(parentPage, oldChildIndex) = newMaxKey
+		if oldChildIndex < internalNodeNumKeys(parentPage).get() {
+			newMaxKey, err := c.table.getNodeMaxKey(oldPage)
+			if err != nil {
+				return err
+			}
+			internalNodeKey(parentPage, oldChildIndex).set(newMaxKey)
 		}
 		return c.table.internalNodeInsert(parentPageNum, newPageNum)
 	}
@@ -159,7 +219,7 @@ func TableStart(table *Table) *Cursor {
 		panic(err)
 	}
 
-	numCells := *leafNodeNumCells(page)
+	numCells := leafNodeNumCells(page).get()
 	if numCells == 0 {
 		c.endOfTable = true
 	}
@@ -180,7 +240,7 @@ func TableEnd(table *Table) *Cursor {
 		panic(err) // TODO: In production code, handle this error properly
 	}
 
-	numCells := *leafNodeNumCells(rootNode)
+	numCells := leafNodeNumCells(rootNode).get()
 	c.cellNum = numCells
 	return c
 }
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Database is an open database file. Its tables and indexes share the file's
+// pager, and each Table and Index handle is created once and reused by later
+// calls to Table and Index.
+type Database struct {
+	pager   *Pager
+	cfg     openConfig
+	tables  map[string]*Table
+	indexes map[string]*Index
+	// mu serializes access with the background checkpointer, see Lock.
+	mu sync.Mutex
+	// latch lets goroutines share the database, see latch.go. handles
+	// guards tables and indexes, which methods holding the latch for reading
+	// add to side by side.
+	latch        sync.RWMutex
+	handles      sync.Mutex
+	checkpointer *checkpointer
+	// groupCommit is nil unless WithGroupCommit is used.
+	groupCommit *groupCommitter
+}
+
+// OpenDatabase opens or creates the database file at filename. A new file
+// starts with a single empty table called "main". A file written in an older
+// format version is upgraded first (see UpgradeDatabase).
+func OpenDatabase(filename string, opts ...Option) (*Database, error) {
+	cfg := newOpenConfig(opts)
+	if _, err := upgradeFile(filename, cfg); err != nil {
+		return nil, err
+	}
+
+	db := &Database{cfg: cfg, tables: make(map[string]*Table), indexes: make(map[string]*Index)}
+	pager, err := db.openPager(filename)
+	if err != nil {
+		return nil, err
+	}
+	db.pager = pager
+
+	newFile := pager.numPages == 0
+	header, err := pager.getPage(headerPageNum)
+	if err != nil {
+		pager.close()
+		return nil, err
+	}
+	if newFile {
+		initializeHeader(header)
+		if pager.cipher != nil {
+			setHeaderEncryption(header, pager.cipher)
+		}
+		if _, err := db.CreateTable(defaultTableName); err != nil {
+			pager.close()
+			return nil, err
+		}
+	} else if err := validateHeader(header); err != nil {
+		pager.close()
+		return nil, err
+	} else if headerDirty(header) {
+		if err := recoverDatabase(pager); err != nil {
+			pager.close()
+			return nil, err
+		}
+	}
+
+	if db.cfg.checkpointInterval > 0 {
+		db.startCheckpointer(db.cfg.checkpointInterval)
+	}
+	if db.cfg.groupCommitDelay > 0 {
+		db.groupCommit = &groupCommitter{maxDelay: db.cfg.groupCommitDelay}
+	}
+	return db, nil
+}
+
+// newOpenConfig applies opts to the default settings.
+func newOpenConfig(opts []Option) openConfig {
+	var cfg openConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.storage == nil {
+		cfg.storage = openFileStorage
+	}
+	return cfg
+}
+
+// openPager opens the file at filename with the pager settings of the
+// database's options.
+func (db *Database) openPager(filename string) (*Pager, error) {
+	pager, err := openPager(filename, db.cfg.storage)
+	if err != nil {
+		return nil, err
+	}
+	pager.preallocChunk = db.cfg.preallocChunk
+	pager.syncMode = db.cfg.syncMode
+	if db.cfg.doubleWrite {
+		pager.doubleWritePath = filename + doubleWriteSuffix
+	}
+	if err := pager.setupEncryption(db.cfg.passphrase); err != nil {
+		pager.close()
+		return nil, err
+	}
+	if db.cfg.mmap && pager.cipher != nil {
+		pager.close()
+		return nil, errEncryptedMmap
+	}
+	if db.cfg.mmap {
+		if err := pager.mapFile(); err != nil {
+			pager.close()
+			return nil, err
+		}
+	}
+	return pager, nil
+}
+
+// Table returns the table called name.
+func (db *Database) Table(name string) (*Table, error) {
+	db.latch.RLock()
+	defer db.latch.RUnlock()
+	return db.table(name)
+}
+
+// table is Table for callers holding the latch.
+func (db *Database) table(name string) (*Table, error) {
+	db.handles.Lock()
+	defer db.handles.Unlock()
+	if t, ok := db.tables[name]; ok {
+		return t, nil
+	}
+
+	header, err := db.pager.getPage(headerPageNum)
+	if err != nil {
+		return nil, err
+	}
+	index, ok := catalogFind(header, name)
+	if !ok || catalogEntryKind(catalogEntry(header, index)) != catalogKindTable {
+		return nil, ErrNoSuchTable
+	}
+	return db.openTable(name, index, catalogEntryRootPage(catalogEntry(header, index)))
+}
+
+// hasTable reports whether t is still one of the database's tables.
+func (db *Database) hasTable(t *Table) bool {
+	for _, other := range db.tables {
+		if other == t {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateTable adds an empty table called name to the catalog and returns it.
+func (db *Database) CreateTable(name string) (*Table, error) {
+	db.latch.Lock()
+	defer db.latch.Unlock()
+
+	if err := validateTableName(name); err != nil {
+		return nil, err
+	}
+	header, err := db.pager.getPage(headerPageNum)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := catalogFind(header, name); ok {
+		return nil, ErrTableExists
+	}
+	// A full catalog is refused before a root page is taken for nothing
+	if headerTableCount(header) >= catalogMaxTables {
+		return nil, ErrTooManyTables
+	}
+	if err := db.pager.markDirty(headerPageNum); err != nil {
+		return nil, err
+	}
+
+	rootPageNum, err := db.pager.getUnusedPageNum()
+	if err != nil {
+		return nil, err
+	}
+	root, err := db.pager.getPageForWrite(rootPageNum)
+	if err != nil {
+		return nil, err
+	}
+	index, err := catalogAppend(header, name, rootPageNum)
+	if err != nil {
+		return nil, err
+	}
+	initializeLeafNode(root)
+	setNodeRoot(root, true)
+	return db.openTable(name, index, rootPageNum)
+}
+
+func (db *Database) openTable(name string, catalogIndex uint32, rootPageNum uint32) (*Table, error) {
+	t := &Table{
+		db:            db,
+		pager:         db.pager,
+		catalogIndex:  catalogIndex,
+		rootPageNum:   rootPageNum,
+		rightmostLeaf: rootPageNum,
+		latch:         &db.latch,
+	}
+	if db.cfg.bloomFilter {
+		if err := t.rebuildBloomFilter(0); err != nil {
+			return nil, err
+		}
+	}
+	db.tables[name] = t
+	return t, nil
+}
+
+// CreateIndex adds an empty index called name to the catalog and returns it.
+// A unique index takes every key only once.
+func (db *Database) CreateIndex(name string, unique bool) (*Index, error) {
+	db.latch.Lock()
+	defer db.latch.Unlock()
+
+	if err := validateTableName(name); err != nil {
+		return nil, err
+	}
+	header, err := db.pager.getPage(headerPageNum)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := catalogFind(header, name); ok {
+		return nil, ErrTableExists
+	}
+	// A full catalog is refused before a root page is taken for nothing
+	if headerTableCount(header) >= catalogMaxTables {
+		return nil, ErrTooManyTables
+	}
+	if err := db.pager.markDirty(headerPageNum); err != nil {
+		return nil, err
+	}
+
+	rootPageNum, err := createIndexTree(db.pager)
+	if err != nil {
+		return nil, err
+	}
+	index, err := catalogAppend(header, name, rootPageNum)
+	if err != nil {
+		return nil, err
+	}
+	kind := catalogKindIndex
+	if unique {
+		kind = catalogKindUniqueIndex
+	}
+	setCatalogEntryKind(catalogEntry(header, index), kind)
+	return db.openIndex(name, index, catalogEntry(header, index)), nil
+}
+
+// Index returns the index called name.
+func (db *Database) Index(name string) (*Index, error) {
+	db.latch.Lock()
+	defer db.latch.Unlock()
+
+	if x, ok := db.indexes[name]; ok {
+		return x, nil
+	}
+
+	header, err := db.pager.getPage(headerPageNum)
+	if err != nil {
+		return nil, err
+	}
+	index, ok := catalogFind(header, name)
+	if !ok || catalogEntryKind(catalogEntry(header, index)) == catalogKindTable {
+		return nil, ErrNoSuchIndex
+	}
+	return db.openIndex(name, index, catalogEntry(header, index)), nil
+}
+
+func (db *Database) openIndex(name string, catalogIndex uint32, entry []byte) *Index {
+	x := &Index{
+		db:           db,
+		name:         name,
+		catalogIndex: catalogIndex,
+		rootPageNum:  catalogEntryRootPage(entry),
+		unique:       catalogEntryKind(entry) == catalogKindUniqueIndex,
+	}
+	db.indexes[name] = x
+	return x
+}
+
+// TableNames returns the names of all tables in the file, sorted.
+func (db *Database) TableNames() ([]string, error) {
+	db.latch.RLock()
+	defer db.latch.RUnlock()
+
+	header, err := db.pager.getPage(headerPageNum)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, headerTableCount(header))
+	for i := range headerTableCount(header) {
+		if entry := catalogEntry(header, i); catalogEntryKind(entry) == catalogKindTable {
+			names = append(names, catalogEntryName(entry))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Stats returns the counters of the database's pager, and how many key
+// bytes the leaves of all tables save. Vacuum opens a new pager, which
+// starts counting from zero.
+func (db *Database) Stats() PagerStats {
+	db.latch.Lock()
+	defer db.latch.Unlock()
+	s := db.pager.Stats()
+	s.KeyBytesSaved = db.keyBytesSaved()
+	return s
+}
+
+// keyBytesSaved adds up the key bytes the leaves of every table leave out,
+// their shared prefix once per cell. The pages it reads for that are not
+// counted in the pager's statistics; tables that cannot be read are skipped.
+func (db *Database) keyBytesSaved() int64 {
+	p := db.pager
+	defer func(stats PagerStats) { p.stats = stats }(p.stats)
+
+	header, err := p.getPage(headerPageNum)
+	if err != nil {
+		return 0
+	}
+	var saved int64
+	for i := range headerTableCount(header) {
+		entry := catalogEntry(header, i)
+		if catalogEntryKind(entry) != catalogKindTable {
+			continue
+		}
+		node, err := p.getPage(catalogEntryRootPage(entry))
+		for err == nil && nodeType(node) == NodeTypeInternal {
+			node, err = p.getPage(internalNodeChild(node, 0).get())
+		}
+		for err == nil {
+			saved += int64(leafNodeNumCells(node).get()) * int64(leafNodePrefixLen(node))
+			next := leafNodeNextLeaf(node).get()
+			if next == 0 {
+				break
+			}
+			node, err = p.getPage(next)
+		}
+	}
+	return saved
+}
+
+// errCloseSnapshots is returned by Close while snapshots still share the
+// pages and the file it would release.
+var errCloseSnapshots = errors.New("cannot close the database while snapshots are open")
+
+// Close writes every cached page back to the file and closes it, after
+// rolling back the open transaction if there is one. Snapshots must be
+// closed first. The database and its tables must not be used afterwards.
+func (db *Database) Close() error {
+	db.latch.RLock()
+	snapshots := len(db.pager.snapshots)
+	db.latch.RUnlock()
+	if snapshots > 0 {
+		return errCloseSnapshots
+	}
+
+	checkpointErr := db.stopCheckpointer()
+	db.latch.Lock()
+	defer db.latch.Unlock()
+	p := db.pager
+	if p.txn != nil {
+		p.rollbackTransaction()
+	}
+	if db.groupCommit != nil {
+		// Flushes still waiting for commits to join them need the latch
+		db.latch.Unlock()
+		db.groupCommit.flushes.Wait()
+		db.latch.Lock()
+	}
+
+	// Write all pages to disk. The pager is closed even if that fails, so
+	// its file is not left open
+	commitErr := p.commit()
+	return errors.Join(commitErr, p.close(), checkpointErr)
+}
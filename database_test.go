@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFullCatalogTakesNoPage(t *testing.T) {
+	db := openTestTable(t).db
+	for i := 0; ; i++ {
+		_, err := db.CreateTable(fmt.Sprintf("t%d", i))
+		if errors.Is(err, ErrTooManyTables) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	numPages := db.pager.numPages
+	if _, err := db.CreateTable("extra"); !errors.Is(err, ErrTooManyTables) {
+		t.Fatalf("CreateTable returned %v, want ErrTooManyTables", err)
+	}
+	if _, err := db.CreateIndex("extra", false); !errors.Is(err, ErrTooManyTables) {
+		t.Fatalf("CreateIndex returned %v, want ErrTooManyTables", err)
+	}
+	if db.pager.numPages != numPages || db.pager.dirty[headerPageNum] {
+		t.Fatalf("a full catalog left %d pages (was %d) and a dirty header %v",
+			db.pager.numPages, numPages, db.pager.dirty[headerPageNum])
+	}
+}
+
+func TestBloomFilterOfDamagedTableFailsToOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Point the table main at a page past the end of any file
+	header, err := db.pager.getPageForWrite(headerPageNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, _ := catalogFind(header, defaultTableName)
+	binary.LittleEndian.PutUint32(catalogEntry(header, index)[catalogRootPageOffset:], tableMaxPages)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenDatabase(path, WithBloomFilter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Table(defaultTableName); err == nil {
+		t.Fatal("Table opened a table whose root page is out of bounds")
+	}
+}
+
+// failingStorage fails every write once failWrites is set and counts the
+// calls to Close.
+type failingStorage struct {
+	Storage
+	failWrites *atomic.Bool
+	closes     *atomic.Int32
+}
+
+func (s failingStorage) WriteAt(p []byte, off int64) (int, error) {
+	if s.failWrites.Load() {
+		return 0, errors.New("write failed")
+	}
+	return s.Storage.WriteAt(p, off)
+}
+
+func (s failingStorage) Close() error {
+	s.closes.Add(1)
+	return s.Storage.Close()
+}
+
+func TestCloseClosesFileWhenCommitFails(t *testing.T) {
+	var failWrites atomic.Bool
+	var closes atomic.Int32
+	storage := func(path string) (Storage, error) {
+		s, err := openFileStorage(path)
+		if err != nil || strings.HasSuffix(path, journalSuffix) {
+			return s, err
+		}
+		return failingStorage{s, &failWrites, &closes}, nil
+	}
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "test.db"), WithStorage(storage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := db.Table(defaultTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert(createRow(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	closes.Store(0)
+	failWrites.Store(true)
+	if err := db.Close(); err == nil {
+		t.Fatal("Close succeeded although writing the pages failed")
+	}
+	if n := closes.Load(); n != 1 {
+		t.Fatalf("Close closed the database file %d times, want 1", n)
+	}
+}
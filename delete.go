@@ -0,0 +1,310 @@
+package main
+
+// Delete removes the row stored under key. found is false if there is no such row.
+//
+// A non-root leaf left less than a third full shares the cells of a sibling
+// under the same parent, or is merged with it when both fit in one page. A
+// non-root internal node left less than half full borrows an entry from a
+// sibling, or is merged with it when the sibling has none to spare. A root
+// left with a single child is replaced by that child. Pages emptied by merges
+// go on the free list for later splits to reuse. The bloom filter cannot forget keys, so it keeps
+// reporting deleted keys as possibly present, which only costs a lookup.
+func (t *Table) Delete(key uint64) (found bool, err error) {
+	t.lock()
+	defer t.unlock()
+	return t.delete(key)
+}
+
+func (t *Table) delete(key uint64) (found bool, err error) {
+	cursor, found, err := t.seek(key)
+	if err != nil || !found {
+		return false, err
+	}
+	page, err := t.pager.getPage(cursor.pageNum)
+	if err != nil {
+		return false, err
+	}
+	numCells := leafNodeNumCells(page).get()
+
+	t.invalidateLeafHint()
+	if err := t.pager.markDirty(cursor.pageNum); err != nil {
+		return false, err
+	}
+	leafNodeRemoveCell(page, cursor.cellNum)
+	numCells--
+	if isNodeRoot(page) {
+		return true, nil
+	}
+
+	parentPageNum := nodeParent(page).get()
+	parent, err := t.pager.getPageForWrite(parentPageNum)
+	if err != nil {
+		return true, err
+	}
+	index := internalNodeFindChildByPage(parent, cursor.pageNum)
+	// The parent's separator is the max key of this leaf, keep it exact when
+	// the last cell was removed
+	if cursor.cellNum == numCells && numCells > 0 && index < internalNodeNumKeys(parent).get() {
+		internalNodeKey(parent, index).set(leafNodeKey(page, numCells-1))
+	}
+
+	if leafNodeUsedSpace(page) >= LeafNodeMinUsedSpace {
+		return true, nil
+	}
+	return true, t.rebalanceLeaf(parentPageNum, index)
+}
+
+// rebalanceLeaf refills the underfull leaf at child index of parentPageNum
+// together with its left sibling (or right sibling for the first child):
+// the two are merged when their cells fit in one page, otherwise their cells
+// are split evenly between them.
+func (t *Table) rebalanceLeaf(parentPageNum uint32, index uint32) error {
+	if index > 0 {
+		index--
+	}
+	parent, err := t.pager.getPageForWrite(parentPageNum)
+	if err != nil {
+		return err
+	}
+	left, err := t.pager.getPageForWrite(internalNodeChild(parent, index).get())
+	if err != nil {
+		return err
+	}
+	right, err := t.pager.getPageForWrite(internalNodeChild(parent, index+1).get())
+	if err != nil {
+		return err
+	}
+	if leafNodeMergedSpace(left, right) <= LeafNodeSpaceForCells {
+		return t.mergeLeaves(parentPageNum, index)
+	}
+
+	leftBuf, rightBuf := getPageBuffer(), getPageBuffer()
+	defer putPageBuffer(leftBuf)
+	defer putPageBuffer(rightBuf)
+	cells := append(leafNodeCellsIn(left, leftBuf[:]), leafNodeCellsIn(right, rightBuf[:])...)
+	split := leafSplitPoint(cells, 50)
+	if leafCellsSpace(cells[:split]) > LeafNodeSpaceForCells || leafCellsSpace(cells[split:]) > LeafNodeSpaceForCells {
+		// The keys share less once spread differently, leave the leaf small
+		return nil
+	}
+	leafNodeClearCells(left)
+	leafNodeClearCells(right)
+	leafNodeAppendCells(left, cells[:split])
+	leafNodeAppendCells(right, cells[split:])
+	leftMaxKey, err := t.getNodeMaxKey(left)
+	if err != nil {
+		return err
+	}
+	internalNodeKey(parent, index).set(leftMaxKey)
+	return nil
+}
+
+// mergeLeaves moves every cell of child index+1 of parentPageNum into child
+// index and drops the emptied leaf from the parent.
+func (t *Table) mergeLeaves(parentPageNum uint32, index uint32) error {
+	parent, err := t.pager.getPageForWrite(parentPageNum)
+	if err != nil {
+		return err
+	}
+	left, err := t.pager.getPageForWrite(internalNodeChild(parent, index).get())
+	if err != nil {
+		return err
+	}
+	rightPageNum := internalNodeChild(parent, index+1).get()
+	right, err := t.pager.getPageForWrite(rightPageNum)
+	if err != nil {
+		return err
+	}
+
+	buf := getPageBuffer()
+	defer putPageBuffer(buf)
+	leafNodeAppendCells(left, leafNodeCellsIn(right, buf[:]))
+	leafNodeNextLeaf(left).set(leafNodeNextLeaf(right).get())
+	if err := t.pager.freePage(rightPageNum); err != nil {
+		return err
+	}
+
+	// The emptied leaf may be the cached rightmost one
+	t.rightmostLeaf = t.rootPageNum
+	return t.removeMergedChild(parentPageNum, index)
+}
+
+// removeMergedChild updates the internal node at pageNum after its children
+// index and index+1 were merged into child index: the merged node takes the
+// place of child index+1 and separator index is dropped. The node is then
+// rebalanced itself if it became too small.
+func (t *Table) removeMergedChild(pageNum uint32, index uint32) error {
+	node, err := t.pager.getPageForWrite(pageNum)
+	if err != nil {
+		return err
+	}
+	internalNodeChild(node, index+1).set(internalNodeChild(node, index).get())
+	internalNodeRemoveCell(node, index)
+	numKeys := internalNodeNumKeys(node).get()
+
+	if isNodeRoot(node) {
+		if numKeys == 0 {
+			return t.collapseRoot()
+		}
+		return nil
+	}
+	if numKeys >= uint32(InternalNodeMinKeys) {
+		return nil
+	}
+	return t.rebalanceInternal(pageNum)
+}
+
+// rebalanceInternal refills the underfull internal node at pageNum by rotating
+// a child over from its left sibling (or right sibling for the first child)
+// through the parent, merging the two when the sibling is at the minimum itself.
+func (t *Table) rebalanceInternal(pageNum uint32) error {
+	node, err := t.pager.getPageForWrite(pageNum)
+	if err != nil {
+		return err
+	}
+	parentPageNum := nodeParent(node).get()
+	parent, err := t.pager.getPageForWrite(parentPageNum)
+	if err != nil {
+		return err
+	}
+	index := internalNodeFindChildByPage(parent, pageNum)
+	numKeys := internalNodeNumKeys(node).get()
+
+	if index > 0 {
+		left, err := t.pager.getPageForWrite(internalNodeChild(parent, index-1).get())
+		if err != nil {
+			return err
+		}
+		leftKeys := internalNodeNumKeys(left).get()
+		if leftKeys <= uint32(InternalNodeMinKeys) {
+			return t.mergeInternal(parentPageNum, index-1)
+		}
+
+		// The left sibling's right child becomes this node's first child. The
+		// parent's separator moves down with it and the left sibling's last
+		// key moves up to replace it.
+		for i := numKeys; i > 0; i-- {
+			copy(internalNodeCell(node, i), internalNodeCell(node, i-1))
+		}
+		movedPageNum := internalNodeRightChild(left).get()
+		internalNodeChildPtr(node, 0).set(movedPageNum)
+		internalNodeKey(node, 0).set(internalNodeKey(parent, index-1).get())
+		internalNodeNumKeys(node).set(numKeys + 1)
+
+		internalNodeKey(parent, index-1).set(internalNodeKey(left, leftKeys-1).get())
+		internalNodeRightChild(left).set(internalNodeChildPtr(left, leftKeys-1).get())
+		internalNodeNumKeys(left).set(leftKeys - 1)
+		return t.setNodeParent(movedPageNum, pageNum)
+	}
+
+	right, err := t.pager.getPageForWrite(internalNodeChild(parent, 1).get())
+	if err != nil {
+		return err
+	}
+	if internalNodeNumKeys(right).get() <= uint32(InternalNodeMinKeys) {
+		return t.mergeInternal(parentPageNum, 0)
+	}
+
+	// The right sibling's first child becomes this node's right child, the
+	// mirror image of the rotation above.
+	movedPageNum := internalNodeChildPtr(right, 0).get()
+	internalNodeChildPtr(node, numKeys).set(internalNodeRightChild(node).get())
+	internalNodeKey(node, numKeys).set(internalNodeKey(parent, 0).get())
+	internalNodeRightChild(node).set(movedPageNum)
+	internalNodeNumKeys(node).set(numKeys + 1)
+
+	internalNodeKey(parent, 0).set(internalNodeKey(right, 0).get())
+	internalNodeRemoveCell(right, 0)
+	return t.setNodeParent(movedPageNum, pageNum)
+}
+
+// mergeInternal moves the parent's separator index and every child of child
+// index+1 of parentPageNum into child index, then drops the emptied node from
+// the parent.
+func (t *Table) mergeInternal(parentPageNum uint32, index uint32) error {
+	parent, err := t.pager.getPageForWrite(parentPageNum)
+	if err != nil {
+		return err
+	}
+	leftPageNum := internalNodeChild(parent, index).get()
+	left, err := t.pager.getPageForWrite(leftPageNum)
+	if err != nil {
+		return err
+	}
+	rightPageNum := internalNodeChild(parent, index+1).get()
+	right, err := t.pager.getPageForWrite(rightPageNum)
+	if err != nil {
+		return err
+	}
+
+	leftKeys := internalNodeNumKeys(left).get()
+	rightKeys := internalNodeNumKeys(right).get()
+	internalNodeChildPtr(left, leftKeys).set(internalNodeRightChild(left).get())
+	internalNodeKey(left, leftKeys).set(internalNodeKey(parent, index).get())
+	for i := range rightKeys {
+		copy(internalNodeCell(left, leftKeys+1+i), internalNodeCell(right, i))
+	}
+	internalNodeRightChild(left).set(internalNodeRightChild(right).get())
+	internalNodeNumKeys(left).set(leftKeys + 1 + rightKeys)
+
+	for i := uint32(0); i <= rightKeys; i++ {
+		if err := t.setNodeParent(internalNodeChild(right, i).get(), leftPageNum); err != nil {
+			return err
+		}
+	}
+
+	// Unlink the emptied node from its level
+	next := internalNodeNextSibling(right).get()
+	internalNodeNextSibling(left).set(next)
+	if next != 0 {
+		nextPage, err := t.pager.getPageForWrite(next)
+		if err != nil {
+			return err
+		}
+		internalNodePrevSibling(nextPage).set(leftPageNum)
+	}
+	if err := t.pager.freePage(rightPageNum); err != nil {
+		return err
+	}
+
+	return t.removeMergedChild(parentPageNum, index)
+}
+
+// collapseRoot replaces a root internal node that has no keys left with its
+// only child, making the tree one level shorter. The root stays on the same page.
+func (t *Table) collapseRoot() error {
+	root, err := t.pager.getPageForWrite(t.rootPageNum)
+	if err != nil {
+		return err
+	}
+	childPageNum := internalNodeRightChild(root).get()
+	child, err := t.pager.getPageForWrite(childPageNum)
+	if err != nil {
+		return err
+	}
+
+	copy(root, child)
+	setNodeRoot(root, true)
+	nodeParent(root).set(0)
+	if nodeType(root) == NodeTypeInternal {
+		numKeys := internalNodeNumKeys(root).get()
+		for i := uint32(0); i <= numKeys; i++ {
+			if err := t.setNodeParent(internalNodeChild(root, i).get(), t.rootPageNum); err != nil {
+				return err
+			}
+		}
+	}
+
+	t.rightmostLeaf = t.rootPageNum
+	return t.pager.freePage(childPageNum)
+}
+
+// setNodeParent points the parent pointer of the node at pageNum to parentPageNum.
+func (t *Table) setNodeParent(pageNum uint32, parentPageNum uint32) error {
+	page, err := t.pager.getPageForWrite(pageNum)
+	if err != nil {
+		return err
+	}
+	nodeParent(page).set(parentPageNum)
+	return nil
+}
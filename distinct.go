@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+// distinctMemoryLimit is the number of value bytes a distinctSet keeps in
+// memory before it spills to temporary files.
+var distinctMemoryLimit = 64 << 10
+
+// distinctPartitions is the number of temporary files a spilled distinctSet
+// spreads its values over.
+const distinctPartitions = 16
+
+// distinctSet collects the distinct values of a column. Values are kept in a
+// hash set, in the order they were first seen, until they take more than
+// distinctMemoryLimit bytes. From then on every value is appended to one of
+// distinctPartitions temporary files chosen by its hash, so equal values land
+// in the same file, and each file is deduplicated on its own when the set is
+// read. A spilled set therefore loses the first-seen order.
+type distinctSet struct {
+	seen  map[string]struct{}
+	order []string
+	size  int
+
+	partitions []*os.File
+	writers    []*bufio.Writer
+}
+
+func newDistinctSet() *distinctSet {
+	return &distinctSet{seen: make(map[string]struct{})}
+}
+
+// add records value. NULL is a value of its own, distinct from every string.
+func (d *distinctSet) add(value []byte, null bool) error {
+	key := make([]byte, 0, len(value)+1)
+	if null {
+		key = append(key, 0)
+	} else {
+		key = append(append(key, 1), value...)
+	}
+
+	if d.partitions != nil {
+		return d.spill(key)
+	}
+	if _, ok := d.seen[string(key)]; ok {
+		return nil
+	}
+	d.seen[string(key)] = struct{}{}
+	d.order = append(d.order, string(key))
+	d.size += len(key)
+	if d.size <= distinctMemoryLimit {
+		return nil
+	}
+
+	if err := d.createPartitions(); err != nil {
+		return err
+	}
+	for _, k := range d.order {
+		if err := d.spill([]byte(k)); err != nil {
+			return err
+		}
+	}
+	d.seen, d.order, d.size = nil, nil, 0
+	return nil
+}
+
+func (d *distinctSet) createPartitions() error {
+	for range distinctPartitions {
+		f, err := os.CreateTemp("", "vlsql-distinct-*")
+		if err != nil {
+			d.close()
+			return err
+		}
+		d.partitions = append(d.partitions, f)
+		d.writers = append(d.writers, bufio.NewWriter(f))
+	}
+	return nil
+}
+
+// spill appends key as a length-prefixed record to its partition.
+func (d *distinctSet) spill(key []byte) error {
+	h := fnv.New32a()
+	h.Write(key)
+	w := d.writers[h.Sum32()%distinctPartitions]
+
+	var n [binary.MaxVarintLen64]byte
+	if _, err := w.Write(n[:binary.PutUvarint(n[:], uint64(len(key)))]); err != nil {
+		return err
+	}
+	_, err := w.Write(key)
+	return err
+}
+
+// each calls fn once for every distinct value, with null set for NULL.
+func (d *distinctSet) each(fn func(value string, null bool)) error {
+	emit := func(key string) {
+		fn(key[1:], key[0] == 0)
+	}
+
+	if d.partitions == nil {
+		for _, k := range d.order {
+			emit(k)
+		}
+		return nil
+	}
+
+	for i, f := range d.partitions {
+		if err := d.writers[i].Flush(); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		seen := make(map[string]struct{})
+		r := bufio.NewReader(f)
+		for {
+			n, err := binary.ReadUvarint(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			key := make([]byte, n)
+			if _, err := io.ReadFull(r, key); err != nil {
+				return err
+			}
+			if _, ok := seen[string(key)]; ok {
+				continue
+			}
+			seen[string(key)] = struct{}{}
+			emit(string(key))
+		}
+	}
+	return nil
+}
+
+// close removes the temporary files of a spilled set.
+func (d *distinctSet) close() {
+	for _, f := range d.partitions {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	d.partitions, d.writers = nil, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Double-Write Buffer Layout
+//
+// A page written to the database file while the machine loses power can end
+// up half old and half new: a torn page, which neither the state before the
+// commit nor the one after explains. With WithDoubleWrite, commit first writes
+// the dirty pages to a double-write file next to the database (its path plus
+// doubleWriteSuffix) and syncs it, and only then writes them to their places
+// in the database file. If a commit is cut short after the double-write
+// file was written whole, the next open copies its pages over the database
+// file instead of rolling back the journal, which finishes the commit with
+// whole pages even if the journal itself did not reach the disk intact, and
+// recovery then checks the result (see recoverDatabase). All fields are
+// little endian.
+//
+//	offset  size  field
+//	     0    16  magic string "vlsql-dblwrite\x00\x00"
+//	    16     4  page size (uint32)
+//	    20     4  number of pages (uint32)
+//	    24     8  size of the database file after the commit (uint64)
+//	    32     -  pages, each a page number (uint32), the CRC-32 (IEEE) of the
+//	              page (uint32) and the page as it goes into the file
+//
+// The header is written after the pages and every page carries a checksum,
+// so a double-write file that did not reach the disk whole is ignored: the
+// database file was not touched yet.
+const (
+	doubleWriteSuffix = "-dblwrite"
+
+	doubleWriteMagicOffset     = 0
+	doubleWriteMagicSize       = 16
+	doubleWritePageSizeOffset  = doubleWriteMagicOffset + doubleWriteMagicSize
+	doubleWritePageCountOffset = doubleWritePageSizeOffset + 4
+	doubleWriteFileSizeOffset  = doubleWritePageCountOffset + 4
+	doubleWriteHeaderSize      = doubleWriteFileSizeOffset + 8
+	doubleWriteRecordSize      = 8 + pageSize
+
+	doubleWriteMagic = "vlsql-dblwrite\x00\x00"
+)
+
+// writeDoubleWrite saves the dirty pages, as they are about to be written to
+// the database file, then the header, and syncs the file unless syncing is off.
+func (p *Pager) writeDoubleWrite(doubleWrite Storage) error {
+	record := make([]byte, doubleWriteRecordSize)
+	scratch := getPageBuffer()
+	defer putPageBuffer(scratch)
+	offset := int64(doubleWriteHeaderSize)
+	count := uint32(0)
+	for pageNum := range p.numPages {
+		if !p.dirty[pageNum] {
+			continue
+		}
+		page, err := p.filePage(pageNum, p.pages[pageNum], scratch)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(record, pageNum)
+		binary.LittleEndian.PutUint32(record[4:], crc32.ChecksumIEEE(page))
+		copy(record[8:], page)
+		if _, err := doubleWrite.WriteAt(record, offset); err != nil {
+			return err
+		}
+		offset += doubleWriteRecordSize
+		count++
+	}
+
+	header := make([]byte, doubleWriteHeaderSize)
+	copy(header[doubleWriteMagicOffset:], doubleWriteMagic)
+	binary.LittleEndian.PutUint32(header[doubleWritePageSizeOffset:], pageSize)
+	binary.LittleEndian.PutUint32(header[doubleWritePageCountOffset:], count)
+	binary.LittleEndian.PutUint64(header[doubleWriteFileSizeOffset:], uint64(p.numPages)*pageSize)
+	if _, err := doubleWrite.WriteAt(header, 0); err != nil {
+		return err
+	}
+	if p.syncMode == SyncOff {
+		return nil
+	}
+	return doubleWrite.Sync()
+}
+
+// replayDoubleWrite copies the pages of a complete double-write file at path
+// over file, reporting whether there was one, and removes the double-write
+// file.
+func replayDoubleWrite(file Storage, path string, open StorageOpener) (replayed bool, err error) {
+	doubleWrite, err := open(path)
+	if err != nil {
+		return false, err
+	}
+
+	size, err := doubleWrite.Size()
+	if err != nil {
+		doubleWrite.Close()
+		return false, err
+	}
+	header := make([]byte, doubleWriteHeaderSize)
+	if size >= doubleWriteHeaderSize {
+		if _, err := doubleWrite.ReadAt(header, 0); err != nil {
+			doubleWrite.Close()
+			return false, err
+		}
+	}
+	count := binary.LittleEndian.Uint32(header[doubleWritePageCountOffset:])
+	if string(header[doubleWriteMagicOffset:doubleWriteMagicOffset+doubleWriteMagicSize]) != doubleWriteMagic ||
+		binary.LittleEndian.Uint32(header[doubleWritePageSizeOffset:]) != pageSize ||
+		size < doubleWriteHeaderSize+int64(count)*doubleWriteRecordSize {
+		// No double-write file, or one whose commit never touched the file
+		return false, discardJournal(doubleWrite)
+	}
+
+	records := make([]byte, int64(count)*doubleWriteRecordSize)
+	if _, err := doubleWrite.ReadAt(records, doubleWriteHeaderSize); err != nil {
+		doubleWrite.Close()
+		return false, err
+	}
+	for i := 0; i < len(records); i += doubleWriteRecordSize {
+		record := records[i : i+doubleWriteRecordSize]
+		if binary.LittleEndian.Uint32(record[4:]) != crc32.ChecksumIEEE(record[8:]) {
+			return false, discardJournal(doubleWrite)
+		}
+	}
+
+	for i := 0; i < len(records); i += doubleWriteRecordSize {
+		record := records[i : i+doubleWriteRecordSize]
+		pageNum := binary.LittleEndian.Uint32(record)
+		if _, err := file.WriteAt(record[8:], int64(pageNum)*pageSize); err != nil {
+			doubleWrite.Close()
+			return false, err
+		}
+	}
+	if err := file.Truncate(int64(binary.LittleEndian.Uint64(header[doubleWriteFileSizeOffset:]))); err != nil {
+		doubleWrite.Close()
+		return false, err
+	}
+	if err := file.Sync(); err != nil {
+		doubleWrite.Close()
+		return false, err
+	}
+	return true, discardJournal(doubleWrite)
+}
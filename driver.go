@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DriverName is the name the database/sql driver is registered under:
+// sql.Open(DriverName, "vlsql.db") opens the database file vlsql.db.
+const DriverName = "verylightsql"
+
+// errExplainInDriver is returned for explain statements run through
+// database/sql, which print their plan like in the REPL.
+var errExplainInDriver = errors.New("explain is only supported in the REPL")
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Driver is the database/sql driver. Statements are the ones the REPL
+// takes, run against the table main, and a ? outside quotes is a
+// placeholder for an argument: integers, strings, []byte, bools, floats,
+// times and nil (stored as NULL), see placeholderLiteral. All connections
+// to the same file share one Database, which they close when the last of
+// them is closed.
+//
+// Transactions are database-wide, like begin in the REPL: while one is open
+// the statements of other connections become part of it, and they cannot
+// begin their own. Programs using transactions from several goroutines
+// should limit the pool to one connection with sql.DB.SetMaxOpenConns.
+type Driver struct {
+	mu  sync.Mutex
+	dbs map[string]*driverDB
+}
+
+// driverDB is a database opened by the driver and the number of
+// connections using it.
+type driverDB struct {
+	db    *Database
+	conns int
+}
+
+// Open returns a connection to the database file name.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	shared, ok := d.dbs[name]
+	if !ok {
+		db, err := OpenDatabase(name)
+		if err != nil {
+			return nil, err
+		}
+		if d.dbs == nil {
+			d.dbs = make(map[string]*driverDB)
+		}
+		shared = &driverDB{db: db}
+		d.dbs[name] = shared
+	}
+	table, err := shared.db.Table(defaultTableName)
+	if err != nil {
+		// A database no connection uses is not released by any
+		if shared.conns == 0 {
+			delete(d.dbs, name)
+			shared.db.Close()
+		}
+		return nil, err
+	}
+	shared.conns++
+	return &driverConn{driver: d, name: name, db: shared.db, table: table}, nil
+}
+
+// release closes the database file name once its last connection is gone.
+func (d *Driver) release(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	shared := d.dbs[name]
+	if shared.conns--; shared.conns > 0 {
+		return nil
+	}
+	delete(d.dbs, name)
+	return shared.db.Close()
+}
+
+type driverConn struct {
+	driver *Driver
+	name   string
+	db     *Database
+	table  *Table
+}
+
+func (c *driverConn) Prepare(query string) (driver.Stmt, error) {
+	n, err := countPlaceholders(query)
+	if err != nil {
+		return nil, err
+	}
+	stmt := &driverStmt{conn: c, query: query, numInput: n}
+	// Without placeholders the statement is complete, so syntax errors show now
+	if n == 0 {
+		if stmt.plan, err = prepare_statement(query); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (c *driverConn) Close() error {
+	return c.driver.release(c.name)
+}
+
+func (c *driverConn) Begin() (driver.Tx, error) {
+	if err := c.db.Begin(); err != nil {
+		return nil, err
+	}
+	return driverTx{c.db}, nil
+}
+
+type driverTx struct {
+	db *Database
+}
+
+func (tx driverTx) Commit() error   { return tx.db.Commit() }
+func (tx driverTx) Rollback() error { return tx.db.Rollback() }
+
+type driverStmt struct {
+	conn     *driverConn
+	query    string
+	numInput int
+	// plan is the statement planned by Prepare when it has no placeholders.
+	plan Plan
+}
+
+func (s *driverStmt) Close() error  { return nil }
+func (s *driverStmt) NumInput() int { return s.numInput }
+
+// bind returns the plan of the statement with args in place of its
+// placeholders.
+func (s *driverStmt) bind(args []driver.Value) (Plan, error) {
+	if s.plan != nil {
+		return s.plan, nil
+	}
+	query, err := bindPlaceholders(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return prepare_statement(query)
+}
+
+func (s *driverStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.runExec(context.Background(), args)
+}
+
+// ExecContext is Exec that stops scans and inserts of several rows once ctx
+// is done.
+func (s *driverStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	values, err := positionalArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.runExec(ctx, values)
+}
+
+func (s *driverStmt) runExec(ctx context.Context, args []driver.Value) (driver.Result, error) {
+	plan, err := s.bind(args)
+	if err != nil {
+		return nil, err
+	}
+	t := s.conn.table
+	switch p := plan.(type) {
+	case execPlan:
+		changed, lastInsertID, err := p.exec(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		return driverResult{lastInsertID: lastInsertID, rowsAffected: changed}, nil
+	case queryPlan:
+		if err := p.query(ctx, t, func([]any) {}); err != nil {
+			return nil, err
+		}
+	case *explainPlan:
+		return nil, errExplainInDriver
+	default:
+		if err := plan.Execute(t); err != nil {
+			return nil, err
+		}
+	}
+	return driver.ResultNoRows, nil
+}
+
+func (s *driverStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.runQuery(context.Background(), args)
+}
+
+// QueryContext is Query that stops the scan once ctx is done.
+func (s *driverStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	values, err := positionalArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.runQuery(ctx, values)
+}
+
+func (s *driverStmt) runQuery(ctx context.Context, args []driver.Value) (driver.Rows, error) {
+	plan, err := s.bind(args)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := plan.(queryPlan)
+	if !ok || p.columns() == nil {
+		// Statements without rows run and return none
+		if _, err := s.runExec(ctx, args); err != nil {
+			return nil, err
+		}
+		return driverRows{&Rows{}}, nil
+	}
+	rows, err := newRows(ctx, s.conn.table, p)
+	if err != nil {
+		return nil, err
+	}
+	return driverRows{rows}, nil
+}
+
+// positionalArgs returns the values of args, which fill the placeholders in
+// order: placeholders have no names to match named arguments against.
+func positionalArgs(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("named argument %s: placeholders are positional", arg.Name)
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
+
+type driverResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r driverResult) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r driverResult) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+// driverRows hands the Rows of a query to database/sql, which does the
+// scanning itself. The rows are read a batch at a time as database/sql asks
+// for them.
+type driverRows struct {
+	rows *Rows
+}
+
+func (r driverRows) Columns() []string { return r.rows.Columns() }
+func (r driverRows) Close() error      { return r.rows.Close() }
+
+func (r driverRows) Next(dest []driver.Value) error {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for i, v := range r.rows.current {
+		dest[i] = v
+	}
+	return nil
+}
+
+// scanPlaceholders calls fn with the offset of every ? in query that is not
+// inside a string literal, and fails if a literal is left open. Like in
+// tokenize, a quote only starts a literal at the start of a token.
+func scanPlaceholders(query string, fn func(offset int)) error {
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case (c == '\'' || c == '"') && (i == 0 || isSpace(query[i-1]) || isPunct(query[i-1])):
+			quote = c
+		case c == '?':
+			fn(i)
+		}
+	}
+	if quote != 0 {
+		return errors.New("unterminated string")
+	}
+	return nil
+}
+
+func countPlaceholders(query string) (int, error) {
+	n := 0
+	err := scanPlaceholders(query, func(int) { n++ })
+	return n, err
+}
+
+// bindPlaceholders returns query with the placeholders replaced by args,
+// written as the statement literals the parser reads back.
+func bindPlaceholders(query string, args []driver.Value) (string, error) {
+	var b strings.Builder
+	var bindErr error
+	start, next := 0, 0
+	err := scanPlaceholders(query, func(offset int) {
+		b.WriteString(query[start:offset])
+		start = offset + 1
+		if next >= len(args) {
+			bindErr = fmt.Errorf("statement has more placeholders than the %d arguments", len(args))
+			return
+		}
+		literal, err := placeholderLiteral(args[next])
+		if err != nil && bindErr == nil {
+			bindErr = fmt.Errorf("argument %d: %w", next+1, err)
+		}
+		b.WriteString(literal)
+		next++
+	})
+	if err != nil {
+		return "", err
+	}
+	if bindErr != nil {
+		return "", bindErr
+	}
+	b.WriteString(query[start:])
+	return b.String(), nil
+}
+
+// placeholderLiteral writes v, one of the types driver.Value holds, as a
+// statement literal: integers in decimal, booleans as 1 and 0, NULL as null
+// and strings in double quotes with their quotes, backslashes and line
+// breaks escaped. Floats without a fraction are written as integers, other
+// floats and times as strings.
+func placeholderLiteral(v driver.Value) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "null", nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			return strconv.FormatInt(int64(v), 10), nil
+		}
+		return placeholderLiteral(strconv.FormatFloat(v, 'g', -1, 64))
+	case time.Time:
+		return placeholderLiteral(v.Format(time.RFC3339Nano))
+	case []byte:
+		return placeholderLiteral(string(v))
+	case string:
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+		return `"` + r.Replace(v) + `"`, nil
+	}
+	return "", fmt.Errorf("cannot store a value of type %T", v)
+}
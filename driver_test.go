@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDriverExecAndQuery(t *testing.T) {
+	db, err := sql.Open(DriverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	res, err := db.Exec("insert (? ? ?) (? ? ?)", 1, "alice", "alice@example.com", 2, `say "hi"`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 2 {
+		t.Fatalf("RowsAffected() = %d, %v, want 2", n, err)
+	}
+	if _, err := db.Exec("insert ? ?", "bob", "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = db.Exec("delete 7"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 0 {
+		t.Fatalf("RowsAffected() of a missing row = %d, %v, want 0", n, err)
+	}
+
+	rows, err := db.Query("select where id between ? and ?", 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type row struct {
+		id       int64
+		username string
+		email    sql.NullString
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.username, &r.email); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []row{
+		{2, `say "hi"`, sql.NullString{}},
+		{3, "bob", sql.NullString{String: "bob@example.com", Valid: true}},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("select returned %v, want %v", got, want)
+	}
+
+	var count int
+	if err := db.QueryRow("select count(*)").Scan(&count); err != nil || count != 3 {
+		t.Fatalf("count(*) = %d, %v, want 3", count, err)
+	}
+	if _, err := db.Prepare("select where"); err == nil {
+		t.Fatal("Prepare accepted a syntax error")
+	}
+}
+
+func TestDriverTransaction(t *testing.T) {
+	db, err := sql.Open(DriverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("insert 1 alice alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	var username string
+	if err := db.QueryRow("select username").Scan(&username); err != sql.ErrNoRows {
+		t.Fatalf("the rolled back row is still there: %q, %v", username, err)
+	}
+}
+
+func TestDriverOpenFailureClosesDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rename the table main, so connections to the file cannot open it
+	header, err := db.pager.getPageForWrite(headerPageNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, _ := catalogFind(header, defaultTableName)
+	copy(catalogEntry(header, index)[catalogNameOffset:], "other")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if _, err := d.Open(path); !errors.Is(err, ErrNoSuchTable) {
+		t.Fatalf("Open returned %v, want ErrNoSuchTable", err)
+	}
+	if len(d.dbs) != 0 {
+		t.Fatalf("driver keeps %d databases after a failed Open, want 0", len(d.dbs))
+	}
+}
+
+func TestDriverStreamsRows(t *testing.T) {
+	db, err := sql.Open(DriverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	n := 2*rowBatchSize + 1
+	for id := range n {
+		if _, err := db.Exec("insert ? ? ?", id+1, "user", "user@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := db.Query("select id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	read := 0
+	for rows.Next() {
+		// The last row goes before the batch holding it is read
+		if read++; read == rowBatchSize {
+			if _, err := db.Exec("delete ?", n); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if read != n-1 {
+		t.Fatalf("read %d rows, want %d", read, n-1)
+	}
+}
+
+func TestDriverLastInsertIDPerStatement(t *testing.T) {
+	db, err := sql.Open(DriverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(8)
+
+	// Connections share one Table, so each result must carry the ID of its
+	// own insert rather than the last one made through any of them
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 40 {
+				username := fmt.Sprintf("u%d-%d", g, i)
+				res, err := db.Exec("insert ? ?", username, "user@example.com")
+				if err != nil {
+					errs <- err
+					return
+				}
+				id, err := res.LastInsertId()
+				if err != nil {
+					errs <- err
+					return
+				}
+				var got string
+				if err := db.QueryRow("select username where id = ?", id).Scan(&got); err != nil || got != username {
+					errs <- fmt.Errorf("LastInsertId() of %s = %d, which holds %q (%v)", username, id, got, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{"update 1 alice alice@example.com", "delete 2", "delete 1000"} {
+		res, err := db.Exec(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, err := res.LastInsertId(); err != nil || id != 0 {
+			t.Fatalf("LastInsertId() of %s = %d, %v, want 0", query, id, err)
+		}
+	}
+}
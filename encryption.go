@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Page Encryption Layout
+//
+// An encrypted database encrypts every page with AES-256-GCM on its way to
+// the file (flush, flushAll, backups) and decrypts it when it is read
+// (getPage, prefetch); the page cache only ever holds plaintext. The key is
+// derived from a passphrase with PBKDF2-HMAC-SHA256, using the salt and
+// iteration count stored in the file header. The header fields of page 0 stay
+// in plaintext so the file can be identified and opened, the catalog after
+// them is encrypted like every other page.
+//
+// The last pageReservedSize bytes of every page are kept free for this, so an
+// encrypted page has the same size and offset as a plain one:
+//
+//	offset          size  field
+//	     0             -  plaintext prefix (the header fields on page 0, empty elsewhere)
+//	     -             -  encrypted rest of the usable page
+//	pageUsableSize    16  GCM tag
+//	pageUsableSize+16 12  nonce, random for every write
+//	pageUsableSize+28  4  zero
+//
+// The page number and the plaintext prefix are authenticated with the page,
+// so pages cannot be moved around or their header fields changed unnoticed.
+// A page that is all zero was never written and reads as an empty page.
+// Rollback journals save pages as they are in the file, already encrypted.
+const (
+	encryptionNone   = 0
+	encryptionAESGCM = 1
+
+	encryptionKeySize    = 32
+	encryptionSaltSize   = 16
+	encryptionIterations = 100_000
+	encryptionTagSize    = 16
+	encryptionNonceSize  = 12
+)
+
+var ErrDatabaseEncrypted = errors.New("database is encrypted, a passphrase is needed to open it")
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+var errDatabaseNotEncrypted = errors.New("database is not encrypted")
+var errEncryptedMmap = errors.New("encrypted databases cannot be memory-mapped")
+var errPageAuthentication = errors.New("page fails authentication, the file is corrupt or was tampered with")
+
+// pageCipher encrypts and decrypts pages with the key of one database.
+// prefix is the size of the plaintext header fields on page 0, which only
+// differs from headerSize for files of an older format version.
+type pageCipher struct {
+	aead       cipher.AEAD
+	salt       [encryptionSaltSize]byte
+	iterations uint32
+	prefix     int
+}
+
+// newPageCipher derives the key for passphrase from salt.
+func newPageCipher(passphrase string, salt []byte, iterations uint32) (*pageCipher, error) {
+	key := pbkdf2SHA256([]byte(passphrase), salt, int(iterations), encryptionKeySize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c := &pageCipher{aead: aead, iterations: iterations, prefix: headerSize}
+	copy(c.salt[:], salt)
+	return c, nil
+}
+
+// plaintextPrefix returns how many bytes at the start of page pageNum are
+// left unencrypted.
+func (c *pageCipher) plaintextPrefix(pageNum uint32) int {
+	if pageNum == headerPageNum {
+		return c.prefix
+	}
+	return 0
+}
+
+// additionalData returns the data authenticated along with page pageNum.
+func additionalData(pageNum uint32, prefix []byte) []byte {
+	data := make([]byte, 4, 4+len(prefix))
+	binary.LittleEndian.PutUint32(data, pageNum)
+	return append(data, prefix...)
+}
+
+// seal writes the encrypted form of page pageNum into dst, which must not
+// overlap page.
+func (c *pageCipher) seal(dst, page []byte, pageNum uint32) error {
+	start := c.plaintextPrefix(pageNum)
+	copy(dst[:start], page[:start])
+	nonce := dst[pageUsableSize+encryptionTagSize : pageUsableSize+encryptionTagSize+encryptionNonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	clear(dst[pageUsableSize+encryptionTagSize+encryptionNonceSize:])
+	c.aead.Seal(dst[start:start], nonce, page[start:pageUsableSize], additionalData(pageNum, page[:start]))
+	return nil
+}
+
+// open decrypts page pageNum, as read from the file, in place.
+func (c *pageCipher) open(page []byte, pageNum uint32) error {
+	if isZeroPage(page) {
+		return nil
+	}
+	start := c.plaintextPrefix(pageNum)
+	nonce := page[pageUsableSize+encryptionTagSize : pageUsableSize+encryptionTagSize+encryptionNonceSize]
+	_, err := c.aead.Open(page[start:start], nonce, page[start:pageUsableSize+encryptionTagSize], additionalData(pageNum, page[:start]))
+	if err != nil {
+		return fmt.Errorf("page %d: %w", pageNum, errPageAuthentication)
+	}
+	clear(page[pageUsableSize:])
+	return nil
+}
+
+func isZeroPage(page []byte) bool {
+	for _, b := range page {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// filePage returns page pageNum as it goes into the file: the page itself,
+// or an encrypted copy in scratch if the database is encrypted.
+func (p *Pager) filePage(pageNum uint32, page []byte, scratch *[pageSize]byte) ([]byte, error) {
+	if p.cipher == nil {
+		return page, nil
+	}
+	if err := p.cipher.seal(scratch[:], page, pageNum); err != nil {
+		return nil, err
+	}
+	return scratch[:], nil
+}
+
+// setupEncryption sets up the pager's cipher for the file it opened. A new
+// file is encrypted if passphrase is not empty; an existing one must be
+// opened with a passphrase exactly when it is encrypted.
+func (p *Pager) setupEncryption(passphrase string) error {
+	if p.fileLength == 0 {
+		if passphrase == "" {
+			return nil
+		}
+		var salt [encryptionSaltSize]byte
+		if _, err := rand.Read(salt[:]); err != nil {
+			return err
+		}
+		c, err := newPageCipher(passphrase, salt[:], encryptionIterations)
+		if err != nil {
+			return err
+		}
+		p.cipher = c
+		return nil
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := p.file.ReadAt(header, 0); err != nil {
+		return err
+	}
+	if string(header[headerMagicOffset:headerMagicOffset+headerMagicSize]) != headerMagic ||
+		binary.LittleEndian.Uint32(header[headerFormatVersionOffset:]) != headerFormatVersion {
+		// Not a database, or one of a format this version cannot read,
+		// validateHeader reports it
+		return nil
+	}
+	switch headerEncryption(header) {
+	case encryptionNone:
+		if passphrase != "" {
+			return errDatabaseNotEncrypted
+		}
+		return nil
+	case encryptionAESGCM:
+	default:
+		return errors.New("unsupported database encryption")
+	}
+	if passphrase == "" {
+		return ErrDatabaseEncrypted
+	}
+
+	c, err := newPageCipher(passphrase, headerEncryptionSalt(header), headerEncryptionIterations(header))
+	if err != nil {
+		return err
+	}
+	p.cipher = c
+	if _, err := p.getPage(headerPageNum); err != nil {
+		return ErrWrongPassphrase
+	}
+	return nil
+}
+
+// pbkdf2SHA256 derives a key of keyLen bytes from password and salt with
+// PBKDF2 (RFC 8018) using HMAC-SHA256.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var blockIndex [4]byte
+	key := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(blockIndex[:], uint32(block))
+		prf.Write(blockIndex[:])
+		key = prf.Sum(key)
+		t := key[len(key)-hashLen:]
+		copy(u, t)
+
+		for range iterations - 1 {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// explainPlan prints the steps of plan as an indented tree, one step per line
+// with the row sources it reads below it. With analyze set the plan is run
+// first (its output is printed as usual) and every line is followed by what
+// the step did: rows it produced, pages it read from the file, page requests
+// served from the cache and the time it took. Row source counters exclude the
+// work of the steps consuming their rows.
+type explainPlan struct {
+	plan    Plan
+	analyze bool
+}
+
+func (p *explainPlan) Execute(t *Table) error {
+	if !p.analyze {
+		printPlanNode(p.plan, 0)
+		return nil
+	}
+
+	plan := instrumentPlan(p.plan)
+	start, before := time.Now(), t.pager.stats
+	if err := plan.Execute(t); err != nil {
+		return err
+	}
+	stats := t.pager.stats.sub(before)
+	fmt.Printf("%s (pages_read=%d cache_hits=%d time=%s)\n",
+		plan.explain(), stats.PagesRead, stats.CacheHits, time.Since(start))
+	for _, in := range plan.inputs() {
+		printPlanNode(in, 1)
+	}
+	return nil
+}
+
+func (p *explainPlan) explain() string {
+	if p.analyze {
+		return "EXPLAIN ANALYZE"
+	}
+	return "EXPLAIN"
+}
+
+func (p *explainPlan) inputs() []rowSource { return nil }
+
+func printPlanNode(n planNode, depth int) {
+	fmt.Printf("%s%s\n", strings.Repeat("  ", depth), n.explain())
+	for _, in := range n.inputs() {
+		printPlanNode(in, depth+1)
+	}
+}
+
+// instrumentPlan returns a copy of plan whose row sources are wrapped in
+// analyzedSource. Plans without row sources are returned as they are.
+func instrumentPlan(plan Plan) Plan {
+	switch p := plan.(type) {
+	case *selectPlan:
+		c := *p
+		c.source = instrumentSource(c.source)
+		return &c
+	case *columnPlan:
+		c := *p
+		c.source = instrumentSource(c.source)
+		return &c
+	case *countPlan:
+		c := *p
+		c.source = instrumentSource(c.source)
+		return &c
+	}
+	return plan
+}
+
+func instrumentSource(s rowSource) rowSource {
+	if f, ok := s.(nullFilter); ok {
+		f.source = instrumentSource(f.source)
+		s = f
+	}
+	return &analyzedSource{source: s}
+}
+
+// analyzedSource counts the rows, page accesses and time of the row source
+// it wraps.
+type analyzedSource struct {
+	source  rowSource
+	rows    int
+	stats   PagerStats
+	elapsed time.Duration
+}
+
+func (a *analyzedSource) scan(ctx context.Context, t *Table, fn func(*RowBatch) error) error {
+	start, before := time.Now(), t.pager.stats
+	// Time and pages spent by fn belong to the consumer, not to this source
+	var fnElapsed time.Duration
+	var fnStats PagerStats
+	err := a.source.scan(ctx, t, func(batch *RowBatch) error {
+		a.rows += batch.Len()
+		fnStart, fnBefore := time.Now(), t.pager.stats
+		err := fn(batch)
+		fnElapsed += time.Since(fnStart)
+		fnStats = fnStats.add(t.pager.stats.sub(fnBefore))
+		return err
+	})
+	a.elapsed += time.Since(start) - fnElapsed
+	a.stats = a.stats.add(t.pager.stats.sub(before).sub(fnStats))
+	return err
+}
+
+// count keeps the counting shortcut of the wrapped source, falling back to a
+// scan for sources without one.
+func (a *analyzedSource) count(t *Table) (int, error) {
+	c, ok := a.source.(rowCounter)
+	if !ok {
+		n := 0
+		err := a.scan(context.Background(), t, func(batch *RowBatch) error {
+			n += batch.Len()
+			return nil
+		})
+		return n, err
+	}
+
+	start, before := time.Now(), t.pager.stats
+	n, err := c.count(t)
+	a.rows += n
+	a.elapsed += time.Since(start)
+	a.stats = a.stats.add(t.pager.stats.sub(before))
+	return n, err
+}
+
+func (a *analyzedSource) resume(last uint64) rowSource {
+	return &analyzedSource{source: a.source.resume(last)}
+}
+
+func (a *analyzedSource) explain() string {
+	return fmt.Sprintf("%s (rows=%d pages_read=%d cache_hits=%d time=%s)",
+		a.source.explain(), a.rows, a.stats.PagesRead, a.stats.CacheHits, a.elapsed)
+}
+
+func (a *analyzedSource) inputs() []rowSource { return a.source.inputs() }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// WithGroupCommit lets the commits of goroutines that commit around the same
+// time share one write to the file. A commit ends its transaction, releases
+// the latch and waits for the next flush, which comes maxDelay after the
+// first commit waiting for it and writes the changes of every transaction
+// that ended by then through the rollback journal, with one set of syncs.
+// Commit returns once its changes are on disk, so it is as durable as
+// without the option but takes up to maxDelay longer; the other goroutines
+// go on writing in the meantime.
+func WithGroupCommit(maxDelay time.Duration) Option {
+	return func(c *openConfig) {
+		c.groupCommitDelay = maxDelay
+	}
+}
+
+// groupCommitter collects the commits waiting for the same flush.
+type groupCommitter struct {
+	maxDelay time.Duration
+	mu       sync.Mutex
+	// pending is the batch the next flush completes, nil while no commit
+	// waits.
+	pending *commitBatch
+	// flushes counts the flushes not done yet, which Close waits for.
+	flushes sync.WaitGroup
+}
+
+// commitBatch is a flush and the commits waiting for it. err is set before
+// done is closed.
+type commitBatch struct {
+	done chan struct{}
+	err  error
+}
+
+// join adds a commit whose transaction has ended to the pending batch,
+// starting one with its own flush if there is none, and returns the batch.
+// The caller holds the latch for writing, so the flush, which takes it too,
+// comes after the transaction.
+func (g *groupCommitter) join(db *Database) *commitBatch {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.pending == nil {
+		g.pending = &commitBatch{done: make(chan struct{})}
+		g.flushes.Add(1)
+		go g.flush(db, g.pending)
+	}
+	return g.pending
+}
+
+// flush waits maxDelay for more commits to join b, then writes the
+// database's dirty pages and wakes up b's commits. A transaction opened
+// with Begin since then holds changes that are not committed, so only the
+// pages as they were when it began are written.
+func (g *groupCommitter) flush(db *Database, b *commitBatch) {
+	defer g.flushes.Done()
+	time.Sleep(g.maxDelay)
+	g.mu.Lock()
+	g.pending = nil
+	g.mu.Unlock()
+
+	db.latch.Lock()
+	if db.pager.txn != nil {
+		b.err = db.pager.commitBeforeTransaction()
+	} else {
+		b.err = db.pager.commit()
+	}
+	db.latch.Unlock()
+	close(b.done)
+}
+
+// wait returns once b's flush is done, with its error. A nil batch is a
+// commit that was written already.
+func (b *commitBatch) wait() error {
+	if b == nil {
+		return nil
+	}
+	<-b.done
+	return b.err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// syncCounter counts the syncs of the database file, leaving out the
+// rollback journal.
+type syncCounter struct {
+	Storage
+	syncs *atomic.Int32
+}
+
+func (s syncCounter) Sync() error {
+	s.syncs.Add(1)
+	return s.Storage.Sync()
+}
+
+func TestGroupCommitSharesSyncs(t *testing.T) {
+	var syncs atomic.Int32
+	storage := func(path string) (Storage, error) {
+		s, err := openFileStorage(path)
+		if err != nil || strings.HasSuffix(path, journalSuffix) {
+			return s, err
+		}
+		return syncCounter{s, &syncs}, nil
+	}
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenDatabase(path, WithStorage(storage), WithGroupCommit(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 8
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx, err := db.BeginWrite()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			w, err := tx.Table(defaultTableName)
+			if err == nil {
+				err = w.Insert(createRow(int64(i)))
+			}
+			if err != nil {
+				tx.Rollback()
+				t.Error(err)
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := syncs.Load(); n == 0 || n >= writers {
+		t.Errorf("%d commits synced the database file %d times, want fewer syncs than commits", writers, n)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	table, err := db.Table(defaultTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := table.Count(); err != nil || n != writers {
+		t.Fatalf("Count() = %d, %v after reopening, want %d", n, err, writers)
+	}
+}
+
+func TestGroupCommitDoesNotWaitForLaterTransaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenDatabase(path, WithGroupCommit(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	table, err := db.Table(defaultTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert(createRow(1)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- db.Commit() }()
+	for db.InTransaction() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A transaction begun before the flush stays open while it runs
+	if err := db.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert(createRow(2)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Commit waits for a transaction begun after it")
+	}
+
+	countRows := func(want int) {
+		t.Helper()
+		other, err := OpenDatabase(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.Close()
+		table, err := other.Table(defaultTableName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := table.Count(); err != nil || n != want {
+			t.Fatalf("Count() = %d, %v in the file, want %d", n, err, want)
+		}
+	}
+	countRows(1)
+	if err := db.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	countRows(2)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// File Header Layout
+//
+// Page 0 of every database file holds the file header instead of a B-tree
+// node, followed by the catalog of tables (see catalog.go). All fields are
+// little endian, the rest of the page is reserved and zero.
+//
+//	offset  size  field
+//	     0    16  magic string "verylightsql\x00\x00\x00\x00"
+//	    16     4  format version (uint32)
+//	    20     4  page size (uint32)
+//	    24     4  number of tables in the catalog (uint32)
+//	    28     4  user_version (int32), free for applications to use
+//	    32     4  application_id (int32), free for applications to use
+//	    36     4  first page of the free list (uint32), 0 if it is empty
+//	    40     4  number of pages on the free list (uint32)
+//	    44     4  dirty flag (uint32), 1 while a commit is writing pages
+//	    48     4  encryption (uint32), 0 for none, 1 for AES-256-GCM
+//	    52     4  key derivation iterations (uint32), 0 if not encrypted
+//	    56    16  key derivation salt, zero if not encrypted
+//	    72     4  number of pages in use (uint32)
+//
+// The file can be longer than the pages in use when it was grown ahead of
+// time (see WithPreallocation), the page count says where the pages end.
+// The header fields stay in plaintext in encrypted databases, the rest of the
+// page does not (see encryption.go).
+//
+// Pages no tree uses any more are chained into the free list: a free page is
+// zero except for its first 4 bytes, the number of the next free page (0 at
+// the end of the list).
+const (
+	headerPageNum = 0
+
+	headerMagicOffset         = 0
+	headerMagicSize           = 16
+	headerFormatVersionOffset = headerMagicOffset + headerMagicSize
+	headerPageSizeOffset      = headerFormatVersionOffset + 4
+	headerTableCountOffset    = headerPageSizeOffset + 4
+	headerUserVersionOffset   = headerTableCountOffset + 4
+	headerAppIDOffset         = headerUserVersionOffset + 4
+	headerFreelistHeadOffset  = headerAppIDOffset + 4
+	headerFreelistCountOffset = headerFreelistHeadOffset + 4
+	headerDirtyOffset         = headerFreelistCountOffset + 4
+	headerEncryptionOffset    = headerDirtyOffset + 4
+	headerIterationsOffset    = headerEncryptionOffset + 4
+	headerSaltOffset          = headerIterationsOffset + 4
+	headerPageCountOffset     = headerSaltOffset + encryptionSaltSize
+	headerSize                = headerPageCountOffset + 4
+
+	headerMagic = "verylightsql\x00\x00\x00\x00"
+	// 2 added the null bitmap to rows, 3 replaced the root page number by
+	// the catalog, 4 made leaves slotted pages of variable-length rows, 5
+	// widened ids and keys to 64 bits, 6 added the free list, 7 the dirty
+	// flag, 8 the encryption fields and the reserved end of every page, 9
+	// the page count, 10 the key prefix of leaves and indexes
+	headerFormatVersion = 10
+)
+
+var errNotADatabase = errors.New("file is not a verylightsql database (bad header)")
+var errUnsupportedVersion = errors.New("unsupported database format version")
+
+// initializeHeader writes a fresh header with an empty catalog.
+func initializeHeader(page []byte) {
+	clear(page)
+	copy(page[headerMagicOffset:], headerMagic)
+	binary.LittleEndian.PutUint32(page[headerFormatVersionOffset:], headerFormatVersion)
+	binary.LittleEndian.PutUint32(page[headerPageSizeOffset:], pageSize)
+	setHeaderPageCount(page, 1)
+}
+
+// validateHeader checks that page is a header this version can read.
+func validateHeader(page []byte) error {
+	if string(page[headerMagicOffset:headerMagicOffset+headerMagicSize]) != headerMagic {
+		return errNotADatabase
+	}
+	if v := binary.LittleEndian.Uint32(page[headerFormatVersionOffset:]); v != headerFormatVersion {
+		return errUnsupportedVersion
+	}
+	if binary.LittleEndian.Uint32(page[headerPageSizeOffset:]) != pageSize {
+		return errors.New("database page size does not match")
+	}
+	if headerTableCount(page) > catalogMaxTables {
+		return errors.New("database catalog is corrupt")
+	}
+	return nil
+}
+
+func headerUserVersion(page []byte) int32 {
+	return int32(binary.LittleEndian.Uint32(page[headerUserVersionOffset:]))
+}
+
+func setHeaderUserVersion(page []byte, v int32) {
+	binary.LittleEndian.PutUint32(page[headerUserVersionOffset:], uint32(v))
+}
+
+func headerApplicationID(page []byte) int32 {
+	return int32(binary.LittleEndian.Uint32(page[headerAppIDOffset:]))
+}
+
+func setHeaderApplicationID(page []byte, v int32) {
+	binary.LittleEndian.PutUint32(page[headerAppIDOffset:], uint32(v))
+}
+
+func headerFreelistHead(page []byte) uint32 {
+	return binary.LittleEndian.Uint32(page[headerFreelistHeadOffset:])
+}
+
+func headerFreelistCount(page []byte) uint32 {
+	return binary.LittleEndian.Uint32(page[headerFreelistCountOffset:])
+}
+
+func setHeaderFreelist(page []byte, head uint32, count uint32) {
+	binary.LittleEndian.PutUint32(page[headerFreelistHeadOffset:], head)
+	binary.LittleEndian.PutUint32(page[headerFreelistCountOffset:], count)
+}
+
+func headerDirty(page []byte) bool {
+	return binary.LittleEndian.Uint32(page[headerDirtyOffset:]) != 0
+}
+
+func setHeaderDirty(page []byte, dirty bool) {
+	var v uint32
+	if dirty {
+		v = 1
+	}
+	binary.LittleEndian.PutUint32(page[headerDirtyOffset:], v)
+}
+
+func headerEncryption(page []byte) uint32 {
+	return binary.LittleEndian.Uint32(page[headerEncryptionOffset:])
+}
+
+func headerEncryptionIterations(page []byte) uint32 {
+	return binary.LittleEndian.Uint32(page[headerIterationsOffset:])
+}
+
+func headerEncryptionSalt(page []byte) []byte {
+	return page[headerSaltOffset : headerSaltOffset+encryptionSaltSize]
+}
+
+func headerPageCount(page []byte) uint32 {
+	return binary.LittleEndian.Uint32(page[headerPageCountOffset:])
+}
+
+func setHeaderPageCount(page []byte, count uint32) {
+	binary.LittleEndian.PutUint32(page[headerPageCountOffset:], count)
+}
+
+// readHeaderPageCount returns the page count recorded in the header of file,
+// or false if file does not start with a header of this format version.
+func readHeaderPageCount(file Storage) (uint32, bool, error) {
+	header := make([]byte, headerSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return 0, false, err
+	}
+	if string(header[headerMagicOffset:headerMagicOffset+headerMagicSize]) != headerMagic ||
+		binary.LittleEndian.Uint32(header[headerFormatVersionOffset:]) != headerFormatVersion {
+		return 0, false, nil
+	}
+	return headerPageCount(header), true, nil
+}
+
+// setHeaderEncryption records in the header that pages are encrypted with
+// the key c derives.
+func setHeaderEncryption(page []byte, c *pageCipher) {
+	binary.LittleEndian.PutUint32(page[headerEncryptionOffset:], encryptionAESGCM)
+	binary.LittleEndian.PutUint32(page[headerIterationsOffset:], c.iterations)
+	copy(page[headerSaltOffset:], c.salt[:])
+}
+
+// UserVersion returns the user_version stored in the file header.
+func (t *Table) UserVersion() (int32, error) {
+	t.rlock()
+	defer t.runlock()
+	header, err := t.pager.getPage(headerPageNum)
+	if err != nil {
+		return 0, err
+	}
+	return headerUserVersion(header), nil
+}
+
+// SetUserVersion stores v as the user_version in the file header.
+func (t *Table) SetUserVersion(v int32) error {
+	t.lock()
+	defer t.unlock()
+	header, err := t.pager.getPageForWrite(headerPageNum)
+	if err != nil {
+		return err
+	}
+	setHeaderUserVersion(header, v)
+	return nil
+}
+
+// ApplicationID returns the application_id stored in the file header.
+func (t *Table) ApplicationID() (int32, error) {
+	t.rlock()
+	defer t.runlock()
+	header, err := t.pager.getPage(headerPageNum)
+	if err != nil {
+		return 0, err
+	}
+	return headerApplicationID(header), nil
+}
+
+// SetApplicationID stores v as the application_id in the file header.
+func (t *Table) SetApplicationID(v int32) error {
+	t.lock()
+	defer t.unlock()
+	header, err := t.pager.getPageForWrite(headerPageNum)
+	if err != nil {
+		return err
+	}
+	setHeaderApplicationID(header, v)
+	return nil
+}
+
+// FreelistCount returns the number of pages on the free list, waiting to be
+// reused.
+func (t *Table) FreelistCount() (int32, error) {
+	t.rlock()
+	defer t.runlock()
+	header, err := t.pager.getPage(headerPageNum)
+	if err != nil {
+		return 0, err
+	}
+	return int32(headerFreelistCount(header)), nil
+}
@@ -0,0 +1,727 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"unsafe"
+)
+
+// Index B-tree Layout
+//
+// An index maps keys of arbitrary bytes to the primary keys (row IDs) of the
+// rows that hold them. Its tree has its own node types. An entry is the
+// key followed by the primary key, and entries are ordered by key, then by
+// primary key, so a key shared by several rows is simply several entries
+// and every entry is unique. Leaves hold the entries; internal nodes hold
+// one cell per child but the last, with the largest entry below that child
+// as separator, like the separators of the table trees.
+//
+// Cells are variable sized and laid out like the cells of a table leaf:
+// cell pointers after the header, cells packed at the end of the page.
+//
+//	offset  size  field
+//	     0     6  common node header
+//	     6     2  number of cells (uint16)
+//	     8     4  leaf: next leaf (0 for the last); internal: right child
+//	    12     2  content start (uint16)
+//	    14     -  cell pointers (uint16 each)
+//
+//	entry:          key length (uint16) | key | primary key (uint64)
+//	leaf cell:      entry
+//	internal cell:  child page (uint32) | entry
+//
+// Deletes only remove entries: leaves are not merged and may end up empty,
+// until Vacuum rebuilds the index.
+const (
+	IndexNodeNumCellsSize      = int(unsafe.Sizeof(uint16(0)))
+	IndexNodeNumCellsOffset    = CommonHeaderSize
+	IndexNodeLinkSize          = int(unsafe.Sizeof(uint32(0)))
+	IndexNodeLinkOffset        = IndexNodeNumCellsOffset + IndexNodeNumCellsSize
+	IndexNodeContentStartSize  = int(unsafe.Sizeof(uint16(0)))
+	IndexNodeContentOffset     = IndexNodeLinkOffset + IndexNodeLinkSize
+	IndexNodeHeaderSize        = IndexNodeContentOffset + IndexNodeContentStartSize
+	IndexNodeCellPointerSize   = int(unsafe.Sizeof(uint16(0)))
+	IndexNodeSpaceForCells     = pageUsableSize - IndexNodeHeaderSize
+	indexEntryKeyLenSize       = int(unsafe.Sizeof(uint16(0)))
+	indexEntryPrimaryKeySize   = int(unsafe.Sizeof(uint64(0)))
+	indexInternalCellChildSize = int(unsafe.Sizeof(uint32(0)))
+
+	// IndexMaxKeySize is the longest key an index takes, so that every node
+	// holds at least four cells and a split always leaves both halves
+	// fitting their page.
+	IndexMaxKeySize = IndexNodeSpaceForCells/4 - IndexNodeCellPointerSize - indexInternalCellChildSize -
+		indexEntryKeyLenSize - indexEntryPrimaryKeySize
+)
+
+var ErrNoSuchIndex = errors.New("no such index")
+var ErrIndexKeyTooLong = fmt.Errorf("index keys are at most %d bytes", IndexMaxKeySize)
+
+// newIndexEntry encodes key and primary key pk as an entry.
+func newIndexEntry(key []byte, pk uint64) []byte {
+	entry := make([]byte, indexEntryKeyLenSize+len(key)+indexEntryPrimaryKeySize)
+	binary.LittleEndian.PutUint16(entry, uint16(len(key)))
+	copy(entry[indexEntryKeyLenSize:], key)
+	binary.LittleEndian.PutUint64(entry[indexEntryKeyLenSize+len(key):], pk)
+	return entry
+}
+
+// indexEntrySize returns the size of the entry at the start of b.
+func indexEntrySize(b []byte) int {
+	return indexEntryKeyLenSize + int(binary.LittleEndian.Uint16(b)) + indexEntryPrimaryKeySize
+}
+
+func indexEntryKey(entry []byte) []byte {
+	return entry[indexEntryKeyLenSize : len(entry)-indexEntryPrimaryKeySize]
+}
+
+func indexEntryPrimaryKey(entry []byte) uint64 {
+	return binary.LittleEndian.Uint64(entry[len(entry)-indexEntryPrimaryKeySize:])
+}
+
+// compareIndexEntries orders entries by key, then by primary key.
+func compareIndexEntries(a, b []byte) int {
+	if c := bytes.Compare(indexEntryKey(a), indexEntryKey(b)); c != 0 {
+		return c
+	}
+	return cmp.Compare(indexEntryPrimaryKey(a), indexEntryPrimaryKey(b))
+}
+
+// newIndexInternalCell encodes the cell of an internal node for child, whose
+// largest entry is sep.
+func newIndexInternalCell(child uint32, sep []byte) []byte {
+	cell := make([]byte, indexInternalCellChildSize+len(sep))
+	binary.LittleEndian.PutUint32(cell, child)
+	copy(cell[indexInternalCellChildSize:], sep)
+	return cell
+}
+
+func indexCellChild(cell []byte) uint32 {
+	return binary.LittleEndian.Uint32(cell)
+}
+
+func indexCellEntry(cell []byte) []byte {
+	return cell[indexInternalCellChildSize:]
+}
+
+func isIndexLeaf(node []byte) bool {
+	return nodeType(node) == NodeTypeIndexLeaf
+}
+
+func indexNodeNumCells(node []byte) field16 {
+	return field16At(node, IndexNodeNumCellsOffset)
+}
+
+// indexNodeLink is the next leaf of a leaf and the right child of an
+// internal node.
+func indexNodeLink(node []byte) field32 {
+	return field32At(node, IndexNodeLinkOffset)
+}
+
+func indexNodeContentStart(node []byte) field16 {
+	return field16At(node, IndexNodeContentOffset)
+}
+
+func indexNodeCellPointer(node []byte, cellNum int) field16 {
+	return field16At(node, IndexNodeHeaderSize+cellNum*IndexNodeCellPointerSize)
+}
+
+func indexNodeCell(node []byte, cellNum int) []byte {
+	start := int(indexNodeCellPointer(node, cellNum).get())
+	if isIndexLeaf(node) {
+		return node[start : start+indexEntrySize(node[start:])]
+	}
+	entryStart := start + indexInternalCellChildSize
+	return node[start : entryStart+indexEntrySize(node[entryStart:])]
+}
+
+// indexNodeEntry returns the entry of cell cellNum, the separator of an
+// internal node's cell.
+func indexNodeEntry(node []byte, cellNum int) []byte {
+	cell := indexNodeCell(node, cellNum)
+	if isIndexLeaf(node) {
+		return cell
+	}
+	return indexCellEntry(cell)
+}
+
+// indexNodeChild returns child childNum of an internal node, the right child
+// for the number of cells.
+func indexNodeChild(node []byte, childNum int) uint32 {
+	if childNum == int(indexNodeNumCells(node).get()) {
+		return indexNodeLink(node).get()
+	}
+	return indexCellChild(indexNodeCell(node, childNum))
+}
+
+func initializeIndexNode(node []byte, leaf bool) {
+	setNodeType(node, NodeTypeIndexInternal)
+	if leaf {
+		setNodeType(node, NodeTypeIndexLeaf)
+	}
+	setNodeRoot(node, false)
+	indexNodeLink(node).set(0)
+	indexNodeSetCells(node, nil)
+}
+
+// indexCellsSpace returns the number of bytes cells take in a node, with
+// their pointers.
+func indexCellsSpace(cells [][]byte) int {
+	space := 0
+	for _, cell := range cells {
+		space += len(cell) + IndexNodeCellPointerSize
+	}
+	return space
+}
+
+// indexNodeInsertCell copies cell into the node at index cellNum, shifting
+// the pointers of the cells after it right. It returns false if the node has
+// no room left.
+func indexNodeInsertCell(node []byte, cellNum int, cell []byte) bool {
+	numCells := int(indexNodeNumCells(node).get())
+	start := int(indexNodeContentStart(node).get()) - len(cell)
+	if start < IndexNodeHeaderSize+(numCells+1)*IndexNodeCellPointerSize {
+		return false
+	}
+	pointers := node[IndexNodeHeaderSize : IndexNodeHeaderSize+(numCells+1)*IndexNodeCellPointerSize]
+	copy(pointers[(cellNum+1)*IndexNodeCellPointerSize:], pointers[cellNum*IndexNodeCellPointerSize:])
+	copy(node[start:], cell)
+	indexNodeCellPointer(node, cellNum).set(uint16(start))
+	indexNodeContentStart(node).set(uint16(start))
+	indexNodeNumCells(node).set(uint16(numCells + 1))
+	return true
+}
+
+// indexNodeCells returns copies of the cells of the node, in order.
+func indexNodeCells(node []byte) [][]byte {
+	cells := make([][]byte, indexNodeNumCells(node).get())
+	for i := range cells {
+		cells[i] = slices.Clone(indexNodeCell(node, i))
+	}
+	return cells
+}
+
+// indexNodeSetCells replaces the cells of the node with copies of cells,
+// which must not alias it. The caller makes sure they fit.
+func indexNodeSetCells(node []byte, cells [][]byte) {
+	indexNodeNumCells(node).set(0)
+	indexNodeContentStart(node).set(pageUsableSize)
+	for i, cell := range cells {
+		indexNodeInsertCell(node, i, cell)
+	}
+}
+
+// indexNodeSearch returns the index of the first cell of the node whose
+// entry is not less than entry, the number of cells if there is none. In an
+// internal node that is the child whose subtree entry belongs to.
+func indexNodeSearch(node []byte, entry []byte) int {
+	i, j := 0, int(indexNodeNumCells(node).get())
+	for i < j {
+		mid := (i + j) / 2
+		if compareIndexEntries(indexNodeEntry(node, mid), entry) < 0 {
+			i = mid + 1
+		} else {
+			j = mid
+		}
+	}
+	return i
+}
+
+// indexSplitPoint returns how many of cells stay in the left node when a node
+// holding them is split. For an internal node the cell after them moves up
+// to the parent and the rest go right. Nodes are split in half by size,
+// except when the cells were added past the end of the index (atEnd): the
+// left node then keeps all it can, so entries inserted in order leave full
+// nodes behind.
+func indexSplitPoint(cells [][]byte, leaf bool, atEnd bool) int {
+	last := len(cells) - 1
+	if !leaf {
+		last--
+	}
+	if atEnd {
+		return last
+	}
+	total, left := indexCellsSpace(cells), 0
+	split := last
+	for i, cell := range cells[:last] {
+		left += len(cell) + IndexNodeCellPointerSize
+		if 2*left >= total {
+			split = i + 1
+			break
+		}
+	}
+	return split
+}
+
+// indexTree is the B-tree of an index in a pager. unique trees take a key
+// only once.
+type indexTree struct {
+	pager       *Pager
+	rootPageNum uint32
+	unique      bool
+}
+
+// createIndexTree allocates the root page of an empty index tree.
+func createIndexTree(p *Pager) (uint32, error) {
+	rootPageNum, err := p.getUnusedPageNum()
+	if err != nil {
+		return 0, err
+	}
+	root, err := p.getPageForWrite(rootPageNum)
+	if err != nil {
+		return 0, err
+	}
+	initializeIndexNode(root, true)
+	setNodeRoot(root, true)
+	return rootPageNum, nil
+}
+
+// seek returns the leaf entry belongs in and the index of the first cell of
+// that leaf whose entry is not less than it.
+func (x indexTree) seek(entry []byte) (pageNum uint32, cellNum int, err error) {
+	pageNum = x.rootPageNum
+	for {
+		node, err := x.pager.getPage(pageNum)
+		if err != nil {
+			return 0, 0, err
+		}
+		i := indexNodeSearch(node, entry)
+		if isIndexLeaf(node) {
+			return pageNum, i, nil
+		}
+		pageNum = indexNodeChild(node, i)
+	}
+}
+
+// indexCursor walks the entries of an index tree in order.
+type indexCursor struct {
+	tree    indexTree
+	pageNum uint32
+	cellNum int
+}
+
+// seekCursor returns a cursor at the first entry not less than entry.
+func (x indexTree) seekCursor(entry []byte) (*indexCursor, error) {
+	pageNum, cellNum, err := x.seek(entry)
+	if err != nil {
+		return nil, err
+	}
+	return &indexCursor{tree: x, pageNum: pageNum, cellNum: cellNum}, nil
+}
+
+// entry returns the entry at the cursor, moving on to the next leaf first if
+// it is past the end of its leaf. ok is false past the last entry.
+func (c *indexCursor) entry() (entry []byte, ok bool, err error) {
+	for {
+		node, err := c.tree.pager.getPage(c.pageNum)
+		if err != nil {
+			return nil, false, err
+		}
+		if c.cellNum < int(indexNodeNumCells(node).get()) {
+			return indexNodeEntry(node, c.cellNum), true, nil
+		}
+		next := indexNodeLink(node).get()
+		if next == 0 {
+			return nil, false, nil
+		}
+		c.pageNum, c.cellNum = next, 0
+	}
+}
+
+func (c *indexCursor) advance() {
+	c.cellNum++
+}
+
+// insert adds entry to the tree. It fails with ErrDuplicateKey if the tree
+// already holds it, or its key if the tree is unique.
+func (x indexTree) insert(entry []byte) error {
+	if x.unique {
+		c, err := x.seekCursor(newIndexEntry(indexEntryKey(entry), 0))
+		if err != nil {
+			return err
+		}
+		existing, ok, err := c.entry()
+		if err != nil {
+			return err
+		}
+		if ok && bytes.Equal(indexEntryKey(existing), indexEntryKey(entry)) {
+			return ErrDuplicateKey
+		}
+	}
+
+	pageNum, cellNum, err := x.seek(entry)
+	if err != nil {
+		return err
+	}
+	node, err := x.pager.getPageForWrite(pageNum)
+	if err != nil {
+		return err
+	}
+	// An equal entry is always in the leaf seek ends in: the separators
+	// route it there
+	if cellNum < int(indexNodeNumCells(node).get()) && compareIndexEntries(indexNodeEntry(node, cellNum), entry) == 0 {
+		return ErrDuplicateKey
+	}
+	if indexNodeInsertCell(node, cellNum, entry) {
+		return nil
+	}
+
+	// A split can go up to the root, which takes two new pages. Running out
+	// of pages half way would leave the tree broken
+	free, err := x.pager.freePageCount()
+	if err != nil {
+		return err
+	}
+	depth, err := x.depth()
+	if err != nil {
+		return err
+	}
+	if free < depth+1 {
+		return ErrTableFull
+	}
+	atEnd := indexNodeLink(node).get() == 0 && cellNum == int(indexNodeNumCells(node).get())
+	cells := slices.Insert(indexNodeCells(node), cellNum, entry)
+	return x.split(pageNum, cells, 0, atEnd)
+}
+
+// depth returns the number of levels of the tree.
+func (x indexTree) depth() (int, error) {
+	pageNum := x.rootPageNum
+	for depth := 1; ; depth++ {
+		node, err := x.pager.getPage(pageNum)
+		if err != nil {
+			return 0, err
+		}
+		if isIndexLeaf(node) {
+			return depth, nil
+		}
+		pageNum = indexNodeChild(node, 0)
+	}
+}
+
+// delete removes entry from the tree and reports whether it was there.
+func (x indexTree) delete(entry []byte) (bool, error) {
+	pageNum, cellNum, err := x.seek(entry)
+	if err != nil {
+		return false, err
+	}
+	node, err := x.pager.getPage(pageNum)
+	if err != nil {
+		return false, err
+	}
+	if cellNum == int(indexNodeNumCells(node).get()) || compareIndexEntries(indexNodeEntry(node, cellNum), entry) != 0 {
+		return false, nil
+	}
+	if node, err = x.pager.getPageForWrite(pageNum); err != nil {
+		return false, err
+	}
+	cells := indexNodeCells(node)
+	indexNodeSetCells(node, slices.Delete(cells, cellNum, cellNum+1))
+	return true, nil
+}
+
+// split divides cells, which no longer fit the node at pageNum, between that
+// node and a new one to its right, and links the new node into the parent.
+// rightChild is the right child that goes with cells in an internal node.
+// atEnd says the cells were added past the end of the index, see
+// indexSplitPoint. The root stays on its page: its halves both move to new
+// pages below it.
+func (x indexTree) split(pageNum uint32, cells [][]byte, rightChild uint32, atEnd bool) error {
+	node, err := x.pager.getPageForWrite(pageNum)
+	if err != nil {
+		return err
+	}
+	leaf := isIndexLeaf(node)
+	m := indexSplitPoint(cells, leaf, atEnd)
+	left, right, leftLink := cells[:m], cells[m:], uint32(0)
+	sep := cells[m-1]
+	if !leaf {
+		right, leftLink, sep = cells[m+1:], indexCellChild(cells[m]), indexCellEntry(cells[m])
+	}
+
+	rightPageNum, rightNode, err := x.newNode(leaf)
+	if err != nil {
+		return err
+	}
+	indexNodeSetCells(rightNode, right)
+
+	if isNodeRoot(node) {
+		leftPageNum, leftNode, err := x.newNode(leaf)
+		if err != nil {
+			return err
+		}
+		indexNodeSetCells(leftNode, left)
+		if leaf {
+			indexNodeLink(leftNode).set(rightPageNum)
+		} else {
+			indexNodeLink(leftNode).set(leftLink)
+			indexNodeLink(rightNode).set(rightChild)
+		}
+		nodeParent(leftNode).set(pageNum)
+		nodeParent(rightNode).set(pageNum)
+
+		initializeIndexNode(node, false)
+		setNodeRoot(node, true)
+		indexNodeSetCells(node, [][]byte{newIndexInternalCell(leftPageNum, sep)})
+		indexNodeLink(node).set(rightPageNum)
+		if err := x.adoptChildren(leftPageNum); err != nil {
+			return err
+		}
+		return x.adoptChildren(rightPageNum)
+	}
+
+	indexNodeSetCells(node, left)
+	if leaf {
+		indexNodeLink(rightNode).set(indexNodeLink(node).get())
+		indexNodeLink(node).set(rightPageNum)
+	} else {
+		indexNodeLink(node).set(leftLink)
+		indexNodeLink(rightNode).set(rightChild)
+	}
+	parentPageNum := nodeParent(node).get()
+	nodeParent(rightNode).set(parentPageNum)
+	if err := x.adoptChildren(rightPageNum); err != nil {
+		return err
+	}
+	return x.promote(parentPageNum, pageNum, sep, rightPageNum, atEnd)
+}
+
+// promote links newPageNum, the right half of the split of child pageNum of
+// the internal node parentPageNum, into that node. sep is the largest entry
+// left in pageNum.
+func (x indexTree) promote(parentPageNum, pageNum uint32, sep []byte, newPageNum uint32, atEnd bool) error {
+	parent, err := x.pager.getPageForWrite(parentPageNum)
+	if err != nil {
+		return err
+	}
+	cells := indexNodeCells(parent)
+	rightChild := indexNodeLink(parent).get()
+	i := slices.IndexFunc(cells, func(cell []byte) bool { return indexCellChild(cell) == pageNum })
+	if i >= 0 {
+		// The new node takes over the old separator
+		old := indexCellEntry(cells[i])
+		cells[i] = newIndexInternalCell(pageNum, sep)
+		cells = slices.Insert(cells, i+1, newIndexInternalCell(newPageNum, old))
+		atEnd = false
+	} else {
+		cells = append(cells, newIndexInternalCell(pageNum, sep))
+		rightChild = newPageNum
+	}
+
+	if indexCellsSpace(cells) <= IndexNodeSpaceForCells {
+		indexNodeSetCells(parent, cells)
+		indexNodeLink(parent).set(rightChild)
+		return nil
+	}
+	return x.split(parentPageNum, cells, rightChild, atEnd)
+}
+
+// newNode allocates an empty node that is not the root.
+func (x indexTree) newNode(leaf bool) (uint32, []byte, error) {
+	pageNum, err := x.pager.getUnusedPageNum()
+	if err != nil {
+		return 0, nil, err
+	}
+	node, err := x.pager.getPageForWrite(pageNum)
+	if err != nil {
+		return 0, nil, err
+	}
+	initializeIndexNode(node, leaf)
+	return pageNum, node, nil
+}
+
+// adoptChildren points the children of the node at pageNum, if it is an
+// internal node, back at it.
+func (x indexTree) adoptChildren(pageNum uint32) error {
+	node, err := x.pager.getPage(pageNum)
+	if err != nil {
+		return err
+	}
+	if isIndexLeaf(node) {
+		return nil
+	}
+	for i := 0; i <= int(indexNodeNumCells(node).get()); i++ {
+		child, err := x.pager.getPageForWrite(indexNodeChild(node, i))
+		if err != nil {
+			return err
+		}
+		nodeParent(child).set(pageNum)
+	}
+	return nil
+}
+
+// entries calls fn with every entry of the tree in order, until it returns
+// false.
+func (x indexTree) entries(fn func(entry []byte) bool) error {
+	c := &indexCursor{tree: x, pageNum: x.rootPageNum}
+	for {
+		node, err := x.pager.getPage(c.pageNum)
+		if err != nil {
+			return err
+		}
+		if isIndexLeaf(node) {
+			break
+		}
+		c.pageNum = indexNodeChild(node, 0)
+	}
+	for {
+		entry, ok, err := c.entry()
+		if err != nil || !ok {
+			return err
+		}
+		if !fn(entry) {
+			return nil
+		}
+		c.advance()
+	}
+}
+
+// checkIndexTree checks the structure of the index subtree rooted at pageNum,
+// the child of parent (0 for the root), and marks its pages in used: every
+// page must exist and belong to one node only, nodes must be index nodes
+// whose cells fit the page, and their entries must be increasing and lie
+// above low, if not nil, and at most high, if not nil.
+func checkIndexTree(p *Pager, pageNum uint32, parent uint32, low, high []byte, used []bool) error {
+	if pageNum == headerPageNum || pageNum >= uint32(len(used)) {
+		return fmt.Errorf("page %d out of range", pageNum)
+	}
+	if used[pageNum] {
+		return fmt.Errorf("page %d is linked twice", pageNum)
+	}
+	used[pageNum] = true
+
+	node, err := p.getPage(pageNum)
+	if err != nil {
+		return err
+	}
+	if t := nodeType(node); t != NodeTypeIndexLeaf && t != NodeTypeIndexInternal {
+		return fmt.Errorf("page %d is not an index node", pageNum)
+	}
+	if isNodeRoot(node) != (parent == 0) || parent != 0 && nodeParent(node).get() != parent {
+		return fmt.Errorf("index node %d is not linked to its parent %d", pageNum, parent)
+	}
+	numCells := int(indexNodeNumCells(node).get())
+	contentStart := int(indexNodeContentStart(node).get())
+	if IndexNodeHeaderSize+numCells*IndexNodeCellPointerSize > contentStart || contentStart > pageUsableSize {
+		return fmt.Errorf("index node %d has %d cells but its content starts at %d", pageNum, numCells, contentStart)
+	}
+	if !isIndexLeaf(node) && numCells == 0 {
+		return fmt.Errorf("index node %d has no cells", pageNum)
+	}
+
+	entryOffset := 0
+	if !isIndexLeaf(node) {
+		entryOffset = indexInternalCellChildSize
+	}
+	for i := range numCells {
+		start := int(indexNodeCellPointer(node, i).get()) + entryOffset
+		if start < contentStart || start+indexEntryKeyLenSize > pageUsableSize ||
+			start+indexEntrySize(node[start:]) > pageUsableSize {
+			return fmt.Errorf("index node %d cell %d out of place", pageNum, i)
+		}
+		entry := indexNodeEntry(node, i)
+		if i > 0 && compareIndexEntries(indexNodeEntry(node, i-1), entry) >= 0 {
+			return fmt.Errorf("index node %d entries out of order", pageNum)
+		}
+		if low != nil && compareIndexEntries(entry, low) <= 0 || high != nil && compareIndexEntries(entry, high) > 0 {
+			return fmt.Errorf("index node %d cell %d is outside the range of its parent's separators", pageNum, i)
+		}
+	}
+	if isIndexLeaf(node) {
+		return nil
+	}
+	for i := 0; i <= numCells; i++ {
+		childLow, childHigh := low, high
+		if i > 0 {
+			childLow = indexNodeEntry(node, i-1)
+		}
+		if i < numCells {
+			childHigh = indexNodeEntry(node, i)
+		}
+		if err := checkIndexTree(p, indexNodeChild(node, i), pageNum, childLow, childHigh, used); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyIndex inserts every entry of the index tree src into dst, in order, so
+// dst ends up with full nodes.
+func copyIndex(src, dst indexTree) error {
+	var err error
+	if walkErr := src.entries(func(entry []byte) bool {
+		err = dst.insert(slices.Clone(entry))
+		return err == nil
+	}); walkErr != nil {
+		return walkErr
+	}
+	return err
+}
+
+// Index is a secondary index of a database: a B-tree mapping keys of up to
+// IndexMaxKeySize bytes to primary keys. It only stores what it is given;
+// keeping it in step with a table is up to the caller.
+type Index struct {
+	db           *Database
+	name         string
+	catalogIndex uint32
+	rootPageNum  uint32
+	unique       bool
+}
+
+func (x *Index) tree() indexTree {
+	return indexTree{pager: x.db.pager, rootPageNum: x.rootPageNum, unique: x.unique}
+}
+
+// Insert adds the entry for key and primary key pk. It fails with
+// ErrDuplicateKey if the index already has it, or has key at all if the
+// index is unique.
+func (x *Index) Insert(key []byte, pk uint64) error {
+	x.db.latch.Lock()
+	defer x.db.latch.Unlock()
+	if len(key) > IndexMaxKeySize {
+		return ErrIndexKeyTooLong
+	}
+	return x.tree().insert(newIndexEntry(key, pk))
+}
+
+// Delete removes the entry for key and primary key pk and reports whether
+// the index had it.
+func (x *Index) Delete(key []byte, pk uint64) (bool, error) {
+	x.db.latch.Lock()
+	defer x.db.latch.Unlock()
+	if len(key) > IndexMaxKeySize {
+		return false, nil
+	}
+	return x.tree().delete(newIndexEntry(key, pk))
+}
+
+// Lookup returns the primary keys stored under key, in increasing order.
+func (x *Index) Lookup(key []byte) ([]uint64, error) {
+	x.db.latch.RLock()
+	defer x.db.latch.RUnlock()
+	c, err := x.tree().seekCursor(newIndexEntry(key, 0))
+	if err != nil {
+		return nil, err
+	}
+	var pks []uint64
+	for {
+		entry, ok, err := c.entry()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || !bytes.Equal(indexEntryKey(entry), key) {
+			return pks, nil
+		}
+		pks = append(pks, indexEntryPrimaryKey(entry))
+		c.advance()
+	}
+}
+
+// Unique reports whether the index takes every key only once.
+func (x *Index) Unique() bool {
+	return x.unique
+}
@@ -0,0 +1,186 @@
+package main
+
+import "fmt"
+
+// integrityChecker walks the trees of a database and collects every
+// violation of the invariants the B-tree code relies on. Unlike checkTree,
+// which stops at the first sign of damage, it goes on past a broken node to
+// report the rest, and it also checks the links that only matter for
+// correct results: key ranges against the parent's separators, parent
+// pointers, root flags and the sibling chains.
+type integrityChecker struct {
+	p        *Pager
+	used     []bool
+	problems []string
+	// leaves and levels are the pages of the current table in key order,
+	// leaves for the leaf level and levels[d] for the internal nodes at depth d
+	leaves []leafLink
+	levels [][]internalLink
+}
+
+type leafLink struct {
+	pageNum, next uint32
+}
+
+type internalLink struct {
+	pageNum, next, prev uint32
+}
+
+// keyRange bounds the keys of a subtree: greater than low, if hasLow, and
+// at most high, if hasHigh.
+type keyRange struct {
+	low, high       uint64
+	hasLow, hasHigh bool
+}
+
+func (r keyRange) contains(key uint64) bool {
+	return (!r.hasLow || key > r.low) && (!r.hasHigh || key <= r.high)
+}
+
+// IntegrityCheck checks every table's tree and returns a description of
+// each violation found, none if the database is sound: keys out of order or
+// outside the range their parent's separators give them, wrong cell counts,
+// pages linked twice or not holding a node, parent pointers and root flags
+// that do not match the tree, and leaf and internal sibling chains that skip
+// or repeat nodes. Pages that cannot be read count as violations too.
+func (db *Database) IntegrityCheck() ([]string, error) {
+	db.latch.RLock()
+	defer db.latch.RUnlock()
+	header, err := db.pager.getPage(headerPageNum)
+	if err != nil {
+		return nil, err
+	}
+	c := &integrityChecker{p: db.pager, used: make([]bool, db.pager.numPages)}
+	c.used[headerPageNum] = true
+	for i := range headerTableCount(header) {
+		entry := catalogEntry(header, i)
+		if catalogEntryKind(entry) != catalogKindTable {
+			// Index trees get the checks of recovery, which stop at the
+			// first problem
+			if err := checkIndexTree(c.p, catalogEntryRootPage(entry), 0, nil, nil, c.used); err != nil {
+				c.problems = append(c.problems, fmt.Sprintf("index %s: %v", catalogEntryName(entry), err))
+			}
+			continue
+		}
+		c.checkTable(catalogEntryName(entry), catalogEntryRootPage(entry))
+	}
+	return c.problems, nil
+}
+
+func (c *integrityChecker) report(table string, format string, args ...any) {
+	c.problems = append(c.problems, fmt.Sprintf("table %s: ", table)+fmt.Sprintf(format, args...))
+}
+
+func (c *integrityChecker) checkTable(table string, rootPageNum uint32) {
+	c.leaves, c.levels = c.leaves[:0], c.levels[:0]
+	c.checkNode(table, rootPageNum, 0, 0, keyRange{})
+
+	for i, leaf := range c.leaves {
+		want := uint32(0)
+		if i+1 < len(c.leaves) {
+			want = c.leaves[i+1].pageNum
+		}
+		if leaf.next != want {
+			c.report(table, "leaf %d links to next leaf %d instead of %d", leaf.pageNum, leaf.next, want)
+		}
+	}
+	for _, level := range c.levels {
+		for i, node := range level {
+			wantNext, wantPrev := uint32(0), uint32(0)
+			if i+1 < len(level) {
+				wantNext = level[i+1].pageNum
+			}
+			if i > 0 {
+				wantPrev = level[i-1].pageNum
+			}
+			if node.next != wantNext {
+				c.report(table, "internal node %d links to next sibling %d instead of %d", node.pageNum, node.next, wantNext)
+			}
+			if node.prev != wantPrev {
+				c.report(table, "internal node %d links to previous sibling %d instead of %d", node.pageNum, node.prev, wantPrev)
+			}
+		}
+	}
+}
+
+// checkNode checks the subtree rooted at pageNum, the child of parent (0 for
+// the root) at the given depth whose keys must lie in r.
+func (c *integrityChecker) checkNode(table string, pageNum uint32, parent uint32, depth int, r keyRange) {
+	if pageNum == headerPageNum || pageNum >= uint32(len(c.used)) {
+		c.report(table, "page %d out of range", pageNum)
+		return
+	}
+	if c.used[pageNum] {
+		c.report(table, "page %d is linked twice", pageNum)
+		return
+	}
+	c.used[pageNum] = true
+
+	node, err := c.p.getPage(pageNum)
+	if err != nil {
+		c.report(table, "page %d: %v", pageNum, err)
+		return
+	}
+	isRoot := parent == 0
+	if isNodeRoot(node) != isRoot {
+		c.report(table, "page %d has root flag %t", pageNum, isNodeRoot(node))
+	}
+	if !isRoot && nodeParent(node).get() != parent {
+		c.report(table, "page %d points to parent %d instead of %d", pageNum, nodeParent(node).get(), parent)
+	}
+
+	switch nodeType(node) {
+	case NodeTypeLeaf:
+		c.leaves = append(c.leaves, leafLink{pageNum: pageNum, next: leafNodeNextLeaf(node).get()})
+		if err := checkLeaf(node, pageNum); err != nil {
+			c.report(table, "%v", err)
+			return
+		}
+		numCells := leafNodeNumCells(node).get()
+		if numCells == 0 && !isRoot {
+			c.report(table, "leaf %d is empty", pageNum)
+		}
+		// One key out of range is enough to tell the leaf is misplaced
+		for i := range numCells {
+			if key := leafNodeKey(node, i); !r.contains(key) {
+				c.report(table, "leaf %d key %d is outside the range of its parent's separators", pageNum, key)
+				break
+			}
+		}
+	case NodeTypeInternal:
+		if depth == len(c.levels) {
+			c.levels = append(c.levels, nil)
+		}
+		c.levels[depth] = append(c.levels[depth], internalLink{
+			pageNum: pageNum,
+			next:    internalNodeNextSibling(node).get(),
+			prev:    internalNodePrevSibling(node).get(),
+		})
+		numKeys := internalNodeNumKeys(node).get()
+		if numKeys == 0 || numKeys > uint32(InternalNodeMaxKeys) {
+			c.report(table, "internal node %d has %d keys", pageNum, numKeys)
+			return
+		}
+		for i := range numKeys {
+			key := internalNodeKey(node, i).get()
+			if i > 0 && key <= internalNodeKey(node, i-1).get() {
+				c.report(table, "internal node %d keys out of order at cell %d", pageNum, i)
+			}
+			if !r.contains(key) {
+				c.report(table, "internal node %d key %d is outside the range of its parent's separators", pageNum, key)
+			}
+		}
+		for i := uint32(0); i <= numKeys; i++ {
+			child := r
+			if i > 0 {
+				child.low, child.hasLow = internalNodeKey(node, i-1).get(), true
+			}
+			if i < numKeys {
+				child.high, child.hasHigh = internalNodeKey(node, i).get(), true
+			}
+			c.checkNode(table, internalNodeChild(node, i).get(), pageNum, depth+1, child)
+		}
+	default:
+		c.report(table, "page %d is not a tree node", pageNum)
+	}
+}
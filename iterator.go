@@ -0,0 +1,104 @@
+package main
+
+// RowIterator reads the rows of a table in key order, rowBatchSize rows at a
+// time, so a program walks a large table with one batch of rows in memory
+// instead of all of them like SelectAll. It is used like bufio.Scanner:
+//
+//	it := table.Rows()
+//	for it.Next() {
+//		row := it.Row()
+//	}
+//	if err := it.Err(); err != nil { ... }
+//
+// Each batch is read under the latch, which is released between batches, so
+// the loop may change the table. A batch continues after the last key of the
+// previous one: rows inserted or deleted further on are seen as they are by
+// then. For a view that does not change, iterate within a ReadTx or over a
+// snapshot table.
+type RowIterator struct {
+	t *Table
+	// inTx is set for iterators of a ReadTable, whose transaction holds the
+	// latch already.
+	inTx bool
+	// next is the key the next batch starts at. end is set once a batch
+	// reached the last leaf.
+	next uint64
+	end  bool
+	rows []Row
+	pos  int
+	row  Row
+	err  error
+}
+
+// Rows returns an iterator over the rows of the table.
+func (t *Table) Rows() *RowIterator {
+	return &RowIterator{t: t}
+}
+
+// Next moves to the next row and reports whether there is one. It returns
+// false at the end of the table or when reading a page fails, see Err.
+func (it *RowIterator) Next() bool {
+	if it.pos == len(it.rows) {
+		if it.end || it.err != nil {
+			return false
+		}
+		it.fill()
+		if it.pos == len(it.rows) {
+			return false
+		}
+	}
+	it.row = it.rows[it.pos]
+	it.pos++
+	return true
+}
+
+// Row returns the row Next moved to.
+func (it *RowIterator) Row() Row {
+	return it.row
+}
+
+// Err returns the error that ended the iteration, or nil if it reached the
+// end of the table.
+func (it *RowIterator) Err() error {
+	return it.err
+}
+
+// fill reads the next batch of rows, deserializing them from the leaves
+// starting at the one holding it.next.
+func (it *RowIterator) fill() {
+	t := it.t
+	if !it.inTx {
+		t.rlock()
+		defer t.runlock()
+	}
+	it.rows, it.pos = it.rows[:0], 0
+
+	start, err := t.findKey(it.next)
+	if err != nil {
+		it.err = err
+		return
+	}
+	pageNum, cellNum := start.pageNum, start.cellNum
+	for len(it.rows) < rowBatchSize {
+		page, err := t.pager.getPage(pageNum)
+		if err != nil {
+			it.err = err
+			return
+		}
+		if cellNum < leafNodeNumCells(page).get() {
+			var row Row
+			deserializeRow(leafNodeValue(page, cellNum), &row)
+			it.rows = append(it.rows, row)
+			cellNum++
+			continue
+		}
+		if pageNum = leafNodeNextLeaf(page).get(); pageNum == 0 {
+			it.end = true
+			break
+		}
+		cellNum = 0
+	}
+	if n := len(it.rows); n > 0 {
+		it.next = uint64(it.rows[n-1].ID) + 1
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestRowsReadsEveryRowInBatches(t *testing.T) {
+	table := openTestTable(t)
+	n := 3*rowBatchSize + 5
+	rows := make([]Row, n)
+	for i := range rows {
+		rows[i] = *createRow(int64(2 * i))
+	}
+	if err := table.InsertMany(rows); err != nil {
+		t.Fatal(err)
+	}
+
+	// The latch is free between batches, so the loop can write to the table
+	it := table.Rows()
+	var ids []int64
+	for it.Next() {
+		row := it.Row()
+		ids = append(ids, row.ID)
+		if row.ID%2 == 0 {
+			if err := table.Insert(createRow(row.ID + 1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("row %d has id %d after %d, want increasing ids", i, ids[i], ids[i-1])
+		}
+	}
+	// Rows inserted past the current batch are read by a later one, those
+	// inserted after the last batch are not
+	if ids[0] != 0 || ids[len(ids)-1] != int64(2*n-2) {
+		t.Fatalf("read ids %d to %d, want 0 to %d", ids[0], ids[len(ids)-1], 2*n-2)
+	}
+	if len(ids) <= n {
+		t.Fatalf("read %d rows, want the %d loaded and some inserted during the loop", len(ids), n)
+	}
+}
+
+func TestRowsOfEmptyTable(t *testing.T) {
+	it := openTestTable(t).Rows()
+	if it.Next() {
+		t.Fatalf("Next() = true on an empty table, row %+v", it.Row())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
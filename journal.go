@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+)
+
+// Rollback Journal Layout
+//
+// Changed pages stay in memory until commit writes them to the database
+// file: when a transaction is committed (or its WithGroupCommit flush runs),
+// at a Checkpoint or a background checkpoint, and at Close. Before
+// overwriting any page, commit saves the original contents of the pages it is
+// about to change in a journal next to the database (its path plus
+// journalSuffix). A crash while the database file is being written leaves the
+// journal behind, and the next open copies the saved pages back, restoring
+// the file as it was before the interrupted commit. All fields are little
+// endian.
+//
+//	offset  size  field
+//	     0    16  magic string "vlsql-journal\x00\x00\x00"
+//	    16     4  page size (uint32)
+//	    20     4  number of saved pages (uint32)
+//	    24     8  size of the database file before the commit (uint64)
+//	    32     -  saved pages, each a page number (uint32) and the page
+//
+// The header is written last, after the saved pages are synced (with
+// SyncFull), so a journal cut short by a crash has no valid header and is
+// ignored: the database file was not touched yet. An empty journal, or none
+// at all, means there is nothing to roll back.
+const (
+	journalSuffix = "-journal"
+
+	journalMagicOffset     = 0
+	journalMagicSize       = 16
+	journalPageSizeOffset  = journalMagicOffset + journalMagicSize
+	journalPageCountOffset = journalPageSizeOffset + 4
+	journalFileSizeOffset  = journalPageCountOffset + 4
+	journalHeaderSize      = journalFileSizeOffset + 8
+	journalRecordSize      = 4 + pageSize
+
+	journalMagic = "vlsql-journal\x00\x00\x00"
+)
+
+var errCorruptJournal = errors.New("rollback journal is corrupt")
+
+// SyncMode says how commit makes sure the journal and the pages it writes
+// reach stable storage rather than only the operating system's cache.
+type SyncMode uint8
+
+const (
+	// SyncFull syncs the saved pages of the journal before writing its
+	// header, the header, and the database file. A power loss at any point
+	// leaves either the old or the new contents.
+	SyncFull SyncMode = iota
+	// SyncNormal syncs the journal once, after its header, and the
+	// database file. A power loss while the journal is written can leave a
+	// valid header in front of pages that never reached the disk.
+	SyncNormal
+	// SyncOff never syncs. The journal still protects against the process
+	// dying, but not against the operating system losing its cache.
+	SyncOff
+)
+
+// commit writes every cached page back to the file. The original contents of
+// the pages that change are journaled first, so a crash part way through
+// leaves a journal that restores the previous state on the next open. With a
+// double-write file, the new contents are saved there next (see
+// writeDoubleWrite). The header's dirty flag is set in the first page written
+// and cleared once all pages are synced, so a torn commit without a usable
+// journal is detected too (see recoverDatabase). Nothing is written when no
+// page is dirty.
+func (p *Pager) commit() error {
+	if !slices.Contains(p.dirty[:p.numPages], true) {
+		return nil
+	}
+
+	header, err := p.getPageForWrite(headerPageNum)
+	if err != nil {
+		return err
+	}
+	// Set before journaling, so the header is always saved clean
+	setHeaderDirty(header, true)
+
+	journal, err := p.openStorage(p.journalPath)
+	if err != nil {
+		return err
+	}
+	if err := p.writeJournal(journal); err != nil {
+		journal.Close()
+		return err
+	}
+	var doubleWrite Storage
+	if p.doubleWritePath != "" {
+		if doubleWrite, err = p.openStorage(p.doubleWritePath); err != nil {
+			journal.Close()
+			return err
+		}
+		if err := p.writeDoubleWrite(doubleWrite); err != nil {
+			doubleWrite.Close()
+			journal.Close()
+			return err
+		}
+	}
+
+	if err := p.writeBack(header); err != nil {
+		if doubleWrite != nil {
+			doubleWrite.Close()
+		}
+		journal.Close()
+		return err
+	}
+	if doubleWrite != nil {
+		if err := discardJournal(doubleWrite); err != nil {
+			journal.Close()
+			return err
+		}
+	}
+	return discardJournal(journal)
+}
+
+// writeBack writes the dirty pages to the file and syncs it, then clears the
+// dirty flag in header and syncs again.
+func (p *Pager) writeBack(header []byte) error {
+	if err := p.flushAll(); err != nil {
+		return err
+	}
+	if err := p.sync(); err != nil {
+		return err
+	}
+
+	setHeaderDirty(header, false)
+	if err := p.flush(headerPageNum); err != nil {
+		return err
+	}
+	if err := p.sync(); err != nil {
+		return err
+	}
+	p.fileLength = int64(p.numPages) * pageSize
+	p.allocatedLength = max(p.allocatedLength, p.fileLength)
+	return nil
+}
+
+// sync flushes the database file to stable storage unless syncing is off.
+func (p *Pager) sync() error {
+	if p.syncMode == SyncOff {
+		return nil
+	}
+	return p.file.Sync()
+}
+
+// writeJournal saves the pages of the file whose dirty cached copies differ
+// from them, then the header that makes the journal valid.
+func (p *Pager) writeJournal(journal Storage) error {
+	filePages := uint32(p.fileLength / pageSize)
+	buf := getPageBuffer()
+	defer putPageBuffer(buf)
+	disk := buf[:]
+	record := make([]byte, journalRecordSize)
+
+	offset := int64(journalHeaderSize)
+	count := uint32(0)
+	for pageNum := range min(filePages, p.numPages) {
+		page := p.pages[pageNum]
+		if !p.dirty[pageNum] {
+			continue
+		}
+		if _, err := p.file.ReadAt(disk, int64(pageNum)*pageSize); err != nil && err != io.EOF {
+			return err
+		}
+		if string(disk) == string(page) {
+			continue
+		}
+
+		binary.LittleEndian.PutUint32(record, pageNum)
+		copy(record[4:], disk)
+		if _, err := journal.WriteAt(record, offset); err != nil {
+			return err
+		}
+		offset += journalRecordSize
+		count++
+	}
+	if p.syncMode == SyncFull {
+		if err := journal.Sync(); err != nil {
+			return err
+		}
+	}
+
+	header := make([]byte, journalHeaderSize)
+	copy(header[journalMagicOffset:], journalMagic)
+	binary.LittleEndian.PutUint32(header[journalPageSizeOffset:], pageSize)
+	binary.LittleEndian.PutUint32(header[journalPageCountOffset:], count)
+	binary.LittleEndian.PutUint64(header[journalFileSizeOffset:], uint64(p.fileLength))
+	if _, err := journal.WriteAt(header, 0); err != nil {
+		return err
+	}
+	if p.syncMode == SyncOff {
+		return nil
+	}
+	return journal.Sync()
+}
+
+// rollbackJournal restores file from the journal at path if a commit did not
+// finish, and removes the journal.
+func rollbackJournal(file Storage, path string, open StorageOpener) error {
+	journal, err := open(path)
+	if err != nil {
+		return err
+	}
+
+	size, err := journal.Size()
+	if err != nil {
+		journal.Close()
+		return err
+	}
+	header := make([]byte, journalHeaderSize)
+	if size >= journalHeaderSize {
+		if _, err := journal.ReadAt(header, 0); err != nil {
+			journal.Close()
+			return err
+		}
+	}
+	if string(header[journalMagicOffset:journalMagicOffset+journalMagicSize]) != journalMagic {
+		// No journal, or one whose commit never touched the file
+		return discardJournal(journal)
+	}
+
+	count := binary.LittleEndian.Uint32(header[journalPageCountOffset:])
+	if binary.LittleEndian.Uint32(header[journalPageSizeOffset:]) != pageSize ||
+		size < journalHeaderSize+int64(count)*journalRecordSize {
+		journal.Close()
+		return errCorruptJournal
+	}
+
+	record := make([]byte, journalRecordSize)
+	for i := range int64(count) {
+		if _, err := journal.ReadAt(record, journalHeaderSize+i*journalRecordSize); err != nil {
+			journal.Close()
+			return err
+		}
+		pageNum := binary.LittleEndian.Uint32(record)
+		if _, err := file.WriteAt(record[4:], int64(pageNum)*pageSize); err != nil {
+			journal.Close()
+			return err
+		}
+	}
+	if err := file.Truncate(int64(binary.LittleEndian.Uint64(header[journalFileSizeOffset:]))); err != nil {
+		journal.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		journal.Close()
+		return err
+	}
+	return discardJournal(journal)
+}
+
+// removeJournal deletes the journal at path without rolling it back.
+func removeJournal(path string, open StorageOpener) error {
+	journal, err := open(path)
+	if err != nil {
+		return err
+	}
+	return discardJournal(journal)
+}
+
+// discardJournal empties the journal, which makes it invalid, and then
+// deletes it if the storage supports that.
+func discardJournal(journal Storage) error {
+	if err := journal.Truncate(0); err != nil {
+		journal.Close()
+		return err
+	}
+	if r, ok := journal.(remover); ok {
+		return r.Remove()
+	}
+	return journal.Close()
+}
@@ -0,0 +1,43 @@
+package main
+
+// A Database and its tables and indexes can be shared by goroutines. Every
+// method holds the database's latch, a sync.RWMutex, while it runs: methods
+// that only read hold it for reading, so lookups and scans run in parallel,
+// and methods that change the database hold it for writing, so writers take
+// turns and wait for the reads in progress to end. Readers fill the page
+// cache side by side under the pager's own mutex.
+//
+// The latch covers one call. The callbacks of scans run with it held for
+// reading and must not change the database. Cursors walked step by step are
+// not covered: they belong to one goroutine, and while other goroutines
+// write, a consistent walk reads the tables of a Snapshot instead, which
+// need no latch. The latch is separate from Lock, which only keeps the
+// background checkpoints out of the way of a session.
+
+// rlock and runlock hold the latch for reading around a Table method that
+// only reads.
+func (t *Table) rlock() {
+	if t.latch != nil {
+		t.latch.RLock()
+	}
+}
+
+func (t *Table) runlock() {
+	if t.latch != nil {
+		t.latch.RUnlock()
+	}
+}
+
+// lock and unlock hold the latch for writing around a Table method that
+// changes the database.
+func (t *Table) lock() {
+	if t.latch != nil {
+		t.latch.Lock()
+	}
+}
+
+func (t *Table) unlock() {
+	if t.latch != nil {
+		t.latch.Unlock()
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestTableSharedByReadersAndWriters(t *testing.T) {
+	table := openTestTable(t)
+	for key := range int64(100) {
+		if err := table.Insert(createRow(key * 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for reader := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				key := uint64(i%100) * 2
+				if _, found, err := table.Get(key); err != nil || !found {
+					t.Errorf("reader %d: Get(%d) = %v, %v", reader, key, found, err)
+					return
+				}
+				if n, err := table.Count(); err != nil || n < 100 {
+					t.Errorf("reader %d: Count() = %d, %v", reader, n, err)
+					return
+				}
+			}
+			if rows := table.SelectAll(); len(rows) < 100 {
+				t.Errorf("reader %d: SelectAll returned %d rows", reader, len(rows))
+			}
+		}()
+	}
+	for writer := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range int64(100) {
+				if key%2 != int64(writer) {
+					continue
+				}
+				if err := table.Insert(createRow(key*2 + 1)); err != nil {
+					t.Errorf("writer %d: %v", writer, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, err := table.Count(); err != nil || n != 200 {
+		t.Fatalf("Count() = %d, %v after the writers, want 200", n, err)
+	}
+	rows := table.SelectAll()
+	for i, row := range rows {
+		if row.ID != int64(i) {
+			t.Fatalf("row %d has id %d", i, row.ID)
+		}
+	}
+}
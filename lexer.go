@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TokenType is the kind of a lexical token
+type TokenType int
+
+const (
+	TOKEN_EOF     TokenType = iota
+	TOKEN_KEYWORD           // reserved word such as insert or where, matched case-insensitively
+	TOKEN_WORD              // any other unquoted run of characters, e.g. alice or bob@example.com
+	TOKEN_NUMBER            // integer literal with an optional sign
+	TOKEN_STRING            // single or double quoted literal, Text holds the unescaped value
+	TOKEN_PUNCT             // one of ( ) , =
+)
+
+// keywords are the reserved words of the statement grammar
+var keywords = map[string]bool{
+	"insert": true, "select": true, "update": true, "delete": true, "pragma": true,
+	"where": true, "order": true, "by": true, "id": true, "asc": true, "desc": true,
+	"count": true, "last_insert_id": true, "in": true, "between": true, "and": true,
+	"is": true, "not": true, "null": true, "distinct": true,
+	"or": true, "ignore": true, "explain": true, "analyze": true, "vacuum": true,
+	"begin": true, "commit": true, "rollback": true,
+}
+
+// Token is a lexical token of a statement. Pos is the byte offset of the token in the input.
+type Token struct {
+	Type TokenType
+	Text string
+	Pos  int
+}
+
+// is reports whether the token is the keyword or punctuation text.
+func (t Token) is(text string) bool {
+	switch t.Type {
+	case TOKEN_KEYWORD:
+		return strings.EqualFold(t.Text, text)
+	case TOKEN_PUNCT:
+		return t.Text == text
+	}
+	return false
+}
+
+// String describes the token for error messages.
+func (t Token) String() string {
+	if t.Type == TOKEN_EOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", t.Text)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func isPunct(c byte) bool {
+	return c == '(' || c == ')' || c == ',' || c == '='
+}
+
+// tokenize splits a statement into tokens, ending with a TOKEN_EOF token.
+//
+// A quote starts a string literal only at the start of a token and the
+// literal runs to the matching closing quote. Inside it a backslash escapes
+// the next character: \\, \', \", \n and \t are recognized.
+//
+// The input is scanned byte by byte rather than decoded as UTF-8, so invalid
+// bytes reach validation (or storage, when raw strings are accepted) unchanged.
+func tokenize(input string) ([]Token, error) {
+	var tokens []Token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case isSpace(c):
+			i++
+
+		case isPunct(c):
+			tokens = append(tokens, Token{Type: TOKEN_PUNCT, Text: input[i : i+1], Pos: i})
+			i++
+
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(input) && input[j] != c; j++ {
+				if input[j] != '\\' {
+					b.WriteByte(input[j])
+					continue
+				}
+				j++
+				if j == len(input) {
+					break
+				}
+				switch input[j] {
+				case '\\', '\'', '"':
+					b.WriteByte(input[j])
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					return nil, fmt.Errorf("syntax error: unknown escape sequence \\%c", input[j])
+				}
+			}
+			if j >= len(input) {
+				return nil, errors.New("syntax error: unterminated string literal")
+			}
+			if j+1 < len(input) && !isSpace(input[j+1]) && !isPunct(input[j+1]) {
+				return nil, fmt.Errorf("syntax error at %q: expected space after string literal", input[i:j+2])
+			}
+			tokens = append(tokens, Token{Type: TOKEN_STRING, Text: b.String(), Pos: i})
+			i = j + 1
+
+		default:
+			j := i
+			for j < len(input) && !isSpace(input[j]) && !isPunct(input[j]) {
+				j++
+			}
+			text := input[i:j]
+			tokens = append(tokens, Token{Type: wordType(text), Text: text, Pos: i})
+			i = j
+		}
+	}
+	return append(tokens, Token{Type: TOKEN_EOF, Pos: len(input)}), nil
+}
+
+// wordType classifies an unquoted word as a keyword, a number or a plain word.
+func wordType(text string) TokenType {
+	if keywords[strings.ToLower(text)] {
+		return TOKEN_KEYWORD
+	}
+	digits := strings.TrimLeft(text, "+-")
+	if len(text)-len(digits) > 1 || digits == "" {
+		return TOKEN_WORD
+	}
+	for k := 0; k < len(digits); k++ {
+		if digits[k] < '0' || digits[k] > '9' {
+			return TOKEN_WORD
+		}
+	}
+	return TOKEN_NUMBER
+}
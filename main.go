@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/kong"
 )
@@ -14,22 +16,128 @@ const (
 )
 
 var CLI struct {
-	DBPath  string `arg:"" name:"database_file" help:"Path to the database file." default:"vlsql.db"`
-	Version bool   `help:"Print version and exit." short:"v"`
+	Open struct {
+		DBPath string `arg:"" name:"database_file" help:"Path to the database file." default:"vlsql.db"`
+	} `cmd:"" default:"withargs" help:"Open the database and read statements from standard input (the default)."`
+	Upgrade struct {
+		DBPath string `arg:"" name:"database_file" help:"Path to the database file."`
+	} `cmd:"" help:"Rewrite a database file written by an older version in the current format, then exit."`
+
+	Version     bool          `help:"Print version and exit." short:"v"`
+	Bloom       bool          `help:"Keep an in-memory bloom filter of keys so lookups of absent keys skip the tree." name:"bloom-filter"`
+	Prealloc    int64         `help:"Grow the database file in chunks of this many bytes (0 disables)." default:"0"`
+	RawBytes    bool          `help:"Accept string values that are not valid UTF-8." name:"raw-strings"`
+	Sync        string        `help:"How hard to sync changes written to disk: full, normal or off." enum:"full,normal,off" default:"full"`
+	Mmap        bool          `help:"Memory-map the database file instead of reading pages into buffers (Linux only)."`
+	Checkpoint  time.Duration `help:"Write changes to the file in the background at this interval (0 only writes them on exit)." default:"0"`
+	DoubleWrite bool          `help:"Write changed pages to a double-write file before the database file, so a crash cannot leave torn pages." name:"double-write"`
+	Passphrase  string        `help:"Encrypt the database with a key derived from this passphrase." env:"VERYLIGHTSQL_PASSPHRASE"`
+	AppendSplit int           `help:"Percent of the last leaf kept in it when an insert past the last key splits it (50 to 100)." default:"50" name:"append-split"`
+	CheckWrites bool          `help:"Check the leaf and its ancestors after every insert and panic if they are inconsistent (for debugging)." name:"check-writes"`
+}
+
+var syncModes = map[string]SyncMode{
+	"full":   SyncFull,
+	"normal": SyncNormal,
+	"off":    SyncOff,
 }
 
-func execute_meta_command(input string, t *Table) error {
-	switch input {
+// session is the state of the REPL: the open database and the table
+// statements run against. With autocommit off, a statement run outside a
+// transaction opens one, so statements pile up until commit or rollback.
+type session struct {
+	db         *Database
+	table      *Table
+	autocommit bool
+}
+
+func execute_meta_command(input string, s *session) error {
+	t := s.table
+	fields := strings.Fields(input)
+	switch fields[0] {
 	case ".exit":
+		if activeProfile != nil {
+			stopProfile()
+		}
 		fmt.Print("Bye!\n")
-		t.Close()
+		s.db.Close()
 		os.Exit(0)
 	case ".help":
-		fmt.Print("Available commands: help, exit\n")
+		fmt.Print("Available commands: help, exit, constants, btree, btree-dot, profile, tables, use, vacuum, backup, pagerstats, integrity_check, autocommit\n")
+	case ".tables":
+		names, err := s.db.TableNames()
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.Join(names, " "))
+	case ".use":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: .use <table>")
+		}
+		table, err := s.db.Table(fields[1])
+		if err == ErrNoSuchTable {
+			table, err = s.db.CreateTable(fields[1])
+		}
+		if err != nil {
+			return err
+		}
+		s.table = table
+	case ".autocommit":
+		if len(fields) != 2 || (fields[1] != "on" && fields[1] != "off") {
+			return fmt.Errorf("usage: .autocommit on|off")
+		}
+		s.autocommit = fields[1] == "on"
+		// Like turning autocommit back on elsewhere, this commits what the
+		// statements so far grouped
+		if s.autocommit && s.db.InTransaction() {
+			return s.db.Commit()
+		}
+	case ".vacuum":
+		return s.db.Vacuum()
+	case ".backup":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: .backup <file>")
+		}
+		return s.db.Backup(fields[1])
+	case ".pagerstats":
+		printPagerStats(s.db.Stats())
+	case ".integrity_check":
+		problems, err := s.db.IntegrityCheck()
+		if err != nil {
+			return err
+		}
+		if len(problems) == 0 {
+			fmt.Println("ok")
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+	case ".profile":
+		return executeProfileCommand(fields[1:])
 	case ".constants":
 		printConstants()
 	case ".btree":
-		printTree(t.pager, 0, 0)
+		if len(fields) > 1 && fields[1] == "--json" {
+			return printTreeJSON(t.pager, t.rootPageNum)
+		}
+		maxDepth := -1
+		if len(fields) > 1 {
+			depth, err := strconv.Atoi(fields[1])
+			if err != nil || depth < 0 || len(fields) > 2 {
+				return fmt.Errorf("usage: .btree [--json | max-depth]")
+			}
+			maxDepth = depth
+		}
+		printTree(t.pager, t.rootPageNum, 0, maxDepth)
+	case ".btree-dot":
+		if len(fields) > 2 {
+			return fmt.Errorf("usage: .btree-dot [file]")
+		}
+		var path string
+		if len(fields) == 2 {
+			path = fields[1]
+		}
+		return printTreeDOT(t.pager, t.rootPageNum, path)
 	default:
 		return fmt.Errorf("unrecognized command: %s", input)
 	}
@@ -37,12 +145,22 @@ func execute_meta_command(input string, t *Table) error {
 }
 
 func printConstants() {
-	fmt.Printf("ROW_SIZE: %d\n", rowSize)
+	fmt.Printf("MAX_ROW_SIZE: %d\n", rowSize)
 	fmt.Printf("COMMON_NODE_HEADER_SIZE: %d\n", CommonHeaderSize)
 	fmt.Printf("LEAF_NODE_HEADER_SIZE: %d\n", LeafNodeHeaderSize)
-	fmt.Printf("LEAF_NODE_CELL_SIZE: %d\n", LeafNodeCellSize)
+	fmt.Printf("LEAF_NODE_CELL_POINTER_SIZE: %d\n", LeafNodeCellPointerSize)
+	fmt.Printf("LEAF_NODE_MAX_CELL_SIZE: %d\n", LeafNodeMaxCellSize)
 	fmt.Printf("LEAF_NODE_SPACE_FOR_CELLS: %d\n", LeafNodeSpaceForCells)
-	fmt.Printf("LEAF_NODE_MAX_CELLS: %d\n", LeafNodeMaxCells)
+	fmt.Printf("LEAF_NODE_MIN_USED_SPACE: %d\n", LeafNodeMinUsedSpace)
+}
+
+func printPagerStats(stats PagerStats) {
+	fmt.Printf("pages read: %d\n", stats.PagesRead)
+	fmt.Printf("pages written: %d\n", stats.PagesWritten)
+	fmt.Printf("cache hits: %d\n", stats.CacheHits)
+	fmt.Printf("cache misses: %d\n", stats.CacheMisses)
+	fmt.Printf("bytes on disk: %d\n", stats.BytesOnDisk)
+	fmt.Printf("key bytes saved: %d\n", stats.KeyBytesSaved)
 }
 
 func indent(level int) {
@@ -51,84 +169,57 @@ func indent(level int) {
 	}
 }
 
-func printTree(pager *Pager, pageNum uint32, indentationLevel int) {
+// printTree prints the subtree rooted at pageNum as an indented outline. Each
+// node line gives its size, page number, parent (or root), free space in bytes
+// and, for leaves, the next leaf, 0 for none. Nodes maxDepth levels below the
+// subtree's root are printed without their keys and children; a negative
+// maxDepth prints the whole subtree.
+func printTree(pager *Pager, pageNum uint32, indentationLevel int, maxDepth int) {
 	page, err := pager.getPage(pageNum)
 	if err != nil {
 		panic(err)
 	}
 	var numKeys, child uint32
+	parent := "root"
+	if !isNodeRoot(page) {
+		parent = fmt.Sprintf("parent %d", nodeParent(page).get())
+	}
 
-	switch *nodeType(page) {
+	switch nodeType(page) {
 	case NodeTypeLeaf:
-		numKeys = *leafNodeNumCells(page)
+		numKeys = leafNodeNumCells(page).get()
 		indent(indentationLevel)
-		fmt.Printf("- leaf (size %d)\n", numKeys)
+		fmt.Printf("- leaf (size %d, page %d, %s, next %d, free %d)\n", numKeys, pageNum, parent,
+			leafNodeNextLeaf(page).get(), LeafNodeSpaceForCells-leafNodeUsedSpace(page))
+		if maxDepth == 0 {
+			return
+		}
 		for i := uint32(0); i < numKeys; i++ {
 			indent(indentationLevel + 1)
-			fmt.Printf("- %d\n", *leafNodeKey(page, i))
+			fmt.Printf("- %d\n", leafNodeKey(page, i))
 		}
 	case NodeTypeInternal:
-		numKeys = *internalNodeNumKeys(page)
+		numKeys = internalNodeNumKeys(page).get()
 		indent(indentationLevel)
-		fmt.Printf("- internal (size %d)\n", numKeys)
+		fmt.Printf("- internal (size %d, page %d, %s, free %d)\n", numKeys, pageNum, parent,
+			(InternalNodeMaxKeys-int(numKeys))*InternalNodeCellSize)
+		if maxDepth == 0 {
+			return
+		}
 		for i := uint32(0); i < numKeys; i++ {
-			child = *internalNodeChild(page, i)
-			printTree(pager, child, indentationLevel+1)
+			child = internalNodeChild(page, i).get()
+			printTree(pager, child, indentationLevel+1, maxDepth-1)
 
 			indent(indentationLevel + 1)
-			fmt.Printf("- key %d\n", *internalNodeKey(page, i))
+			fmt.Printf("- key %d\n", internalNodeKey(page, i).get())
 		}
-		child = *internalNodeRightChild(page)
-		printTree(pager, child, indentationLevel+1)
+		child = internalNodeRightChild(page).get()
+		printTree(pager, child, indentationLevel+1, maxDepth-1)
 	default:
 		panic("Unrecognized node type")
 	}
 }
 
-func executeInsert(stmt Statement, table *Table) error {
-	return table.Insert(&stmt.RowToInsert)
-}
-
-func executeSelect(stmt Statement, table *Table) error {
-	rows := table.SelectAll()
-	for _, row := range rows {
-		printRow(&row)
-	}
-	return nil
-}
-
-func printRow(row *Row) {
-	// Convert byte arrays to strings for display
-	username := string(row.Username[:])
-	email := string(row.Email[:])
-
-	// Trim null bytes for display
-	for i, b := range row.Username {
-		if b == 0 {
-			username = string(row.Username[:i])
-			break
-		}
-	}
-	for i, b := range row.Email {
-		if b == 0 {
-			email = string(row.Email[:i])
-			break
-		}
-	}
-
-	fmt.Printf("(%d, %s, %s)\n", row.ID, username, email)
-}
-
-func execute_statement(stmt Statement, table *Table) error {
-	switch stmt.Type {
-	case STATEMENT_INSERT:
-		return executeInsert(stmt, table)
-	case STATEMENT_SELECT:
-		return executeSelect(stmt, table)
-	}
-	return nil
-}
-
 func main() {
 	ctx := kong.Parse(&CLI,
 		kong.Name("verylightsql"),
@@ -142,13 +233,25 @@ func main() {
 	}
 
 	fmt.Printf("Verylightsql v%s\n", VERSION)
-	fmt.Printf("Opening database: %s\n", CLI.DBPath)
+	if ctx.Command() == "upgrade <database_file>" {
+		runUpgrade(CLI.Upgrade.DBPath)
+		ctx.Exit(0)
+	}
+	fmt.Printf("Opening database: %s\n", CLI.Open.DBPath)
+
+	acceptRawStrings = CLI.RawBytes
 
-	table, err := OpenDatabase(CLI.DBPath)
+	db, err := OpenDatabase(CLI.Open.DBPath, options()...)
+	if err != nil {
+		fmt.Printf("Error opening database file: %s\n", err)
+		os.Exit(1)
+	}
+	table, err := db.Table(defaultTableName)
 	if err != nil {
 		fmt.Printf("Error opening database file: %s\n", err)
 		os.Exit(1)
 	}
+	s := &session{db: db, table: table, autocommit: true}
 
 	reader := bufio.NewReader(os.Stdin)
 
@@ -170,23 +273,100 @@ func main() {
 			continue
 		}
 
-		if input[0] == '.' {
-			if err := execute_meta_command(input, table); err != nil {
-				fmt.Printf("%s\n", err)
-			}
-			continue
-		}
+		// Background checkpoints wait until the line is done
+		s.db.Lock()
+		s.run(input)
+		s.db.Unlock()
+	}
+}
 
-		stmt, err := prepare_statement(input)
-		if err != nil {
-			fmt.Printf("%s.\n", err)
-			continue
+// options turns the command line flags into options for OpenDatabase.
+func options() []Option {
+	var opts []Option
+	if CLI.Bloom {
+		opts = append(opts, WithBloomFilter())
+	}
+	if CLI.Prealloc > 0 {
+		opts = append(opts, WithPreallocation(CLI.Prealloc))
+	}
+	opts = append(opts, WithSyncMode(syncModes[CLI.Sync]))
+	if CLI.Mmap {
+		opts = append(opts, WithMmap())
+	}
+	if CLI.Checkpoint > 0 {
+		opts = append(opts, WithCheckpointInterval(CLI.Checkpoint))
+	}
+	if CLI.DoubleWrite {
+		opts = append(opts, WithDoubleWrite())
+	}
+	if CLI.Passphrase != "" {
+		opts = append(opts, WithPassphrase(CLI.Passphrase))
+	}
+	if CLI.AppendSplit != 50 {
+		opts = append(opts, WithAppendSplit(CLI.AppendSplit))
+	}
+	if CLI.CheckWrites {
+		opts = append(opts, WithWriteChecks())
+	}
+	return opts
+}
+
+// runUpgrade upgrades the database file at path to the current format
+// version, exiting with status 1 if that fails.
+func runUpgrade(path string) {
+	fmt.Printf("Upgrading database: %s\n", path)
+	// Upgrading must not create a database that was not there
+	if _, err := os.Stat(path); err != nil {
+		fmt.Printf("Error upgrading database file: %s\n", err)
+		os.Exit(1)
+	}
+	version, err := UpgradeDatabase(path, options()...)
+	if err != nil {
+		fmt.Printf("Error upgrading database file: %s\n", err)
+		os.Exit(1)
+	}
+	if version == headerFormatVersion {
+		fmt.Printf("Already in format version %d.\n", version)
+		return
+	}
+	fmt.Printf("Upgraded from format version %d to %d.\n", version, headerFormatVersion)
+}
+
+// run executes one line of input, a meta command or a statement, and prints
+// its outcome.
+func (s *session) run(input string) {
+	if input[0] == '.' {
+		if err := execute_meta_command(input, s); err != nil {
+			fmt.Printf("%s\n", err)
 		}
+		return
+	}
 
-		if err := execute_statement(stmt, table); err != nil {
-			fmt.Printf("Error: %s.\n", err)
-			continue
+	plan, err := prepare_statement(input)
+	if err != nil {
+		fmt.Printf("%s.\n", err)
+		return
+	}
+
+	switch plan.(type) {
+	case *transactionPlan, *vacuumPlan:
+	default:
+		if !s.autocommit && !s.db.InTransaction() {
+			if err := s.db.Begin(); err != nil {
+				fmt.Printf("Error: %s.\n", err)
+				return
+			}
 		}
-		fmt.Println("Executed.")
 	}
+
+	err = plan.Execute(s.table)
+	// A rollback forgets the tables its transaction created
+	if !s.db.hasTable(s.table) {
+		s.table, _ = s.db.Table(defaultTableName)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s.\n", err)
+		return
+	}
+	fmt.Println("Executed.")
 }
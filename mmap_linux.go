@@ -0,0 +1,19 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first size bytes of f copy-on-write: writes to the mapping
+// stay private to the process and reach the file only through WriteAt.
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+}
+
+func munmapFile(mapping []byte) error {
+	return syscall.Munmap(mapping)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile is only implemented on Linux; elsewhere WithMmap fails to open.
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("memory-mapped files are not supported on this platform")
+}
+
+func munmapFile(mapping []byte) error {
+	return nil
+}
@@ -3,88 +3,497 @@ package main
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 var errParseStringTooLong = errors.New("string is too long")
 var errParseNegativeID = errors.New("ID must be positive")
+var errParseInvalidUTF8 = errors.New("string is not valid UTF-8")
+var errParseNulByte = errors.New("string contains a NUL byte")
 
-// StatementType represents the type of SQL statement
-type StatementType int
+// acceptRawStrings skips the UTF-8 check on string values so arbitrary bytes
+// can be stored. NUL bytes are still rejected: columns are NUL padded, so a
+// NUL would silently cut the value short when it is read back.
+var acceptRawStrings bool
 
+// Column sizes are in bytes, not characters: a username holds 32 ASCII
+// characters but only 16 two-byte ones. Longer values are rejected on insert
+// and update.
 const (
-	STATEMENT_INSERT StatementType = iota
-	STATEMENT_SELECT
+	ColumnUsernameSize = 32
+	ColumnEmailSize    = 255
 )
 
-// Statement represents a SQL statement
-type Statement struct {
-	Type        StatementType
-	RowToInsert Row // only used by insert statement
-}
-
+// Null bits of Row.Nulls, one per column that can be NULL
 const (
-	ColumnUsernameSize = 32
-	ColumnEmailSize    = 255
+	NullUsername uint8 = 1 << iota
+	NullEmail
 )
 
 // TODO: this should be a generic implementation
 type Row struct {
-	ID       int32
+	ID       int64
 	Username [ColumnUsernameSize]byte // TODO: what if we use string? How does DBs manage sparse part?
 	Email    [ColumnEmailSize]byte
+	Nulls    uint8 // null bitmap; a NULL column's bytes are all zero
 }
 
-// parse_insert_string_to_row parses a string input into a Row struct
-// Expects input in the format: "insert <id> <username> <email>"
-func parse_insert_string_to_row(input string) (Row, error) {
+// fill_row validates the ID and string values of a parsed row and copies the
+// strings into its columns.
+func fill_row(row *Row, username string, email string) error {
+	if len(username) > ColumnUsernameSize {
+		return errParseStringTooLong
+	}
+	if len(email) > ColumnEmailSize {
+		return errParseStringTooLong
+	}
+	if row.ID < 0 {
+		return errParseNegativeID
+	}
+	for _, s := range []string{username, email} {
+		if err := validateString(s); err != nil {
+			return err
+		}
+	}
+
+	copy(row.Username[:], username)
+	copy(row.Email[:], email)
+	return nil
+}
+
+// validateString checks that a string value can be stored and read back unchanged.
+func validateString(s string) error {
+	if strings.IndexByte(s, 0) >= 0 {
+		return errParseNulByte
+	}
+	if !acceptRawStrings && !utf8.ValidString(s) {
+		return errParseInvalidUTF8
+	}
+	return nil
+}
+
+// parser walks the tokens of one statement. Each parse method consumes the
+// tokens of its construct and reports the token it got stuck on when the
+// input does not match the grammar.
+type parser struct {
+	tokens []Token
+	pos    int
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Type != TOKEN_EOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the keyword or punctuation text.
+func (p *parser) accept(text string) bool {
+	if p.peek().is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) errorf(tok Token, expected string) error {
+	return fmt.Errorf("syntax error at %s: expected %s", tok, expected)
+}
+
+func (p *parser) expect(text string) error {
+	if tok := p.next(); !tok.is(text) {
+		return p.errorf(tok, fmt.Sprintf("%q", text))
+	}
+	return nil
+}
+
+func (p *parser) expectEnd() error {
+	if tok := p.peek(); tok.Type != TOKEN_EOF {
+		return p.errorf(tok, "end of statement")
+	}
+	return nil
+}
+
+// parseInt consumes an integer literal that must fit in a signed integer of
+// bitSize bits.
+func (p *parser) parseInt(what string, bitSize int) (int64, error) {
+	tok := p.next()
+	if tok.Type != TOKEN_NUMBER {
+		return 0, p.errorf(tok, what)
+	}
+	v, err := strconv.ParseInt(tok.Text, 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("syntax error at %s: %s out of range", tok, what)
+	}
+	return v, nil
+}
+
+// parseID consumes a row id, which must not be negative.
+func (p *parser) parseID() (int64, error) {
+	id, err := p.parseInt("id", 64)
+	if err != nil {
+		return 0, err
+	}
+	if id < 0 {
+		return 0, errParseNegativeID
+	}
+	return id, nil
+}
+
+// parseValue consumes a string value. Unquoted words, numbers and keywords are
+// taken literally, so "insert 1 select 42" stores the strings "select" and "42",
+// except for an unquoted null, which stands for NULL. 'null' quoted is the string.
+func (p *parser) parseValue(what string) (value string, null bool, err error) {
+	tok := p.next()
+	if tok.Type == TOKEN_EOF || tok.Type == TOKEN_PUNCT {
+		return "", false, p.errorf(tok, what)
+	}
+	if tok.is("null") {
+		return "", true, nil
+	}
+	return tok.Text, false, nil
+}
+
+// parseColumns consumes "<username> <email>" into row.
+func (p *parser) parseColumns(row *Row) error {
+	username, usernameNull, err := p.parseValue("username")
+	if err != nil {
+		return err
+	}
+	email, emailNull, err := p.parseValue("email")
+	if err != nil {
+		return err
+	}
+	if usernameNull {
+		row.Nulls |= NullUsername
+	}
+	if emailNull {
+		row.Nulls |= NullEmail
+	}
+	return fill_row(row, username, email)
+}
+
+// parseRow consumes "<id> <username> <email>".
+func (p *parser) parseRow() (Row, error) {
 	var row Row
-	var username, email string
-	_, err := fmt.Sscanf(input, "insert %d %s %s", &row.ID, &username, &email)
+	id, err := p.parseID()
 	if err != nil {
-		return row, fmt.Errorf("syntax error: could not parse row: %w", err)
+		return row, err
 	}
+	row.ID = id
+	return row, p.parseColumns(&row)
+}
 
-	if len(username) > ColumnUsernameSize {
-		return row, errParseStringTooLong
+// parseInsert parses "insert <id> <username> <email>", "insert <username>
+// <email>" and "insert null <username> <email>" (the table assigns the id in
+// both) and the multi-row form "insert (<id> <username> <email>) [,] (...) ...",
+// each optionally as "insert or ignore ...".
+func (p *parser) parseInsert() (*InsertStatement, error) {
+	stmt := &InsertStatement{}
+
+	if p.accept("or") {
+		if err := p.expect("ignore"); err != nil {
+			return nil, err
+		}
+		stmt.IgnoreDuplicates = true
 	}
-	if len(email) > ColumnEmailSize {
-		return row, errParseStringTooLong
+
+	if p.peek().is("(") {
+		for p.accept("(") {
+			row, err := p.parseRow()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			stmt.Rows = append(stmt.Rows, row)
+			p.accept(",")
+		}
+		return stmt, p.expectEnd()
 	}
-	if row.ID < 0 {
-		return row, errParseNegativeID
+
+	// Two values, or a null id, means the table assigns the id
+	if len(p.tokens)-p.pos == 3 || len(p.tokens)-p.pos == 4 && p.accept("null") {
+		var row Row
+		if err := p.parseColumns(&row); err != nil {
+			return nil, err
+		}
+		stmt.Rows, stmt.AutoID = []Row{row}, true
+		return stmt, nil
+	}
+
+	row, err := p.parseRow()
+	if err != nil {
+		return nil, err
 	}
+	stmt.Rows = []Row{row}
+	return stmt, p.expectEnd()
+}
 
-	// TODO: Handle overflow
-	for i := 0; i < len(username) && i < ColumnUsernameSize; i++ {
-		row.Username[i] = username[i]
+// parseUpdate parses "update <id> <username> <email>".
+func (p *parser) parseUpdate() (*UpdateStatement, error) {
+	row, err := p.parseRow()
+	if err != nil {
+		return nil, err
 	}
-	for i := 0; i < len(email) && i < ColumnEmailSize; i++ {
-		row.Email[i] = email[i]
+	return &UpdateStatement{Row: row}, p.expectEnd()
+}
+
+// parseDelete parses "delete <id>".
+func (p *parser) parseDelete() (*DeleteStatement, error) {
+	id, err := p.parseID()
+	if err != nil {
+		return nil, err
 	}
+	return &DeleteStatement{ID: id}, p.expectEnd()
+}
+
+// parseSelect parses "select [* | count(*) | last_insert_id() | [distinct] <column>]
+// [where <condition>] [order by id [asc|desc]]".
+func (p *parser) parseSelect() (*SelectStatement, error) {
+	stmt := &SelectStatement{}
 
-	return row, nil
+	switch tok := p.peek(); {
+	case tok.Type == TOKEN_WORD && tok.Text == "*":
+		p.next()
+	case tok.is("count"):
+		p.next()
+		stmt.Projection = PROJECT_COUNT
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.Text != "*" {
+			return nil, p.errorf(tok, `"*"`)
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	case tok.is("last_insert_id"):
+		p.next()
+		stmt.Projection = PROJECT_LAST_INSERT_ID
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	case tok.is("distinct") || isColumn(tok):
+		stmt.Projection = PROJECT_COLUMN
+		stmt.Distinct = p.accept("distinct")
+		col := p.next()
+		if !isColumn(col) {
+			return nil, p.errorf(col, "column")
+		}
+		stmt.Column = strings.ToLower(col.Text)
+	}
+
+	if p.accept("where") {
+		cond, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Where = cond
+	}
+
+	if p.accept("order") {
+		if err := p.expect("by"); err != nil {
+			return nil, err
+		}
+		if err := p.expect("id"); err != nil {
+			return nil, err
+		}
+		stmt.OrderBy = &OrderBy{}
+		if !p.accept("asc") && p.accept("desc") {
+			stmt.OrderBy.Descending = true
+		}
+	}
+	return stmt, p.expectEnd()
 }
 
-func prepare_statement(input string) (Statement, error) {
-	var stmt Statement
+// isColumn reports whether tok names a column.
+func isColumn(tok Token) bool {
+	return tok.is("id") || tok.Type == TOKEN_WORD && (tok.Text == "username" || tok.Text == "email")
+}
 
-	action := strings.Split(input, " ")[0]
+// parseCondition parses the predicate of a where clause: "id = <id>",
+// "id in (<id>, <id>, ...)", "id between <id> and <id>" or
+// "<username | email> is [not] null".
+func (p *parser) parseCondition() (Condition, error) {
+	if tok := p.peek(); tok.Type == TOKEN_WORD && (tok.Text == "username" || tok.Text == "email") {
+		p.next()
+		if err := p.expect("is"); err != nil {
+			return nil, err
+		}
+		cond := &IsNull{Column: tok.Text, Not: p.accept("not")}
+		return cond, p.expect("null")
+	}
+	if tok := p.next(); !tok.is("id") {
+		return nil, p.errorf(tok, "column")
+	}
 
-	switch action {
-	case "insert":
-		row, err := parse_insert_string_to_row(input)
+	if p.accept("between") {
+		low, err := p.parseID()
 		if err != nil {
-			return stmt, err
+			return nil, err
 		}
-		stmt.RowToInsert = row
-		stmt.Type = STATEMENT_INSERT
+		if err := p.expect("and"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseID()
+		if err != nil {
+			return nil, err
+		}
+		return &IDBetween{Low: low, High: high}, nil
+	}
+
+	if p.accept("in") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond := &IDIn{}
+		for {
+			id, err := p.parseID()
+			if err != nil {
+				return nil, err
+			}
+			cond.IDs = append(cond.IDs, id)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return cond, nil
+	}
 
-	case "select":
-		stmt.Type = STATEMENT_SELECT
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	id, err := p.parseID()
+	if err != nil {
+		return nil, err
+	}
+	return &IDEquals{ID: id}, nil
+}
+
+// parsePragma parses "pragma <name>" and "pragma <name> = <value>". The
+// supported pragmas are the integer fields of the file header; freelist_count
+// can only be read.
+func (p *parser) parsePragma() (*PragmaStatement, error) {
+	stmt := &PragmaStatement{}
+
+	tok := p.next()
+	if tok.Type != TOKEN_WORD {
+		return nil, p.errorf(tok, "pragma name")
+	}
+	stmt.Name = tok.Text
+	switch stmt.Name {
+	case "user_version", "application_id", "freelist_count":
 	default:
-		return stmt, fmt.Errorf("unrecognized keyword at start of '%s'", input)
+		return nil, fmt.Errorf("unknown pragma: %s", stmt.Name)
+	}
+
+	if p.accept("=") {
+		if stmt.Name == "freelist_count" {
+			return nil, fmt.Errorf("pragma %s is read-only", stmt.Name)
+		}
+		v, err := p.parseInt(stmt.Name+" value", 32)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Value = int32(v)
+		stmt.HasValue = true
 	}
+	return stmt, p.expectEnd()
+}
 
-	return stmt, nil
+// parse_statement parses one statement into its syntax tree.
+func parse_statement(input string) (Statement, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	return p.parseStatement(input)
+}
+
+// parseStatement parses the statement starting at the current token. input is
+// only used for error messages.
+func (p *parser) parseStatement(input string) (Statement, error) {
+	// Each case converts its result to the interface only on success, so a
+	// failed parse returns a plain nil Statement
+	switch tok := p.next(); {
+	case tok.is("insert"):
+		stmt, err := p.parseInsert()
+		if err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	case tok.is("select"):
+		stmt, err := p.parseSelect()
+		if err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	case tok.is("update"):
+		stmt, err := p.parseUpdate()
+		if err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	case tok.is("delete"):
+		stmt, err := p.parseDelete()
+		if err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	case tok.is("pragma"):
+		stmt, err := p.parsePragma()
+		if err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	case tok.is("vacuum"):
+		if err := p.expectEnd(); err != nil {
+			return nil, err
+		}
+		return &VacuumStatement{}, nil
+	case tok.is("begin"):
+		if err := p.expectEnd(); err != nil {
+			return nil, err
+		}
+		return &BeginStatement{}, nil
+	case tok.is("commit"):
+		if err := p.expectEnd(); err != nil {
+			return nil, err
+		}
+		return &CommitStatement{}, nil
+	case tok.is("rollback"):
+		if err := p.expectEnd(); err != nil {
+			return nil, err
+		}
+		return &RollbackStatement{}, nil
+	case tok.is("explain"):
+		stmt := &ExplainStatement{Analyze: p.accept("analyze")}
+		if next := p.peek(); next.is("explain") {
+			return nil, p.errorf(next, "statement")
+		}
+		inner, err := p.parseStatement(input)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Statement = inner
+		return stmt, nil
+	default:
+		return nil, fmt.Errorf("unrecognized keyword at start of '%s'", input)
+	}
 }
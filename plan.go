@@ -0,0 +1,728 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Plan is a prepared statement: the work a parsed statement stands for, ready
+// to run against a table. Plans are built from the syntax tree by
+// plan_statement, so a clause only has to be parsed once and the choice of how
+// to run it (scan or lookup, forward or backward) is made in one place.
+type Plan interface {
+	Execute(t *Table) error
+	planNode
+}
+
+// queryPlan is implemented by the plans of statements that return rows.
+// query runs the plan like Execute, but hands the values of each row to fn
+// instead of printing them, in the order of columns: int64 for integers,
+// string for text and nil for NULL, and stops with ctx's error once ctx is
+// done. columns is nil when the statement returns no rows after all, like a
+// pragma that sets its field.
+type queryPlan interface {
+	Plan
+	columns() []string
+	query(ctx context.Context, t *Table, fn func(values []any)) error
+}
+
+// streamPlan is implemented by query plans that produce one row per row of
+// their source, so their rows can be read a batch at a time: Rows scans the
+// source a batch at a time and turns each row into its values. ok is false
+// when the plan has to see every row first, like a distinct projection.
+type streamPlan interface {
+	queryPlan
+	stream() (source rowSource, ok bool)
+	rowValues(batch *RowBatch, i int) []any
+}
+
+// execPlan is implemented by the plans of statements that change rows. exec
+// runs the plan like Execute without printing anything and returns how many
+// rows it changed and the ID of the last row an insert added, 0 for other
+// statements and inserts that added nothing. Inserts of several rows stop
+// with ctx's error once ctx is done.
+type execPlan interface {
+	Plan
+	exec(ctx context.Context, t *Table) (changed, lastInsertID int64, err error)
+}
+
+// planNode is one step of a plan as EXPLAIN shows it.
+type planNode interface {
+	// explain describes the step on one line.
+	explain() string
+	// inputs returns the row sources the step reads, nil for none.
+	inputs() []rowSource
+}
+
+// prepare_statement parses input and plans the resulting statement.
+func prepare_statement(input string) (Plan, error) {
+	stmt, err := parse_statement(input)
+	if err != nil {
+		return nil, err
+	}
+	return plan_statement(stmt)
+}
+
+// plan_statement turns a syntax tree into the plan that carries it out.
+func plan_statement(stmt Statement) (Plan, error) {
+	switch s := stmt.(type) {
+	case *InsertStatement:
+		return &insertPlan{rows: s.Rows, autoID: s.AutoID, ignore: s.IgnoreDuplicates}, nil
+	case *SelectStatement:
+		return planSelect(s)
+	case *UpdateStatement:
+		return &updatePlan{row: s.Row}, nil
+	case *DeleteStatement:
+		return &deletePlan{key: uint64(s.ID)}, nil
+	case *PragmaStatement:
+		return &pragmaPlan{name: s.Name, value: s.Value, set: s.HasValue}, nil
+	case *VacuumStatement:
+		return &vacuumPlan{}, nil
+	case *BeginStatement:
+		return &transactionPlan{op: "BEGIN"}, nil
+	case *CommitStatement:
+		return &transactionPlan{op: "COMMIT"}, nil
+	case *RollbackStatement:
+		return &transactionPlan{op: "ROLLBACK"}, nil
+	case *ExplainStatement:
+		plan, err := plan_statement(s.Statement)
+		if err != nil {
+			return nil, err
+		}
+		return &explainPlan{plan: plan, analyze: s.Analyze}, nil
+	}
+	return nil, fmt.Errorf("cannot plan statement of type %T", stmt)
+}
+
+// planSelect picks the row source for the where and order by clauses and puts
+// the projection on top of it.
+func planSelect(s *SelectStatement) (Plan, error) {
+	descending := s.OrderBy != nil && s.OrderBy.Descending
+
+	var source rowSource
+	switch cond := s.Where.(type) {
+	case nil:
+		source = tableScan{descending: descending}
+	case *IDEquals:
+		source = keyLookup{keys: []uint64{uint64(cond.ID)}, descending: descending}
+	case *IDIn:
+		keys := make([]uint64, len(cond.IDs))
+		for i, id := range cond.IDs {
+			keys[i] = uint64(id)
+		}
+		source = keyLookup{keys: keys, descending: descending}
+	case *IDBetween:
+		source = rangeScan{low: uint64(cond.Low), high: uint64(cond.High), descending: descending}
+	case *IsNull:
+		column := NullUsername
+		if cond.Column == "email" {
+			column = NullEmail
+		}
+		source = nullFilter{source: tableScan{descending: descending}, column: column, not: cond.Not}
+	default:
+		return nil, fmt.Errorf("cannot plan where clause of type %T", cond)
+	}
+
+	switch s.Projection {
+	case PROJECT_COUNT:
+		return &countPlan{source: source}, nil
+	case PROJECT_LAST_INSERT_ID:
+		if s.Where != nil || s.OrderBy != nil {
+			return nil, errors.New("last_insert_id() takes no where or order by clause")
+		}
+		return lastInsertIDPlan{}, nil
+	case PROJECT_COLUMN:
+		return &columnPlan{source: source, column: s.Column, distinct: s.Distinct}, nil
+	}
+	return &selectPlan{source: source}, nil
+}
+
+// rowSource produces the rows a select reads, in output order, batch by
+// batch, until ctx is done. resume returns the source of the rows that come
+// after the one with key last, so a reader can stop between batches and go
+// on later (see Rows).
+type rowSource interface {
+	scan(ctx context.Context, t *Table, fn func(*RowBatch) error) error
+	resume(last uint64) rowSource
+	planNode
+}
+
+// rowCounter is implemented by row sources that can count their rows
+// without producing them.
+type rowCounter interface {
+	count(t *Table) (int, error)
+}
+
+// tableScan reads every row by walking the leaves.
+type tableScan struct {
+	descending bool
+}
+
+func (s tableScan) scan(ctx context.Context, t *Table, fn func(*RowBatch) error) error {
+	if s.descending {
+		return t.ScanRangeReverseContext(ctx, 0, math.MaxUint64, fn)
+	}
+	return t.ScanRangeContext(ctx, 0, math.MaxUint64, fn)
+}
+
+func (s tableScan) resume(last uint64) rowSource {
+	return rangeScan{low: 0, high: math.MaxUint64, descending: s.descending}.resume(last)
+}
+
+// count answers from the leaf headers without reading any rows.
+func (s tableScan) count(t *Table) (int, error) {
+	return t.Count()
+}
+
+func (s tableScan) explain() string {
+	if s.descending {
+		return "SCAN TABLE DESC"
+	}
+	return "SCAN TABLE"
+}
+
+func (s tableScan) inputs() []rowSource { return nil }
+
+// rangeScan reads the rows with keys from low to high, both inclusive, by
+// walking the leaves from the first key in range instead of from the start.
+type rangeScan struct {
+	low, high  uint64
+	descending bool
+}
+
+func (s rangeScan) scan(ctx context.Context, t *Table, fn func(*RowBatch) error) error {
+	if s.descending {
+		return t.ScanRangeReverseContext(ctx, s.low, s.high, fn)
+	}
+	return t.ScanRangeContext(ctx, s.low, s.high, fn)
+}
+
+func (s rangeScan) resume(last uint64) rowSource {
+	switch {
+	case s.descending && last == 0, !s.descending && last == math.MaxUint64:
+		return rangeScan{low: 1, high: 0}
+	case s.descending:
+		s.high = last - 1
+	default:
+		s.low = last + 1
+	}
+	return s
+}
+
+func (s rangeScan) explain() string {
+	desc := ""
+	if s.descending {
+		desc = " DESC"
+	}
+	return fmt.Sprintf("RANGE SCAN id %d..%d%s", s.low, s.high, desc)
+}
+
+func (s rangeScan) inputs() []rowSource { return nil }
+
+// nullFilter passes on the rows of source whose column (a Row.Nulls bit) is
+// NULL, or is not NULL when not is set.
+type nullFilter struct {
+	source rowSource
+	column uint8
+	not    bool
+}
+
+func (s nullFilter) scan(ctx context.Context, t *Table, fn func(*RowBatch) error) error {
+	out := newRowBatch()
+	return s.source.scan(ctx, t, func(batch *RowBatch) error {
+		for i := range batch.Len() {
+			if (batch.Nulls[i]&s.column != 0) == s.not {
+				continue
+			}
+			out.appendFrom(batch, i)
+		}
+		// Values alias the source batch, hand them over before it is reused
+		if out.Len() == 0 {
+			return nil
+		}
+		err := fn(out)
+		out.reset()
+		return err
+	})
+}
+
+func (s nullFilter) resume(last uint64) rowSource {
+	s.source = s.source.resume(last)
+	return s
+}
+
+func (s nullFilter) explain() string {
+	column, not := "username", ""
+	if s.column == NullEmail {
+		column = "email"
+	}
+	if s.not {
+		not = "NOT "
+	}
+	return fmt.Sprintf("FILTER %s IS %sNULL", column, not)
+}
+
+func (s nullFilter) inputs() []rowSource { return []rowSource{s.source} }
+
+// keyLookup reads the rows stored under keys with point lookups instead of a
+// scan. The keys are sorted and deduplicated first, so rows come out in key
+// order and each lookup continues from the previous one's path (see
+// Table.GetMany). Keys that are not in the table produce no row.
+type keyLookup struct {
+	keys       []uint64
+	descending bool
+}
+
+func (s keyLookup) scan(ctx context.Context, t *Table, fn func(*RowBatch) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	rows, err := t.GetMany(s.keys)
+	if err != nil {
+		return err
+	}
+	if s.descending {
+		slices.Reverse(rows)
+	}
+
+	// The batch aliases the buffers of its rows until fn has seen it
+	batch := newRowBatch()
+	bufs := make([]*[LeafNodeValueOffset + rowSize]byte, 0, rowBatchSize)
+	release := func() {
+		for _, buf := range bufs {
+			putRowBuffer(buf)
+		}
+		bufs = bufs[:0]
+	}
+	defer release()
+	for i := range rows {
+		buf := getRowBuffer()
+		bufs = append(bufs, buf)
+		serializeRow(&rows[i], buf[:])
+		batch.appendRow(buf[:])
+		if batch.Len() == rowBatchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch.reset()
+			release()
+		}
+	}
+	return flushBatch(batch, fn)
+}
+
+func (s keyLookup) resume(last uint64) rowSource {
+	keys := make([]uint64, 0, len(s.keys))
+	for _, key := range s.keys {
+		if (key > last) != s.descending && key != last {
+			keys = append(keys, key)
+		}
+	}
+	s.keys = keys
+	return s
+}
+
+func (s keyLookup) explain() string {
+	keys := make([]string, len(s.keys))
+	for i, key := range s.keys {
+		keys[i] = strconv.FormatUint(uint64(key), 10)
+	}
+	desc := ""
+	if s.descending {
+		desc = " DESC"
+	}
+	return fmt.Sprintf("KEY LOOKUP id in (%s)%s", strings.Join(keys, ", "), desc)
+}
+
+func (s keyLookup) inputs() []rowSource { return nil }
+
+// selectPlan prints the rows of its source batch by batch as they are
+// produced, so memory stays flat for large tables.
+type selectPlan struct {
+	source rowSource
+}
+
+func (p *selectPlan) Execute(t *Table) error {
+	return p.source.scan(context.Background(), t, func(batch *RowBatch) error {
+		for i := range batch.Len() {
+			printRow(batch, i)
+		}
+		return nil
+	})
+}
+
+func (p *selectPlan) columns() []string { return []string{"id", "username", "email"} }
+
+func (p *selectPlan) query(ctx context.Context, t *Table, fn func(values []any)) error {
+	return p.source.scan(ctx, t, func(batch *RowBatch) error {
+		for i := range batch.Len() {
+			fn(p.rowValues(batch, i))
+		}
+		return nil
+	})
+}
+
+func (p *selectPlan) stream() (rowSource, bool) { return p.source, true }
+
+func (p *selectPlan) rowValues(batch *RowBatch, i int) []any {
+	values := []any{int64(batch.IDs[i]), string(batch.Usernames[i]), string(batch.Emails[i])}
+	if batch.Nulls[i]&NullUsername != 0 {
+		values[1] = nil
+	}
+	if batch.Nulls[i]&NullEmail != 0 {
+		values[2] = nil
+	}
+	return values
+}
+
+func (p *selectPlan) explain() string     { return "PRINT ROWS" }
+func (p *selectPlan) inputs() []rowSource { return []rowSource{p.source} }
+
+// printRow prints row i of batch, showing NULL columns as NULL.
+func printRow(batch *RowBatch, i int) {
+	username, email := string(batch.Usernames[i]), string(batch.Emails[i])
+	if batch.Nulls[i]&NullUsername != 0 {
+		username = "NULL"
+	}
+	if batch.Nulls[i]&NullEmail != 0 {
+		email = "NULL"
+	}
+	fmt.Printf("(%d, %s, %s)\n", batch.IDs[i], username, email)
+}
+
+// columnPlan prints one column of the rows of its source. With distinct set
+// each value is printed once, after the scan, in the order it was first seen
+// (see distinctSet for results too large to keep in memory).
+type columnPlan struct {
+	source   rowSource
+	column   string
+	distinct bool
+}
+
+func (p *columnPlan) Execute(t *Table) error {
+	return p.each(context.Background(), t, func(value string, null bool) {
+		if null {
+			value = "NULL"
+		}
+		fmt.Printf("(%s)\n", value)
+	})
+}
+
+func (p *columnPlan) columns() []string { return []string{p.column} }
+
+func (p *columnPlan) query(ctx context.Context, t *Table, fn func(values []any)) error {
+	return p.each(ctx, t, func(value string, null bool) {
+		switch {
+		case null:
+			fn([]any{nil})
+		case p.column == "id":
+			id, _ := strconv.ParseInt(value, 10, 64)
+			fn([]any{id})
+		default:
+			fn([]any{value})
+		}
+	})
+}
+
+func (p *columnPlan) stream() (rowSource, bool) { return p.source, !p.distinct }
+
+func (p *columnPlan) rowValues(batch *RowBatch, i int) []any {
+	value, null := p.value(batch, i)
+	switch {
+	case null:
+		return []any{nil}
+	case p.column == "id":
+		return []any{batch.IDs[i]}
+	}
+	return []any{string(value)}
+}
+
+// each calls fn with the plan's column of every row, or of every distinct
+// value after the scan.
+func (p *columnPlan) each(ctx context.Context, t *Table, fn func(value string, null bool)) error {
+	var set *distinctSet
+	if p.distinct {
+		set = newDistinctSet()
+		defer set.close()
+	}
+	err := p.source.scan(ctx, t, func(batch *RowBatch) error {
+		for i := range batch.Len() {
+			value, null := p.value(batch, i)
+			if set == nil {
+				fn(string(value), null)
+				continue
+			}
+			if err := set.add(value, null); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || set == nil {
+		return err
+	}
+	return set.each(fn)
+}
+
+// value returns the plan's column of row i of batch.
+func (p *columnPlan) value(batch *RowBatch, i int) (value []byte, null bool) {
+	switch p.column {
+	case "username":
+		return batch.Usernames[i], batch.Nulls[i]&NullUsername != 0
+	case "email":
+		return batch.Emails[i], batch.Nulls[i]&NullEmail != 0
+	}
+	return strconv.AppendInt(nil, int64(batch.IDs[i]), 10), false
+}
+
+func (p *columnPlan) explain() string {
+	if p.distinct {
+		return "PRINT DISTINCT " + p.column
+	}
+	return "PRINT COLUMN " + p.column
+}
+
+func (p *columnPlan) inputs() []rowSource { return []rowSource{p.source} }
+
+// countPlan prints the number of rows its source produces. Sources that can
+// count without producing rows, like a full table scan, are asked directly.
+type countPlan struct {
+	source rowSource
+}
+
+func (p *countPlan) explain() string     { return "COUNT" }
+func (p *countPlan) inputs() []rowSource { return []rowSource{p.source} }
+
+func (p *countPlan) Execute(t *Table) error {
+	count, err := p.count(context.Background(), t)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("(%d)\n", count)
+	return nil
+}
+
+func (p *countPlan) columns() []string { return []string{"count(*)"} }
+
+func (p *countPlan) query(ctx context.Context, t *Table, fn func(values []any)) error {
+	count, err := p.count(ctx, t)
+	if err != nil {
+		return err
+	}
+	fn([]any{int64(count)})
+	return nil
+}
+
+func (p *countPlan) count(ctx context.Context, t *Table) (int, error) {
+	if c, ok := p.source.(rowCounter); ok {
+		return c.count(t)
+	}
+	var count int
+	err := p.source.scan(ctx, t, func(batch *RowBatch) error {
+		count += batch.Len()
+		return nil
+	})
+	return count, err
+}
+
+// lastInsertIDPlan prints the id of the last row inserted in this session.
+type lastInsertIDPlan struct{}
+
+func (lastInsertIDPlan) Execute(t *Table) error {
+	fmt.Printf("(%d)\n", t.LastInsertID())
+	return nil
+}
+
+func (lastInsertIDPlan) columns() []string { return []string{"last_insert_id()"} }
+
+func (lastInsertIDPlan) query(ctx context.Context, t *Table, fn func(values []any)) error {
+	fn([]any{t.LastInsertID()})
+	return nil
+}
+
+func (lastInsertIDPlan) explain() string     { return "LAST INSERT ID" }
+func (lastInsertIDPlan) inputs() []rowSource { return nil }
+
+// insertPlan inserts its rows. With ignore set, rows with a taken id are
+// skipped and their number is reported.
+type insertPlan struct {
+	rows   []Row
+	autoID bool
+	ignore bool
+}
+
+func (p *insertPlan) Execute(t *Table) error {
+	inserted, _, err := p.exec(context.Background(), t)
+	if err != nil {
+		return err
+	}
+	if p.ignore {
+		fmt.Printf("Skipped %d of %d rows.\n", int64(len(p.rows))-inserted, len(p.rows))
+	}
+	return nil
+}
+
+// exec holds the latch from the insert until it read the ID the insert
+// recorded, so an insert through another connection sharing t cannot come in
+// between.
+func (p *insertPlan) exec(ctx context.Context, t *Table) (int64, int64, error) {
+	t.lock()
+	defer t.unlock()
+	if p.autoID {
+		id, err := t.insertAutoID(&p.rows[0])
+		if err != nil {
+			return 0, 0, err
+		}
+		return 1, id, nil
+	}
+
+	var inserted int64
+	var err error
+	switch {
+	case p.ignore:
+		var skipped int
+		skipped, err = t.insertOrIgnore(p.rows)
+		inserted = int64(len(p.rows) - skipped)
+	case len(p.rows) == 1:
+		inserted, err = 1, t.insert(&p.rows[0])
+	default:
+		inserted, err = int64(len(p.rows)), t.insertMany(ctx, p.rows)
+	}
+	if err != nil || inserted == 0 {
+		return inserted, 0, err
+	}
+	return inserted, t.lastInsertID, nil
+}
+
+func (p *insertPlan) explain() string {
+	switch {
+	case p.autoID:
+		return "INSERT 1 ROW WITH AUTO ID"
+	case p.ignore:
+		return fmt.Sprintf("INSERT OR IGNORE %d ROWS", len(p.rows))
+	}
+	return fmt.Sprintf("INSERT %d ROWS", len(p.rows))
+}
+
+func (p *insertPlan) inputs() []rowSource { return nil }
+
+type updatePlan struct {
+	row Row
+}
+
+func (p *updatePlan) Execute(t *Table) error {
+	_, _, err := p.exec(context.Background(), t)
+	return err
+}
+
+func (p *updatePlan) exec(ctx context.Context, t *Table) (int64, int64, error) {
+	found, err := t.Update(&p.row)
+	if !found {
+		return 0, 0, err
+	}
+	return 1, 0, err
+}
+
+func (p *updatePlan) explain() string     { return fmt.Sprintf("UPDATE id %d", p.row.ID) }
+func (p *updatePlan) inputs() []rowSource { return nil }
+
+type deletePlan struct {
+	key uint64
+}
+
+func (p *deletePlan) Execute(t *Table) error {
+	_, _, err := p.exec(context.Background(), t)
+	return err
+}
+
+func (p *deletePlan) exec(ctx context.Context, t *Table) (int64, int64, error) {
+	found, err := t.Delete(p.key)
+	if !found {
+		return 0, 0, err
+	}
+	return 1, 0, err
+}
+
+func (p *deletePlan) explain() string     { return fmt.Sprintf("DELETE id %d", p.key) }
+func (p *deletePlan) inputs() []rowSource { return nil }
+
+// pragmaPlan reads or writes one of the integer fields of the file header.
+// Reads print the current value on its own line.
+type pragmaPlan struct {
+	name  string
+	value int32
+	set   bool
+}
+
+func (p *pragmaPlan) Execute(t *Table) error {
+	return p.query(context.Background(), t, func(values []any) {
+		fmt.Printf("%d\n", values[0])
+	})
+}
+
+func (p *pragmaPlan) columns() []string {
+	if p.set {
+		return nil
+	}
+	return []string{p.name}
+}
+
+func (p *pragmaPlan) query(ctx context.Context, t *Table, fn func(values []any)) error {
+	get, set := t.UserVersion, t.SetUserVersion
+	switch p.name {
+	case "application_id":
+		get, set = t.ApplicationID, t.SetApplicationID
+	case "freelist_count":
+		get = t.FreelistCount
+	}
+	if p.set {
+		return set(p.value)
+	}
+	v, err := get()
+	if err != nil {
+		return err
+	}
+	fn([]any{int64(v)})
+	return nil
+}
+
+func (p *pragmaPlan) explain() string {
+	if p.set {
+		return fmt.Sprintf("PRAGMA %s = %d", p.name, p.value)
+	}
+	return "PRAGMA " + p.name
+}
+
+func (p *pragmaPlan) inputs() []rowSource { return nil }
+
+// vacuumPlan rebuilds the file of the table's database, see Database.Vacuum.
+type vacuumPlan struct{}
+
+func (p *vacuumPlan) Execute(t *Table) error { return t.db.Vacuum() }
+
+func (p *vacuumPlan) explain() string     { return "VACUUM" }
+func (p *vacuumPlan) inputs() []rowSource { return nil }
+
+// transactionPlan opens, commits or rolls back a transaction of the table's
+// database: op is BEGIN, COMMIT or ROLLBACK.
+type transactionPlan struct {
+	op string
+}
+
+func (p *transactionPlan) Execute(t *Table) error {
+	switch p.op {
+	case "BEGIN":
+		return t.db.Begin()
+	case "COMMIT":
+		return t.db.Commit()
+	}
+	return t.db.Rollback()
+}
+
+func (p *transactionPlan) explain() string     { return p.op }
+func (p *transactionPlan) inputs() []rowSource { return nil }
@@ -0,0 +1,21 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// preallocate reserves disk blocks for [offset, offset+length) of f with
+// fallocate, falling back to extending the file when the filesystem doesn't
+// support it.
+func preallocate(f *os.File, offset, length int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, offset, length)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return f.Truncate(offset + length)
+	}
+	return err
+}
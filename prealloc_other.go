@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+// preallocate extends f to cover [offset, offset+length). Platforms without
+// fallocate get a sparse extension, which still saves the per-write growth.
+func preallocate(f *os.File, offset, length int64) error {
+	return f.Truncate(offset + length)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+const defaultProfilePrefix = "vlsql"
+
+// profileSession is a CPU profile in progress started by ".profile start".
+// The heap profile is written when the session is stopped so it reflects the
+// statements that ran in between.
+type profileSession struct {
+	cpuFile  *os.File
+	heapPath string
+}
+
+// activeProfile is the running profile session, nil when profiling is off.
+var activeProfile *profileSession
+
+// startProfile starts CPU profiling into <prefix>.cpu.pprof.
+func startProfile(prefix string) (*profileSession, error) {
+	if activeProfile != nil {
+		return nil, errors.New("profiling already started")
+	}
+
+	cpuPath := prefix + ".cpu.pprof"
+	f, err := os.Create(cpuPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	activeProfile = &profileSession{
+		cpuFile:  f,
+		heapPath: prefix + ".heap.pprof",
+	}
+	return activeProfile, nil
+}
+
+// stopProfile stops the CPU profile and writes the heap profile next to it.
+func stopProfile() (*profileSession, error) {
+	s := activeProfile
+	if s == nil {
+		return nil, errors.New("profiling not started")
+	}
+	activeProfile = nil
+
+	pprof.StopCPUProfile()
+	if err := s.cpuFile.Close(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(s.heapPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// Get up-to-date statistics for the heap profile
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// executeProfileCommand handles ".profile start [prefix]" and ".profile stop".
+func executeProfileCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: .profile start [prefix] | .profile stop")
+	}
+
+	switch args[0] {
+	case "start":
+		prefix := defaultProfilePrefix
+		if len(args) > 1 {
+			prefix = args[1]
+		}
+		s, err := startProfile(prefix)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Profiling CPU to %s\n", s.cpuFile.Name())
+	case "stop":
+		s, err := stopProfile()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s and %s\n", s.cpuFile.Name(), s.heapPath)
+	default:
+		return fmt.Errorf("unknown profile action: %s", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCorruptDatabase is returned when opening a file whose last commit was
+// interrupted without leaving a journal to roll it back, and whose trees
+// turned out to be damaged.
+var ErrCorruptDatabase = errors.New("database was not closed cleanly and is corrupt")
+
+// recoverDatabase runs when the header's dirty flag says a commit did not
+// finish and there was no journal to undo it, which can happen when syncing
+// is relaxed and the machine loses power. The tree of every table and index
+// is checked for structural damage; if none is found the free list is
+// rebuilt from the pages no tree uses, which also reclaims pages leaked by
+// the interrupted commit, and the flag is cleared.
+func recoverDatabase(p *Pager) error {
+	header, err := p.getPageForWrite(headerPageNum)
+	if err != nil {
+		return err
+	}
+
+	used := make([]bool, p.numPages)
+	used[headerPageNum] = true
+	for i := range headerTableCount(header) {
+		entry := catalogEntry(header, i)
+		if catalogEntryKind(entry) != catalogKindTable {
+			if err := checkIndexTree(p, catalogEntryRootPage(entry), 0, nil, nil, used); err != nil {
+				return fmt.Errorf("%w: index %s: %v", ErrCorruptDatabase, catalogEntryName(entry), err)
+			}
+			continue
+		}
+		if err := checkTree(p, catalogEntryRootPage(entry), used); err != nil {
+			return fmt.Errorf("%w: table %s: %v", ErrCorruptDatabase, catalogEntryName(entry), err)
+		}
+	}
+
+	// Free the highest pages first so the list hands out low pages first
+	setHeaderFreelist(header, 0, 0)
+	for pageNum := p.numPages - 1; pageNum > headerPageNum; pageNum-- {
+		if used[pageNum] {
+			continue
+		}
+		if err := p.freePage(pageNum); err != nil {
+			return err
+		}
+	}
+	setHeaderDirty(header, false)
+	return nil
+}
+
+// checkTree checks the structure of the subtree rooted at pageNum and marks
+// its pages in used: every page must exist and belong to one node only,
+// nodes must be leaves or internal nodes whose cells fit the page, and the
+// keys of a node must be increasing.
+func checkTree(p *Pager, pageNum uint32, used []bool) error {
+	if pageNum == headerPageNum || pageNum >= uint32(len(used)) {
+		return fmt.Errorf("page %d out of range", pageNum)
+	}
+	if used[pageNum] {
+		return fmt.Errorf("page %d is linked twice", pageNum)
+	}
+	used[pageNum] = true
+
+	node, err := p.getPage(pageNum)
+	if err != nil {
+		return err
+	}
+	switch nodeType(node) {
+	case NodeTypeLeaf:
+		return checkLeaf(node, pageNum)
+	case NodeTypeInternal:
+		numKeys := internalNodeNumKeys(node).get()
+		if numKeys == 0 || numKeys > uint32(InternalNodeMaxKeys) {
+			return fmt.Errorf("internal node %d has %d keys", pageNum, numKeys)
+		}
+		for i := range numKeys {
+			if i > 0 && internalNodeKey(node, i).get() <= internalNodeKey(node, i-1).get() {
+				return fmt.Errorf("internal node %d keys out of order", pageNum)
+			}
+		}
+		for i := uint32(0); i <= numKeys; i++ {
+			if err := checkTree(p, internalNodeChild(node, i).get(), used); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("page %d is not a tree node", pageNum)
+}
+
+func checkLeaf(node []byte, pageNum uint32) error {
+	numCells := leafNodeNumCells(node).get()
+	contentStart := int(leafNodeContentStart(node).get())
+	if LeafNodeHeaderSize+int(numCells)*LeafNodeCellPointerSize > contentStart || contentStart > pageUsableSize {
+		return fmt.Errorf("leaf %d has %d cells but its content starts at %d", pageNum, numCells, contentStart)
+	}
+	if leafNodePrefixLen(node) > LeafNodeKeySize {
+		return fmt.Errorf("leaf %d has a key prefix of %d bytes", pageNum, leafNodePrefixLen(node))
+	}
+	suffixLen := LeafNodeKeySize - leafNodePrefixLen(node)
+	for i := range numCells {
+		start := int(leafNodeCellPointer(node, i).get())
+		if start < contentStart || start+suffixLen+usernameOffset >= pageUsableSize {
+			return fmt.Errorf("leaf %d cell %d out of place", pageNum, i)
+		}
+		// Follow the string lengths without trusting them to stay in the page
+		row := node[start+suffixLen:]
+		emailOffset := usernameOffset + textLengthSize + int(row[usernameOffset])
+		if emailOffset >= len(row) || emailOffset+textLengthSize+int(row[emailOffset]) > len(row) {
+			return fmt.Errorf("leaf %d cell %d overruns the page", pageNum, i)
+		}
+		if i > 0 && leafNodeKey(node, i) <= leafNodeKey(node, i-1) {
+			return fmt.Errorf("leaf %d keys out of order", pageNum)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// errQueryWithoutRows is returned by Query for statements that return no
+// rows, like inserts and pragmas that set their field.
+var errQueryWithoutRows = errors.New("statement returns no rows")
+
+// errBatchFull stops the scan for a batch of Rows once it holds enough rows.
+var errBatchFull = errors.New("batch is full")
+
+// Rows is the result of a query, read like with database/sql:
+//
+//	rows, err := table.Query("select where id between ? and ?", 10, 20)
+//	if err != nil { ... }
+//	for rows.Next() {
+//		var id int64
+//		var username, email sql.NullString
+//		if err := rows.Scan(&id, &username, &email); err != nil { ... }
+//	}
+//	if err := rows.Err(); err != nil { ... }
+//
+// The rows of selects are read like with RowIterator: rowBatchSize at a time
+// as Next goes, each batch under the latch and starting after the key of the
+// last row of the batch before. Only one batch is in memory, and rows changed
+// between batches are seen as they are by then. Counts, distinct columns and
+// pragmas are collected when the query runs.
+type Rows struct {
+	columns []string
+	// source is where the next batch of plan's rows comes from, nil once the
+	// last batch is read or when the rows were collected.
+	ctx    context.Context
+	t      *Table
+	plan   streamPlan
+	source rowSource
+	values [][]any
+	pos    int
+	// current is the row Next moved to, nil before the first call and after
+	// the last row.
+	current []any
+	err     error
+}
+
+// Query runs the statement query, which must return rows, against the table
+// and returns its rows. A ? outside quotes is a placeholder for the next of
+// args, which are converted like database/sql converts the arguments of a
+// driver: integers, strings, []byte, bool, float64, time.Time and nil (for
+// NULL) are taken, and a driver.Valuer gives its value.
+func (t *Table) Query(query string, args ...any) (*Rows, error) {
+	return t.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext is Query that stops the query's scans once ctx is done.
+func (t *Table) QueryContext(ctx context.Context, query string, args ...any) (*Rows, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		v, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		values[i] = v
+	}
+	query, err := bindPlaceholders(query, values)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := prepare_statement(query)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := plan.(queryPlan)
+	if !ok || p.columns() == nil {
+		return nil, errQueryWithoutRows
+	}
+	return newRows(ctx, t, p)
+}
+
+// newRows runs p against t. The rows of a plan that streams are read a batch
+// at a time, the first batch right away so errors show now; the rows of
+// other plans are collected.
+func newRows(ctx context.Context, t *Table, p queryPlan) (*Rows, error) {
+	rows := &Rows{columns: p.columns()}
+	if sp, ok := p.(streamPlan); ok {
+		if source, ok := sp.stream(); ok {
+			rows.ctx, rows.t, rows.plan, rows.source = ctx, t, sp, source
+			if rows.fetch(); rows.err != nil {
+				return nil, rows.err
+			}
+			return rows, nil
+		}
+	}
+
+	err := p.query(ctx, t, func(values []any) {
+		rows.values = append(rows.values, values)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// fetch reads the next batch of rows from source into values.
+func (r *Rows) fetch() {
+	r.values, r.pos = r.values[:0], 0
+	var last uint64
+	err := r.source.scan(r.ctx, r.t, func(batch *RowBatch) error {
+		for i := range batch.Len() {
+			r.values = append(r.values, r.plan.rowValues(batch, i))
+			last = uint64(batch.IDs[i])
+		}
+		if len(r.values) >= rowBatchSize {
+			return errBatchFull
+		}
+		return nil
+	})
+	if err == errBatchFull {
+		r.source = r.source.resume(last)
+		return
+	}
+	r.source, r.err = nil, err
+}
+
+// Columns returns the names of the columns of the rows.
+func (r *Rows) Columns() []string {
+	return r.columns
+}
+
+// Next moves to the next row and reports whether there is one. It returns
+// false after the last row or when reading the next batch fails, see Err.
+func (r *Rows) Next() bool {
+	if r.pos == len(r.values) && r.source != nil {
+		r.fetch()
+	}
+	if r.pos == len(r.values) {
+		r.current = nil
+		return false
+	}
+	r.current = r.values[r.pos]
+	r.pos++
+	return true
+}
+
+// Err returns the error that ended Next early, or nil if it read every row.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Scan copies the columns of the row Next moved to into dest, one pointer
+// per column. Integers go into *int64, *int, *string or *[]byte, text into
+// *string or *[]byte, and anything into *any or an sql.Scanner such as
+// sql.NullString, which is how NULL columns are read.
+func (r *Rows) Scan(dest ...any) error {
+	if r.current == nil {
+		return errors.New("Scan called without a row, call Next first")
+	}
+	if len(dest) != len(r.current) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(r.current), len(dest))
+	}
+	for i, v := range r.current {
+		if err := scanValue(dest[i], v); err != nil {
+			return fmt.Errorf("column %s: %w", r.columns[i], err)
+		}
+	}
+	return nil
+}
+
+// Close drops the rows not read yet. Next returns false afterwards.
+func (r *Rows) Close() error {
+	r.source, r.values, r.pos, r.current = nil, nil, 0, nil
+	return nil
+}
+
+// scanValue stores v, an int64, string or nil, in dest.
+func scanValue(dest, v any) error {
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(v)
+	}
+	if d, ok := dest.(*any); ok {
+		*d = v
+		return nil
+	}
+	if v == nil {
+		return fmt.Errorf("cannot scan NULL into %T", dest)
+	}
+
+	switch d := dest.(type) {
+	case *int64:
+		if n, ok := v.(int64); ok {
+			*d = n
+			return nil
+		}
+	case *int:
+		if n, ok := v.(int64); ok {
+			*d = int(n)
+			return nil
+		}
+	case *string:
+		switch v := v.(type) {
+		case string:
+			*d = v
+			return nil
+		case int64:
+			*d = strconv.FormatInt(v, 10)
+			return nil
+		}
+	case *[]byte:
+		switch v := v.(type) {
+		case string:
+			*d = []byte(v)
+			return nil
+		case int64:
+			*d = strconv.AppendInt(nil, v, 10)
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot scan %T into %T", v, dest)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestQueryScan(t *testing.T) {
+	table := openTestTable(t)
+	withNull := createRow(2)
+	withNull.Email, withNull.Nulls = [ColumnEmailSize]byte{}, NullEmail
+	for _, row := range []*Row{createRow(1), withNull, createRow(3)} {
+		if err := table.Insert(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := table.Query("select where id between ? and ?", 2, int64(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Scan(new(any), new(any), new(any)); err == nil {
+		t.Fatal("Scan before Next succeeded")
+	}
+	var got []string
+	for rows.Next() {
+		var id int
+		var username string
+		var email sql.NullString
+		if err := rows.Scan(&id, &username, &email); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, username+" "+email.String)
+		if (id == 2) == email.Valid {
+			t.Fatalf("row %d has email %+v, want NULL only in row 2", id, email)
+		}
+	}
+	if len(got) != 2 || got[0] != "user2 " || got[1] != "user3 user3@example.com" {
+		t.Fatalf("rows = %q", got)
+	}
+
+	rows, err = table.Query("select email where id = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var email string
+	if !rows.Next() || rows.Scan(&email) == nil {
+		t.Fatal("scanning NULL into a string succeeded")
+	}
+	if err := rows.Scan(&email, &email); err == nil {
+		t.Fatal("Scan with two destinations for one column succeeded")
+	}
+
+	rows, err = table.Query("select count(*)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count []byte
+	if !rows.Next() || rows.Scan(&count) != nil || string(count) != "3" {
+		t.Fatalf("count(*) scanned into []byte = %q, want 3", count)
+	}
+
+	if _, err := table.Query("delete 1"); !errors.Is(err, errQueryWithoutRows) {
+		t.Fatalf("Query of a delete returned %v, want errQueryWithoutRows", err)
+	}
+}
+
+func TestQueryStreamsBatches(t *testing.T) {
+	table := openTestTable(t)
+	n := 3*rowBatchSize + 5
+	rows := make([]Row, n)
+	keys := make([]string, n)
+	for i := range rows {
+		rows[i] = *createRow(int64(i + 1))
+		if i%3 == 0 {
+			rows[i].Username, rows[i].Nulls = [ColumnUsernameSize]byte{}, NullUsername
+		}
+		keys[i] = strconv.Itoa(i + 1)
+	}
+	if err := table.InsertMany(rows); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each query deletes the last row it would read once it read one batch,
+	// which a later batch then leaves out, as do the queries after it
+	queries := []struct {
+		query string
+		args  []any
+		last  uint64
+		want  int
+	}{
+		{"select id order by id desc", nil, 1, n - 1},
+		{"select id where id between ? and ?", []any{int32(2), uint(n - 1)}, uint64(n - 1), n - 3},
+		{"select id where username is not null", nil, uint64(n), n - (n+2)/3 - 1},
+		{"select id where id in (" + strings.Join(keys, ", ") + ") order by id desc", nil, 2, n - 4},
+	}
+	for _, q := range queries {
+		result, err := table.Query(q.query, q.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", q.query, err)
+		}
+		var ids []int64
+		for result.Next() {
+			var id int64
+			if err := result.Scan(&id); err != nil {
+				t.Fatalf("%s: %v", q.query, err)
+			}
+			ids = append(ids, id)
+			// No batch holds the latch between calls to Next
+			if len(ids) == rowBatchSize {
+				if found, err := table.Delete(q.last); !found || err != nil {
+					t.Fatalf("Delete(%d) = %v, %v", q.last, found, err)
+				}
+			}
+		}
+		if err := result.Err(); err != nil {
+			t.Fatalf("%s: %v", q.query, err)
+		}
+		desc := strings.Contains(q.query, "desc")
+		for i := 1; i < len(ids); i++ {
+			if (ids[i] > ids[i-1]) == desc || ids[i] == ids[i-1] {
+				t.Fatalf("%s: id %d follows %d", q.query, ids[i], ids[i-1])
+			}
+		}
+		if len(ids) != q.want {
+			t.Fatalf("%s: read %d rows, want %d", q.query, len(ids), q.want)
+		}
+	}
+}
+
+func TestQueryConvertsArguments(t *testing.T) {
+	table := openTestTable(t)
+	for id := range 3 {
+		if err := table.Insert(createRow(int64(id + 1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, arg := range []any{int8(2), uint64(2), float64(2), sql.NullInt64{Int64: 2, Valid: true}} {
+		rows, err := table.Query("select id where id = ?", arg)
+		if err != nil {
+			t.Fatalf("Query with a %T argument: %v", arg, err)
+		}
+		var id int64
+		if !rows.Next() || rows.Scan(&id) != nil || id != 2 {
+			t.Fatalf("Query with a %T argument read id %d, want 2", arg, id)
+		}
+	}
+	if _, err := table.Query("select where id = ?", struct{}{}); err == nil {
+		t.Fatal("Query accepted a struct argument")
+	}
+}
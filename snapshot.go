@@ -0,0 +1,118 @@
+package main
+
+import "errors"
+
+// ErrSnapshotReadOnly is returned when a table of a snapshot is changed.
+var ErrSnapshotReadOnly = errors.New("snapshot is read-only")
+
+// Snapshot is a read-only view of a database as it was when the snapshot was
+// taken. Its tables can be scanned while the database goes on changing, even
+// while inserts split the nodes a scan is walking: the snapshot shares the
+// pages of the database until it reads them or they change, and takes its
+// own copy of each page at whichever comes first. Pages it never shared are
+// read from the file, where they cannot have changed either. A snapshot must
+// be closed before the database: Close and Vacuum refuse to run while one is
+// open.
+//
+// A snapshot can be read from one goroutine while others change the
+// database: its tables do not take the database's latch (see latch.go), so
+// readers of a snapshot and writers only wait for each other while a page is
+// copied. A snapshot and its tables are used by one goroutine at a time.
+type Snapshot struct {
+	db     *Database
+	pager  *Pager
+	tables map[string]*Table
+}
+
+// Snapshot takes a snapshot of the database, including changes not yet
+// committed.
+func (db *Database) Snapshot() *Snapshot {
+	db.latch.Lock()
+	defer db.latch.Unlock()
+
+	live := db.pager
+	p := &Pager{
+		fileLength:  live.fileLength,
+		file:        live.file,
+		pages:       live.pages,
+		numPages:    live.numPages,
+		mapping:     live.mapping,
+		mappedPages: live.mappedPages,
+		cipher:      live.cipher,
+		snapshot:    true,
+	}
+	for pageNum, page := range p.pages {
+		p.shared[pageNum] = page != nil
+	}
+	live.snapshots = append(live.snapshots, p)
+	return &Snapshot{db: db, pager: p, tables: make(map[string]*Table)}
+}
+
+// preserve gives the snapshot pager p its own copy of page, the contents of
+// page pageNum of the live pager, unless it has one already or the page did
+// not exist when the snapshot was taken.
+func (p *Pager) preserve(pageNum uint32, page []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pageNum >= p.numPages || (p.pages[pageNum] != nil && !p.shared[pageNum]) {
+		return
+	}
+	own := p.arena.alloc()
+	copy(own, page)
+	p.pages[pageNum] = own
+	p.shared[pageNum] = false
+}
+
+// unshare replaces the page pageNum the snapshot pager p shares with the
+// live pager by a copy, before the page is handed to a reader. The live
+// pager may change the shared buffer as soon as p.mu is released, but it
+// calls preserve first, so the copy is still the page as the snapshot saw it.
+func (p *Pager) unshare(pageNum uint32) {
+	own := p.arena.alloc()
+	copy(own, p.pages[pageNum])
+	p.pages[pageNum] = own
+	p.shared[pageNum] = false
+}
+
+// Table returns the table called name as it was when the snapshot was taken.
+func (s *Snapshot) Table(name string) (*Table, error) {
+	if t, ok := s.tables[name]; ok {
+		return t, nil
+	}
+
+	header, err := s.pager.getPage(headerPageNum)
+	if err != nil {
+		return nil, err
+	}
+	index, ok := catalogFind(header, name)
+	if !ok || catalogEntryKind(catalogEntry(header, index)) != catalogKindTable {
+		return nil, ErrNoSuchTable
+	}
+	rootPageNum := catalogEntryRootPage(catalogEntry(header, index))
+	t := &Table{
+		db:            s.db,
+		pager:         s.pager,
+		catalogIndex:  index,
+		rootPageNum:   rootPageNum,
+		rightmostLeaf: rootPageNum,
+	}
+	s.tables[name] = t
+	return t, nil
+}
+
+// Close releases the pages the snapshot copied. The snapshot and its tables
+// must not be used afterwards.
+func (s *Snapshot) Close() {
+	s.db.latch.Lock()
+	defer s.db.latch.Unlock()
+
+	live := s.db.pager
+	for i, other := range live.snapshots {
+		if other == s.pager {
+			live.snapshots = append(live.snapshots[:i], live.snapshots[i+1:]...)
+			break
+		}
+	}
+	s.pager.pages = [tableMaxPages][]byte{}
+	s.pager.arena.release()
+}
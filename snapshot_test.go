@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestSnapshotReadWhileDatabaseChanges(t *testing.T) {
+	table := openTestTable(t)
+	db := table.db
+	for key := range int64(300) {
+		if err := table.Insert(createRow(key * 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snap := db.Snapshot()
+	snapTable, err := snap.Table(defaultTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			rows := snapTable.SelectAll()
+			if len(rows) != 300 {
+				t.Errorf("snapshot has %d rows, want 300", len(rows))
+				return
+			}
+			for i, row := range rows {
+				if row.ID != int64(i)*2 {
+					t.Errorf("snapshot row %d has id %d, want %d", i, row.ID, i*2)
+					return
+				}
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	// Odd keys split the leaves the scans walk, deletes merge them
+	for key := range int64(300) {
+		err := table.Insert(createRow(key*2 + 1))
+		if err == nil && key%3 == 0 {
+			_, err = table.Delete(uint64(key * 2))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	snap.Close()
+}
+
+func TestCloseRefusedWhileSnapshotOpen(t *testing.T) {
+	table := openTestTable(t)
+	db := table.db
+	if err := table.Insert(createRow(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := db.Snapshot()
+	if err := db.Close(); !errors.Is(err, errCloseSnapshots) {
+		t.Fatalf("Close returned %v with a snapshot open, want errCloseSnapshots", err)
+	}
+	// The snapshot still reads the pages it shares with the database
+	snapTable, err := snap.Table(defaultTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := snapTable.SelectAll(); len(rows) != 1 || rows[0].ID != 1 {
+		t.Fatalf("snapshot rows = %+v, want the row with id 1", rows)
+	}
+	snap.Close()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import "os"
+
+// Storage is the backend the pager reads and writes pages through. The default
+// is a plain file (fileStorage); alternative implementations, such as an
+// io_uring or in-memory backend, can be plugged in with WithStorage.
+type Storage interface {
+	ReadAt(p []byte, off int64) (n int, err error)
+	WriteAt(p []byte, off int64) (n int, err error)
+	// Size returns the current size of the storage in bytes.
+	Size() (int64, error)
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// StorageOpener opens (creating if needed) the storage for the database at path.
+type StorageOpener func(path string) (Storage, error)
+
+// preallocator is implemented by storages that can reserve space ahead of
+// writes more cheaply than extending the file with Truncate.
+type preallocator interface {
+	Preallocate(offset, length int64) error
+}
+
+// vectorWriter is implemented by storages that can write several buffers to
+// consecutive offsets in one call, like pwritev. flushAll uses it to write a
+// run of adjacent pages straight from the page cache.
+type vectorWriter interface {
+	WriteVAt(bufs [][]byte, off int64) error
+}
+
+// remover is implemented by storages that can delete themselves. The pager
+// uses it to drop its rollback journal after a commit; a journal without it
+// is only emptied.
+type remover interface {
+	Remove() error
+}
+
+// fileStorage is the default Storage, backed by an *os.File.
+type fileStorage struct {
+	*os.File
+}
+
+func openFileStorage(path string) (Storage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return fileStorage{file}, nil
+}
+
+func (f fileStorage) Size() (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (f fileStorage) Preallocate(offset, length int64) error {
+	return preallocate(f.File, offset, length)
+}
+
+func (f fileStorage) WriteVAt(bufs [][]byte, off int64) error {
+	return pwritev(f.File, bufs, off)
+}
+
+// Remove closes and deletes the file.
+func (f fileStorage) Remove() error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(f.Name())
+}
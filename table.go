@@ -1,37 +1,150 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"context"
 	"encoding/binary"
 	"errors"
 	"io"
-	"os"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
+// Row Layout
+//
+// A serialized row starts with the null bitmap and the id at fixed offsets.
+// The username and email follow, each as a one byte length and then only the
+// bytes of the string, so rows take as much room as their values need.
+//
+//	offset  size  field
+//	     0     1  null bitmap (NullUsername, NullEmail)
+//	     1     8  id (int64)
+//	     9     1  username length n
+//	    10     n  username
+//	  10+n     1  email length m
+//	  11+n     m  email
 const (
-	idSize         = int(unsafe.Sizeof(int32(0)))
+	idSize         = int(unsafe.Sizeof(int64(0)))
 	usernameSize   = ColumnUsernameSize
 	emailSize      = ColumnEmailSize
-	idOffset       = 0
+	textLengthSize = 1
+	nullsSize      = 1
+	nullsOffset    = 0
+	idOffset       = nullsOffset + nullsSize
 	usernameOffset = idOffset + idSize
-	emailOffset    = usernameOffset + usernameSize
-	rowSize        = idSize + usernameSize + emailSize
+	// rowSize is the size of the largest row, with both strings at full length
+	rowSize = nullsSize + idSize + textLengthSize + usernameSize + textLengthSize + emailSize
 
 	pageSize      = 4096
 	tableMaxPages = 100
+	// pageReservedSize bytes at the end of every page are left for the tag
+	// and nonce of encrypted databases (see encryption.go), nodes and the
+	// catalog only use the first pageUsableSize bytes.
+	pageReservedSize = 32
+	pageUsableSize   = pageSize - pageReservedSize
 )
 
 var ErrTableFull = errors.New("table is full")
 var ErrFlushEmptyPage = errors.New("attempt to flush empty page")
 var ErrLeafSplittingNotImplemented = errors.New("leaf node splitting not implemented")
 var ErrDuplicateKey = errors.New("duplicate key")
+var ErrIDsExhausted = errors.New("no IDs left to assign")
 
 // Pager manages the paged file storage
 type Pager struct {
 	fileLength int64
-	file       *os.File
+	file       Storage
 	pages      [tableMaxPages][]byte
 	numPages   uint32
+	arena      pageArena // backs the buffers in pages
+	// dirty marks the cached pages changed since they were read, which are
+	// the only ones commit writes back.
+	dirty [tableMaxPages]bool
+	// backups are the running backups, told about every page that changes.
+	backups []*Backup
+	// snapshots are the pagers of the open snapshots. Each gets its own copy
+	// of a page it shares with this pager before the page changes.
+	snapshots []*Pager
+	// cipher encrypts the pages written to the file, nil if the database is
+	// not encrypted.
+	cipher *pageCipher
+	// snapshot is set on the pager of a Snapshot, which only reads. shared
+	// marks the cached pages whose buffers still belong to the live pager.
+	snapshot bool
+	shared   [tableMaxPages]bool
+	// mu guards the page cache and stats, which goroutines reading the
+	// database at the same time fill in side by side (see latch.go), as do
+	// the reader of a snapshot and the preserve calls into it.
+	mu sync.Mutex
+	// preallocChunk is the step the file grows in, 0 disables preallocation.
+	// allocatedLength is the file size including preallocated space.
+	preallocChunk   int64
+	allocatedLength int64
+	// stats counts page accesses for EXPLAIN ANALYZE and Stats.
+	stats PagerStats
+	// journalPath is where commit keeps the rollback journal, opened with
+	// openStorage like the database file itself.
+	journalPath string
+	openStorage StorageOpener
+	// doubleWritePath is where commit writes pages before writing them to
+	// the file, "" to write them directly (see writeDoubleWrite).
+	doubleWritePath string
+	syncMode        SyncMode
+	// mapping is the file mapped into memory by mapFile, nil if it is not.
+	// The first mappedPages pages are served from it instead of read into
+	// arena buffers.
+	mapping     []byte
+	mappedPages uint32
+	// txn is the open transaction, nil outside one (see Database.Begin).
+	txn *pagerTxn
+}
+
+// PagerStats counts how page requests were served. PagesRead are pages loaded
+// from the file, on demand or by prefetch, and PagesWritten pages written back
+// to it; CacheHits are requests for pages that were already cached and
+// CacheMisses the others. BytesOnDisk is the size of the file, preallocated
+// space included, and is only filled in by Stats. KeyBytesSaved is how many
+// bytes of keys the leaves do not store thanks to their shared key prefix,
+// and is only filled in by Database.Stats.
+type PagerStats struct {
+	PagesRead     uint64
+	PagesWritten  uint64
+	CacheHits     uint64
+	CacheMisses   uint64
+	BytesOnDisk   int64
+	KeyBytesSaved int64
+}
+
+func (s PagerStats) sub(o PagerStats) PagerStats {
+	return PagerStats{
+		PagesRead:    s.PagesRead - o.PagesRead,
+		PagesWritten: s.PagesWritten - o.PagesWritten,
+		CacheHits:    s.CacheHits - o.CacheHits,
+		CacheMisses:  s.CacheMisses - o.CacheMisses,
+	}
+}
+
+func (s PagerStats) add(o PagerStats) PagerStats {
+	return PagerStats{
+		PagesRead:    s.PagesRead + o.PagesRead,
+		PagesWritten: s.PagesWritten + o.PagesWritten,
+		CacheHits:    s.CacheHits + o.CacheHits,
+		CacheMisses:  s.CacheMisses + o.CacheMisses,
+	}
+}
+
+// Stats returns the pager's counters since it was opened.
+func (p *Pager) Stats() PagerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	s.BytesOnDisk = max(p.allocatedLength, p.fileLength)
+	return s
 }
 
 // getPage retrieves a page from the pager, loading it from disk if necessary.
@@ -41,34 +154,52 @@ func (p *Pager) getPage(pageNum uint32) ([]byte, error) {
 	if pageNum >= tableMaxPages {
 		return nil, errors.New("page number out of bounds")
 	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
 	// Load page from file if not already loaded
-	if p.pages[pageNum] == nil {
+	if p.pages[pageNum] != nil {
+		p.stats.CacheHits++
+	} else if pageNum < p.mappedPages {
+		p.stats.CacheMisses++
+		offset := int(pageNum) * pageSize
+		p.pages[pageNum] = p.mapping[offset : offset+pageSize : offset+pageSize]
+		// The mapping of a snapshot is the live pager's, which writes to it
+		p.shared[pageNum] = p.snapshot
+		p.stats.PagesRead++
+	} else {
+		p.stats.CacheMisses++
 		numPages := uint32(p.fileLength / pageSize)
 		// We might save a partial page at the end of the file
 		if p.fileLength%pageSize != 0 {
 			numPages++
 		}
 
-		if pageNum <= numPages {
-			p.pages[pageNum] = make([]byte, pageSize)
-			n, err := p.file.ReadAt(p.pages[pageNum], int64(pageNum)*pageSize)
+		// We always hand out a writable buffer, even when there is no persisted
+		// data for this page yet (e.g. when appending new rows past the current
+		// file length). Arena chunks come back zeroed, so a short read leaves
+		// the rest of the page empty.
+		page := p.arena.alloc()
+		if pageNum < numPages {
+			n, err := p.file.ReadAt(page, int64(pageNum)*pageSize)
 			if err != nil && err != io.EOF {
+				p.arena.free(page)
 				return nil, err
 			}
-			// If we read less than a full page, zero out the rest
-			if n < pageSize {
-				for i := n; i < pageSize; i++ {
-					p.pages[pageNum][i] = 0
+			if n > 0 {
+				p.stats.PagesRead++
+			}
+			if p.cipher != nil {
+				if err := p.cipher.open(page, pageNum); err != nil {
+					p.arena.free(page)
+					return nil, err
 				}
 			}
 		}
-
-		if p.pages[pageNum] == nil {
-			// We still have to allocate a fresh page here because there may be no
-			// persisted data for this page yet (e.g. when appending new rows past the
-			// current file length), so the caller always receives a writable buffer.
-			p.pages[pageNum] = make([]byte, pageSize)
+		p.pages[pageNum] = page
+		// A page past the end of the file only exists in the cache
+		if int64(pageNum)*pageSize >= p.fileLength {
+			p.dirty[pageNum] = true
 		}
 
 		// Update numPages if we just allocated a new page
@@ -77,9 +208,99 @@ func (p *Pager) getPage(pageNum uint32) ([]byte, error) {
 		}
 	}
 
+	if p.shared[pageNum] {
+		p.unshare(pageNum)
+	}
 	return p.pages[pageNum], nil
 }
 
+// getPageForWrite is getPage for callers about to change the page: it marks
+// the page dirty so commit writes it back.
+func (p *Pager) getPageForWrite(pageNum uint32) ([]byte, error) {
+	page, err := p.getPage(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.markDirty(pageNum); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// markDirty records that the cached page pageNum is about to change. It must
+// be called before the change, so open snapshots can keep the old contents.
+func (p *Pager) markDirty(pageNum uint32) error {
+	if p.snapshot {
+		return ErrSnapshotReadOnly
+	}
+	for _, s := range p.snapshots {
+		s.preserve(pageNum, p.pages[pageNum])
+	}
+	if p.txn != nil {
+		p.txn.save(pageNum, p.pages[pageNum])
+	}
+	p.dirty[pageNum] = true
+	for _, b := range p.backups {
+		if pageNum < b.next {
+			b.stale[pageNum] = true
+		}
+	}
+	return nil
+}
+
+// readAheadPages is how many consecutive pages prefetch pulls in with one read.
+const readAheadPages = 8
+
+// prefetch loads up to count consecutive pages starting at pageNum into the
+// cache with a single ReadAt, stopping early at the end of the file or at a
+// page that is already cached. Sequential scans use it to replace a run of
+// per-page reads with one larger read.
+func (p *Pager) prefetch(pageNum uint32, count uint32) error {
+	if p.mapping != nil {
+		// The kernel reads the mapped pages ahead by itself
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	end := min(pageNum+count, uint32(p.fileLength/pageSize), tableMaxPages)
+	n := pageNum
+	for n < end && p.pages[n] == nil {
+		n++
+	}
+	if n-pageNum < 2 {
+		// Nothing to batch, getPage will load the page on demand
+		return nil
+	}
+
+	run := p.arena.allocRun(int(n - pageNum))
+	read, err := p.file.ReadAt(run, int64(pageNum)*pageSize)
+	if err != nil && err != io.EOF {
+		for i := 0; i < len(run); i += pageSize {
+			p.arena.free(run[i : i+pageSize : i+pageSize])
+		}
+		return err
+	}
+	clear(run[read:])
+	p.stats.PagesRead += uint64(n - pageNum)
+	if p.cipher != nil {
+		for i := pageNum; i < n; i++ {
+			offset := int(i-pageNum) * pageSize
+			if err := p.cipher.open(run[offset:offset+pageSize], i); err != nil {
+				for i := 0; i < len(run); i += pageSize {
+					p.arena.free(run[i : i+pageSize : i+pageSize])
+				}
+				return err
+			}
+		}
+	}
+
+	for i := pageNum; i < n; i++ {
+		offset := int(i-pageNum) * pageSize
+		p.pages[i] = run[offset : offset+pageSize : offset+pageSize]
+	}
+	return nil
+}
+
 // flush writes a page back to disk
 // Each Btree node is a page, so this function is used to persist Btree nodes
 func (p *Pager) flush(pageNum uint32) error {
@@ -87,36 +308,318 @@ func (p *Pager) flush(pageNum uint32) error {
 		return ErrFlushEmptyPage
 	}
 
-	_, err := p.file.WriteAt(p.pages[pageNum], int64(pageNum)*pageSize)
+	if err := p.ensureFileSize(int64(pageNum+1) * pageSize); err != nil {
+		return err
+	}
+	scratch := getPageBuffer()
+	defer putPageBuffer(scratch)
+	page, err := p.filePage(pageNum, p.pages[pageNum], scratch)
+	if err != nil {
+		return err
+	}
+	if _, err := p.file.WriteAt(page, int64(pageNum)*pageSize); err != nil {
+		return err
+	}
+	p.stats.PagesWritten++
+	return nil
+}
+
+// ensureFileSize grows the file to hold at least size bytes when preallocation
+// is enabled. The file is extended in whole preallocChunk steps so a bulk load
+// doesn't pay for growing the file one page at a time.
+func (p *Pager) ensureFileSize(size int64) error {
+	if p.preallocChunk == 0 || size <= p.allocatedLength {
+		return nil
+	}
+	newLength := (size + p.preallocChunk - 1) / p.preallocChunk * p.preallocChunk
+	var err error
+	if pa, ok := p.file.(preallocator); ok {
+		err = pa.Preallocate(p.allocatedLength, newLength-p.allocatedLength)
+	} else {
+		err = p.file.Truncate(newLength)
+	}
+	if err != nil {
+		return err
+	}
+	p.allocatedLength = newLength
+	return nil
+}
+
+// close drops the cached pages, recycling their buffers, and closes the file
+// without writing anything back.
+func (p *Pager) close() error {
+	p.pages = [tableMaxPages][]byte{}
+	p.dirty = [tableMaxPages]bool{}
+	p.arena.release()
+	if err := p.unmapFile(); err != nil {
+		p.file.Close()
+		return err
+	}
+	return p.file.Close()
+}
+
+// mapFile maps the database file into memory so getPage hands out pages
+// without reading them. The mapping is private: changes to a page copy it and
+// are written back by flushAll like any other page. Pages added after
+// mapping live in arena buffers.
+func (p *Pager) mapFile() error {
+	file, ok := p.file.(fileStorage)
+	if !ok {
+		return errors.New("memory mapping needs the default file storage")
+	}
+	if p.fileLength == 0 {
+		return nil
+	}
+	mapping, err := mmapFile(file.File, p.fileLength)
+	if err != nil {
+		return err
+	}
+	p.mapping = mapping
+	p.mappedPages = uint32(p.fileLength / pageSize)
+	return nil
+}
+
+// unmapFile releases the mapping. Pages served from it must not be used
+// afterwards.
+func (p *Pager) unmapFile() error {
+	if p.mapping == nil {
+		return nil
+	}
+	err := munmapFile(p.mapping)
+	p.mapping, p.mappedPages = nil, 0
 	return err
 }
 
-// getUnusedPageNum returns the next unused page number for appending new pages.
-// TODO: This function currently does not handle reusing freed pages after deletions.
-func (p *Pager) getUnusedPageNum() uint32 {
-	return p.numPages
+// maxCoalescedPages caps how many adjacent pages flushAll merges into a single
+// write, bounding the size of the staging buffer.
+const maxCoalescedPages = 64
+
+// flushAll writes every dirty page back to disk and marks it clean. Pages that
+// were only read are left alone. Runs of adjacent dirty pages are written with
+// a single call, so closing a large database costs one syscall per run
+// instead of one per page: a vectored write straight from the cached pages
+// when the storage supports it, otherwise a WriteAt of the pages staged into
+// one buffer. Encrypted pages are always staged, sealed.
+func (p *Pager) flushAll() error {
+	var staging []byte
+	for pageNum := uint32(0); pageNum < p.numPages; {
+		if !p.dirty[pageNum] {
+			pageNum++
+			continue
+		}
+
+		start := pageNum
+		for pageNum < p.numPages && p.dirty[pageNum] && pageNum-start < maxCoalescedPages {
+			pageNum++
+		}
+
+		if pageNum-start == 1 {
+			if err := p.flush(start); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := p.ensureFileSize(int64(pageNum) * pageSize); err != nil {
+			return err
+		}
+		if vw, ok := p.file.(vectorWriter); ok && p.cipher == nil {
+			if err := vw.WriteVAt(p.pages[start:pageNum], int64(start)*pageSize); err != nil {
+				return err
+			}
+			p.stats.PagesWritten += uint64(pageNum - start)
+			continue
+		}
+		if staging == nil {
+			staging = make([]byte, 0, min(p.numPages, maxCoalescedPages)*pageSize)
+		}
+		staging = staging[:0]
+		for n := start; n < pageNum; n++ {
+			if p.cipher == nil {
+				staging = append(staging, p.pages[n]...)
+				continue
+			}
+			staging = staging[:len(staging)+pageSize]
+			if err := p.cipher.seal(staging[len(staging)-pageSize:], p.pages[n], n); err != nil {
+				return err
+			}
+		}
+		if _, err := p.file.WriteAt(staging, int64(start)*pageSize); err != nil {
+			return err
+		}
+		p.stats.PagesWritten += uint64(pageNum - start)
+	}
+	p.dirty = [tableMaxPages]bool{}
+	return nil
+}
+
+// freePageCount returns how many more pages getUnusedPageNum can hand out:
+// the pages on the free list and those left below tableMaxPages.
+func (p *Pager) freePageCount() (int, error) {
+	header, err := p.getPage(headerPageNum)
+	if err != nil {
+		return 0, err
+	}
+	return int(headerFreelistCount(header)) + tableMaxPages - int(p.numPages), nil
 }
 
-func openPager(filename string) (*Pager, error) {
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+// getUnusedPageNum returns a page number for a new node: the first page of
+// the free list, cleared, or the page past the end of the file when the free
+// list is empty.
+func (p *Pager) getUnusedPageNum() (uint32, error) {
+	header, err := p.getPageForWrite(headerPageNum)
+	if err != nil {
+		return 0, err
+	}
+	head := headerFreelistHead(header)
+	if head == 0 {
+		setHeaderPageCount(header, p.numPages+1)
+		return p.numPages, nil
+	}
+
+	page, err := p.getPageForWrite(head)
+	if err != nil {
+		return 0, err
+	}
+	setHeaderFreelist(header, binary.LittleEndian.Uint32(page), headerFreelistCount(header)-1)
+	clear(page)
+	return head, nil
+}
+
+// getUnusedPageNumNear is getUnusedPageNum for a node that belongs next to
+// the node on page near, like the new sibling of a split. It takes the free
+// page closest after near, or the closest one before it if there is none, so
+// siblings stay close together in the file and a scan reads it mostly in
+// order even after many random inserts.
+func (p *Pager) getUnusedPageNumNear(near uint32) (uint32, error) {
+	header, err := p.getPageForWrite(headerPageNum)
+	if err != nil {
+		return 0, err
+	}
+	count := headerFreelistCount(header)
+	if count == 0 {
+		return p.getUnusedPageNum()
+	}
+
+	// Walk the list for the best page and the one linking to it
+	best, bestPrev := headerFreelistHead(header), uint32(0)
+	prev, pageNum := uint32(0), best
+	for range count {
+		if pageNum == 0 {
+			break
+		}
+		page, err := p.getPage(pageNum)
+		if err != nil {
+			return 0, err
+		}
+		if nearer(pageNum, best, near) {
+			best, bestPrev = pageNum, prev
+		}
+		prev, pageNum = pageNum, binary.LittleEndian.Uint32(page)
+	}
+	if bestPrev == 0 {
+		return p.getUnusedPageNum()
+	}
+
+	page, err := p.getPageForWrite(best)
+	if err != nil {
+		return 0, err
+	}
+	prevPage, err := p.getPageForWrite(bestPrev)
+	if err != nil {
+		return 0, err
+	}
+	copy(prevPage[:4], page[:4])
+	setHeaderFreelist(header, headerFreelistHead(header), count-1)
+	clear(page)
+	return best, nil
+}
+
+// nearer reports whether page a is a better place than page b for a node
+// that belongs next to the node on page near: pages after near come first,
+// closest first, then the pages before it, closest first.
+func nearer(a, b, near uint32) bool {
+	if (a > near) != (b > near) {
+		return a > near
+	}
+	if a > near {
+		return a < b
+	}
+	return a > b
+}
+
+// freePage puts pageNum on the free list for getUnusedPageNum to hand out
+// again. No node may point to the page any more.
+func (p *Pager) freePage(pageNum uint32) error {
+	header, err := p.getPageForWrite(headerPageNum)
+	if err != nil {
+		return err
+	}
+	page, err := p.getPageForWrite(pageNum)
+	if err != nil {
+		return err
+	}
+	clear(page)
+	binary.LittleEndian.PutUint32(page, headerFreelistHead(header))
+	setHeaderFreelist(header, pageNum, headerFreelistCount(header)+1)
+	return nil
+}
+
+func openPager(filename string, open StorageOpener) (*Pager, error) {
+	file, err := open(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	fileInfo, err := file.Stat()
+	// An interrupted commit is finished from its double-write file if that
+	// was written whole, and undone with its journal otherwise
+	replayed, err := replayDoubleWrite(file, filename+doubleWriteSuffix, open)
 	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	journalPath := filename + journalSuffix
+	if replayed {
+		err = removeJournal(journalPath, open)
+	} else {
+		err = rollbackJournal(file, journalPath, open)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	fileSize, err := file.Size()
+	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
-	fileSize := fileInfo.Size()
 	if fileSize%pageSize != 0 {
+		file.Close()
 		return nil, errors.New("db file is not a whole number of pages. Corrupt file?")
 	}
 
+	// Space past the page count was preallocated and holds no pages yet
+	numPages := uint32(fileSize / pageSize)
+	if numPages > 0 {
+		count, ok, err := readHeaderPageCount(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if ok && count > 0 && count <= numPages {
+			numPages = count
+		}
+	}
+
 	pager := &Pager{
-		fileLength: fileSize,
-		file:       file,
-		numPages:   uint32(fileSize / pageSize),
+		fileLength:      int64(numPages) * pageSize,
+		file:            file,
+		numPages:        numPages,
+		allocatedLength: fileSize,
+		journalPath:     journalPath,
+		openStorage:     open,
 	}
 
 	// TODO: Eager allocation of pages can be done here if needed
@@ -127,114 +630,377 @@ func openPager(filename string) (*Pager, error) {
 type Table struct {
 	rootPageNum uint32
 	pager       *Pager
+	// db is the database the table belongs to.
+	db *Database
+	// catalogIndex is the table's entry in the catalog on the header page.
+	catalogIndex uint32
+	// rightmostLeaf caches the page number of the last leaf so appends of
+	// increasing keys can skip the descent from the root. It is only a hint
+	// and is revalidated against the page contents before use.
+	rightmostLeaf uint32
+	// leafHint remembers the leaf the last lookup ended in, 0 for none (page
+	// 0 is the file header). Lookups for keys inside that leaf's key range
+	// reuse it instead of descending again. Lookups running side by side
+	// under the latch for reading all set it, hence the atomic.
+	leafHint atomic.Uint32
+	// latch is the database's latch, taken by the table's methods (see
+	// latch.go). It is nil for the tables of a snapshot, which need none.
+	latch *sync.RWMutex
+	// bloom is an optional in-memory filter over all keys in the table, see WithBloomFilter.
+	bloom *bloomFilter
+	// lastInsertID is the ID of the last row inserted through this Table, 0 if none.
+	lastInsertID int64
 }
 
-func OpenDatabase(filename string) (*Table, error) {
-	pager, err := openPager(filename)
-	if err != nil {
-		return nil, err
+// openConfig collects the settings applied by Option values in OpenDatabase.
+type openConfig struct {
+	bloomFilter   bool
+	preallocChunk int64
+	storage       StorageOpener
+	syncMode      SyncMode
+	mmap          bool
+	// checkpointInterval is how often dirty pages are committed in the
+	// background, 0 only commits on Close.
+	checkpointInterval time.Duration
+	// groupCommitDelay is how long a flush waits for more commits to join
+	// it, 0 to write every commit on its own (see WithGroupCommit).
+	groupCommitDelay time.Duration
+	// passphrase encrypts a new database and opens an encrypted one.
+	passphrase  string
+	doubleWrite bool
+	// appendSplitPercent is how much of a leaf an insert past the last key
+	// of the table leaves in it when it splits the leaf, 0 for half like
+	// every other split.
+	appendSplitPercent int
+	// writeChecks checks the tree around every insert (see WithWriteChecks).
+	writeChecks bool
+}
+
+// Option customizes how OpenDatabase opens a database.
+type Option func(*openConfig)
+
+// WithBloomFilter keeps an in-memory bloom filter over each table's keys. It
+// is built by scanning the table when it is opened and updated on every
+// insert, so lookups for keys that were never inserted return without
+// touching the B-tree.
+func WithBloomFilter() Option {
+	return func(c *openConfig) {
+		c.bloomFilter = true
+	}
+}
+
+// WithPreallocation makes the pager grow the database file in chunks of at
+// least chunkSize bytes (rounded up to whole pages) instead of page by page.
+// Preallocated space stays with the file for later sessions to fill; the
+// header records how many pages are in use.
+func WithPreallocation(chunkSize int64) Option {
+	return func(c *openConfig) {
+		c.preallocChunk = (chunkSize + pageSize - 1) / pageSize * pageSize
+	}
+}
+
+// WithStorage makes the pager go through the Storage returned by open instead
+// of a plain file. The filename given to OpenDatabase is passed on to open.
+func WithStorage(open StorageOpener) Option {
+	return func(c *openConfig) {
+		c.storage = open
 	}
+}
 
-	table := &Table{
-		pager:       pager,
-		rootPageNum: 0,
+// WithMmap memory-maps the database file and serves pages straight from the
+// mapping, avoiding a read call and a copy per page. Changed pages are still
+// written back on Close. Only the default file storage on Linux can be mapped.
+func WithMmap() Option {
+	return func(c *openConfig) {
+		c.mmap = true
 	}
+}
 
-	if pager.numPages == 0 {
-		// New database file. Initialize page 0 as leaf node
-		rootNode, err := pager.getPage(0)
+// WithCheckpointInterval commits dirty pages to the file every interval from
+// a background goroutine instead of only on Close. The database must then be
+// used under its Lock.
+func WithCheckpointInterval(interval time.Duration) Option {
+	return func(c *openConfig) {
+		c.checkpointInterval = interval
+	}
+}
+
+// WithPassphrase encrypts the pages of the database with a key derived from
+// passphrase. A new database is created encrypted; an existing one must have
+// been created with the same passphrase. Encrypted databases cannot be used
+// with WithMmap.
+func WithPassphrase(passphrase string) Option {
+	return func(c *openConfig) {
+		c.passphrase = passphrase
+	}
+}
+
+// WithDoubleWrite makes commit write the changed pages to a double-write
+// file next to the database, and sync it, before writing them to the
+// database file. A crash in the middle of writing a page then cannot leave a
+// torn page behind: the next open copies the pages over again.
+func WithDoubleWrite() Option {
+	return func(c *openConfig) {
+		c.doubleWrite = true
+	}
+}
+
+// WithAppendSplit makes an insert past the last key of a table that splits
+// the last leaf keep percent of the leaf's bytes in it, instead of half, and
+// move only the rest to the new leaf. Inserts in increasing key order, like
+// the ones of automatic IDs, then fill leaves to about percent instead of
+// leaving each one half empty; other splits still halve the leaf. percent is
+// clamped to 50..100, 100 moving only the new row.
+func WithAppendSplit(percent int) Option {
+	return func(c *openConfig) {
+		c.appendSplitPercent = min(max(percent, 50), 100)
+	}
+}
+
+// WithSyncMode sets how hard Close works to get the database onto stable
+// storage. The default is SyncFull.
+func WithSyncMode(mode SyncMode) Option {
+	return func(c *openConfig) {
+		c.syncMode = mode
+	}
+}
+
+// rebuildBloomFilter recreates the bloom filter from every key in the table,
+// sized for at least minCapacity keys.
+func (t *Table) rebuildBloomFilter(minCapacity int) error {
+	start, err := t.findKey(0)
+	if err != nil {
+		return err
+	}
+	var keys []uint64
+	pageNum := start.pageNum
+	for {
+		page, err := t.pager.getPage(pageNum)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		for cellNum := range leafNodeNumCells(page).get() {
+			keys = append(keys, leafNodeKey(page, cellNum))
+		}
+		if pageNum = leafNodeNextLeaf(page).get(); pageNum == 0 {
+			break
 		}
-		initializeLeafNode(rootNode)
-		setNodeRoot(rootNode, true)
 	}
 
-	return table, nil
+	t.bloom = newBloomFilter(max(minCapacity, 2*len(keys)))
+	for _, key := range keys {
+		t.bloom.add(key)
+	}
+	return nil
+}
+
+// trackInsertedKey records a newly inserted key in the bloom filter, growing
+// the filter once it holds more keys than it was sized for.
+func (t *Table) trackInsertedKey(key uint64) error {
+	if t.bloom == nil {
+		return nil
+	}
+	t.bloom.add(key)
+	if t.bloom.full() {
+		return t.rebuildBloomFilter(2 * t.bloom.capacity)
+	}
+	return nil
 }
 
-// serializeRow converts a Row struct to bytes and stores it in the destination
-func serializeRow(row *Row, dest []byte) {
-	binary.LittleEndian.PutUint32(dest[idOffset:], uint32(row.ID))
-	copy(dest[usernameOffset:usernameOffset+usernameSize], row.Username[:])
-	copy(dest[emailOffset:emailOffset+emailSize], row.Email[:])
+// serializedRowSize returns the number of bytes serializeRow writes for row.
+func serializedRowSize(row *Row) int {
+	return usernameOffset + textLengthSize + len(trimNulBytes(row.Username[:])) +
+		textLengthSize + len(trimNulBytes(row.Email[:]))
+}
+
+// serializeRow converts a Row struct to bytes and stores it in the destination,
+// which must hold at least serializedRowSize(row) bytes. It returns the number
+// of bytes written.
+func serializeRow(row *Row, dest []byte) int {
+	dest[nullsOffset] = row.Nulls
+	binary.LittleEndian.PutUint64(dest[idOffset:], uint64(row.ID))
+	n := usernameOffset
+	n += putText(dest[n:], trimNulBytes(row.Username[:]))
+	n += putText(dest[n:], trimNulBytes(row.Email[:]))
+	return n
+}
+
+func putText(dest []byte, text []byte) int {
+	dest[0] = byte(len(text))
+	return textLengthSize + copy(dest[textLengthSize:], text)
+}
+
+// rowText returns the string stored at offset of a serialized row and the
+// offset of the field after it.
+func rowText(src []byte, offset int) (text []byte, next int) {
+	n := int(src[offset])
+	start := offset + textLengthSize
+	return src[start : start+n], start + n
+}
+
+// serializedRowLen returns the length of the serialized row at the start of src.
+func serializedRowLen(src []byte) int {
+	_, emailOffset := rowText(src, usernameOffset)
+	_, end := rowText(src, emailOffset)
+	return end
 }
 
 // deserializeRow converts bytes back to a Row struct
 func deserializeRow(src []byte, row *Row) {
-	row.ID = int32(binary.LittleEndian.Uint32(src[idOffset:]))
-	copy(row.Username[:], src[usernameOffset:usernameOffset+usernameSize])
-	copy(row.Email[:], src[emailOffset:emailOffset+emailSize])
+	row.Nulls = src[nullsOffset]
+	row.ID = int64(binary.LittleEndian.Uint64(src[idOffset:]))
+	username, emailOffset := rowText(src, usernameOffset)
+	email, _ := rowText(src, emailOffset)
+	clear(row.Username[copy(row.Username[:], username):])
+	clear(row.Email[copy(row.Email[:], email):])
+}
+
+// RowView is a read-only view of a serialized row inside a page. Fields are
+// decoded on access rather than copied up front like deserializeRow does, so
+// code that only needs the id never touches the username or email bytes.
+// A view aliases page memory and is only valid until the table is modified.
+type RowView struct {
+	data []byte
+}
+
+func (v RowView) ID() int64 {
+	return int64(binary.LittleEndian.Uint64(v.data[idOffset:]))
+}
+
+func (v RowView) Username() string {
+	username, _ := rowText(v.data, usernameOffset)
+	return string(username)
+}
+
+func (v RowView) Email() string {
+	_, emailOffset := rowText(v.data, usernameOffset)
+	email, _ := rowText(v.data, emailOffset)
+	return string(email)
+}
+
+// IsNull reports whether the column with the given null bit (NullUsername or
+// NullEmail) holds NULL. A NULL column reads as the empty string.
+func (v RowView) IsNull(column uint8) bool {
+	return v.data[nullsOffset]&column != 0
+}
+
+// Decode copies every field of the view into row.
+func (v RowView) Decode(row *Row) {
+	deserializeRow(v.data, row)
+}
+
+// trimNul converts a NUL-padded fixed-size column to a string, stopping at the first NUL byte.
+func trimNul(b []byte) string {
+	return string(trimNulBytes(b))
+}
+
+// trimNulBytes is like trimNul but returns a subslice of b instead of copying.
+func trimNulBytes(b []byte) []byte {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return b[:i]
+	}
+	return b
 }
 
 // findKey finds the position of a key in the table and returns a cursor to it
 // if the key is not found, it returns a cursor to the position where it should be inserted
-func (t *Table) findKey(key uint32) (*Cursor, error) {
+func (t *Table) findKey(key uint64) (*Cursor, error) {
+	if hint := t.leafHint.Load(); t.leafHintCovers(hint, key) {
+		return t.findKeyInLeaf(hint, key), nil
+	}
+
 	rootPage, err := t.pager.getPage(t.rootPageNum)
 	if err != nil {
-		panic(err) // In a real application, handle this error properly
+		return nil, err
 	}
 
-	switch *nodeType(rootPage) {
+	var c *Cursor
+	switch nodeType(rootPage) {
 	case NodeTypeLeaf:
-		return t.findKeyInLeaf(t.rootPageNum, key), nil
+		c = t.findKeyInLeaf(t.rootPageNum, key)
 	case NodeTypeInternal:
-		return t.findKeyInInternal(t.rootPageNum, key)
+		c, err = t.findKeyInInternal(t.rootPageNum, key)
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, errors.New("unknown node type to find key")
 	}
+
+	t.leafHint.Store(c.pageNum)
+	return c, nil
+}
+
+// seek is findKey for callers that need to know whether key is in the table:
+// found reports whether the cursor is on the row stored under key. When it
+// is not, the cursor is where key would be inserted.
+func (t *Table) seek(key uint64) (cursor *Cursor, found bool, err error) {
+	cursor, err = t.findKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+	page, err := t.pager.getPage(cursor.pageNum)
+	if err != nil {
+		return nil, false, err
+	}
+	found = cursor.cellNum < leafNodeNumCells(page).get() && leafNodeKey(page, cursor.cellNum) == key
+	return cursor, found, nil
+}
+
+// leafHintCovers reports whether key falls between the first and last key of
+// the hinted leaf hint. Leaves hold disjoint key ranges, so such a key can
+// only live in (or be inserted into) that leaf.
+func (t *Table) leafHintCovers(hint uint32, key uint64) bool {
+	if hint == 0 {
+		return false
+	}
+	node, err := t.pager.getPage(hint)
+	if err != nil || nodeType(node) != NodeTypeLeaf {
+		return false
+	}
+	numCells := leafNodeNumCells(node).get()
+	if numCells == 0 {
+		return false
+	}
+	return leafNodeKey(node, 0) <= key && key <= leafNodeKey(node, numCells-1)
+}
+
+// invalidateLeafHint drops the cached leaf. Called whenever cells move between pages.
+func (t *Table) invalidateLeafHint() {
+	t.leafHint.Store(0)
 }
 
 // findKeyInLeaf searches for a key in a leaf node and returns a cursor to its position
 // if the key is not found, it returns a cursor to the position where it should be inserted
-func (t *Table) findKeyInLeaf(pageNum uint32, key uint32) *Cursor {
+func (t *Table) findKeyInLeaf(pageNum uint32, key uint64) *Cursor {
 	node, err := t.pager.getPage(pageNum)
 	if err != nil {
 		panic(err) // In a real application, handle this error properly
 	}
-	numOfCells := *leafNodeNumCells(node)
-	c := &Cursor{
+	return &Cursor{
 		table:   t,
 		pageNum: pageNum,
+		cellNum: leafNodeFindKey(node, key),
 	}
-
-	// Binary search
-	i, j := uint32(0), numOfCells
-	for i != j {
-		mid := (i + j) / 2
-		midKey := *leafNodeKey(node, mid)
-		if key == midKey {
-			c.cellNum = mid
-			return c
-		}
-		if key < midKey {
-			j = mid
-		} else {
-			i = mid + 1
-		}
-	}
-
-	c.cellNum = i
-	return c
 }
 
 // findKeyInInternal searches for a key in an internal node and returns a cursor to its position
 // if the key is not found, it returns a cursor to the position where it should be inserted
-func (t *Table) findKeyInInternal(pageNum uint32, key uint32) (*Cursor, error) {
+func (t *Table) findKeyInInternal(pageNum uint32, key uint64) (*Cursor, error) {
 	node, err := t.pager.getPage(pageNum)
 	if err != nil {
 		return nil, err
 	}
 
 	childIndex := internalNodeFindChild(node, key)
-	childPageNum := *internalNodeChild(node, childIndex)
+	childPageNum := internalNodeChild(node, childIndex).get()
 
 	childNode, err := t.pager.getPage(childPageNum)
 	if err != nil {
 		return nil, err
 	}
 
-	switch *nodeType(childNode) {
+	switch nodeType(childNode) {
 	case NodeTypeLeaf:
 		return t.findKeyInLeaf(childPageNum, key), nil
 	case NodeTypeInternal:
@@ -244,22 +1010,124 @@ func (t *Table) findKeyInInternal(pageNum uint32, key uint32) (*Cursor, error) {
 	}
 }
 
+// rightmostLeafPage follows right child pointers from pageNum down to the last leaf of its subtree.
+func (t *Table) rightmostLeafPage(pageNum uint32) (uint32, error) {
+	for {
+		node, err := t.pager.getPage(pageNum)
+		if err != nil {
+			return 0, err
+		}
+		if nodeType(node) == NodeTypeLeaf {
+			return pageNum, nil
+		}
+		pageNum = internalNodeRightChild(node).get()
+	}
+}
+
+// getNodeMaxKey returns the largest key in the subtree rooted at node. The
+// separators of an internal node only bound the children left of its right
+// child, so for an internal node it follows right children down to the last
+// leaf of the subtree.
+func (t *Table) getNodeMaxKey(node []byte) (uint64, error) {
+	if nodeType(node) == NodeTypeInternal {
+		pageNum, err := t.rightmostLeafPage(internalNodeRightChild(node).get())
+		if err != nil {
+			return 0, err
+		}
+		if node, err = t.pager.getPage(pageNum); err != nil {
+			return 0, err
+		}
+	}
+	if nodeType(node) != NodeTypeLeaf {
+		return 0, errors.New("unknown node type to find max key")
+	}
+	return leafNodeKey(node, leafNodeNumCells(node).get()-1), nil
+}
+
+// previousLeaf returns the leaf before the one at pageNum in key order, or 0
+// if it is the first. Leaves only link forward, so it climbs the parents until
+// it can step one child to the left and then follows right children down.
+func (t *Table) previousLeaf(pageNum uint32) (uint32, error) {
+	for {
+		node, err := t.pager.getPage(pageNum)
+		if err != nil {
+			return 0, err
+		}
+		if isNodeRoot(node) {
+			return 0, nil
+		}
+		parentPageNum := nodeParent(node).get()
+		parent, err := t.pager.getPage(parentPageNum)
+		if err != nil {
+			return 0, err
+		}
+		if index := internalNodeFindChildByPage(parent, pageNum); index > 0 {
+			return t.rightmostLeafPage(internalNodeChild(parent, index-1).get())
+		}
+		pageNum = parentPageNum
+	}
+}
+
+// appendCursor returns a cursor past the last cell of the rightmost leaf when key
+// is greater than every key in the table, so sequential inserts skip the
+// root-to-leaf descent in findKey. ok is false when the fast path does not apply.
+func (t *Table) appendCursor(key uint64) (cursor *Cursor, ok bool, err error) {
+	page, err := t.pager.getPage(t.rightmostLeaf)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The cached page stops being a leaf when the root leaf is split into an
+	// internal node; find the new rightmost leaf from the root.
+	if nodeType(page) != NodeTypeLeaf {
+		t.rightmostLeaf, err = t.rightmostLeafPage(t.rootPageNum)
+		if err != nil {
+			return nil, false, err
+		}
+		if page, err = t.pager.getPage(t.rightmostLeaf); err != nil {
+			return nil, false, err
+		}
+	}
+
+	// A split of the cached leaf moves its upper half to a new right sibling
+	for next := leafNodeNextLeaf(page).get(); next != 0; next = leafNodeNextLeaf(page).get() {
+		t.rightmostLeaf = next
+		if page, err = t.pager.getPage(next); err != nil {
+			return nil, false, err
+		}
+	}
+
+	numCells := leafNodeNumCells(page).get()
+	if numCells > 0 && key <= leafNodeKey(page, numCells-1) {
+		return nil, false, nil
+	}
+
+	return &Cursor{
+		table:   t,
+		pageNum: t.rightmostLeaf,
+		cellNum: numCells,
+	}, true, nil
+}
+
 // createNewRoot creates a new root node when the current root is split
 // Old root copied to new page becomes left child.
 // New root node becomes the root of the tree.
 // Add of the right child is passed as argument.
 func (t *Table) createNewRoot(rightChildPageNum uint32) error {
-	oldRootPage, err := t.pager.getPage(t.rootPageNum)
+	oldRootPage, err := t.pager.getPageForWrite(t.rootPageNum)
 	if err != nil {
 		return err
 	}
 
-	rightChild, err := t.pager.getPage(rightChildPageNum)
+	rightChild, err := t.pager.getPageForWrite(rightChildPageNum)
 	if err != nil {
 		return err
 	}
-	leftChildPageNum := t.pager.getUnusedPageNum()
-	leftChild, err := t.pager.getPage(leftChildPageNum)
+	leftChildPageNum, err := t.pager.getUnusedPageNumNear(t.rootPageNum)
+	if err != nil {
+		return err
+	}
+	leftChild, err := t.pager.getPageForWrite(leftChildPageNum)
 	if err != nil {
 		return err
 	}
@@ -271,73 +1139,87 @@ func (t *Table) createNewRoot(rightChildPageNum uint32) error {
 	// root node is a new internal node with one key and two children
 	initializeInternalNode(oldRootPage)
 	setNodeRoot(oldRootPage, true)
-	*internalNodeNumKeys(oldRootPage) = 1
-	*internalNodeChild(oldRootPage, 0) = leftChildPageNum
-	// Use getNodeMaxKey to get the max key from left child - works for both leaf and internal nodes
-	*internalNodeKey(oldRootPage, 0) = getNodeMaxKey(leftChild)
-	*internalNodeRightChild(oldRootPage) = rightChildPageNum
-	*nodeParent(leftChild) = t.rootPageNum
-	*nodeParent(rightChild) = t.rootPageNum
+	internalNodeNumKeys(oldRootPage).set(1)
+	internalNodeChild(oldRootPage, 0).set(leftChildPageNum)
+	leftMaxKey, err := t.getNodeMaxKey(leftChild)
+	if err != nil {
+		return err
+	}
+	internalNodeKey(oldRootPage, 0).set(leftMaxKey)
+	internalNodeRightChild(oldRootPage).set(rightChildPageNum)
+	nodeParent(leftChild).set(t.rootPageNum)
+	nodeParent(rightChild).set(t.rootPageNum)
 
 	// If the left child is an internal node, we need to update the parent pointers
 	// of all its children to point to the new left child page
-	if *nodeType(leftChild) == NodeTypeInternal {
-		numKeys := *internalNodeNumKeys(leftChild)
+	if nodeType(leftChild) == NodeTypeInternal {
+		// The old root had no siblings; the two halves become each other's
+		internalNodeNextSibling(leftChild).set(rightChildPageNum)
+		internalNodePrevSibling(rightChild).set(leftChildPageNum)
+
+		numKeys := internalNodeNumKeys(leftChild).get()
 		for i := uint32(0); i <= numKeys; i++ {
-			grandchildPageNum := *internalNodeChild(leftChild, i)
-			grandchild, err := t.pager.getPage(grandchildPageNum)
+			grandchildPageNum := internalNodeChild(leftChild, i).get()
+			grandchild, err := t.pager.getPageForWrite(grandchildPageNum)
 			if err != nil {
 				return err
 			}
-			*nodeParent(grandchild) = leftChildPageNum
+			nodeParent(grandchild).set(leftChildPageNum)
 		}
 	}
 	return nil
 }
 
 func (t *Table) internalNodeInsert(parentPageNum uint32, childPageNum uint32) error {
-	parentPage, err := t.pager.getPage(parentPageNum)
+	parentPage, err := t.pager.getPageForWrite(parentPageNum)
 	if err != nil {
 		return err
 	}
-	numKeys := *internalNodeNumKeys(parentPage)
-	if numKeys >= InternalNodeMaxKeys {
+	numKeys := internalNodeNumKeys(parentPage).get()
+	if numKeys >= uint32(InternalNodeMaxKeys) {
 		// Need to split the internal node
 		return t.internalNodeSplitAndInsert(parentPageNum, childPageNum)
 	}
 
-	childPage, err := t.pager.getPage(childPageNum)
+	childPage, err := t.pager.getPageForWrite(childPageNum)
+	if err != nil {
+		return err
+	}
+	childMaxKey, err := t.getNodeMaxKey(childPage)
 	if err != nil {
 		return err
 	}
-	childMaxKey := getNodeMaxKey(childPage)
 
-	rightChildPageNum := *internalNodeRightChild(parentPage)
-	rightChildPage, err := t.pager.getPage(rightChildPageNum)
+	rightChildPageNum := internalNodeRightChild(parentPage).get()
+	rightChildPage, err := t.pager.getPageForWrite(rightChildPageNum)
+	if err != nil {
+		return err
+	}
+	rightMaxKey, err := t.getNodeMaxKey(rightChildPage)
 	if err != nil {
 		return err
 	}
 
-	if childMaxKey > getNodeMaxKey(rightChildPage) {
+	if childMaxKey > rightMaxKey {
 		// New child becomes the rightmost child
 		// Move current right child to become a regular cell
-		*internalNodeChildPtr(parentPage, numKeys) = rightChildPageNum
-		*internalNodeKey(parentPage, numKeys) = getNodeMaxKey(rightChildPage)
-		*internalNodeRightChild(parentPage) = childPageNum
+		internalNodeChildPtr(parentPage, numKeys).set(rightChildPageNum)
+		internalNodeKey(parentPage, numKeys).set(rightMaxKey)
+		internalNodeRightChild(parentPage).set(childPageNum)
 	} else {
 		// Find where to insert the new child
 		index := internalNodeFindChild(parentPage, childMaxKey)
 		// Shift cells to make room for new child
 		for i := numKeys; i > index; i-- {
-			*internalNodeChildPtr(parentPage, i) = *internalNodeChild(parentPage, i-1)
-			*internalNodeKey(parentPage, i) = *internalNodeKey(parentPage, i-1)
+			internalNodeChildPtr(parentPage, i).set(internalNodeChild(parentPage, i-1).get())
+			internalNodeKey(parentPage, i).set(internalNodeKey(parentPage, i-1).get())
 		}
-		*internalNodeChildPtr(parentPage, index) = childPageNum
-		*internalNodeKey(parentPage, index) = childMaxKey
+		internalNodeChildPtr(parentPage, index).set(childPageNum)
+		internalNodeKey(parentPage, index).set(childMaxKey)
 	}
 
 	// Increment key count after all modifications
-	*internalNodeNumKeys(parentPage) = numKeys + 1
+	internalNodeNumKeys(parentPage).set(numKeys + 1)
 
 	return nil
 }
@@ -352,33 +1234,40 @@ func (t *Table) internalNodeInsert(parentPageNum uint32, childPageNum uint32) er
 // 4. Update parent pointers for all children
 // 5. If root was split, update the new root; otherwise insert into parent
 func (t *Table) internalNodeSplitAndInsert(oldPageNum uint32, childPageNum uint32) error {
-	oldPage, err := t.pager.getPage(oldPageNum)
+	oldPage, err := t.pager.getPageForWrite(oldPageNum)
 	if err != nil {
 		return err
 	}
 
-	childPage, err := t.pager.getPage(childPageNum)
+	childPage, err := t.pager.getPageForWrite(childPageNum)
+	if err != nil {
+		return err
+	}
+	childMaxKey, err := t.getNodeMaxKey(childPage)
 	if err != nil {
 		return err
 	}
-	childMaxKey := getNodeMaxKey(childPage)
 
 	// Check if we're splitting the root
 	splittingRoot := isNodeRoot(oldPage)
 
 	// Get information we need before potentially modifying pages
-	oldNumKeys := *internalNodeNumKeys(oldPage)
-	oldRightChild := *internalNodeRightChild(oldPage)
-	oldParentPageNum := *nodeParent(oldPage)
+	oldNumKeys := internalNodeNumKeys(oldPage).get()
+	oldRightChild := internalNodeRightChild(oldPage).get()
+	oldParentPageNum := nodeParent(oldPage).get()
 
-	curRightChildPage, err := t.pager.getPage(oldRightChild)
+	curRightChildPage, err := t.pager.getPageForWrite(oldRightChild)
+	if err != nil {
+		return err
+	}
+	curRightMaxKey, err := t.getNodeMaxKey(curRightChildPage)
 	if err != nil {
 		return err
 	}
 
 	// Determine where the new child should be inserted
 	var newChildIndex uint32
-	if childMaxKey > getNodeMaxKey(curRightChildPage) {
+	if childMaxKey > curRightMaxKey {
 		// New child would become the rightmost
 		newChildIndex = oldNumKeys + 1
 	} else {
@@ -390,9 +1279,10 @@ func (t *Table) internalNodeSplitAndInsert(oldPageNum uint32, childPageNum uint3
 	// Plus 1 new child = oldNumKeys+2 children total, oldNumKeys+1 keys
 	type keyChild struct {
 		child uint32
-		key   uint32
+		key   uint64
 	}
-	allCells := make([]keyChild, InternalNodeMaxKeys+1)
+	// Fixed-size scratch space keeps this off the heap on every split
+	var allCells [InternalNodeMaxKeys + 1]keyChild
 	var allRightChild uint32
 
 	// Collect all existing cells plus the new one in sorted order
@@ -406,15 +1296,15 @@ func (t *Table) internalNodeSplitAndInsert(oldPageNum uint32, childPageNum uint3
 
 		if i < oldNumKeys {
 			allCells[cellIdx] = keyChild{
-				child: *internalNodeChild(oldPage, i),
-				key:   *internalNodeKey(oldPage, i),
+				child: internalNodeChild(oldPage, i).get(),
+				key:   internalNodeKey(oldPage, i).get(),
 			}
 			cellIdx++
 		} else if i == oldNumKeys {
 			// Handle the right child
 			if newChildIndex == oldNumKeys+1 {
 				// New child becomes rightmost
-				allCells[cellIdx] = keyChild{child: oldRightChild, key: getNodeMaxKey(curRightChildPage)}
+				allCells[cellIdx] = keyChild{child: oldRightChild, key: curRightMaxKey}
 				cellIdx++
 				allRightChild = childPageNum
 			} else {
@@ -433,8 +1323,11 @@ func (t *Table) internalNodeSplitAndInsert(oldPageNum uint32, childPageNum uint3
 	// - Right node: cells InternalNodeLeftSplitCount+1..InternalNodeMaxKeys, right child = allRightChild
 
 	// Create new right sibling node
-	newPageNum := t.pager.getUnusedPageNum()
-	newPage, err := t.pager.getPage(newPageNum)
+	newPageNum, err := t.pager.getUnusedPageNumNear(oldPageNum)
+	if err != nil {
+		return err
+	}
+	newPage, err := t.pager.getPageForWrite(newPageNum)
 	if err != nil {
 		return err
 	}
@@ -448,8 +1341,11 @@ func (t *Table) internalNodeSplitAndInsert(oldPageNum uint32, childPageNum uint3
 		// The left child will be a copy of the old root, the right child is new
 
 		// Allocate a page for left child (copy of old root)
-		leftChildPageNum := t.pager.getUnusedPageNum()
-		leftChild, err := t.pager.getPage(leftChildPageNum)
+		leftChildPageNum, err := t.pager.getUnusedPageNumNear(oldPageNum)
+		if err != nil {
+			return err
+		}
+		leftChild, err := t.pager.getPageForWrite(leftChildPageNum)
 		if err != nil {
 			return err
 		}
@@ -461,51 +1357,56 @@ func (t *Table) internalNodeSplitAndInsert(oldPageNum uint32, childPageNum uint3
 		// Set up old root as new internal root
 		initializeInternalNode(oldPage)
 		setNodeRoot(oldPage, true)
-		*internalNodeNumKeys(oldPage) = 1
-		*internalNodeChild(oldPage, 0) = leftChildPageNum
-		*internalNodeKey(oldPage, 0) = parentKey
-		*internalNodeRightChild(oldPage) = newPageNum
+		internalNodeNumKeys(oldPage).set(1)
+		internalNodeChild(oldPage, 0).set(leftChildPageNum)
+		internalNodeKey(oldPage, 0).set(parentKey)
+		internalNodeRightChild(oldPage).set(newPageNum)
 
 		// Update parent pointers
-		*nodeParent(leftChild) = t.rootPageNum
-		*nodeParent(newPage) = t.rootPageNum
+		nodeParent(leftChild).set(t.rootPageNum)
+		nodeParent(newPage).set(t.rootPageNum)
+
+		// The two halves are the only nodes on their level
+		internalNodeNextSibling(leftChild).set(newPageNum)
+		internalNodePrevSibling(leftChild).set(0)
+		internalNodePrevSibling(newPage).set(leftChildPageNum)
 
 		// Now leftChild has the old content, we need to update it
 		// Update left child with correct cells
-		*internalNodeNumKeys(leftChild) = uint32(InternalNodeLeftSplitCount)
+		internalNodeNumKeys(leftChild).set(uint32(InternalNodeLeftSplitCount))
 		for i := 0; i < InternalNodeLeftSplitCount; i++ {
-			*internalNodeChildPtr(leftChild, uint32(i)) = allCells[i].child
-			*internalNodeKey(leftChild, uint32(i)) = allCells[i].key
+			internalNodeChildPtr(leftChild, uint32(i)).set(allCells[i].child)
+			internalNodeKey(leftChild, uint32(i)).set(allCells[i].key)
 		}
-		*internalNodeRightChild(leftChild) = allCells[InternalNodeLeftSplitCount].child
+		internalNodeRightChild(leftChild).set(allCells[InternalNodeLeftSplitCount].child)
 
 		// Update new (right) node
-		*internalNodeNumKeys(newPage) = uint32(InternalNodeRightSplitCount)
+		internalNodeNumKeys(newPage).set(uint32(InternalNodeRightSplitCount))
 		for i := 0; i < InternalNodeRightSplitCount; i++ {
 			srcIdx := InternalNodeLeftSplitCount + 1 + i
-			*internalNodeChildPtr(newPage, uint32(i)) = allCells[srcIdx].child
-			*internalNodeKey(newPage, uint32(i)) = allCells[srcIdx].key
+			internalNodeChildPtr(newPage, uint32(i)).set(allCells[srcIdx].child)
+			internalNodeKey(newPage, uint32(i)).set(allCells[srcIdx].key)
 		}
-		*internalNodeRightChild(newPage) = allRightChild
+		internalNodeRightChild(newPage).set(allRightChild)
 
 		// Update parent pointers for all grandchildren
 		// Children that go to leftChild
 		for i := uint32(0); i <= uint32(InternalNodeLeftSplitCount); i++ {
-			grandchildPageNum := *internalNodeChild(leftChild, i)
-			grandchild, err := t.pager.getPage(grandchildPageNum)
+			grandchildPageNum := internalNodeChild(leftChild, i).get()
+			grandchild, err := t.pager.getPageForWrite(grandchildPageNum)
 			if err != nil {
 				return err
 			}
-			*nodeParent(grandchild) = leftChildPageNum
+			nodeParent(grandchild).set(leftChildPageNum)
 		}
 		// Children that go to newPage
 		for i := uint32(0); i <= uint32(InternalNodeRightSplitCount); i++ {
-			grandchildPageNum := *internalNodeChild(newPage, i)
-			grandchild, err := t.pager.getPage(grandchildPageNum)
+			grandchildPageNum := internalNodeChild(newPage, i).get()
+			grandchild, err := t.pager.getPageForWrite(grandchildPageNum)
 			if err != nil {
 				return err
 			}
-			*nodeParent(grandchild) = newPageNum
+			nodeParent(grandchild).set(newPageNum)
 		}
 
 		return nil
@@ -514,55 +1415,69 @@ func (t *Table) internalNodeSplitAndInsert(oldPageNum uint32, childPageNum uint3
 	// Non-root split: update old page in place, create new sibling
 
 	// Set parent for new page
-	*nodeParent(newPage) = oldParentPageNum
+	nodeParent(newPage).set(oldParentPageNum)
+
+	// Link the new node into the level right after the old one
+	oldNextSibling := internalNodeNextSibling(oldPage).get()
+	internalNodeNextSibling(newPage).set(oldNextSibling)
+	internalNodePrevSibling(newPage).set(oldPageNum)
+	internalNodeNextSibling(oldPage).set(newPageNum)
+	if oldNextSibling != 0 {
+		nextSiblingPage, err := t.pager.getPageForWrite(oldNextSibling)
+		if err != nil {
+			return err
+		}
+		internalNodePrevSibling(nextSiblingPage).set(newPageNum)
+	}
 
 	// Update old (left) node
-	*internalNodeNumKeys(oldPage) = uint32(InternalNodeLeftSplitCount)
+	internalNodeNumKeys(oldPage).set(uint32(InternalNodeLeftSplitCount))
 	for i := 0; i < InternalNodeLeftSplitCount; i++ {
-		*internalNodeChildPtr(oldPage, uint32(i)) = allCells[i].child
-		*internalNodeKey(oldPage, uint32(i)) = allCells[i].key
+		internalNodeChildPtr(oldPage, uint32(i)).set(allCells[i].child)
+		internalNodeKey(oldPage, uint32(i)).set(allCells[i].key)
 	}
-	*internalNodeRightChild(oldPage) = allCells[InternalNodeLeftSplitCount].child
+	internalNodeRightChild(oldPage).set(allCells[InternalNodeLeftSplitCount].child)
 
 	// Update new (right) node
-	*internalNodeNumKeys(newPage) = uint32(InternalNodeRightSplitCount)
+	internalNodeNumKeys(newPage).set(uint32(InternalNodeRightSplitCount))
 	for i := 0; i < InternalNodeRightSplitCount; i++ {
 		srcIdx := InternalNodeLeftSplitCount + 1 + i
-		*internalNodeChildPtr(newPage, uint32(i)) = allCells[srcIdx].child
-		*internalNodeKey(newPage, uint32(i)) = allCells[srcIdx].key
+		internalNodeChildPtr(newPage, uint32(i)).set(allCells[srcIdx].child)
+		internalNodeKey(newPage, uint32(i)).set(allCells[srcIdx].key)
 	}
-	*internalNodeRightChild(newPage) = allRightChild
+	internalNodeRightChild(newPage).set(allRightChild)
 
 	// Update parent pointers for all children that moved to the new node
 	for i := uint32(0); i <= uint32(InternalNodeRightSplitCount); i++ {
-		childPgNum := *internalNodeChild(newPage, i)
-		childPg, err := t.pager.getPage(childPgNum)
+		childPgNum := internalNodeChild(newPage, i).get()
+		childPg, err := t.pager.getPageForWrite(childPgNum)
 		if err != nil {
 			return err
 		}
-		*nodeParent(childPg) = newPageNum
+		nodeParent(childPg).set(newPageNum)
 	}
 
 	// Update parent pointers for children in old node (they may have been shuffled)
 	for i := uint32(0); i <= uint32(InternalNodeLeftSplitCount); i++ {
-		childPgNum := *internalNodeChild(oldPage, i)
-		childPg, err := t.pager.getPage(childPgNum)
+		childPgNum := internalNodeChild(oldPage, i).get()
+		childPg, err := t.pager.getPageForWrite(childPgNum)
 		if err != nil {
 			return err
 		}
-		*nodeParent(childPg) = oldPageNum
+		nodeParent(childPg).set(oldPageNum)
 	}
 
 	// Update the old key in parent and insert new child
-	parentPage, err := t.pager.getPage(oldParentPageNum)
+	parentPage, err := t.pager.getPageForWrite(oldParentPageNum)
 	if err != nil {
 		return err
 	}
 
-	// Find the child index by page number and update the key
+	// Find the child index by page number and update the key. The old node
+	// now ends with the child whose max key went up as parentKey.
 	oldChildIndex := internalNodeFindChildByPage(parentPage, oldPageNum)
-	if oldChildIndex < *internalNodeNumKeys(parentPage) {
-		*internalNodeKey(parentPage, oldChildIndex) = getNodeMaxKey(oldPage)
+	if oldChildIndex < internalNodeNumKeys(parentPage).get() {
+		internalNodeKey(parentPage, oldChildIndex).set(parentKey)
 	}
 
 	// Insert the new right sibling into the parent
@@ -572,65 +1487,393 @@ func (t *Table) internalNodeSplitAndInsert(oldPageNum uint32, childPageNum uint3
 
 // Insert adds a new row to the table
 func (t *Table) Insert(row *Row) error {
-	page, err := t.pager.getPage(t.rootPageNum)
+	t.lock()
+	defer t.unlock()
+	return t.insert(row)
+}
+
+func (t *Table) insert(row *Row) error {
+	keyToInsert := uint64(row.ID)
+
+	// Fast path: keys larger than the current max go straight to the last leaf
+	appendCursor, ok, err := t.appendCursor(keyToInsert)
 	if err != nil {
 		return err
 	}
+	if ok {
+		if err := appendCursor.InsertLeafNode(keyToInsert, row); err != nil {
+			return err
+		}
+		if t.db.cfg.writeChecks {
+			t.checkWrite(keyToInsert)
+		}
+		return t.recordInsert(row.ID)
+	}
+
+	cursor, found, err := t.seek(keyToInsert)
+	if err != nil {
+		return err
+	}
+	if found {
+		return ErrDuplicateKey
+	}
 
-	numOfCells := *leafNodeNumCells(page)
+	if err := cursor.InsertLeafNode(keyToInsert, row); err != nil {
+		return err
+	}
+	if t.db.cfg.writeChecks {
+		t.checkWrite(keyToInsert)
+	}
+	return t.recordInsert(row.ID)
+}
+
+// recordInsert notes a successful insert of id: it goes into the bloom filter,
+// becomes the last insert ID and raises the table's sequence if it is larger.
+func (t *Table) recordInsert(id int64) error {
+	if err := t.trackInsertedKey(uint64(id)); err != nil {
+		return err
+	}
+	t.lastInsertID = id
 
-	keyToInsert := uint32(row.ID)
-	cursor, err := t.findKey(keyToInsert)
+	header, err := t.pager.getPage(headerPageNum)
 	if err != nil {
 		return err
 	}
+	entry := catalogEntry(header, t.catalogIndex)
+	if uint64(id) > catalogEntrySequence(entry) {
+		if err := t.pager.markDirty(headerPageNum); err != nil {
+			return err
+		}
+		setCatalogEntrySequence(entry, uint64(id))
+	}
+	return nil
+}
+
+// InsertMany inserts rows as one batch. They are inserted in key order, so
+// consecutive keys go through the append fast path or the cached leaf of the
+// previous insert instead of each descending from the root. Keys are checked
+// first: if any is already in the table or repeated within rows, nothing is
+// inserted and ErrDuplicateKey is returned.
+func (t *Table) InsertMany(rows []Row) error {
+	return t.InsertManyContext(context.Background(), rows)
+}
 
-	// Check for duplicate keys
-	// Only compare when the cursor points to an existing cell; if it’s at numOfCells,
-	// the key wasn’t found and the cursor sits on the first free slot for insertion.
-	if cursor.cellNum < numOfCells {
-		existingKey := *leafNodeKey(page, cursor.cellNum)
-		if existingKey == keyToInsert {
+// InsertManyContext is InsertMany that stops with ctx's error once ctx is
+// done. It checks ctx before each row, and the rows inserted by then stay in
+// the table unless a transaction around it is rolled back.
+func (t *Table) InsertManyContext(ctx context.Context, rows []Row) error {
+	t.lock()
+	defer t.unlock()
+	return t.insertMany(ctx, rows)
+}
+
+func (t *Table) insertMany(ctx context.Context, rows []Row) error {
+	sorted := slices.Clone(rows)
+	slices.SortFunc(sorted, func(a, b Row) int { return cmp.Compare(a.ID, b.ID) })
+
+	keys := make([]uint64, len(sorted))
+	for i := range sorted {
+		if i > 0 && sorted[i].ID == sorted[i-1].ID {
 			return ErrDuplicateKey
 		}
+		keys[i] = uint64(sorted[i].ID)
+	}
+	existing, err := t.getMany(keys)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return ErrDuplicateKey
 	}
 
-	return cursor.InsertLeafNode(uint32(row.ID), row)
+	for i := range sorted {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := t.insert(&sorted[i]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// SelectAll returns all rows in the table
-func (t *Table) SelectAll() []Row {
-	cursor := TableStart(t)
-	rows := make([]Row, 0, t.pager.numPages*uint32(LeafNodeMaxCells))
-	var row Row
-	for !cursor.IsEndOfTable() {
-		deserializeRow(cursor.Value(), &row)
-		rows = append(rows, row)
-		cursor.Advance()
+// InsertOrIgnore inserts rows as one batch like InsertMany, but skips the rows
+// whose key is already in the table or taken by an earlier row in rows
+// instead of failing. It returns the number of rows skipped.
+func (t *Table) InsertOrIgnore(rows []Row) (skipped int, err error) {
+	t.lock()
+	defer t.unlock()
+	return t.insertOrIgnore(rows)
+}
+
+func (t *Table) insertOrIgnore(rows []Row) (skipped int, err error) {
+	// A stable sort keeps the first of several rows with the same key first
+	sorted := slices.Clone(rows)
+	slices.SortStableFunc(sorted, func(a, b Row) int { return cmp.Compare(a.ID, b.ID) })
+
+	keys := make([]uint64, len(sorted))
+	for i := range sorted {
+		keys[i] = uint64(sorted[i].ID)
+	}
+	existing, err := t.getMany(keys)
+	if err != nil {
+		return 0, err
+	}
+	taken := make(map[int64]bool, len(existing))
+	for i := range existing {
+		taken[existing[i].ID] = true
 	}
 
-	return rows
+	for i := range sorted {
+		if taken[sorted[i].ID] {
+			skipped++
+			continue
+		}
+		if err := t.insert(&sorted[i]); err != nil {
+			return skipped, err
+		}
+		taken[sorted[i].ID] = true
+	}
+	return skipped, nil
 }
 
-func (t *Table) Close() error {
-	p := t.pager
+// Update overwrites the stored row whose ID matches row.ID, in place when the
+// new row has the same size as the old one. found is false if there is no
+// such row, in which case nothing is written.
+func (t *Table) Update(row *Row) (found bool, err error) {
+	t.lock()
+	defer t.unlock()
+	return t.update(row)
+}
 
-	// Write all pages to disk
-	for pageNum := range t.pager.numPages {
-		if p.pages[pageNum] == nil {
-			continue
+func (t *Table) update(row *Row) (found bool, err error) {
+	key := uint64(row.ID)
+	cursor, found, err := t.seek(key)
+	if err != nil || !found {
+		return false, err
+	}
+	page, err := t.pager.getPageForWrite(cursor.pageNum)
+	if err != nil {
+		return false, err
+	}
+	if value := leafNodeValue(page, cursor.cellNum); len(value) == serializedRowSize(row) {
+		serializeRow(row, value)
+		return true, nil
+	}
+
+	// The row grew or shrank, store it in a new cell at the same position
+	oldBuf := getRowBuffer()
+	defer putRowBuffer(oldBuf)
+	old := oldBuf[:copy(oldBuf[:], leafNodeValue(page, cursor.cellNum))]
+	leafNodeRemoveCell(page, cursor.cellNum)
+	if cell := leafNodeInsertCell(page, cursor.cellNum, key, serializedRowSize(row)); cell != nil {
+		serializeRow(row, cell)
+	} else {
+		// The leaf has to split: put the old row back unless the split can
+		// get a page for every level it may reach, so it cannot fail halfway
+		if err := t.checkSplitPages(); err != nil {
+			copy(leafNodeAddCell(page, cursor.cellNum, key, len(old)), old)
+			return true, err
+		}
+		if err := cursor.SplitAndInsert(key, row); err != nil {
+			return true, err
 		}
+	}
+	if t.db.cfg.writeChecks {
+		t.checkWrite(key)
+	}
+	return true, nil
+}
 
-		err := t.pager.flush(uint32(pageNum))
+// checkSplitPages returns ErrTableFull unless a leaf split can get the pages
+// it needs: one per level of the tree, and one more for a new root.
+func (t *Table) checkSplitPages() error {
+	free, err := t.pager.freePageCount()
+	if err != nil {
+		return err
+	}
+	depth := 1
+	for pageNum := t.rootPageNum; ; depth++ {
+		node, err := t.pager.getPage(pageNum)
 		if err != nil {
 			return err
 		}
+		if nodeType(node) == NodeTypeLeaf {
+			break
+		}
+		pageNum = internalNodeChild(node, 0).get()
+	}
+	if free < depth+1 {
+		return ErrTableFull
+	}
+	return nil
+}
+
+// InsertAutoID inserts row under the ID following the largest one ever
+// stored in the table (1 for a table that never had rows), overwriting row.ID,
+// and returns that ID. The largest ID is kept in the table's catalog entry, so
+// IDs of deleted rows are not handed out again, even after a restart.
+func (t *Table) InsertAutoID(row *Row) (int64, error) {
+	t.lock()
+	defer t.unlock()
+	return t.insertAutoID(row)
+}
+
+func (t *Table) insertAutoID(row *Row) (int64, error) {
+	header, err := t.pager.getPage(headerPageNum)
+	if err != nil {
+		return 0, err
 	}
+	maxKey := catalogEntrySequence(catalogEntry(header, t.catalogIndex))
 
-	err := p.file.Close()
+	// Rows stored before the sequence was kept did not raise it
+	leafPageNum, err := t.rightmostLeafPage(t.rootPageNum)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	leaf, err := t.pager.getPage(leafPageNum)
+	if err != nil {
+		return 0, err
+	}
+	if numCells := leafNodeNumCells(leaf).get(); numCells > 0 {
+		maxKey = max(maxKey, leafNodeKey(leaf, numCells-1))
 	}
 
-	return nil
+	if maxKey >= math.MaxInt64 {
+		return 0, ErrIDsExhausted
+	}
+	id := int64(maxKey) + 1
+
+	row.ID = id
+	if err := t.insert(row); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// LastInsertID returns the ID of the most recent successful insert through
+// this Table, whether assigned by InsertAutoID or given explicitly. It is 0
+// before the first insert and is not persisted.
+func (t *Table) LastInsertID() int64 {
+	t.rlock()
+	defer t.runlock()
+	return t.lastInsertID
+}
+
+// Get looks up the row stored under key. found is false if there is no such row.
+func (t *Table) Get(key uint64) (row Row, found bool, err error) {
+	t.rlock()
+	defer t.runlock()
+	return t.get(key)
+}
+
+func (t *Table) get(key uint64) (row Row, found bool, err error) {
+	if t.bloom != nil && !t.bloom.mayContain(key) {
+		return row, false, nil
+	}
+
+	cursor, found, err := t.seek(key)
+	if err != nil || !found {
+		return row, false, err
+	}
+	page, err := t.pager.getPage(cursor.pageNum)
+	if err != nil {
+		return row, false, err
+	}
+	deserializeRow(leafNodeValue(page, cursor.cellNum), &row)
+	return row, true, nil
+}
+
+// GetMany looks up several keys at once and returns the rows that exist, in
+// ascending key order. Keys are sorted and the tree is walked once: the path of
+// internal nodes from the previous lookup is kept, and each following key only
+// climbs back up to the first ancestor whose subtree can still contain it
+// instead of descending from the root again.
+func (t *Table) GetMany(keys []uint64) ([]Row, error) {
+	t.rlock()
+	defer t.runlock()
+	return t.getMany(keys)
+}
+
+func (t *Table) getMany(keys []uint64) ([]Row, error) {
+	sorted := slices.Clone(keys)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	// frame is a node on the current root-to-leaf path. upper is the separator
+	// to the right of the node in its parent; bounded is false on the right
+	// spine of the tree, where no separator limits the subtree.
+	type frame struct {
+		pageNum uint32
+		upper   uint64
+		bounded bool
+	}
+	path := []frame{{pageNum: t.rootPageNum}}
+	rows := make([]Row, 0, len(sorted))
+
+	for _, key := range sorted {
+		// Keys only grow, so a subtree can lose a key only to its right side
+		for len(path) > 1 {
+			top := path[len(path)-1]
+			if !top.bounded || routesLeftOf(key, top.upper) {
+				break
+			}
+			path = path[:len(path)-1]
+		}
+
+		// Descend from the deepest node still on the path down to a leaf
+		var node []byte
+		for {
+			top := path[len(path)-1]
+			var err error
+			node, err = t.pager.getPage(top.pageNum)
+			if err != nil {
+				return nil, err
+			}
+			if nodeType(node) == NodeTypeLeaf {
+				break
+			}
+
+			childIndex := internalNodeFindChild(node, key)
+			child := frame{
+				pageNum: internalNodeChild(node, childIndex).get(),
+				upper:   top.upper,
+				bounded: top.bounded,
+			}
+			if childIndex < internalNodeNumKeys(node).get() {
+				child.upper, child.bounded = internalNodeKey(node, childIndex).get(), true
+			}
+			path = append(path, child)
+		}
+
+		cellNum := leafNodeFindKey(node, key)
+		if cellNum < leafNodeNumCells(node).get() && leafNodeKey(node, cellNum) == key {
+			var row Row
+			deserializeRow(leafNodeValue(node, cellNum), &row)
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, nil
+}
+
+// SelectAll returns all rows in the table. Rows reads them a batch at a
+// time instead.
+func (t *Table) SelectAll() []Row {
+	t.rlock()
+	defer t.runlock()
+	return t.selectAll()
+}
+
+func (t *Table) selectAll() []Row {
+	cursor := TableStart(t)
+	var rows []Row
+	var row Row
+	for !cursor.IsEndOfTable() {
+		deserializeRow(cursor.Value(), &row)
+		rows = append(rows, row)
+		cursor.Advance()
+	}
+
+	return rows
 }
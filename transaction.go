@@ -0,0 +1,190 @@
+package main
+
+import "errors"
+
+var (
+	// ErrTransactionOpen is returned by Begin while a transaction is open,
+	// and by the operations that cannot run inside one.
+	ErrTransactionOpen = errors.New("a transaction is open")
+	// ErrNoTransaction is returned by Commit and Rollback outside a
+	// transaction.
+	ErrNoTransaction = errors.New("no transaction is open")
+)
+
+// pagerTxn is what a pager needs to undo the changes of a transaction: its
+// page count and dirty pages at Begin, and the contents the pages changed
+// since then had at Begin.
+type pagerTxn struct {
+	numPages uint32
+	dirty    [tableMaxPages]bool
+	saved    [tableMaxPages][]byte
+	arena    pageArena // backs the buffers in saved
+}
+
+// save keeps the contents of page pageNum before its first change in the
+// transaction. Pages added by the transaction have nothing to go back to.
+func (txn *pagerTxn) save(pageNum uint32, page []byte) {
+	if pageNum >= txn.numPages || txn.saved[pageNum] != nil {
+		return
+	}
+	saved := txn.arena.alloc()
+	copy(saved, page)
+	txn.saved[pageNum] = saved
+}
+
+// Begin opens a transaction. Until Commit, changes to the database stay in
+// memory: background checkpoints skip them and Rollback puts every page
+// back as it was at Begin. Commit writes them to the file through the
+// rollback journal, like Checkpoint. Closing the database with a transaction
+// open rolls it back.
+func (db *Database) Begin() error {
+	db.latch.Lock()
+	defer db.latch.Unlock()
+	return db.begin()
+}
+
+func (db *Database) begin() error {
+	p := db.pager
+	if p.txn != nil {
+		return ErrTransactionOpen
+	}
+	p.txn = &pagerTxn{numPages: p.numPages, dirty: p.dirty}
+	return nil
+}
+
+// InTransaction reports whether a transaction is open.
+func (db *Database) InTransaction() bool {
+	db.latch.RLock()
+	defer db.latch.RUnlock()
+	return db.pager.txn != nil
+}
+
+// Commit ends the open transaction and writes every change made so far to
+// the file.
+func (db *Database) Commit() error {
+	db.latch.Lock()
+	batch, err := db.commit()
+	db.latch.Unlock()
+	if err != nil {
+		return err
+	}
+	return batch.wait()
+}
+
+// commit ends the open transaction and writes its changes, or with
+// WithGroupCommit returns the batch whose flush will. The caller waits for
+// the batch after releasing the latch, which the flush needs.
+func (db *Database) commit() (*commitBatch, error) {
+	p := db.pager
+	if p.txn == nil {
+		return nil, ErrNoTransaction
+	}
+	p.endTransaction()
+	if db.groupCommit != nil {
+		return db.groupCommit.join(db), nil
+	}
+	return nil, p.commit()
+}
+
+// Rollback ends the open transaction and discards its changes. Tables and
+// indexes created by it are gone afterwards, and their handles must not be
+// used.
+func (db *Database) Rollback() error {
+	db.latch.Lock()
+	defer db.latch.Unlock()
+	return db.rollback()
+}
+
+func (db *Database) rollback() error {
+	p := db.pager
+	if p.txn == nil {
+		return ErrNoTransaction
+	}
+	p.rollbackTransaction()
+
+	header, err := p.getPage(headerPageNum)
+	if err != nil {
+		return err
+	}
+	for name, t := range db.tables {
+		if _, ok := catalogFind(header, name); !ok {
+			delete(db.tables, name)
+			continue
+		}
+		// Cells may have moved back to other leaves
+		t.invalidateLeafHint()
+		t.rightmostLeaf = t.rootPageNum
+	}
+	for name := range db.indexes {
+		if _, ok := catalogFind(header, name); !ok {
+			delete(db.indexes, name)
+		}
+	}
+	return nil
+}
+
+// rollbackTransaction puts back the pages changed by the open transaction
+// and forgets the ones it added.
+func (p *Pager) rollbackTransaction() {
+	txn := p.txn
+	for pageNum, saved := range txn.saved[:txn.numPages] {
+		if saved == nil {
+			continue
+		}
+		// Snapshots taken during the transaction keep what they saw
+		for _, s := range p.snapshots {
+			s.preserve(uint32(pageNum), p.pages[pageNum])
+		}
+		copy(p.pages[pageNum], saved)
+	}
+	// The buffers of added pages stay in the arena until it is released,
+	// as snapshots may still share them
+	clear(p.pages[txn.numPages:p.numPages])
+	p.numPages = txn.numPages
+	p.dirty = txn.dirty
+	p.endTransaction()
+}
+
+// commitBeforeTransaction is commit with a transaction open: it writes the
+// pages as they were when the transaction began, the state the commits
+// before it left, and keeps the changes of the transaction in memory.
+func (p *Pager) commitBeforeTransaction() error {
+	txn := p.txn
+	for pageNum, saved := range txn.saved[:txn.numPages] {
+		if saved == nil {
+			continue
+		}
+		// Snapshots keep the pages they see now, not the ones swapped in
+		for _, s := range p.snapshots {
+			s.preserve(uint32(pageNum), p.pages[pageNum])
+		}
+		p.pages[pageNum], txn.saved[pageNum] = saved, p.pages[pageNum]
+	}
+	numPages, dirty := p.numPages, p.dirty
+	p.numPages, p.dirty, p.txn = txn.numPages, txn.dirty, nil
+
+	err := p.commit()
+
+	p.txn = txn
+	for pageNum, saved := range txn.saved[:txn.numPages] {
+		if saved != nil {
+			p.pages[pageNum], txn.saved[pageNum] = saved, p.pages[pageNum]
+		}
+	}
+	p.numPages = numPages
+	if err != nil {
+		p.dirty = dirty
+		return err
+	}
+	// What the transaction changed or added still differs from the file
+	txn.dirty = [tableMaxPages]bool{}
+	for pageNum := range numPages {
+		p.dirty[pageNum] = dirty[pageNum] && (pageNum >= txn.numPages || txn.saved[pageNum] != nil)
+	}
+	return nil
+}
+
+func (p *Pager) endTransaction() {
+	p.txn.arena.release()
+	p.txn = nil
+}
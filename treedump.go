@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// treeNodeJSON is the JSON form of a B-tree node used by ".btree --json".
+// Fields that only apply to one node type are omitted for the other.
+type treeNodeJSON struct {
+	Page        uint32          `json:"page"`
+	Type        string          `json:"type"`
+	IsRoot      bool            `json:"is_root"`
+	Parent      *uint32         `json:"parent,omitempty"`
+	Keys        []uint64        `json:"keys"`
+	NextLeaf    *uint32         `json:"next_leaf,omitempty"`
+	NextSibling *uint32         `json:"next_sibling,omitempty"`
+	PrevSibling *uint32         `json:"prev_sibling,omitempty"`
+	Children    []*treeNodeJSON `json:"children,omitempty"`
+}
+
+// buildTreeJSON converts the subtree rooted at pageNum into its JSON form.
+// Page links use 0 for "none", matching the on-disk representation.
+func buildTreeJSON(pager *Pager, pageNum uint32) (*treeNodeJSON, error) {
+	page, err := pager.getPage(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	node, err := describeNode(page, pageNum)
+	if err != nil {
+		return nil, err
+	}
+	if node.Type == "internal" {
+		for i := uint32(0); i <= internalNodeNumKeys(page).get(); i++ {
+			child, err := buildTreeJSON(pager, internalNodeChild(page, i).get())
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+	}
+	return node, nil
+}
+
+// describeNode is the JSON form of the node in page, without its children.
+func describeNode(page []byte, pageNum uint32) (*treeNodeJSON, error) {
+	node := &treeNodeJSON{
+		Page:   pageNum,
+		IsRoot: isNodeRoot(page),
+		Keys:   []uint64{},
+	}
+	if !node.IsRoot {
+		parent := nodeParent(page).get()
+		node.Parent = &parent
+	}
+
+	switch nodeType(page) {
+	case NodeTypeLeaf:
+		node.Type = "leaf"
+		for i := uint32(0); i < leafNodeNumCells(page).get(); i++ {
+			node.Keys = append(node.Keys, leafNodeKey(page, i))
+		}
+		nextLeaf := leafNodeNextLeaf(page).get()
+		node.NextLeaf = &nextLeaf
+	case NodeTypeInternal:
+		node.Type = "internal"
+		for i := uint32(0); i < internalNodeNumKeys(page).get(); i++ {
+			node.Keys = append(node.Keys, internalNodeKey(page, i).get())
+		}
+		nextSibling, prevSibling := internalNodeNextSibling(page).get(), internalNodePrevSibling(page).get()
+		node.NextSibling, node.PrevSibling = &nextSibling, &prevSibling
+	default:
+		return nil, fmt.Errorf("unknown node type %d on page %d", nodeType(page), pageNum)
+	}
+
+	return node, nil
+}
+
+// printTreeJSON writes the whole tree as a single line of JSON.
+func printTreeJSON(pager *Pager, rootPageNum uint32) error {
+	root, err := buildTreeJSON(pager, rootPageNum)
+	if err != nil {
+		return err
+	}
+	out, err := json.Marshal(root)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// writeTreeDOT writes the whole tree to w as a Graphviz digraph, one node per
+// page labelled with its page number, type and keys. Solid edges go from
+// parents to children, dashed ones follow the next-leaf and next-sibling
+// links, which are left out of the layout so each level stays in key order.
+func writeTreeDOT(w io.Writer, pager *Pager, rootPageNum uint32) error {
+	root, err := buildTreeJSON(pager, rootPageNum)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("digraph btree {\n")
+	b.WriteString("  node [shape=box];\n")
+	writeNodeDOT(&b, root)
+	b.WriteString("}\n")
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+func writeNodeDOT(b *strings.Builder, node *treeNodeJSON) {
+	keys := make([]string, len(node.Keys))
+	for i, key := range node.Keys {
+		keys[i] = fmt.Sprint(key)
+	}
+	fmt.Fprintf(b, "  page%d [label=\"page %d\\n%s\\n%s\"];\n", node.Page, node.Page, node.Type, strings.Join(keys, " "))
+	for _, child := range node.Children {
+		fmt.Fprintf(b, "  page%d -> page%d;\n", node.Page, child.Page)
+	}
+	for _, next := range []*uint32{node.NextLeaf, node.NextSibling} {
+		if next != nil && *next != 0 {
+			fmt.Fprintf(b, "  page%d -> page%d [style=dashed, constraint=false];\n", node.Page, *next)
+		}
+	}
+	for _, child := range node.Children {
+		writeNodeDOT(b, child)
+	}
+}
+
+// printTreeDOT writes the tree as DOT to the file at path, or to standard
+// output if path is empty.
+func printTreeDOT(pager *Pager, rootPageNum uint32, path string) error {
+	if path == "" {
+		return writeTreeDOT(os.Stdout, pager, rootPageNum)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeTreeDOT(f, pager, rootPageNum); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrTxDone is returned by the methods of a ReadTx or WriteTx that has
+// already ended.
+var ErrTxDone = errors.New("transaction has already ended")
+
+// ReadTx holds the database's latch for reading from BeginRead to Close, so
+// a program can make several reads that see the same database: writers wait
+// until it ends, while other readers go on. Its tables read without taking
+// the latch again, and must not be used after Close.
+type ReadTx struct {
+	db   *Database
+	done bool
+}
+
+// BeginRead waits until no writer holds the latch and returns a ReadTx
+// holding it for reading. Until the ReadTx is closed, the goroutine only
+// reaches the database through it: taking the latch again could wait for a
+// writer that waits for the ReadTx.
+func (db *Database) BeginRead() *ReadTx {
+	db.latch.RLock()
+	return &ReadTx{db: db}
+}
+
+// Table returns the table called name for reading within the transaction.
+func (tx *ReadTx) Table(name string) (ReadTable, error) {
+	if tx.done {
+		return ReadTable{}, ErrTxDone
+	}
+	t, err := tx.db.table(name)
+	if err != nil {
+		return ReadTable{}, err
+	}
+	return ReadTable{t: t}, nil
+}
+
+// Close ends the transaction and releases the latch.
+func (tx *ReadTx) Close() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.db.latch.RUnlock()
+	return nil
+}
+
+// WriteTx holds the database's latch for writing from BeginWrite to Commit
+// or Rollback, so no other goroutine reads or writes in between. It runs a
+// transaction like Begin does, so Rollback discards what its tables changed.
+// Its tables must not be used after it ends.
+type WriteTx struct {
+	db   *Database
+	done bool
+}
+
+// BeginWrite waits until no one else holds the latch and returns a WriteTx
+// holding it for writing. It fails with ErrTransactionOpen while a
+// transaction opened by Begin is open. Until the WriteTx ends, the goroutine
+// must only reach the database through it, or it waits for itself.
+func (db *Database) BeginWrite() (*WriteTx, error) {
+	db.latch.Lock()
+	if err := db.begin(); err != nil {
+		db.latch.Unlock()
+		return nil, err
+	}
+	return &WriteTx{db: db}, nil
+}
+
+// Table returns the table called name for reading and writing within the
+// transaction.
+func (tx *WriteTx) Table(name string) (WriteTable, error) {
+	if tx.done {
+		return WriteTable{}, ErrTxDone
+	}
+	t, err := tx.db.table(name)
+	if err != nil {
+		return WriteTable{}, err
+	}
+	return WriteTable{ReadTable{t: t}}, nil
+}
+
+// Commit ends the transaction, writes its changes to the file like Commit
+// and releases the latch, even if writing fails.
+func (tx *WriteTx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	batch, err := tx.db.commit()
+	tx.db.latch.Unlock()
+	if err != nil {
+		return err
+	}
+	return batch.wait()
+}
+
+// Rollback ends the transaction, discards its changes like Rollback and
+// releases the latch.
+func (tx *WriteTx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	defer tx.db.latch.Unlock()
+	return tx.db.rollback()
+}
+
+// ReadTable is a table read within a ReadTx or WriteTx. Its methods are
+// those of Table, without the latch the transaction already holds.
+type ReadTable struct {
+	t *Table
+}
+
+func (r ReadTable) Get(key uint64) (row Row, found bool, err error) { return r.t.get(key) }
+func (r ReadTable) GetMany(keys []uint64) ([]Row, error)            { return r.t.getMany(keys) }
+func (r ReadTable) SelectAll() []Row                                { return r.t.selectAll() }
+func (r ReadTable) Rows() *RowIterator                              { return &RowIterator{t: r.t, inTx: true} }
+func (r ReadTable) Count() (int, error)                             { return r.t.count() }
+
+func (r ReadTable) ScanRange(low, high uint64, fn func(*RowBatch) error) error {
+	return r.t.scanRange(context.Background(), low, high, fn)
+}
+
+func (r ReadTable) ScanRangeContext(ctx context.Context, low, high uint64, fn func(*RowBatch) error) error {
+	return r.t.scanRange(ctx, low, high, fn)
+}
+
+// WriteTable is a table changed within a WriteTx. Its methods are those of
+// Table, without the latch the transaction already holds.
+type WriteTable struct {
+	ReadTable
+}
+
+func (w WriteTable) Insert(row *Row) error                     { return w.t.insert(row) }
+func (w WriteTable) InsertMany(rows []Row) error               { return w.t.insertMany(context.Background(), rows) }
+func (w WriteTable) InsertAutoID(row *Row) (int64, error)      { return w.t.insertAutoID(row) }
+func (w WriteTable) Update(row *Row) (found bool, err error)   { return w.t.update(row) }
+func (w WriteTable) Delete(key uint64) (found bool, err error) { return w.t.delete(key) }
+
+func (w WriteTable) InsertManyContext(ctx context.Context, rows []Row) error {
+	return w.t.insertMany(ctx, rows)
+}
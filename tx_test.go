@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadTxHoldsOffWriters(t *testing.T) {
+	table := openTestTable(t)
+	db := table.db
+	if err := table.Insert(createRow(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := db.BeginRead()
+	inserted := make(chan error)
+	go func() { inserted <- table.Insert(createRow(2)) }()
+
+	r, err := tx.Table(defaultTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if n, err := r.Count(); err != nil || n != 1 {
+			t.Fatalf("Count() = %d, %v inside the read transaction, want 1", n, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-inserted:
+		t.Fatalf("insert finished during the read transaction: %v", err)
+	default:
+	}
+
+	if err := tx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-inserted; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Table(defaultTableName); !errors.Is(err, ErrTxDone) {
+		t.Fatalf("Table after Close returned %v, want ErrTxDone", err)
+	}
+}
+
+func TestWriteTxCommitAndRollback(t *testing.T) {
+	table := openTestTable(t)
+	db := table.db
+
+	tx, err := db.BeginWrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := tx.Table(defaultTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Insert(createRow(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.BeginWrite(); !errors.Is(err, ErrTransactionOpen) {
+		t.Fatalf("BeginWrite inside a transaction returned %v, want ErrTransactionOpen", err)
+	}
+	if err := db.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.BeginWrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, err = tx.Table(defaultTableName); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Insert(createRow(2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := table.SelectAll()
+	if len(rows) != 1 || rows[0].ID != 1 {
+		t.Fatalf("after rollback the table holds %v, want only row 1", rows)
+	}
+}
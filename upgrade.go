@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Format Upgrades
+//
+// OpenDatabase upgrades a file written in an older format version before it
+// opens it; UpgradeDatabase does only that. Like Vacuum, the upgrade copies
+// every table into a new file next to the database (its path plus
+// upgradeSuffix), commits it and renames it over the old file, so a crash
+// leaves one of the two files whole. Versions 5 to 9 store internal nodes
+// and rows like the current version, and leaves with every key in full.
+// They differ in the header fields they have, in where the catalog starts
+// and in the end of the page later versions reserve, so their trees are read
+// as they are and packed again into the current layout; their free pages are
+// left behind. Older versions stored 32-bit keys and cannot be upgraded.
+const upgradeSuffix = "-upgrade"
+
+// plainLeafHeaderSize is where the cell pointers of a leaf start in the
+// older format versions, whose leaves have no key prefix.
+const plainLeafHeaderSize = LeafNodeContentOffset + LeafNodeContentStartSize
+
+// formatLayout describes the header page of an older format version. The
+// header fields it has are where the current version keeps them.
+type formatLayout struct {
+	// catalogOffset is where the catalog starts, right after the header
+	// fields.
+	catalogOffset int
+	// dirtyFlag and encryption tell whether the header has these fields.
+	dirtyFlag  bool
+	encryption bool
+}
+
+// upgradableFormats are the older format versions UpgradeDatabase can read.
+var upgradableFormats = map[uint32]formatLayout{
+	5: {catalogOffset: 36},
+	6: {catalogOffset: 44},
+	7: {catalogOffset: 48, dirtyFlag: true},
+	8: {catalogOffset: 72, dirtyFlag: true, encryption: true},
+	9: {catalogOffset: 76, dirtyFlag: true, encryption: true},
+}
+
+var errUpgradeUnclean = errors.New("database was not closed cleanly, open it with the version that wrote it first")
+
+// UpgradeDatabase rewrites the database file at filename in the current
+// format version if an older version wrote it, and returns the version the
+// file was in. Only the options for storage, syncing and the passphrase
+// matter.
+func UpgradeDatabase(filename string, opts ...Option) (version uint32, err error) {
+	version, err = upgradeFile(filename, newOpenConfig(opts))
+	if err != nil {
+		return version, err
+	}
+	if version == 0 {
+		return 0, errNotADatabase
+	}
+	if version > headerFormatVersion {
+		return version, errUnsupportedVersion
+	}
+	return version, nil
+}
+
+// upgradeFile upgrades the file at filename if an older format version wrote
+// it and returns the version it was in, 0 if it is empty. Files of a newer
+// version are left for validateHeader to refuse.
+func upgradeFile(filename string, cfg openConfig) (uint32, error) {
+	src, err := openPager(filename, cfg.storage)
+	if err != nil {
+		return 0, err
+	}
+	if src.numPages == 0 {
+		return 0, src.close()
+	}
+
+	// The header fields are in plaintext even in encrypted files
+	raw := make([]byte, headerSize)
+	if _, err := src.file.ReadAt(raw, 0); err != nil {
+		src.close()
+		return 0, err
+	}
+	if string(raw[headerMagicOffset:headerMagicOffset+headerMagicSize]) != headerMagic {
+		src.close()
+		return 0, errNotADatabase
+	}
+	version := binary.LittleEndian.Uint32(raw[headerFormatVersionOffset:])
+	layout, ok := upgradableFormats[version]
+	if !ok {
+		if err := src.close(); err != nil {
+			return version, err
+		}
+		if version < headerFormatVersion {
+			return version, fmt.Errorf("database format version %d is too old to upgrade", version)
+		}
+		return version, nil
+	}
+	if _, ok := src.file.(fileStorage); !ok {
+		src.close()
+		return version, errors.New("upgrade needs the default file storage")
+	}
+
+	tmpPath := filename + upgradeSuffix
+	err = writeUpgrade(src, layout, raw, tmpPath, cfg)
+	if closeErr := src.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return version, err
+	}
+	return version, os.Rename(tmpPath, filename)
+}
+
+// writeUpgrade copies the tables of src, an open file of the given layout
+// whose header fields are in raw, into a new file at path and commits it.
+func writeUpgrade(src *Pager, layout formatLayout, raw []byte, path string, cfg openConfig) error {
+	if layout.dirtyFlag && headerDirty(raw) {
+		return errUpgradeUnclean
+	}
+	encrypted := layout.encryption && headerEncryption(raw) != encryptionNone
+	switch {
+	case encrypted && headerEncryption(raw) != encryptionAESGCM:
+		return errors.New("unsupported database encryption")
+	case encrypted && cfg.passphrase == "":
+		return ErrDatabaseEncrypted
+	case !encrypted && cfg.passphrase != "":
+		return errDatabaseNotEncrypted
+	}
+	if encrypted {
+		c, err := newPageCipher(cfg.passphrase, headerEncryptionSalt(raw), headerEncryptionIterations(raw))
+		if err != nil {
+			return err
+		}
+		c.prefix = layout.catalogOffset
+		src.cipher = c
+		if _, err := src.getPage(headerPageNum); err != nil {
+			return ErrWrongPassphrase
+		}
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	dst, err := openPager(path, cfg.storage)
+	if err != nil {
+		return err
+	}
+	dst.syncMode = cfg.syncMode
+	if src.cipher != nil {
+		// Same key, with the header fields of the current version in plaintext
+		c := *src.cipher
+		c.prefix = headerSize
+		dst.cipher = &c
+	}
+	if err := copyInto(src, layout.catalogOffset, plainLeafCells, dst); err != nil {
+		dst.close()
+		return err
+	}
+	if err := dst.commit(); err != nil {
+		dst.close()
+		return err
+	}
+	return dst.close()
+}
+
+// plainLeafCells returns copies of the cells of a leaf of an older format
+// version, which hold their key in full like cells outside a page do. They
+// are read cell by cell, as leaves of some versions reach into the reserved
+// end of the page.
+func plainLeafCells(node []byte) [][]byte {
+	cells := make([][]byte, leafNodeNumCells(node).get())
+	for i := range cells {
+		start := int(binary.LittleEndian.Uint16(node[plainLeafHeaderSize+i*LeafNodeCellPointerSize:]))
+		end := start + LeafNodeValueOffset + serializedRowLen(node[start+LeafNodeValueOffset:])
+		cells[i] = slices.Clone(node[start:end])
+	}
+	return cells
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// vacuumSuffix names the file Vacuum builds next to the database before
+// renaming it over the original.
+const vacuumSuffix = "-vacuum"
+
+// errVacuumSnapshots is returned by Vacuum while snapshots still read the
+// file it would replace.
+var errVacuumSnapshots = errors.New("cannot vacuum while snapshots are open")
+
+// Vacuum rebuilds the database into a new file and swaps it in place of the
+// old one. Every table is rewritten with its leaves filled as far as they go
+// and full internal nodes above them, so space left behind by deleted rows
+// and half-empty leaves is given back and the free list ends up empty. The
+// new file is committed before it is renamed over the old one, so a crash
+// leaves either file intact. If the old file cannot be swapped out, it is
+// reopened and the error returned. Table handles stay valid. Snapshots must be
+// closed and transactions committed or rolled back first.
+func (db *Database) Vacuum() error {
+	db.latch.Lock()
+	defer db.latch.Unlock()
+	if len(db.pager.snapshots) > 0 {
+		return errVacuumSnapshots
+	}
+	if db.pager.txn != nil {
+		return ErrTransactionOpen
+	}
+	file, ok := db.pager.file.(fileStorage)
+	if !ok {
+		return errors.New("vacuum needs the default file storage")
+	}
+	path := file.Name()
+	tmpPath := path + vacuumSuffix
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	dst, err := openPager(tmpPath, db.cfg.storage)
+	if err != nil {
+		return err
+	}
+	dst.syncMode = db.cfg.syncMode
+	dst.cipher = db.pager.cipher
+	if err := copyInto(db.pager, catalogOffset, leafNodeCells, dst); err != nil {
+		dst.close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := dst.commit(); err != nil {
+		dst.close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := dst.close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// The old file is brought up to date first, so whichever file is at path
+	// when the pagers are swapped holds every row
+	if err := db.pager.commit(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	backups := db.pager.backups
+	swapErr := db.pager.close()
+	if swapErr == nil {
+		swapErr = os.Rename(tmpPath, path)
+	}
+	if swapErr != nil {
+		os.Remove(tmpPath)
+	}
+	pager, err := db.openPager(path)
+	if err != nil {
+		return errors.Join(swapErr, err)
+	}
+	db.pager = pager
+
+	// Running backups copied pages of the old file, start them over
+	pager.backups = backups
+	if swapErr == nil {
+		for _, b := range backups {
+			b.restart()
+		}
+	}
+
+	header, err := pager.getPage(headerPageNum)
+	if err != nil {
+		return err
+	}
+	for _, t := range db.tables {
+		t.pager = pager
+		t.rootPageNum = catalogEntryRootPage(catalogEntry(header, t.catalogIndex))
+		t.rightmostLeaf = t.rootPageNum
+		t.invalidateLeafHint()
+		if t.bloom != nil {
+			if err := t.rebuildBloomFilter(0); err != nil {
+				swapErr = errors.Join(swapErr, err)
+			}
+		}
+	}
+	for _, x := range db.indexes {
+		x.rootPageNum = catalogEntryRootPage(catalogEntry(header, x.catalogIndex))
+	}
+	return swapErr
+}
+
+// copyInto writes a header, a catalog and packed copies of every table and
+// index of the pager src, whose catalog starts at srcCatalogOffset, into the
+// empty pager dst. They keep their catalog order. leafCells reads the cells
+// of a table leaf of src.
+func copyInto(srcPager *Pager, srcCatalogOffset int, leafCells func(node []byte) [][]byte, dst *Pager) error {
+	src, err := srcPager.getPage(headerPageNum)
+	if err != nil {
+		return err
+	}
+	header, err := dst.getPage(headerPageNum)
+	if err != nil {
+		return err
+	}
+	initializeHeader(header)
+	setHeaderUserVersion(header, headerUserVersion(src))
+	setHeaderApplicationID(header, headerApplicationID(src))
+	if dst.cipher != nil {
+		setHeaderEncryption(header, dst.cipher)
+	}
+
+	for i := range headerTableCount(src) {
+		entry := catalogEntryAt(src, srcCatalogOffset, i)
+		if kind := catalogEntryKind(entry); kind != catalogKindTable {
+			rootPageNum, err := createIndexTree(dst)
+			if err != nil {
+				return err
+			}
+			srcTree := indexTree{pager: srcPager, rootPageNum: catalogEntryRootPage(entry)}
+			if err := copyIndex(srcTree, indexTree{pager: dst, rootPageNum: rootPageNum}); err != nil {
+				return err
+			}
+			if _, err := catalogAppend(header, catalogEntryName(entry), rootPageNum); err != nil {
+				return err
+			}
+			setCatalogEntryKind(catalogEntry(header, i), kind)
+			continue
+		}
+		cells, err := srcPager.treeCells(catalogEntryRootPage(entry), leafCells)
+		if err != nil {
+			return err
+		}
+		// The root is reserved first so it comes before the rest of the tree
+		rootPageNum, err := dst.getUnusedPageNum()
+		if err != nil {
+			return err
+		}
+		if _, err := dst.getPageForWrite(rootPageNum); err != nil {
+			return err
+		}
+		if err := buildPackedTree(dst, rootPageNum, cells, LeafNodeSpaceForCells); err != nil {
+			return err
+		}
+		if _, err := catalogAppend(header, catalogEntryName(entry), rootPageNum); err != nil {
+			return err
+		}
+		setCatalogEntrySequence(catalogEntry(header, i), catalogEntrySequence(entry))
+	}
+	return nil
+}
+
+// treeCells returns copies of the cells of every leaf of the tree rooted at
+// pageNum, in key order, read by leafCells.
+func (p *Pager) treeCells(pageNum uint32, leafCells func(node []byte) [][]byte) ([][]byte, error) {
+	node, err := p.getPage(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	for nodeType(node) == NodeTypeInternal {
+		if node, err = p.getPage(internalNodeChild(node, 0).get()); err != nil {
+			return nil, err
+		}
+	}
+
+	var cells [][]byte
+	for {
+		cells = append(cells, leafCells(node)...)
+		next := leafNodeNextLeaf(node).get()
+		if next == 0 {
+			return cells, nil
+		}
+		if node, err = p.getPage(next); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// leafNodeCells returns copies of the cells of the leaf with their keys in
+// full.
+func leafNodeCells(node []byte) [][]byte {
+	return leafNodeCellsIn(node, nil)
+}
+
+// packedNode is a node written by buildPackedTree and the largest key below it.
+type packedNode struct {
+	pageNum uint32
+	maxKey  uint64
+}
+
+// packedLayout returns how buildPackedTree lays out cells, which are in key
+// order, as the end indexes of the runs of packRuns for every level from the
+// leaves up to the level of the root, which has a single run. Leaves are
+// filled up to leafCapacity bytes and internal nodes up to
+// InternalNodeMaxKeys.
+func packedLayout(cells [][]byte, leafCapacity int) [][]int {
+	levels := [][]int{packLeafRuns(cells, leafCapacity)}
+	for n := len(levels[0]); n > 1; n = len(levels[len(levels)-1]) {
+		sizes := make([]int, n)
+		for i := range sizes {
+			sizes[i] = 1
+		}
+		levels = append(levels, packRuns(sizes, InternalNodeMaxKeys+1, InternalNodeMinKeys+1))
+	}
+	return levels
+}
+
+// packedPages returns how many pages buildPackedTree takes for cells,
+// including the root.
+func packedPages(cells [][]byte, leafCapacity int) int {
+	pages := 0
+	for _, runs := range packedLayout(cells, leafCapacity) {
+		pages += len(runs)
+	}
+	return pages
+}
+
+// buildPackedTree writes a tree holding cells, which are in key order, into
+// dst with its root on page rootPageNum. Leaves are filled up to leafCapacity
+// bytes and internal nodes up to InternalNodeMaxKeys; only the last node of a
+// level can be smaller, and it is evened out with its neighbour if it would
+// fall below the minimum a delete keeps. The root page is written last.
+func buildPackedTree(dst *Pager, rootPageNum uint32, cells [][]byte, leafCapacity int) error {
+	layout := packedLayout(cells, leafCapacity)
+
+	runs := layout[0]
+	level := make([]packedNode, len(runs))
+	start := 0
+	for i, end := range runs {
+		pageNum, node, err := allocPackedNode(dst, rootPageNum, len(runs) == 1)
+		if err != nil {
+			return err
+		}
+		initializeLeafNode(node)
+		leafNodeAppendCells(node, cells[start:end])
+		if i > 0 {
+			prev, err := dst.getPageForWrite(level[i-1].pageNum)
+			if err != nil {
+				return err
+			}
+			leafNodeNextLeaf(prev).set(pageNum)
+		}
+		level[i] = packedNode{pageNum: pageNum}
+		if end > start {
+			level[i].maxKey = leafNodeKey(node, uint32(end-start-1))
+		}
+		start = end
+	}
+
+	for _, runs := range layout[1:] {
+		parents := make([]packedNode, len(runs))
+		start := 0
+		for i, end := range runs {
+			pageNum, node, err := allocPackedNode(dst, rootPageNum, len(runs) == 1)
+			if err != nil {
+				return err
+			}
+			initializeInternalNode(node)
+			children := level[start:end]
+			numKeys := uint32(len(children) - 1)
+			internalNodeNumKeys(node).set(numKeys)
+			for j, child := range children {
+				if uint32(j) < numKeys {
+					internalNodeChildPtr(node, uint32(j)).set(child.pageNum)
+					internalNodeKey(node, uint32(j)).set(child.maxKey)
+				} else {
+					internalNodeRightChild(node).set(child.pageNum)
+				}
+				childNode, err := dst.getPageForWrite(child.pageNum)
+				if err != nil {
+					return err
+				}
+				nodeParent(childNode).set(pageNum)
+			}
+			if i > 0 {
+				prev, err := dst.getPageForWrite(parents[i-1].pageNum)
+				if err != nil {
+					return err
+				}
+				internalNodeNextSibling(prev).set(pageNum)
+				internalNodePrevSibling(node).set(parents[i-1].pageNum)
+			}
+			parents[i] = packedNode{pageNum: pageNum, maxKey: children[len(children)-1].maxKey}
+			start = end
+		}
+		level = parents
+	}
+
+	root, err := dst.getPageForWrite(rootPageNum)
+	if err != nil {
+		return err
+	}
+	setNodeRoot(root, true)
+	return nil
+}
+
+// allocPackedNode returns the page for the next node of a level: the reserved
+// root page for the only node of the top level, a new page otherwise.
+func allocPackedNode(dst *Pager, rootPageNum uint32, isRoot bool) (uint32, []byte, error) {
+	pageNum := rootPageNum
+	if !isRoot {
+		var err error
+		if pageNum, err = dst.getUnusedPageNum(); err != nil {
+			return 0, nil, err
+		}
+	}
+	node, err := dst.getPageForWrite(pageNum)
+	return pageNum, node, err
+}
+
+// packLeafRuns is packRuns for leaf cells, in key order: a run takes as much
+// room as its cells need with the key prefix they share (see leafCellsSpace),
+// and a last run smaller than LeafNodeMinUsedSpace is evened out with the one
+// before it by leafSplitPoint.
+func packLeafRuns(cells [][]byte, capacity int) []int {
+	var ends []int
+	start, total := 0, 0
+	for i, cell := range cells {
+		size := len(cell) + LeafNodeCellPointerSize
+		// All cells of the run share what its first and last cell share
+		prefixLen := sharedKeyBytes(leafCellKey(cells[start]), leafCellKey(cell))
+		if i > start && total+size-(i-start+1)*prefixLen > capacity {
+			ends = append(ends, i)
+			start, total = i, 0
+		}
+		total += size
+	}
+	ends = append(ends, len(cells))
+
+	n := len(ends)
+	if n < 2 || leafCellsSpace(cells[start:]) >= LeafNodeMinUsedSpace {
+		return ends
+	}
+	prev := 0
+	if n > 2 {
+		prev = ends[n-3]
+	}
+	ends[n-2] = prev + leafSplitPoint(cells[prev:], 50)
+	return ends
+}
+
+// packRuns splits items of the given sizes into consecutive runs of at most
+// capacity, each filled before the next starts, and returns the end index of
+// every run. A last run smaller than minimum shares the items of the run
+// before it evenly. There is always at least one run, possibly empty.
+func packRuns(sizes []int, capacity int, minimum int) []int {
+	var ends []int
+	used := 0
+	for i, size := range sizes {
+		if used+size > capacity && used > 0 {
+			ends = append(ends, i)
+			used = 0
+		}
+		used += size
+	}
+	ends = append(ends, len(sizes))
+
+	n := len(ends)
+	if n < 2 || used >= minimum {
+		return ends
+	}
+	start := 0
+	if n > 2 {
+		start = ends[n-3]
+	}
+	total := 0
+	for _, size := range sizes[start:] {
+		total += size
+	}
+	left := 0
+	for i := start; i < len(sizes)-1; i++ {
+		left += sizes[i]
+		if 2*left >= total {
+			ends[n-2] = i + 1
+			break
+		}
+	}
+	return ends
+}
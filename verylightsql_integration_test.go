@@ -7,10 +7,14 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -37,13 +41,14 @@ func init() {
 }
 
 // runScript runs the verylightsql binary in the specified working directory with the provided commands as input.
-func runScript(t *testing.T, workdir string, commands []string) (lines []string, all string, code int) {
+// flags go on the command line before the database file.
+func runScript(t *testing.T, workdir string, commands []string, flags ...string) (lines []string, all string, code int) {
 	t.Helper()
 
 	ctx, cancel := context.WithTimeout(context.Background(), integrationTestTimeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, verylightsqlBinary, verylightsqlDBName)
+	cmd := exec.CommandContext(ctx, verylightsqlBinary, append(flags, verylightsqlDBName)...)
 	cmd.Dir = workdir
 
 	stdin, err := cmd.StdinPipe()
@@ -112,6 +117,29 @@ func wantWithHeader(lines ...string) []string {
 	return append(headerLines, lines...)
 }
 
+// wideRow returns the username and email of row i padded to the column
+// limits, so that every row takes a full-size leaf cell and a leaf holds 13
+// rows whatever their ids, like it did before leaves stored rows by size.
+func wideRow(i int) (username, email string) {
+	username = fmt.Sprintf("user%d", i)
+	username += strings.Repeat("u", 32-len(username))
+	email = fmt.Sprintf("person%d@example.com", i)
+	email = strings.Repeat("p", 255-len(email)) + email
+	return username, email
+}
+
+// wideInsert returns the statement inserting row i with the strings of wideRow.
+func wideInsert(i int) string {
+	username, email := wideRow(i)
+	return fmt.Sprintf("insert %d %s %s", i, username, email)
+}
+
+// wideRowLine returns row i with the strings of wideRow as select prints it.
+func wideRowLine(i int) string {
+	username, email := wideRow(i)
+	return fmt.Sprintf("(%d, %s, %s)", i, username, email)
+}
+
 func Test_InsertsAndRetrievesRow(t *testing.T) {
 	dir := t.TempDir()
 
@@ -152,180 +180,768 @@ func Test_PersistsDataAfterClose(t *testing.T) {
 	}, want2)
 }
 
-func Test_InsertMaxLengthStrings(t *testing.T) {
+func Test_Transactions(t *testing.T) {
 	dir := t.TempDir()
 
-	longUsername := strings.Repeat("a", 32)
-	longEmail := strings.Repeat("a", 255)
-
 	script := []string{
-		fmt.Sprintf("insert 1 %s %s", longUsername, longEmail),
+		"insert 1 user1 person1@example.com",
+		"commit",
+		"begin",
+		"begin",
+	}
+	// Enough rows to split the root leaf, undone by the rollback
+	for i := 2; i <= 16; i++ {
+		script = append(script, wideInsert(i))
+	}
+	script = append(script,
+		"select count(*)",
+		".use other",
+		"insert 1 other1 other1@example.com",
+		"rollback",
 		"select",
+		".btree",
+		".tables",
+		"begin",
+		"insert 3 user3 person3@example.com",
+		"commit",
+		"begin",
+		"insert 4 user4 person4@example.com",
 		".exit",
-	}
+	)
 
 	want := wantWithHeader(
 		"> Executed.",
-		fmt.Sprintf("> (1, %s, %s)", longUsername, longEmail),
+		"> Error: no transaction is open.",
+		"> Executed.",
+		"> Error: a transaction is open.",
+	)
+	for range 15 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want,
+		"> (16)",
+		"Executed.",
+		"> > Executed.",
+		"> Executed.",
+		"> (1, user1, person1@example.com)",
 		"Executed.",
+		"> - leaf (size 1, page 1, root, next 0, free 4002)",
+		"  - 1",
+		"> main",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
 		"> Bye!",
 	)
-
 	mustRunAndAssert(t, dir, script, want)
+
+	// The commit is on disk, the transaction left open at exit is not
+	mustRunAndAssert(t, dir, []string{"select", ".exit"}, wantWithHeader(
+		"> (1, user1, person1@example.com)",
+		"(3, user3, person3@example.com)",
+		"Executed.",
+		"> Bye!",
+	))
 }
 
-func Test_TableFullError(t *testing.T) {
+func Test_AutocommitOff(t *testing.T) {
 	dir := t.TempDir()
 
-	// Table max rows is limited by tableMaxPages=100
-	// With internal node splitting working, we can insert many more rows
-	// until we run out of pages. Try to insert enough rows to fill all pages.
-	script := make([]string, 0, 1402)
-	for i := 1; i <= 1401; i++ {
-		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
-	}
-	script = append(script, ".exit")
-
-	out, full, code := runScript(t, dir, script)
-
-	// Expect the process to exit (either success or with "page number out of bounds" error)
-	if code != 0 {
-		t.Fatalf("expected process to return zero exit code, but got exit code %d; output:\n%s", code, full)
-	}
+	mustRunAndAssert(t, dir, []string{
+		".autocommit maybe",
+		".autocommit off",
+		"insert 1 user1 person1@example.com",
+		"insert 2 user2 person2@example.com",
+		"commit",
+		"insert 3 user3 person3@example.com",
+		"rollback",
+		"select",
+		"insert 4 user4 person4@example.com",
+		".autocommit on",
+		"commit",
+		"insert 5 user5 person5@example.com",
+		".autocommit off",
+		"insert 6 user6 person6@example.com",
+		".exit",
+	}, wantWithHeader(
+		"> usage: .autocommit on|off",
+		"> > Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> (1, user1, person1@example.com)",
+		"(2, user2, person2@example.com)",
+		"Executed.",
+		"> Executed.",
+		"> > Error: no transaction is open.",
+		"> Executed.",
+		"> > Executed.",
+		"> Bye!",
+	))
 
-	// Verify we got "page number out of bounds" error when we ran out of pages
-	if len(out) < 2 {
-		t.Fatalf("output too short:\n%q", out)
-	}
-	found := false
-	for _, line := range out {
-		if strings.Contains(line, "page number out of bounds") {
-			found = true
-			break
-		}
-	}
-	if !found {
-		t.Fatalf("expected 'page number out of bounds' error in output when table is full, but did not find it.\nExit code: %d\nOutput:\n%s", code, full)
-	}
+	// Turning autocommit on committed row 4, row 6 was never committed
+	mustRunAndAssert(t, dir, []string{"select", ".exit"}, wantWithHeader(
+		"> (1, user1, person1@example.com)",
+		"(2, user2, person2@example.com)",
+		"(4, user4, person4@example.com)",
+		"(5, user5, person5@example.com)",
+		"Executed.",
+		"> Bye!",
+	))
 }
 
-func Test_ErrorOnTooLongStrings(t *testing.T) {
+func Test_RollsBackInterruptedCommit(t *testing.T) {
 	dir := t.TempDir()
+	dbPath := filepath.Join(dir, verylightsqlDBName)
 
-	longUsername := strings.Repeat("a", 33) // 1 over the limit
-	longEmail := strings.Repeat("a", 256)   // 1 over the limit
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 user1 person1@example.com",
+		".exit",
+	}, wantWithHeader("> Executed.", "> Bye!"))
+	before, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	script := []string{
-		fmt.Sprintf("insert 1 %s %s", longUsername, longEmail),
-		"select",
+	mustRunAndAssert(t, dir, []string{
+		"insert 2 user2 person2@example.com",
 		".exit",
+	}, wantWithHeader("> Executed.", "> Bye!"))
+
+	// Leave the journal a commit of the second session would have written
+	// before it crashed: every page as it was after the first session.
+	const pageSize = 4096
+	header := make([]byte, 32)
+	copy(header, "vlsql-journal")
+	binary.LittleEndian.PutUint32(header[16:], pageSize)
+	binary.LittleEndian.PutUint32(header[20:], uint32(len(before)/pageSize))
+	binary.LittleEndian.PutUint64(header[24:], uint64(len(before)))
+	journal := header
+	for i := 0; i < len(before); i += pageSize {
+		journal = binary.LittleEndian.AppendUint32(journal, uint32(i/pageSize))
+		journal = append(journal, before[i:i+pageSize]...)
+	}
+	journalPath := dbPath + "-journal"
+	if err := os.WriteFile(journalPath, journal, 0666); err != nil {
+		t.Fatal(err)
 	}
 
-	want := wantWithHeader(
-		"> string is too long.",
-		"> Executed.",
+	mustRunAndAssert(t, dir, []string{
+		"select",
+		".exit",
+	}, wantWithHeader(
+		"> (1, user1, person1@example.com)",
+		"Executed.",
 		"> Bye!",
-	)
-
-	mustRunAndAssert(t, dir, script, want)
+	))
+	if _, err := os.Stat(journalPath); !os.IsNotExist(err) {
+		t.Fatalf("journal still exists after rollback: %v", err)
+	}
 }
 
-func Test_ErrorOnNegativeID(t *testing.T) {
+func Test_RepairsTornPageFromDoubleWrite(t *testing.T) {
 	dir := t.TempDir()
+	dbPath := filepath.Join(dir, verylightsqlDBName)
 
-	script := []string{
-		"insert -1 cstack foo@bar.com",
-		"select",
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 user1 person1@example.com",
+		".exit",
+	}, wantWithHeader("> Executed.", "> Bye!"))
+	before, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustRunAndAssert(t, dir, []string{
+		"insert 2 user2 person2@example.com",
 		".exit",
+	}, wantWithHeader("> Executed.", "> Bye!"))
+	after, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	want := wantWithHeader(
-		"> ID must be positive.",
-		"> Executed.",
+	// Leave what a crash of the second session's commit would have left:
+	// the double-write file with every page, its header marked dirty, and
+	// the root page of the main table only half written.
+	const pageSize = 4096
+	binary.LittleEndian.PutUint32(after[44:], 1)
+	header := make([]byte, 32)
+	copy(header, "vlsql-dblwrite")
+	binary.LittleEndian.PutUint32(header[16:], pageSize)
+	binary.LittleEndian.PutUint32(header[20:], uint32(len(after)/pageSize))
+	binary.LittleEndian.PutUint64(header[24:], uint64(len(after)))
+	doubleWrite := header
+	for i := 0; i < len(after); i += pageSize {
+		page := after[i : i+pageSize]
+		doubleWrite = binary.LittleEndian.AppendUint32(doubleWrite, uint32(i/pageSize))
+		doubleWrite = binary.LittleEndian.AppendUint32(doubleWrite, crc32.ChecksumIEEE(page))
+		doubleWrite = append(doubleWrite, page...)
+	}
+	doubleWritePath := dbPath + "-dblwrite"
+	if err := os.WriteFile(doubleWritePath, doubleWrite, 0666); err != nil {
+		t.Fatal(err)
+	}
+	torn := slices.Clone(after)
+	copy(torn[pageSize+pageSize/2:2*pageSize], before[pageSize+pageSize/2:2*pageSize])
+	if err := os.WriteFile(dbPath, torn, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	mustRunAndAssert(t, dir, []string{
+		"select",
+		".exit",
+	}, wantWithHeader(
+		"> (1, user1, person1@example.com)",
+		"(2, user2, person2@example.com)",
+		"Executed.",
 		"> Bye!",
-	)
+	))
+	if _, err := os.Stat(doubleWritePath); !os.IsNotExist(err) {
+		t.Fatalf("double-write file still exists after replay: %v", err)
+	}
+}
 
-	mustRunAndAssert(t, dir, script, want)
+// markUnclean sets the dirty flag in the header of the database in dir, as a
+// commit cut short without a journal would leave it.
+func markUnclean(t *testing.T, dir string, change func(db []byte) []byte) {
+	t.Helper()
+	dbPath := filepath.Join(dir, verylightsqlDBName)
+	db, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(db[44:], 1)
+	if err := os.WriteFile(dbPath, change(db), 0666); err != nil {
+		t.Fatal(err)
+	}
 }
 
-func Test_PrintConstantsMetaCommand(t *testing.T) {
+func Test_RecoversUncleanShutdown(t *testing.T) {
 	dir := t.TempDir()
 
-	want := wantWithHeader(
-		"> ROW_SIZE: 291",
-		"COMMON_NODE_HEADER_SIZE: 6",
-		"LEAF_NODE_HEADER_SIZE: 14",
-		"LEAF_NODE_CELL_SIZE: 295",
-		"LEAF_NODE_SPACE_FOR_CELLS: 4082",
-		"LEAF_NODE_MAX_CELLS: 13",
-		"> Bye!",
-	)
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 user1 person1@example.com",
+		"insert 2 user2 person2@example.com",
+		".exit",
+	}, wantWithHeader("> Executed.", "> Executed.", "> Bye!"))
+
+	// A page written and counted by the interrupted commit but not linked
+	// into any tree
+	markUnclean(t, dir, func(db []byte) []byte {
+		binary.LittleEndian.PutUint32(db[72:], binary.LittleEndian.Uint32(db[72:])+1)
+		return append(db, make([]byte, 4096)...)
+	})
 
 	mustRunAndAssert(t, dir, []string{
-		".constants",
+		"pragma freelist_count",
+		"select",
 		".exit",
-	}, want)
+	}, wantWithHeader(
+		"> 1",
+		"Executed.",
+		"> (1, user1, person1@example.com)",
+		"(2, user2, person2@example.com)",
+		"Executed.",
+		"> Bye!",
+	))
 }
 
-func Test_PrintBtreeMetaCommandOnEmptyTable(t *testing.T) {
+func Test_KeepsPreallocatedSpace(t *testing.T) {
 	dir := t.TempDir()
-
-	want := wantWithHeader(
-		"> - leaf (size 0)",
-		"> Bye!",
-	)
+	dbPath := filepath.Join(dir, verylightsqlDBName)
 
 	mustRunAndAssert(t, dir, []string{
-		".btree",
+		"insert 1 user1 person1@example.com",
 		".exit",
-	}, want)
-}
+	}, wantWithHeader("> Executed.", "> Bye!"))
 
-func Test_PrintBtreeMetaCommandWithRows(t *testing.T) {
-	dir := t.TempDir()
+	// Space preallocated past the pages in use is not taken for pages
+	f, err := os.OpenFile(dbPath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(16 * 4096); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
 
-	want := wantWithHeader(
-		"> Executed.",
-		"> Executed.",
+	mustRunAndAssert(t, dir, []string{
+		"pragma freelist_count",
+		"insert 2 user2 person2@example.com",
+		"select",
+		".exit",
+	}, wantWithHeader(
+		"> 0",
+		"Executed.",
 		"> Executed.",
-		"> - leaf (size 3)",
-		"  - 1",
-		"  - 2",
-		"  - 3",
+		"> (1, user1, person1@example.com)",
+		"(2, user2, person2@example.com)",
+		"Executed.",
 		"> Bye!",
-	)
+	))
+
+	db, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db) != 16*4096 {
+		t.Fatalf("database file is %d bytes, want the preallocated %d", len(db), 16*4096)
+	}
+	// The header page and the root of the main table
+	if count := binary.LittleEndian.Uint32(db[72:]); count != 2 {
+		t.Fatalf("header counts %d pages, want 2", count)
+	}
+}
+
+func Test_RefusesCorruptUncleanShutdown(t *testing.T) {
+	dir := t.TempDir()
 
 	mustRunAndAssert(t, dir, []string{
-		"insert 3 user3 person3@example.com",
 		"insert 1 user1 person1@example.com",
-		"insert 2 user2 person2@example.com",
-		".btree",
 		".exit",
-	}, want)
+	}, wantWithHeader("> Executed.", "> Bye!"))
+
+	// Tear the root page of the main table
+	markUnclean(t, dir, func(db []byte) []byte {
+		db[4096] = 0xff
+		return db
+	})
+
+	lines, all, code := runScript(t, dir, []string{".exit"})
+	if code != 1 {
+		t.Fatalf("expected exit code 1, got %d; output:\n%s", code, all)
+	}
+	want := "Error opening database file: database was not closed cleanly and is corrupt: table main: page 1 is not a tree node"
+	if got := lines[len(lines)-1]; got != want {
+		t.Fatalf("unexpected last line %q, want %q", got, want)
+	}
 }
 
-func Test_ErrorOnDuplicateIDs(t *testing.T) {
-	dir := t.TempDir()
+// rewriteAsFormat turns the header page of the database in dir into the one
+// of an older format version whose catalog starts at catalogOffset, and its
+// leaves into ones without a key prefix.
+func rewriteAsFormat(t *testing.T, dir string, version uint32, catalogOffset int) {
+	t.Helper()
+	dbPath := filepath.Join(dir, verylightsqlDBName)
+	db, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const currentCatalogOffset = 76
+	catalog := slices.Clone(db[currentCatalogOffset : currentCatalogOffset+48*binary.LittleEndian.Uint32(db[24:])])
+	clear(db[catalogOffset:4096])
+	copy(db[catalogOffset:], catalog)
+	binary.LittleEndian.PutUint32(db[16:], version)
+	for start := 4096; start < len(db); start += 4096 {
+		if db[start] == 1 {
+			writePlainLeaf(db[start : start+4096])
+		}
+	}
+	if err := os.WriteFile(dbPath, db, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
 
-	want := wantWithHeader(
-		"> Executed.",
-		"> Error: duplicate key.",
-		"> Bye!",
-	)
+// writePlainLeaf rewrites leaf in place with every key in full after a
+// 16-byte header, as format versions before 10 stored leaves.
+func writePlainLeaf(leaf []byte) {
+	const usableSize = 4064
+	numCells := int(binary.LittleEndian.Uint32(leaf[6:]))
+	suffixLen := 8 - int(leaf[16])
+	prefix := binary.LittleEndian.Uint64(leaf[17:])
+	starts := make([]int, numCells)
+	for i := range starts {
+		starts[i] = int(binary.LittleEndian.Uint16(leaf[25+2*i:]))
+	}
+	sorted := slices.Clone(starts)
+	slices.Sort(sorted)
+
+	plain := make([]byte, len(leaf))
+	copy(plain, leaf[:14])
+	content := usableSize
+	for i, start := range starts {
+		end := usableSize
+		if j, _ := slices.BinarySearch(sorted, start); j+1 < len(sorted) {
+			end = sorted[j+1]
+		}
+		var suffix [8]byte
+		copy(suffix[:], leaf[start:start+suffixLen])
+		value := leaf[start+suffixLen : end]
+
+		content -= 8 + len(value)
+		binary.LittleEndian.PutUint64(plain[content:], prefix|binary.LittleEndian.Uint64(suffix[:]))
+		copy(plain[content+8:], value)
+		binary.LittleEndian.PutUint16(plain[16+2*i:], uint16(content))
+	}
+	binary.LittleEndian.PutUint16(plain[14:], uint16(content))
+	copy(leaf, plain)
+}
+
+func Test_UpgradesOlderFormatOnOpen(t *testing.T) {
+	dir := t.TempDir()
 
 	mustRunAndAssert(t, dir, []string{
 		"insert 1 user1 person1@example.com",
-		"insert 1 newuser newemail",
+		"insert 2 user2 person2@example.com",
+		"pragma user_version = 3",
+		".use other",
+		"insert 7 user7 person7@example.com",
 		".exit",
-	}, want)
+	}, wantWithHeader("> Executed.", "> Executed.", "> Executed.", "> > Executed.", "> Bye!"))
+	rewriteAsFormat(t, dir, 7, 48)
+
+	mustRunAndAssert(t, dir, []string{
+		".tables",
+		"pragma user_version",
+		"select",
+		".use other",
+		"select",
+		".exit",
+	}, wantWithHeader(
+		"> main other",
+		"> 3",
+		"Executed.",
+		"> (1, user1, person1@example.com)",
+		"(2, user2, person2@example.com)",
+		"Executed.",
+		"> > (7, user7, person7@example.com)",
+		"Executed.",
+		"> Bye!",
+	))
+
+	db, err := os.ReadFile(filepath.Join(dir, verylightsqlDBName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := binary.LittleEndian.Uint32(db[16:]); v != 10 {
+		t.Fatalf("format version is %d after the upgrade, want 10", v)
+	}
+	if _, err := os.Stat(filepath.Join(dir, verylightsqlDBName+"-upgrade")); !os.IsNotExist(err) {
+		t.Fatalf("upgrade file left behind: %v", err)
+	}
 }
 
-func Test_PrintThreeLeafNodeBtree(t *testing.T) {
+func Test_UpgradeCommand(t *testing.T) {
 	dir := t.TempDir()
 
-	script := make([]string, 0, 18)
-	for i := 1; i <= 15; i++ {
-		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 user1 person1@example.com",
+		".exit",
+	}, wantWithHeader("> Executed.", "> Bye!"))
+	rewriteAsFormat(t, dir, 5, 36)
+
+	upgrade := func() string {
+		ctx, cancel := context.WithTimeout(context.Background(), integrationTestTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, verylightsqlBinary, "upgrade", verylightsqlDBName)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("upgrade: %v; output:\n%s", err, out)
+		}
+		return string(out)
+	}
+	want := fmt.Sprintf("Verylightsql v%s\nUpgrading database: %s\n", verylightsqlVersion, verylightsqlDBName)
+	if got := upgrade(); got != want+"Upgraded from format version 5 to 10.\n" {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+	if got := upgrade(); got != want+"Already in format version 10.\n" {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+
+	mustRunAndAssert(t, dir, []string{"select", ".exit"}, wantWithHeader(
+		"> (1, user1, person1@example.com)",
+		"Executed.",
+		"> Bye!",
+	))
+
+	// Versions before 64-bit keys are refused
+	rewriteAsFormat(t, dir, 4, 36)
+	lines, all, code := runScript(t, dir, []string{".exit"})
+	if code != 1 {
+		t.Fatalf("expected exit code 1, got %d; output:\n%s", code, all)
+	}
+	if got, want := lines[len(lines)-1], "Error opening database file: database format version 4 is too old to upgrade"; got != want {
+		t.Fatalf("unexpected last line %q, want %q", got, want)
+	}
+}
+
+func Test_EncryptsDatabaseWithPassphrase(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Setenv("VERYLIGHTSQL_PASSPHRASE", "correct horse")
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 user1 person1@example.com",
+		".exit",
+	}, wantWithHeader("> Executed.", "> Bye!"))
+
+	data, err := os.ReadFile(filepath.Join(dir, verylightsqlDBName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("person1@example.com")) {
+		t.Fatal("row stored in plaintext")
+	}
+
+	mustRunAndAssert(t, dir, []string{
+		"select",
+		".exit",
+	}, wantWithHeader(
+		"> (1, user1, person1@example.com)",
+		"Executed.",
+		"> Bye!",
+	))
+
+	for passphrase, want := range map[string]string{
+		"":      "Error opening database file: database is encrypted, a passphrase is needed to open it",
+		"wrong": "Error opening database file: wrong passphrase",
+	} {
+		t.Setenv("VERYLIGHTSQL_PASSPHRASE", passphrase)
+		lines, all, code := runScript(t, dir, []string{".exit"})
+		if code != 1 {
+			t.Fatalf("expected exit code 1, got %d; output:\n%s", code, all)
+		}
+		if got := lines[len(lines)-1]; got != want {
+			t.Fatalf("unexpected last line %q, want %q", got, want)
+		}
+	}
+}
+
+func Test_ReadOnlySessionLeavesFileUntouched(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, verylightsqlDBName)
+
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 user1 person1@example.com",
+		".exit",
+	}, wantWithHeader("> Executed.", "> Bye!"))
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(dbPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	mustRunAndAssert(t, dir, []string{
+		"select",
+		"pragma user_version",
+		".exit",
+	}, wantWithHeader(
+		"> (1, user1, person1@example.com)",
+		"Executed.",
+		"> 0",
+		"Executed.",
+		"> Bye!",
+	))
+
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Fatalf("file modified at %v by a session that only read", info.ModTime())
+	}
+}
+
+func Test_InsertMaxLengthStrings(t *testing.T) {
+	dir := t.TempDir()
+
+	longUsername := strings.Repeat("a", 32)
+	longEmail := strings.Repeat("a", 255)
+
+	script := []string{
+		fmt.Sprintf("insert 1 %s %s", longUsername, longEmail),
+		"select",
+		".exit",
+	}
+
+	want := wantWithHeader(
+		"> Executed.",
+		fmt.Sprintf("> (1, %s, %s)", longUsername, longEmail),
+		"Executed.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, script, want)
+}
+
+func Test_TableFullError(t *testing.T) {
+	dir := t.TempDir()
+
+	// Table max rows is limited by tableMaxPages=100
+	// With internal node splitting working, we can insert many more rows
+	// until we run out of pages. Try to insert enough rows to fill all pages,
+	// using rows of the largest size so that few fit in a page.
+	script := make([]string, 0, 1402)
+	for i := 1; i <= 1401; i++ {
+		script = append(script, wideInsert(i))
+	}
+	script = append(script, ".exit")
+
+	out, full, code := runScript(t, dir, script)
+
+	// Expect the process to exit (either success or with "page number out of bounds" error)
+	if code != 0 {
+		t.Fatalf("expected process to return zero exit code, but got exit code %d; output:\n%s", code, full)
+	}
+
+	// Verify we got "page number out of bounds" error when we ran out of pages
+	if len(out) < 2 {
+		t.Fatalf("output too short:\n%q", out)
+	}
+	found := false
+	for _, line := range out {
+		if strings.Contains(line, "page number out of bounds") {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Fatalf("expected 'page number out of bounds' error in output when table is full, but did not find it.\nExit code: %d\nOutput:\n%s", code, full)
+	}
+}
+
+func Test_ErrorOnTooLongStrings(t *testing.T) {
+	dir := t.TempDir()
+
+	longUsername := strings.Repeat("a", 33) // 1 over the limit
+	longEmail := strings.Repeat("a", 256)   // 1 over the limit
+
+	script := []string{
+		fmt.Sprintf("insert 1 %s %s", longUsername, longEmail),
+		"select",
+		".exit",
+	}
+
+	want := wantWithHeader(
+		"> string is too long.",
+		"> Executed.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, script, want)
+}
+
+func Test_ErrorOnNegativeID(t *testing.T) {
+	dir := t.TempDir()
+
+	script := []string{
+		"insert -1 cstack foo@bar.com",
+		"select",
+		".exit",
+	}
+
+	want := wantWithHeader(
+		"> ID must be positive.",
+		"> Executed.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, script, want)
+}
+
+func Test_PrintConstantsMetaCommand(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> MAX_ROW_SIZE: 298",
+		"COMMON_NODE_HEADER_SIZE: 6",
+		"LEAF_NODE_HEADER_SIZE: 25",
+		"LEAF_NODE_CELL_POINTER_SIZE: 2",
+		"LEAF_NODE_MAX_CELL_SIZE: 306",
+		"LEAF_NODE_SPACE_FOR_CELLS: 4039",
+		"LEAF_NODE_MIN_USED_SPACE: 1346",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		".constants",
+		".exit",
+	}, want)
+}
+
+func Test_PrintBtreeMetaCommandOnEmptyTable(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> - leaf (size 0, page 1, root, next 0, free 4039)",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		".btree",
+		".exit",
+	}, want)
+}
+
+func Test_PrintBtreeMetaCommandWithRows(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> - leaf (size 3, page 1, root, next 0, free 3925)",
+		"  - 1",
+		"  - 2",
+		"  - 3",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		"insert 3 user3 person3@example.com",
+		"insert 1 user1 person1@example.com",
+		"insert 2 user2 person2@example.com",
+		".btree",
+		".exit",
+	}, want)
+}
+
+func Test_ErrorOnDuplicateIDs(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> Executed.",
+		"> Error: duplicate key.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 user1 person1@example.com",
+		"insert 1 newuser newemail",
+		".exit",
+	}, want)
+}
+
+func Test_ErrorOnDuplicateIDsInMultiLevelTree(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 18)
+	for i := 1; i <= 15; i++ {
+		script = append(script, wideInsert(i))
+	}
+	script = append(script, wideInsert(3), wideInsert(12), ".exit")
+
+	want := wantWithHeader()
+	for range 15 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want,
+		"> Error: duplicate key.",
+		"> Error: duplicate key.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, script, want)
+}
+
+func Test_PrintThreeLeafNodeBtree(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 18)
+	for i := 1; i <= 15; i++ {
+		script = append(script, wideInsert(i))
 	}
 	script = append(script, ".btree")
 	script = append(script, ".exit")
@@ -338,8 +954,8 @@ func Test_PrintThreeLeafNodeBtree(t *testing.T) {
 	}
 	// Btree structure output
 	want = append(want,
-		"> - internal (size 1)",
-		"  - leaf (size 7)",
+		"> - internal (size 1, page 1, root, free 4020)",
+		"  - leaf (size 7, page 3, parent 1, next 2, free 1932)",
 		"    - 1",
 		"    - 2",
 		"    - 3",
@@ -348,7 +964,7 @@ func Test_PrintThreeLeafNodeBtree(t *testing.T) {
 		"    - 6",
 		"    - 7",
 		"  - key 7",
-		"  - leaf (size 8)",
+		"  - leaf (size 8, page 2, parent 1, next 0, free 1631)",
 		"    - 8",
 		"    - 9",
 		"    - 10",
@@ -363,12 +979,38 @@ func Test_PrintThreeLeafNodeBtree(t *testing.T) {
 	mustRunAndAssert(t, dir, script, want)
 }
 
+func Test_PrintBtreeToMaxDepth(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 19)
+	for i := 1; i <= 15; i++ {
+		script = append(script, wideInsert(i))
+	}
+	script = append(script, ".btree 0", ".btree 1", ".btree -1", ".exit")
+
+	want := wantWithHeader()
+	for range 15 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want,
+		"> - internal (size 1, page 1, root, free 4020)",
+		"> - internal (size 1, page 1, root, free 4020)",
+		"  - leaf (size 7, page 3, parent 1, next 2, free 1932)",
+		"  - key 7",
+		"  - leaf (size 8, page 2, parent 1, next 0, free 1631)",
+		"> usage: .btree [--json | max-depth]",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, script, want)
+}
+
 func Test_PrintAllRowsInMultiLevelTree(t *testing.T) {
 	dir := t.TempDir()
 
 	script := make([]string, 0, 17)
 	for i := 1; i <= 15; i++ {
-		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+		script = append(script, wideInsert(i))
 	}
 	script = append(script, "select")
 	script = append(script, ".exit")
@@ -379,9 +1021,9 @@ func Test_PrintAllRowsInMultiLevelTree(t *testing.T) {
 		want = append(want, "> Executed.")
 	}
 
-	want = append(want, "> (1, user1, person1@example.com)")
+	want = append(want, "> "+wideRowLine(1))
 	for i := 2; i <= 15; i++ {
-		want = append(want, fmt.Sprintf("(%d, user%d, person%d@example.com)", i, i, i))
+		want = append(want, wideRowLine(i))
 	}
 	want = append(want,
 		"Executed.",
@@ -394,40 +1036,17 @@ func Test_PrintAllRowsInMultiLevelTree(t *testing.T) {
 func Test_PrintFourLeafNodeBtree(t *testing.T) {
 	dir := t.TempDir()
 
-	script := []string{
-		"insert 18 user18 person18@example.com",
-		"insert 7 user7 person7@example.com",
-		"insert 10 user10 person10@example.com",
-		"insert 29 user29 person29@example.com",
-		"insert 23 user23 person23@example.com",
-		"insert 4 user4 person4@example.com",
-		"insert 14 user14 person14@example.com",
-		"insert 30 user30 person30@example.com",
-		"insert 15 user15 person15@example.com",
-		"insert 26 user26 person26@example.com",
-		"insert 22 user22 person22@example.com",
-		"insert 19 user19 person19@example.com",
-		"insert 2 user2 person2@example.com",
-		"insert 1 user1 person1@example.com",
-		"insert 21 user21 person21@example.com",
-		"insert 11 user11 person11@example.com",
-		"insert 6 user6 person6@example.com",
-		"insert 20 user20 person20@example.com",
-		"insert 5 user5 person5@example.com",
-		"insert 8 user8 person8@example.com",
-		"insert 9 user9 person9@example.com",
-		"insert 3 user3 person3@example.com",
-		"insert 12 user12 person12@example.com",
-		"insert 27 user27 person27@example.com",
-		"insert 17 user17 person17@example.com",
-		"insert 16 user16 person16@example.com",
-		"insert 13 user13 person13@example.com",
-		"insert 24 user24 person24@example.com",
-		"insert 25 user25 person25@example.com",
-		"insert 28 user28 person28@example.com",
+	script := make([]string, 0, 32)
+	for _, id := range []int{
+		18, 7, 10, 29, 23, 4, 14, 30, 15, 26, 22, 19, 2, 1, 21,
+		11, 6, 20, 5, 8, 9, 3, 12, 27, 17, 16, 13, 24, 25, 28,
+	} {
+		script = append(script, wideInsert(id))
+	}
+	script = append(script,
 		".btree",
 		".exit",
-	}
+	)
 
 	want := wantWithHeader()
 	// 30 "Executed." lines for inserts
@@ -436,8 +1055,8 @@ func Test_PrintFourLeafNodeBtree(t *testing.T) {
 	}
 	// Btree structure output for 4-leaf-node tree
 	want = append(want,
-		"> - internal (size 3)",
-		"  - leaf (size 7)",
+		"> - internal (size 3, page 1, root, free 3996)",
+		"  - leaf (size 7, page 3, parent 1, next 4, free 1932)",
 		"    - 1",
 		"    - 2",
 		"    - 3",
@@ -446,7 +1065,7 @@ func Test_PrintFourLeafNodeBtree(t *testing.T) {
 		"    - 6",
 		"    - 7",
 		"  - key 7",
-		"  - leaf (size 8)",
+		"  - leaf (size 8, page 4, parent 1, next 2, free 1631)",
 		"    - 8",
 		"    - 9",
 		"    - 10",
@@ -456,7 +1075,7 @@ func Test_PrintFourLeafNodeBtree(t *testing.T) {
 		"    - 14",
 		"    - 15",
 		"  - key 15",
-		"  - leaf (size 7)",
+		"  - leaf (size 7, page 2, parent 1, next 5, free 1932)",
 		"    - 16",
 		"    - 17",
 		"    - 18",
@@ -465,7 +1084,7 @@ func Test_PrintFourLeafNodeBtree(t *testing.T) {
 		"    - 21",
 		"    - 22",
 		"  - key 22",
-		"  - leaf (size 8)",
+		"  - leaf (size 8, page 5, parent 1, next 0, free 1631)",
 		"    - 23",
 		"    - 24",
 		"    - 25",
@@ -479,3 +1098,1172 @@ func Test_PrintFourLeafNodeBtree(t *testing.T) {
 
 	mustRunAndAssert(t, dir, script, want)
 }
+
+func Test_AppendSplitKeepsLeavesFull(t *testing.T) {
+	script := []string{}
+	for i := 1; i <= 200; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	script = append(script, ".btree 1", ".exit")
+
+	// The first leaf, which is left behind by the first split
+	firstLeaf := func(flags ...string) string {
+		lines, all, code := runScript(t, t.TempDir(), script, flags...)
+		if code != 0 {
+			t.Fatalf("unexpected exit code %d; output:\n%s", code, all)
+		}
+		for _, line := range lines {
+			if strings.HasPrefix(line, "  - leaf") {
+				return line
+			}
+		}
+		t.Fatalf("no leaf under the root; output:\n%s", all)
+		return ""
+	}
+	if got := firstLeaf(); got != "  - leaf (size 52, page 3, parent 1, next 2, free 1977)" {
+		t.Fatalf("default split left %q", got)
+	}
+	if got := firstLeaf("--append-split", "90"); got != "  - leaf (size 92, page 3, parent 1, next 2, free 377)" {
+		t.Fatalf("90%% split left %q", got)
+	}
+}
+
+func Test_ProfileMetaCommandWritesProfiles(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> Profiling CPU to prof.cpu.pprof",
+		"> Executed.",
+		"> Wrote prof.cpu.pprof and prof.heap.pprof",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		".profile start prof",
+		"insert 1 user1 person1@example.com",
+		".profile stop",
+		".exit",
+	}, want)
+
+	for _, name := range []string{"prof.cpu.pprof", "prof.heap.pprof"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("expected profile %s to exist: %v", name, err)
+		}
+		if info.Size() == 0 {
+			t.Fatalf("expected profile %s to be non-empty", name)
+		}
+	}
+}
+
+func Test_ErrorOnInvalidUTF8String(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> string is not valid UTF-8.",
+		"> Executed.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 user\xff\xfe person1@example.com",
+		"select",
+		".exit",
+	}, want)
+}
+
+func Test_ErrorOnEmbeddedNulByte(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> string contains a NUL byte.",
+		"> Executed.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 us\x00er1 person1@example.com",
+		"select",
+		".exit",
+	}, want)
+}
+
+func Test_NonASCIIStringsRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	// 16 two-byte runes fill the 32-byte username column exactly
+	fullUsername := strings.Repeat("é", 16)
+
+	want := wantWithHeader(
+		"> Executed.",
+		"> Executed.",
+		"> string is too long.",
+		"> (1, józef, zoë@exämple.com)",
+		fmt.Sprintf("(2, %s, 日本@example.com)", fullUsername),
+		"Executed.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 józef zoë@exämple.com",
+		fmt.Sprintf("insert 2 %s 日本@example.com", fullUsername),
+		fmt.Sprintf("insert 3 %sé person3@example.com", fullUsername),
+		"select",
+		".exit",
+	}, want)
+}
+
+func Test_PrintBtreeAsJSON(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 17)
+	for i := 1; i <= 15; i++ {
+		script = append(script, wideInsert(i))
+	}
+	script = append(script, ".btree --json", ".exit")
+
+	want := wantWithHeader()
+	for range 15 {
+		want = append(want, "> Executed.")
+	}
+	// The root split copies the first half into a new page 3, the second half stays on page 2
+	want = append(want,
+		`> {"page":1,"type":"internal","is_root":true,"keys":[7],"next_sibling":0,"prev_sibling":0,"children":[`+
+			`{"page":3,"type":"leaf","is_root":false,"parent":1,"keys":[1,2,3,4,5,6,7],"next_leaf":2},`+
+			`{"page":2,"type":"leaf","is_root":false,"parent":1,"keys":[8,9,10,11,12,13,14,15],"next_leaf":0}]}`,
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, script, want)
+}
+
+func Test_PrintBtreeAsDOT(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 18)
+	for i := 1; i <= 15; i++ {
+		script = append(script, wideInsert(i))
+	}
+	script = append(script, ".btree-dot", ".btree-dot tree.dot", ".exit")
+
+	dot := []string{
+		"digraph btree {",
+		"  node [shape=box];",
+		`  page1 [label="page 1\ninternal\n7"];`,
+		"  page1 -> page3;",
+		"  page1 -> page2;",
+		`  page3 [label="page 3\nleaf\n1 2 3 4 5 6 7"];`,
+		"  page3 -> page2 [style=dashed, constraint=false];",
+		`  page2 [label="page 2\nleaf\n8 9 10 11 12 13 14 15"];`,
+		"}",
+	}
+	want := wantWithHeader()
+	for range 15 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want, "> "+dot[0])
+	want = append(want, dot[1:]...)
+	want = append(want, "> > Bye!")
+
+	mustRunAndAssert(t, dir, script, want)
+
+	written, err := os.ReadFile(filepath.Join(dir, "tree.dot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(dot, "\n") + "\n"; string(written) != got {
+		t.Fatalf("tree.dot:\n%s\nwant:\n%s", written, got)
+	}
+}
+
+func Test_PragmaHeaderFieldsPersist(t *testing.T) {
+	dir := t.TempDir()
+
+	want1 := wantWithHeader(
+		"> 0",
+		"Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> unknown pragma: page_count.",
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, []string{
+		"pragma user_version",
+		"pragma user_version = 7",
+		"pragma application_id=-42",
+		"pragma page_count",
+		".exit",
+	}, want1)
+
+	want2 := wantWithHeader(
+		"> 7",
+		"Executed.",
+		"> -42",
+		"Executed.",
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, []string{
+		"pragma user_version",
+		"pragma application_id",
+		".exit",
+	}, want2)
+}
+
+func Test_ErrorOnFileWithoutHeader(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, verylightsqlDBName), make([]byte, 4096), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, all, code := runScript(t, dir, []string{".exit"})
+	if code != 1 {
+		t.Fatalf("expected exit code 1, got %d; output:\n%s", code, all)
+	}
+	want := "Error opening database file: file is not a verylightsql database (bad header)"
+	if got := lines[len(lines)-1]; got != want {
+		t.Fatalf("unexpected last line %q, want %q", got, want)
+	}
+}
+
+func Test_InsertWithoutIDAssignsNextID(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> (0)",
+		"Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> (2)",
+		"Executed.",
+		"> Executed.",
+		"> (11)",
+		"Executed.",
+		"> (1, alice, alice@example.com)",
+		"(2, bob, bob@example.com)",
+		"(10, carol, carol@example.com)",
+		"(11, dave, dave@example.com)",
+		"Executed.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		"select last_insert_id()",
+		"insert alice alice@example.com",
+		"insert 10 carol carol@example.com",
+		"insert 2 bob bob@example.com",
+		"select last_insert_id()",
+		"insert dave dave@example.com",
+		"select last_insert_id()",
+		"select",
+		".exit",
+	}, want)
+}
+
+func Test_IDsBeyond32Bits(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> (5000000001)",
+		"Executed.",
+		"> (5000000000, snowflake, s@example.com)",
+		"Executed.",
+		"> (5000000001, next, n@example.com)",
+		"(5000000000, snowflake, s@example.com)",
+		"Executed.",
+		"> Executed.",
+		"> (7, small, small@example.com)",
+		"(9223372036854775807, max, max@example.com)",
+		"Executed.",
+		"> Error: no IDs left to assign.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		"insert 5000000000 snowflake s@example.com",
+		"insert 7 small small@example.com",
+		"insert next n@example.com",
+		"select last_insert_id()",
+		"select where id = 5000000000",
+		"select where id between 4294967296 and 9223372036854775807 order by id desc",
+		"insert 9223372036854775807 max max@example.com",
+		"select where id in (9223372036854775807, 7)",
+		"insert full full@example.com",
+		".exit",
+	}, want)
+}
+
+func Test_AutoIncrementSurvivesDeletingMaxRow(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> (2)",
+		"Executed.",
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, []string{
+		"insert null alice alice@example.com",
+		"insert null bob bob@example.com",
+		"insert 3 carol carol@example.com",
+		"delete 3",
+		"select count(*)",
+		".exit",
+	}, want)
+
+	// 3 was deleted, but the next id still comes after it after a restart
+	want = wantWithHeader(
+		"> Executed.",
+		"> (4)",
+		"Executed.",
+		"> (1, alice, alice@example.com)",
+		"(2, bob, bob@example.com)",
+		"(4, dave, dave@example.com)",
+		"Executed.",
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, []string{
+		"insert null dave dave@example.com",
+		"select last_insert_id()",
+		"select",
+		".exit",
+	}, want)
+}
+
+func Test_InsertOrIgnoreSkipsDuplicates(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> Executed.",
+		"> Executed.",
+		"> Skipped 3 of 5 rows.",
+		"Executed.",
+		"> Skipped 0 of 1 rows.",
+		"Executed.",
+		"> Error: duplicate key.",
+		"> (1, alice, alice@example.com)",
+		"(2, bob, bob@example.com)",
+		"(3, carol, carol@example.com)",
+		"(4, dave, dave@example.com)",
+		"(5, erin, erin@example.com)",
+		"Executed.",
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 alice alice@example.com",
+		"insert 3 carol carol@example.com",
+		// 1 and 3 are taken, the second row with id 4 loses to the first
+		"insert or ignore (4 dave dave@example.com) (1 mallory m@example.com) (2 bob bob@example.com) (3 x x@example.com) (4 y y@example.com)",
+		"insert or ignore 5 erin erin@example.com",
+		"insert 5 erin erin@example.com",
+		"select",
+		".exit",
+	}, want)
+}
+
+func Test_ExplainShowsPlan(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 30)
+	for i := 1; i <= 20; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	script = append(script,
+		"explain select where username is null order by id desc",
+		"explain select count(*) where id in (4, 2)",
+		"explain select distinct email where id between 3 and 9",
+		"explain insert or ignore (1 a a@example.com) (2 b b@example.com)",
+		"explain explain select",
+		".exit",
+	)
+
+	want := wantWithHeader()
+	for range 20 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want,
+		"> PRINT ROWS",
+		"  FILTER username IS NULL",
+		"    SCAN TABLE DESC",
+		"Executed.",
+		"> COUNT",
+		"  KEY LOOKUP id in (4, 2)",
+		"Executed.",
+		"> PRINT DISTINCT email",
+		"  RANGE SCAN id 3..9",
+		"Executed.",
+		"> INSERT OR IGNORE 2 ROWS",
+		"Executed.",
+		`> syntax error at "explain": expected statement.`,
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, script, want)
+
+	// A fresh process starts with a cold cache, so the scan has to read pages
+	lines, all, code := runScript(t, dir, []string{
+		"explain analyze select where id between 3 and 9",
+		".exit",
+	})
+	if code != 0 {
+		t.Fatalf("unexpected exit code %d; output:\n%s", code, all)
+	}
+	patterns := []string{
+		`^> \(3, user3, person3@example.com\)$`,
+		`^PRINT ROWS \(pages_read=[1-9]\d* cache_hits=\d+ time=\S+\)$`,
+		`^  RANGE SCAN id 3\.\.9 \(rows=7 pages_read=[1-9]\d* cache_hits=\d+ time=\S+\)$`,
+		`^Executed\.$`,
+	}
+	// The report follows the seven selected rows
+	got := []string{lines[2], lines[9], lines[10], lines[11]}
+	for i, pattern := range patterns {
+		if !regexp.MustCompile(pattern).MatchString(got[i]) {
+			t.Fatalf("line %q does not match %q; output:\n%s", got[i], pattern, all)
+		}
+	}
+}
+
+func Test_PrintsPagerStats(t *testing.T) {
+	dir := t.TempDir()
+
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 user1 person1@example.com",
+		".exit",
+	}, wantWithHeader("> Executed.", "> Bye!"))
+
+	// Nothing is written back before the session ends
+	lines, all, code := runScript(t, dir, []string{
+		"select",
+		".pagerstats",
+		".exit",
+	})
+	if code != 0 {
+		t.Fatalf("unexpected exit code %d; output:\n%s", code, all)
+	}
+	patterns := []string{
+		`^Executed\.$`,
+		`^> pages read: [1-9]\d*$`,
+		`^pages written: 0$`,
+		`^cache hits: \d+$`,
+		`^cache misses: [1-9]\d*$`,
+		`^bytes on disk: 8192$`,
+		`^key bytes saved: 8$`,
+	}
+	if len(lines) < 3+len(patterns) {
+		t.Fatalf("output too short:\n%s", all)
+	}
+	for i, pattern := range patterns {
+		if got := lines[3+i]; !regexp.MustCompile(pattern).MatchString(got) {
+			t.Fatalf("line %q does not match %q; output:\n%s", got, pattern, all)
+		}
+	}
+}
+
+func Test_IntegrityCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	script := []string{}
+	want := []string{}
+	for i := 1; i <= 20; i++ {
+		script = append(script, wideInsert(i))
+		want = append(want, "> Executed.")
+	}
+	mustRunAndAssert(t, dir, append(script, ".integrity_check", ".exit"),
+		wantWithHeader(append(want, "> ok", "> Bye!")...))
+
+	// Point the last leaf at the wrong parent and link it to the root
+	dbPath := filepath.Join(dir, verylightsqlDBName)
+	db, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(db[2*4096+2:], 9)
+	binary.LittleEndian.PutUint32(db[2*4096+10:], 1)
+	if err := os.WriteFile(dbPath, db, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	mustRunAndAssert(t, dir, []string{
+		".integrity_check",
+		".exit",
+	}, wantWithHeader(
+		"> table main: page 2 points to parent 9 instead of 1",
+		"table main: leaf 2 links to next leaf 1 instead of 0",
+		"> Bye!",
+	))
+}
+
+func Test_SelectWhereID(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 26)
+	for i := 1; i <= 20; i++ {
+		script = append(script, wideInsert(i))
+	}
+	script = append(script,
+		"select where id = 14",
+		"select where id=3",
+		// 7 is the separator key in the root, the max of its left child
+		"select where id = 7",
+		"select where id = 99",
+		"select where name = 3",
+		".exit",
+	)
+
+	want := wantWithHeader()
+	for range 20 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want,
+		"> "+wideRowLine(14),
+		"Executed.",
+		"> "+wideRowLine(3),
+		"Executed.",
+		"> "+wideRowLine(7),
+		"Executed.",
+		"> Executed.",
+		`> syntax error at "name": expected column.`,
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, script, want)
+}
+
+func Test_SelectWhereIDIn(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 26)
+	for i := 1; i <= 20; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	script = append(script,
+		// Keys come back in key order whatever order they are listed in,
+		// repeated and missing keys are dropped
+		"select where id in (15, 2, 99, 7, 2)",
+		"select where id in (3) order by id desc",
+		"select count(*) where id in (1, 20, 21)",
+		"select where id in ()",
+		"select where id in (1, 2",
+		".exit",
+	)
+
+	want := wantWithHeader()
+	for range 20 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want,
+		"> (2, user2, person2@example.com)",
+		"(7, user7, person7@example.com)",
+		"(15, user15, person15@example.com)",
+		"Executed.",
+		"> (3, user3, person3@example.com)",
+		"Executed.",
+		"> (2)",
+		"Executed.",
+		`> syntax error at ")": expected id.`,
+		`> syntax error at end of input: expected ")".`,
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, script, want)
+}
+
+func Test_SelectWhereIDBetween(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 30)
+	for i := 1; i <= 20; i++ {
+		script = append(script, wideInsert(i))
+	}
+	script = append(script,
+		// Both bounds are inclusive; 7 and 8 sit on either side of a leaf boundary
+		"select where id between 6 and 9",
+		"select where id between 5 and 5",
+		"select where id between 0 and 2",
+		"select where id between 19 and 99",
+		"select where id between 9 and 3",
+		"select where id between 6 and 9 order by id desc",
+		"select count(*) where id between 2 and 20",
+		"select where id between 3",
+		".exit",
+	)
+
+	want := wantWithHeader()
+	for range 20 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want,
+		"> "+wideRowLine(6),
+		wideRowLine(7),
+		wideRowLine(8),
+		wideRowLine(9),
+		"Executed.",
+		"> "+wideRowLine(5),
+		"Executed.",
+		"> "+wideRowLine(1),
+		wideRowLine(2),
+		"Executed.",
+		"> "+wideRowLine(19),
+		wideRowLine(20),
+		"Executed.",
+		"> Executed.",
+		"> "+wideRowLine(9),
+		wideRowLine(8),
+		wideRowLine(7),
+		wideRowLine(6),
+		"Executed.",
+		"> (19)",
+		"Executed.",
+		`> syntax error at end of input: expected "and".`,
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, script, want)
+}
+
+func Test_NullValues(t *testing.T) {
+	dir := t.TempDir()
+
+	script := []string{
+		"insert 1 alice null",
+		"insert 2 NULL bob@example.com",
+		"insert 3 'null' \"\"",
+		"insert 4 dave dave@example.com",
+		"insert null null",
+		"update 4 dave null",
+		"select",
+		"select where email is null",
+		"select where username is not null",
+		"select count(*) where email is not null",
+		"select where email is nil",
+		".exit",
+	}
+	want := wantWithHeader(
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> (1, alice, NULL)",
+		"(2, NULL, bob@example.com)",
+		"(3, null, )",
+		"(4, dave, NULL)",
+		"(5, NULL, NULL)",
+		"Executed.",
+		"> (1, alice, NULL)",
+		"(4, dave, NULL)",
+		"(5, NULL, NULL)",
+		"Executed.",
+		"> (1, alice, NULL)",
+		"(3, null, )",
+		"(4, dave, NULL)",
+		"Executed.",
+		"> (2)",
+		"Executed.",
+		`> syntax error at "nil": expected "null".`,
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, script, want)
+
+	// NULLs survive reopening the file
+	want = wantWithHeader(
+		"> (2, NULL, bob@example.com)",
+		"Executed.",
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, []string{"select where id = 2", ".exit"}, want)
+}
+
+func Test_SelectDistinctColumn(t *testing.T) {
+	dir := t.TempDir()
+
+	script := []string{
+		"insert 1 alice a@example.com",
+		"insert 2 bob b@example.com",
+		"insert 3 alice null",
+		"insert 4 carol null",
+		"insert 5 bob b@example.com",
+		"select distinct username",
+		"select distinct email",
+		"select distinct username where id between 2 and 4 order by id desc",
+		"select email where id in (1, 3)",
+		"select distinct",
+		".exit",
+	}
+	want := wantWithHeader(
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> (alice)",
+		"(bob)",
+		"(carol)",
+		"Executed.",
+		"> (a@example.com)",
+		"(b@example.com)",
+		"(NULL)",
+		"Executed.",
+		"> (carol)",
+		"(alice)",
+		"(bob)",
+		"Executed.",
+		"> (a@example.com)",
+		"(NULL)",
+		"Executed.",
+		"> syntax error at end of input: expected column.",
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, script, want)
+}
+
+func Test_MultipleTablesInOneFile(t *testing.T) {
+	dir := t.TempDir()
+
+	script := []string{
+		"insert 1 alice alice@example.com",
+		".use orders",
+		"insert 1 widget w@example.com",
+		"insert 2 gadget g@example.com",
+		"select",
+		".use main",
+		"select",
+		".tables",
+		".use 9lives",
+		".exit",
+	}
+	want := wantWithHeader(
+		"> Executed.",
+		"> > Executed.",
+		"> Executed.",
+		"> (1, widget, w@example.com)",
+		"(2, gadget, g@example.com)",
+		"Executed.",
+		"> > (1, alice, alice@example.com)",
+		"Executed.",
+		"> main orders",
+		"> table names are 1 to 32 letters, digits and underscores, not starting with a digit",
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, script, want)
+
+	// Both tables are found again through the catalog after reopening
+	want = wantWithHeader(
+		"> (1, alice, alice@example.com)",
+		"Executed.",
+		"> > (2, gadget, g@example.com)",
+		"Executed.",
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, []string{"select", ".use orders", "select where id = 2", ".exit"}, want)
+}
+
+func Test_DeleteRebalancesAndCollapsesRoot(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 27)
+	for i := 1; i <= 17; i++ {
+		script = append(script, wideInsert(i))
+	}
+	// The first split leaves keys 1-7 and 8-17 in two leaves. Deleting 7, 1
+	// and 2 leaves the left leaf less than a third full, so the two leaves
+	// share their cells evenly. Deleting 11 to 13 then merges both leaves
+	// back into the root.
+	script = append(script,
+		"delete 7",
+		"delete 1",
+		"delete 1",
+		"delete 2",
+		".btree",
+		"delete 11",
+		"delete 12",
+		"delete 13",
+		"select where id = 8",
+		".btree",
+		".exit",
+	)
+
+	want := wantWithHeader()
+	for range 21 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want,
+		"> - internal (size 1, page 1, root, free 4020)",
+		"  - leaf (size 7, page 3, parent 1, next 2, free 1932)",
+		"    - 3",
+		"    - 4",
+		"    - 5",
+		"    - 6",
+		"    - 8",
+		"    - 9",
+		"    - 10",
+		"  - key 10",
+		"  - leaf (size 7, page 2, parent 1, next 0, free 1932)",
+		"    - 11",
+		"    - 12",
+		"    - 13",
+		"    - 14",
+		"    - 15",
+		"    - 16",
+		"    - 17",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> "+wideRowLine(8),
+		"Executed.",
+		"> - leaf (size 11, page 1, root, next 0, free 728)",
+		"  - 3",
+		"  - 4",
+		"  - 5",
+		"  - 6",
+		"  - 8",
+		"  - 9",
+		"  - 10",
+		"  - 14",
+		"  - 15",
+		"  - 16",
+		"  - 17",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, script, want)
+}
+
+func Test_VacuumPacksTables(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 32)
+	for i := 1; i <= 17; i++ {
+		script = append(script, wideInsert(i))
+	}
+	// Leaves of 7 and 6 rows after the deletes, which fit in one
+	script = append(script,
+		"delete 14",
+		"delete 15",
+		"delete 16",
+		"delete 17",
+		".use other",
+		"insert 1 user1 person1@example.com",
+		"vacuum",
+		"select",
+		".use main",
+		".vacuum",
+		".btree",
+		"select where id = 13",
+		".exit",
+	)
+
+	want := wantWithHeader()
+	for range 21 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want,
+		"> > Executed.",
+		"> Executed.",
+		"> (1, user1, person1@example.com)",
+		"Executed.",
+		"> > > - leaf (size 13, page 1, root, next 0, free 126)",
+	)
+	for i := 1; i <= 13; i++ {
+		want = append(want, fmt.Sprintf("  - %d", i))
+	}
+	want = append(want,
+		"> "+wideRowLine(13),
+		"Executed.",
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, script, want)
+
+	// The header page and one leaf per table
+	info, err := os.Stat(filepath.Join(dir, verylightsqlDBName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Size(), int64(3*4096); got != want {
+		t.Fatalf("file size = %d, want %d", got, want)
+	}
+}
+
+func Test_BackupCopiesDatabase(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 32)
+	for i := 1; i <= 17; i++ {
+		script = append(script, wideInsert(i))
+	}
+	// Changes after the backup stay out of the copy
+	script = append(script,
+		".backup copy.db",
+		wideInsert(18),
+		"delete 1",
+		".exit",
+	)
+	want := wantWithHeader()
+	for range 17 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want, "> > Executed.", "> Executed.", "> Bye!")
+	mustRunAndAssert(t, dir, script, want)
+
+	copyDir := t.TempDir()
+	if err := os.Rename(filepath.Join(dir, "copy.db"), filepath.Join(copyDir, verylightsqlDBName)); err != nil {
+		t.Fatal(err)
+	}
+	want = wantWithHeader()
+	for i := 1; i <= 17; i++ {
+		prefix := ""
+		if i == 1 {
+			prefix = "> "
+		}
+		want = append(want, prefix+wideRowLine(i))
+	}
+	want = append(want, "Executed.", "> Bye!")
+	mustRunAndAssert(t, copyDir, []string{"select", ".exit"}, want)
+}
+
+func Test_FreedPagesAreReused(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 32)
+	for i := 1; i <= 17; i++ {
+		script = append(script, wideInsert(i))
+	}
+	// Merging the two leaves back into the root frees both leaf pages, which
+	// the next split takes instead of growing the file.
+	script = append(script,
+		"delete 7",
+		"delete 1",
+		"delete 2",
+		"delete 11",
+		"delete 12",
+		"delete 13",
+		"pragma freelist_count",
+	)
+	for i := 18; i <= 20; i++ {
+		script = append(script, wideInsert(i))
+	}
+	script = append(script, "pragma freelist_count", "pragma freelist_count = 1", ".exit")
+
+	want := wantWithHeader()
+	for range 23 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want, "> 2", "Executed.")
+	for range 3 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want,
+		"> 0",
+		"Executed.",
+		"> pragma freelist_count is read-only.",
+		"> Bye!",
+	)
+	mustRunAndAssert(t, dir, script, want)
+
+	// The header page, the root and the two reused leaves
+	info, err := os.Stat(filepath.Join(dir, verylightsqlDBName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Size(), int64(4*4096); got != want {
+		t.Fatalf("file size = %d, want %d", got, want)
+	}
+}
+
+func Test_UpdateRewritesRowInPlace(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> string is too long.",
+		"> (1, user1, person1@example.com)",
+		"(2, renamed, new@example.com)",
+		"Executed.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		"insert 1 user1 person1@example.com",
+		"insert 2 user2 person2@example.com",
+		"update 2 renamed new@example.com",
+		"update 3 nobody nobody@example.com",
+		fmt.Sprintf("update 1 %s person1@example.com", strings.Repeat("a", 33)),
+		"select",
+		".exit",
+	}, want)
+}
+
+func Test_SelectOrderByID(t *testing.T) {
+	dir := t.TempDir()
+
+	script := make([]string, 0, 24)
+	for i := 1; i <= 20; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	script = append(script, "select order by id desc", "select order by id asc", "select order by name", ".exit")
+
+	want := wantWithHeader()
+	for range 20 {
+		want = append(want, "> Executed.")
+	}
+	// Descending order spans both leaves of the tree
+	for i := 20; i >= 1; i-- {
+		want = append(want, fmt.Sprintf("(%d, user%d, person%d@example.com)", i, i, i))
+	}
+	want[len(want)-20] = "> " + want[len(want)-20]
+	want = append(want, "Executed.")
+	for i := 1; i <= 20; i++ {
+		want = append(want, fmt.Sprintf("(%d, user%d, person%d@example.com)", i, i, i))
+	}
+	want[len(want)-20] = "> " + want[len(want)-20]
+	want = append(want,
+		"Executed.",
+		`> syntax error at "name": expected "id".`,
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, script, want)
+}
+
+func Test_SelectCount(t *testing.T) {
+	dir := t.TempDir()
+
+	script := []string{"select count(*)"}
+	for i := 1; i <= 20; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	script = append(script, "delete 5", "select count(*)", ".exit")
+
+	want := wantWithHeader("> (0)", "Executed.")
+	for range 21 {
+		want = append(want, "> Executed.")
+	}
+	want = append(want,
+		"> (19)",
+		"Executed.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, script, want)
+}
+
+func Test_MultiRowInsert(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> Executed.",
+		"> Error: duplicate key.",
+		"> Error: duplicate key.",
+		`> syntax error at end of input: expected ")".`,
+		"> (1, user1, person1@example.com)",
+		"(2, user2, person2@example.com)",
+		"(3, user3, person3@example.com)",
+		"Executed.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		"insert (3 user3 person3@example.com), (1 user1 person1@example.com) (2 user2 person2@example.com)",
+		// Nothing from a batch is inserted when one of its keys exists
+		"insert (4 user4 person4@example.com) (2 user2 person2@example.com)",
+		"insert (5 user5 person5@example.com) (5 user5 person5@example.com)",
+		"insert (6 user6 person6@example.com",
+		"select",
+		".exit",
+	}, want)
+}
+
+func Test_QuotedStringLiterals(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> Executed.",
+		"> Executed.",
+		"> Executed.",
+		"> syntax error: unterminated string literal.",
+		"> syntax error: unknown escape sequence \\q.",
+		"> (1, John Smith, john smith@example.com)",
+		"(2, O'Brien, say \"hi\"\\now)",
+		"(3, Jane Doe, jane@example.com)",
+		"Executed.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		`insert 1 "John Smith" 'john smith@example.com'`,
+		`insert 2 'O\'Brien' "say \"hi\"\\now"`,
+		`insert (3 'Jane Doe' "jane@example.com")`,
+		`insert 4 "unterminated person4@example.com`,
+		`insert 5 "bad\q" person5@example.com`,
+		"select",
+		".exit",
+	}, want)
+}
+
+func Test_SyntaxErrorsReportOffendingToken(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		`> syntax error at "abc": expected id.`,
+		`> syntax error at end of input: expected email.`,
+		`> syntax error at "extra": expected end of statement.`,
+		`> syntax error at "99999999999999999999": id out of range.`,
+		`> syntax error at ")": expected "*".`,
+		"> Executed.",
+		"> (1, select, 42)",
+		"Executed.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		"insert abc user1 person1@example.com",
+		"update 1 user1",
+		"select where id = 1 extra",
+		"delete 99999999999999999999",
+		"select count()",
+		// Keywords and numbers are plain values in value positions
+		"INSERT 1 select 42",
+		"SELECT where ID = 1",
+		".exit",
+	}, want)
+}
+
+func Test_SelectClausesCompose(t *testing.T) {
+	dir := t.TempDir()
+
+	want := wantWithHeader(
+		"> Executed.",
+		"> (1)",
+		"Executed.",
+		"> (0)",
+		"Executed.",
+		"> (3, user3, person3@example.com)",
+		"(2, user2, person2@example.com)",
+		"(1, user1, person1@example.com)",
+		"Executed.",
+		"> (2, user2, person2@example.com)",
+		"Executed.",
+		"> last_insert_id() takes no where or order by clause.",
+		"> Bye!",
+	)
+
+	mustRunAndAssert(t, dir, []string{
+		"insert (1 user1 person1@example.com) (2 user2 person2@example.com) (3 user3 person3@example.com)",
+		"select count(*) where id = 3",
+		"select count(*) where id = 99",
+		"select * order by id desc",
+		"select * where id = 2 order by id desc",
+		"select last_insert_id() where id = 1",
+		".exit",
+	}, want)
+}
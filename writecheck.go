@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// WithWriteChecks makes every insert check the leaf the new row landed in
+// and each of its ancestors up to the root: keys in order, cell counts that
+// fit the page, parent and child pointers that agree, and keys inside the
+// range the parent's separators give the node. A failed check panics with
+// the nodes on the path, so a bug in split or merge code shows up at the
+// write that caused it instead of in a later read. It costs a walk from the
+// leaf to the root per insert and is meant for debugging.
+func WithWriteChecks() Option {
+	return func(c *openConfig) {
+		c.writeChecks = true
+	}
+}
+
+// checkWrite runs the checks of WithWriteChecks for the leaf holding key
+// and its ancestors, panicking if one fails.
+func (t *Table) checkWrite(key uint64) {
+	cursor, err := t.findKey(key)
+	if err != nil {
+		panic(fmt.Sprintf("write check after writing key %d: %v", key, err))
+	}
+	path, err := t.checkPath(cursor.pageNum)
+	if err != nil {
+		panic(fmt.Sprintf("write check after writing key %d: %v\n%s", key, err, t.dumpPath(path)))
+	}
+}
+
+// checkPath checks the leaf at pageNum and the internal nodes above it. It
+// returns the page numbers it went through, from the leaf up.
+func (t *Table) checkPath(pageNum uint32) ([]uint32, error) {
+	path := []uint32{pageNum}
+	node, err := t.pager.getPage(pageNum)
+	if err != nil {
+		return path, err
+	}
+	if nodeType(node) != NodeTypeLeaf {
+		return path, fmt.Errorf("page %d is not a leaf", pageNum)
+	}
+	if err := checkLeaf(node, pageNum); err != nil {
+		return path, err
+	}
+	numCells := leafNodeNumCells(node).get()
+	if numCells == 0 && !isNodeRoot(node) {
+		return path, fmt.Errorf("leaf %d is empty", pageNum)
+	}
+
+	for !isNodeRoot(node) {
+		var first, last uint64
+		if numCells > 0 {
+			first, last = leafNodeKey(node, 0), leafNodeKey(node, numCells-1)
+		}
+		if nodeType(node) == NodeTypeInternal {
+			numKeys := internalNodeNumKeys(node).get()
+			first, last = internalNodeKey(node, 0).get(), internalNodeKey(node, numKeys-1).get()
+		}
+
+		parentPageNum := nodeParent(node).get()
+		path = append(path, parentPageNum)
+		parent, err := t.pager.getPage(parentPageNum)
+		if err != nil {
+			return path, err
+		}
+		if nodeType(parent) != NodeTypeInternal {
+			return path, fmt.Errorf("parent %d of page %d is not an internal node", parentPageNum, pageNum)
+		}
+		numKeys := internalNodeNumKeys(parent).get()
+		if numKeys == 0 || numKeys > uint32(InternalNodeMaxKeys) {
+			return path, fmt.Errorf("internal node %d has %d keys", parentPageNum, numKeys)
+		}
+		index := numKeys + 1
+		for i := uint32(0); i <= numKeys; i++ {
+			if internalNodeChild(parent, i).get() == pageNum {
+				index = i
+			}
+			if i > 0 && i < numKeys && internalNodeKey(parent, i).get() <= internalNodeKey(parent, i-1).get() {
+				return path, fmt.Errorf("internal node %d keys out of order at cell %d", parentPageNum, i)
+			}
+		}
+		if index > numKeys {
+			return path, fmt.Errorf("page %d points to parent %d, which does not point back", pageNum, parentPageNum)
+		}
+		if numCells > 0 {
+			if index > 0 && first <= internalNodeKey(parent, index-1).get() {
+				return path, fmt.Errorf("page %d key %d is not above the separator %d before it", pageNum, first, internalNodeKey(parent, index-1).get())
+			}
+			if index < numKeys && last > internalNodeKey(parent, index).get() {
+				return path, fmt.Errorf("page %d key %d is above the separator %d after it", pageNum, last, internalNodeKey(parent, index).get())
+			}
+		}
+
+		pageNum, node, numCells = parentPageNum, parent, numKeys
+	}
+	return path, nil
+}
+
+// dumpPath describes the nodes of path, one line of JSON per node without
+// its children, for the message of a failed write check.
+func (t *Table) dumpPath(path []uint32) string {
+	var b strings.Builder
+	for _, pageNum := range path {
+		page, err := t.pager.getPage(pageNum)
+		if err != nil {
+			fmt.Fprintf(&b, "page %d: %v\n", pageNum, err)
+			continue
+		}
+		node, err := describeNode(page, pageNum)
+		if err != nil {
+			fmt.Fprintf(&b, "page %d: %v\n", pageNum, err)
+			continue
+		}
+		out, err := json.Marshal(node)
+		if err != nil {
+			fmt.Fprintf(&b, "page %d: %v\n", pageNum, err)
+			continue
+		}
+		fmt.Fprintf(&b, "%s\n", out)
+	}
+	return b.String()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestWriteChecksPassOnSplits(t *testing.T) {
+	table := openTestTable(t, WithWriteChecks())
+	for _, key := range rand.New(rand.NewSource(1)).Perm(300) {
+		if err := table.Insert(createRow(int64(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWriteChecksPanicOnBadSeparator(t *testing.T) {
+	table := openTestTable(t, WithWriteChecks())
+	for key := range int64(200) {
+		if err := table.Insert(createRow(key * 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Lower the separator of the first leaf below its last keys
+	root, err := table.pager.getPageForWrite(table.rootPageNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodeType(root) != NodeTypeInternal {
+		t.Fatal("root did not split")
+	}
+	internalNodeKey(root, 0).set(internalNodeKey(root, 0).get() - 3)
+	table.invalidateLeafHint()
+
+	defer func() {
+		msg := fmt.Sprint(recover())
+		if !strings.Contains(msg, "is above the separator") || !strings.Contains(msg, `"type":"internal"`) {
+			t.Fatalf("unexpected panic: %s", msg)
+		}
+	}()
+	// The key routes to the first leaf, whose last keys are now too large
+	_ = table.Insert(createRow(1))
+}
@@ -0,0 +1,69 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io"
+	"math/bits"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// pwritev writes bufs back to back to f starting at off, with one pwritev
+// call for all of them unless the kernel writes less than asked.
+func pwritev(f *os.File, bufs [][]byte, off int64) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	// Trimmed as the write proceeds, so work on a copy
+	bufs = append([][]byte(nil), bufs...)
+	iovecs := make([]syscall.Iovec, 0, len(bufs))
+
+	for len(bufs) > 0 {
+		iovecs = iovecs[:0]
+		for _, buf := range bufs {
+			if len(buf) == 0 {
+				continue
+			}
+			iovec := syscall.Iovec{Base: &buf[0]}
+			iovec.SetLen(len(buf))
+			iovecs = append(iovecs, iovec)
+		}
+		if len(iovecs) == 0 {
+			return nil
+		}
+
+		var n uintptr
+		var errno syscall.Errno
+		err := conn.Write(func(fd uintptr) bool {
+			// The offset is split in two words on 32-bit platforms
+			lo, hi := uintptr(off), uintptr(uint64(off)>>(bits.UintSize-1)>>1)
+			n, _, errno = syscall.Syscall6(syscall.SYS_PWRITEV, fd,
+				uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)), lo, hi, 0)
+			return errno != syscall.EINTR
+		})
+		if err != nil {
+			return err
+		}
+		if errno != 0 {
+			return errno
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+
+		off += int64(n)
+		for written := int(n); written > 0; {
+			if written < len(bufs[0]) {
+				bufs[0] = bufs[0][written:]
+				break
+			}
+			written -= len(bufs[0])
+			bufs = bufs[1:]
+		}
+	}
+	return nil
+}
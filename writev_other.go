@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+// pwritev writes bufs back to back to f starting at off. Without pwritev this
+// is one write per buffer.
+func pwritev(f *os.File, bufs [][]byte, off int64) error {
+	for _, buf := range bufs {
+		if _, err := f.WriteAt(buf, off); err != nil {
+			return err
+		}
+		off += int64(len(buf))
+	}
+	return nil
+}